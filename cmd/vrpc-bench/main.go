@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuebench"
+	"github.com/codeallergy/value-rpc/valueclient"
+	"github.com/codeallergy/value-rpc/valueserver"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var mix = flag.String("mix", "call", "operations mix, for example call=70,get=10,put=10,chat=10")
+var concurrency = flag.Int("c", valuebench.DefaultConfig.Concurrency, "number of parallel workers")
+var duration = flag.Duration("d", valuebench.DefaultConfig.Duration, "benchmark duration")
+var requests = flag.Int64("n", 0, "stop after number of operations, 0 runs for duration")
+var size = flag.Int("size", valuebench.DefaultConfig.PayloadSize, "payload size in bytes of args and stream values")
+var stream = flag.Int("stream", valuebench.DefaultConfig.StreamLength, "number of values in GET, PUT and chat streams")
+var receiveCap = flag.Int("receive-cap", valuebench.DefaultConfig.ReceiveCap, "receive buffer of GET and chat streams")
+var timeout = flag.Duration("timeout", 5*time.Second, "request timeout")
+var warmup = flag.Duration("warmup", time.Second, "run before measuring, 0 disables")
+var jsonOut = flag.Bool("json", false, "print report in JSON")
+var baseline = flag.String("baseline", "", "JSON report of previous run to compare with")
+var tolerance = flag.Float64("tolerance", 0.1, "allowed regression against baseline, 0.1 is 10%")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: vrpc-bench [flags] serve address
+       vrpc-bench [flags] run address|local
+
+serve  starts server with built-in benchmark handlers
+run    drives the mix of operations against the server, 'local' starts the server in process on loopback
+
+Exit code is 1 if the run has errors or regressed against the baseline.
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func serve(address string) error {
+	logger, _ := zap.NewProduction()
+	srv, err := valueserver.NewServer(address, logger)
+	if err != nil {
+		return err
+	}
+	if err := valuebench.Register(srv); err != nil {
+		srv.Close()
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		srv.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "serving benchmark handlers on %v\n", srv.Addr())
+	return srv.Run()
+}
+
+func startLocal() (valueserver.Server, string, error) {
+	srv, err := valueserver.NewServer("127.0.0.1:0", zap.NewNop())
+	if err != nil {
+		return nil, "", err
+	}
+	if err := valuebench.Register(srv); err != nil {
+		srv.Close()
+		return nil, "", err
+	}
+	go srv.Run()
+	return srv, srv.Addr().String(), nil
+}
+
+func loadBaseline(path string) (*valuebench.Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report valuebench.Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, errors.Errorf("baseline '%s', %v", path, err)
+	}
+	return &report, nil
+}
+
+// returns true if the run failed
+func run(address string) (bool, error) {
+
+	cfg := valuebench.Config{
+		Concurrency:  *concurrency,
+		Duration:     *duration,
+		Requests:     *requests,
+		PayloadSize:  *size,
+		StreamLength: *stream,
+		ReceiveCap:   *receiveCap,
+	}
+	var err error
+	if cfg.Mix, err = valuebench.ParseMix(*mix); err != nil {
+		return false, err
+	}
+	if *requests > 0 {
+		cfg.Duration = 0
+	}
+
+	var base *valuebench.Report
+	if *baseline != "" {
+		if base, err = loadBaseline(*baseline); err != nil {
+			return false, err
+		}
+	}
+
+	if address == "local" {
+		srv, addr, err := startLocal()
+		if err != nil {
+			return false, err
+		}
+		defer srv.Close()
+		address = addr
+	}
+
+	cli := valueclient.NewClient(address, "")
+	cli.SetConnectionHandler(func(value.Map) {})
+	cli.SetTimeout(timeout.Milliseconds())
+	if err := cli.Connect(); err != nil {
+		return false, err
+	}
+	defer cli.Close()
+
+	if *warmup > 0 {
+		warm := cfg
+		warm.Duration, warm.Requests = *warmup, 0
+		if _, err := valuebench.Run(cli, warm); err != nil {
+			return false, err
+		}
+	}
+
+	report, err := valuebench.Run(cli, cfg)
+	if err != nil {
+		return false, err
+	}
+
+	if *jsonOut {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return false, err
+		}
+		fmt.Printf("%s\n", out)
+	} else {
+		report.Print(os.Stdout)
+	}
+
+	_, errs := report.Total()
+	failed := errs > 0
+	if base != nil {
+		for _, r := range valuebench.Compare(base, report, *tolerance) {
+			fmt.Fprintf(os.Stderr, "REGRESSION %s\n", r)
+			failed = true
+		}
+	}
+	return failed, nil
+}
+
+func doMain() int {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		usage()
+		return 2
+	}
+	switch flag.Arg(0) {
+	case "serve":
+		if err := serve(flag.Arg(1)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error on run(), %v\n", err)
+			return 2
+		}
+		return 0
+	case "run":
+		failed, err := run(flag.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error on run(), %v\n", err)
+			return 2
+		}
+		if failed {
+			return 1
+		}
+		return 0
+	}
+	usage()
+	return 2
+}
+
+func main() {
+	os.Exit(doMain())
+}
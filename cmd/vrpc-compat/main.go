@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/codeallergy/value-rpc/valueclient"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valueschema"
+	"os"
+)
+
+var socks5 = flag.String("socks5", "", "SOCKS5 proxy address")
+var timeoutMls = flag.Int64("timeout", valueclient.DefaultTimeoutMls, "call timeout in milliseconds")
+var quiet = flag.Bool("q", false, "print only breaking changes")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: vrpc-compat [flags] old new\n\n")
+	fmt.Fprintf(os.Stderr, "old and new are JSON Schema dumps of vrpc-schema or addresses of live servers.\n")
+	fmt.Fprintf(os.Stderr, "Exit code is 1 if there are breaking changes.\n\n")
+	flag.PrintDefaults()
+}
+
+func load(source string) ([]valuerpc.FunctionInfo, error) {
+
+	if _, err := os.Stat(source); err == nil {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		return valueschema.ParseCatalog(data)
+	}
+
+	cli := valueclient.NewClient(source, *socks5)
+	defer cli.Close()
+
+	cli.SetTimeout(*timeoutMls)
+	if err := cli.Connect(); err != nil {
+		return nil, err
+	}
+
+	return valueclient.ListFunctions(cli)
+}
+
+func run(oldSource, newSource string) (bool, error) {
+
+	oldList, err := load(oldSource)
+	if err != nil {
+		return false, err
+	}
+
+	newList, err := load(newSource)
+	if err != nil {
+		return false, err
+	}
+
+	changes := valueschema.Compare(oldList, newList)
+	for _, c := range changes {
+		if !*quiet || c.Severity == valueschema.Breaking {
+			fmt.Println(c.String())
+		}
+	}
+
+	return valueschema.HasBreaking(changes), nil
+}
+
+func doMain() int {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		usage()
+		return 2
+	}
+	breaking, err := run(flag.Arg(0), flag.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error on run(), %v\n", err)
+		return 2
+	}
+	if breaking {
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(doMain())
+}
@@ -0,0 +1,455 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var format = flag.String("format", "raw", "input format: raw, tshark or recording")
+var port = flag.Int("port", 0, "server port, required for tshark format to find direction")
+var maxFrame = flag.Int("max-frame", 64<<20, "max frame size, larger length field means the stream is out of sync")
+var maxValue = flag.Int("max-value", 300, "max printed length of args, results and values, 0 is unlimited")
+var summary = flag.Bool("summary", true, "print request summary")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: vrpc-dump [flags] file...
+
+Decodes captured vRPC traffic and prints annotated messages with request correlation.
+
+Formats:
+  raw        TCP payload of one direction per file, for example written by tcpflow,
+             direction is detected by message types, there is no timing
+  tshark     lines 'time_epoch<TAB>srcport<TAB>dstport<TAB>payload_hex' made by
+             tshark -r capture.pcap -Y 'tcp.len>0' -T fields -e frame.time_epoch -e tcp.srcport -e tcp.dstport -e tcp.payload
+  recording  traffic recording made by valuerpc.Recorder
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+type message struct {
+	time time.Time // zero if unknown
+	conn string
+	dir  valuerpc.Direction
+	size int
+	msg  value.Map
+	err  error
+	seq  int
+}
+
+// splits byte stream to frames, returns the rest of incomplete frame
+func splitFrames(data []byte, fn func(frame []byte)) ([]byte, error) {
+	for {
+		r := bytes.NewReader(data)
+		frame, err := valuerpc.ReadFrame(r, *maxFrame)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fn(frame)
+		data = data[len(data)-r.Len():]
+	}
+}
+
+func decode(frame []byte) (value.Map, error) {
+	val, err := value.Unpack(frame, true)
+	if err != nil {
+		return nil, errors.Errorf("msgpack unpack, %v", err)
+	}
+	if val == nil || val.Kind() != value.MAP {
+		return nil, errors.New("expected msgpack table")
+	}
+	return val.(value.Map), nil
+}
+
+func messageType(msg value.Map) (valuerpc.MessageType, bool) {
+	if msg == nil {
+		return 0, false
+	}
+	mt := msg.GetNumber(valuerpc.MessageTypeField)
+	if mt == nil {
+		return 0, false
+	}
+	return valuerpc.MessageType(mt.Long()), true
+}
+
+// direction by the first message that only one side sends
+func detectDirection(list []*message) valuerpc.Direction {
+	for _, m := range list {
+		mt, ok := messageType(m.msg)
+		if !ok {
+			continue
+		}
+		switch mt {
+		case valuerpc.HandshakeRequest, valuerpc.FunctionRequest, valuerpc.GetStreamRequest, valuerpc.PutStreamRequest, valuerpc.ChatRequest, valuerpc.CancelRequest:
+			return valuerpc.ClientToServer
+		case valuerpc.HandshakeResponse, valuerpc.FunctionResponse, valuerpc.ErrorResponse, valuerpc.StreamReady:
+			return valuerpc.ServerToClient
+		}
+	}
+	return valuerpc.ClientToServer
+}
+
+// tcpflow names both directions 'src-dst' and 'dst-src', they are one connection
+func rawConn(path string) string {
+	name := filepath.Base(path)
+	parts := strings.Split(name, "-")
+	if len(parts) != 2 {
+		return name
+	}
+	if parts[1] < parts[0] {
+		parts[0], parts[1] = parts[1], parts[0]
+	}
+	return parts[0] + "-" + parts[1]
+}
+
+func readRaw(path string) ([]*message, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conn := rawConn(path)
+	var list []*message
+	rest, err := splitFrames(data, func(frame []byte) {
+		msg, err := decode(frame)
+		list = append(list, &message{conn: conn, size: len(frame), msg: msg, err: err})
+	})
+	if err != nil {
+		return list, errors.Errorf("%s, %v", path, err)
+	}
+	if len(rest) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %d bytes of incomplete frame at the end\n", path, len(rest))
+	}
+	dir := detectDirection(list)
+	for _, m := range list {
+		m.dir = dir
+	}
+	return list, nil
+}
+
+func parseEpoch(s string) (time.Time, error) {
+	parts := strings.SplitN(s, ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if len(parts) == 2 {
+		frac := (parts[1] + "000000000")[:9]
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+func readTshark(path string) ([]*message, error) {
+	if *port == 0 {
+		return nil, errors.New("tshark format requires -port")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	type streamKey struct {
+		conn string
+		dir  valuerpc.Direction
+	}
+	buffers := make(map[streamKey][]byte)
+	broken := make(map[streamKey]bool)
+
+	var list []*message
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 4 || fields[3] == "" {
+			continue
+		}
+		ts, err := parseEpoch(fields[0])
+		if err != nil {
+			return nil, errors.Errorf("%s:%d time, %v", path, line, err)
+		}
+		payload, err := hex.DecodeString(strings.ReplaceAll(fields[3], ":", ""))
+		if err != nil {
+			return nil, errors.Errorf("%s:%d payload, %v", path, line, err)
+		}
+		key := streamKey{conn: "port " + fields[1], dir: valuerpc.ClientToServer}
+		if fields[1] == strconv.Itoa(*port) {
+			key = streamKey{conn: "port " + fields[2], dir: valuerpc.ServerToClient}
+		}
+		if broken[key] {
+			continue
+		}
+		rest, err := splitFrames(append(buffers[key], payload...), func(frame []byte) {
+			msg, err := decode(frame)
+			list = append(list, &message{time: ts, conn: key.conn, dir: key.dir, size: len(frame), msg: msg, err: err})
+		})
+		if err != nil {
+			// capture started in the middle of the stream or lost segments
+			fmt.Fprintf(os.Stderr, "%s:%d %s %s out of sync, %v\n", path, line, key.conn, key.dir, err)
+			broken[key] = true
+			continue
+		}
+		buffers[key] = rest
+	}
+	return list, scanner.Err()
+}
+
+func readRecording(path string) ([]*message, error) {
+	records, err := valuerpc.ReadRecords(path)
+	var list []*message
+	for _, rec := range records {
+		list = append(list, &message{
+			time: rec.Time,
+			conn: "conn " + strconv.FormatInt(rec.Conn, 10),
+			dir:  rec.Direction,
+			msg:  rec.Message,
+		})
+	}
+	return list, err
+}
+
+func shorten(s string) string {
+	if *maxValue > 0 && len(s) > *maxValue {
+		return s[:*maxValue] + "..."
+	}
+	return s
+}
+
+func valueString(val value.Value) string {
+	if val == nil {
+		return "null"
+	}
+	out, err := valuerpc.ToJSON(val)
+	if err != nil {
+		return shorten(val.String())
+	}
+	return shorten(string(out))
+}
+
+type requestKey struct {
+	conn string
+	rid  int64
+}
+
+type request struct {
+	key      requestKey
+	name     string
+	mt       valuerpc.MessageType
+	start    time.Time
+	end      time.Time
+	in       int // stream values sent by client
+	out      int // stream values sent by server
+	status   string
+	messages int
+}
+
+type correlator struct {
+	requests map[requestKey]*request
+	order    []*request
+	origin   time.Time
+}
+
+func (t *correlator) elapsed(ts time.Time) string {
+	if ts.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("[+%.6fs] ", ts.Sub(t.origin).Seconds())
+}
+
+func (t *correlator) print(m *message) {
+	prefix := fmt.Sprintf("%s#%d %s %s", t.elapsed(m.time), m.seq, m.conn, m.dir)
+	if m.err != nil {
+		fmt.Printf("%s %d bytes, %v\n", prefix, m.size, m.err)
+		return
+	}
+	mt, ok := messageType(m.msg)
+	if !ok {
+		fmt.Printf("%s no message type %s\n", prefix, valueString(m.msg))
+		return
+	}
+
+	rid := int64(0)
+	if n := m.msg.GetNumber(valuerpc.RequestIdField); n != nil {
+		rid = n.Long()
+	}
+	key := requestKey{m.conn, rid}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s %s rid=%d", prefix, mt, rid)
+
+	req, known := t.requests[key]
+	switch mt {
+	case valuerpc.FunctionRequest, valuerpc.GetStreamRequest, valuerpc.PutStreamRequest, valuerpc.ChatRequest:
+		req = &request{key: key, mt: mt, start: m.time, status: "open"}
+		if name := m.msg.GetString(valuerpc.FunctionNameField); name != nil {
+			req.name = name.String()
+		}
+		t.requests[key] = req
+		t.order = append(t.order, req)
+		known = true
+		fmt.Fprintf(&out, " fn=%s", req.name)
+		if args, ok := m.msg.Get(valuerpc.ArgumentsField); ok {
+			fmt.Fprintf(&out, " args=%s", valueString(args))
+		}
+		if sla := m.msg.GetNumber(valuerpc.TimeoutField); sla != nil {
+			fmt.Fprintf(&out, " timeout=%dms", sla.Long())
+		}
+	case valuerpc.HandshakeRequest:
+		if cid := m.msg.GetNumber(valuerpc.ClientIdField); cid != nil {
+			fmt.Fprintf(&out, " cid=%d", cid.Long())
+		}
+	default:
+		if known {
+			fmt.Fprintf(&out, " fn=%s", req.name)
+		}
+	}
+
+	if res, ok := m.msg.Get(valuerpc.ResultField); ok {
+		fmt.Fprintf(&out, " res=%s", valueString(res))
+	}
+	if val, ok := m.msg.Get(valuerpc.ValueField); ok {
+		fmt.Fprintf(&out, " val=%s", valueString(val))
+	}
+	if e := m.msg.GetString(valuerpc.ErrorField); e != nil {
+		fmt.Fprintf(&out, " err=%q", e.String())
+	}
+	if code := m.msg.GetNumber(valuerpc.ErrorCodeField); code != nil {
+		fmt.Fprintf(&out, " code=%d", code.Long())
+	}
+
+	if known {
+		req.messages++
+		if _, ok := m.msg.Get(valuerpc.ValueField); ok {
+			if m.dir == valuerpc.ClientToServer {
+				req.in++
+			} else {
+				req.out++
+			}
+		}
+		switch mt {
+		case valuerpc.FunctionResponse:
+			req.status = "ok"
+		case valuerpc.ErrorResponse:
+			req.status = "error"
+		case valuerpc.CancelRequest:
+			req.status = "canceled"
+		case valuerpc.StreamReady:
+			req.status = "streaming"
+		case valuerpc.StreamEnd:
+			if req.status != "canceled" && req.status != "error" {
+				req.status = "ended"
+			}
+		}
+		if !m.time.IsZero() && !req.start.IsZero() && m.time.After(req.start) {
+			req.end = m.time
+			if mt == valuerpc.FunctionResponse || mt == valuerpc.ErrorResponse || mt == valuerpc.StreamEnd {
+				fmt.Fprintf(&out, " (%v after request)", req.end.Sub(req.start).Round(time.Microsecond))
+			}
+		}
+	}
+
+	fmt.Println(out.String())
+}
+
+func (t *correlator) printSummary() {
+	if len(t.order) == 0 {
+		return
+	}
+	fmt.Printf("\n%-12s %8s  %-30s %-17s %-9s %6s %6s %12s\n", "CONN", "RID", "FUNCTION", "TYPE", "STATUS", "IN", "OUT", "DURATION")
+	for _, req := range t.order {
+		duration := "-"
+		if !req.end.IsZero() {
+			duration = req.end.Sub(req.start).Round(time.Microsecond).String()
+		}
+		fmt.Printf("%-12s %8d  %-30s %-17s %-9s %6d %6d %12s\n", req.key.conn, req.key.rid, req.name, req.mt, req.status, req.in, req.out, duration)
+	}
+}
+
+func run(files []string) error {
+
+	var list []*message
+	for _, path := range files {
+		var part []*message
+		var err error
+		switch *format {
+		case "raw":
+			part, err = readRaw(path)
+		case "tshark":
+			part, err = readTshark(path)
+		case "recording":
+			part, err = readRecording(path)
+		default:
+			return errors.Errorf("unknown format '%s'", *format)
+		}
+		list = append(list, part...)
+		if err != nil {
+			return err
+		}
+	}
+
+	timed := len(list) > 0
+	for _, m := range list {
+		if m.time.IsZero() {
+			timed = false
+		}
+	}
+	if timed {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].time.Before(list[j].time) })
+	}
+
+	c := &correlator{requests: make(map[requestKey]*request)}
+	if timed {
+		c.origin = list[0].time
+	}
+	for i, m := range list {
+		m.seq = i
+		c.print(m)
+	}
+	if *summary {
+		c.printSummary()
+	}
+	return nil
+}
+
+func doMain() int {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		return 2
+	}
+	if err := run(flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error on run(), %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(doMain())
+}
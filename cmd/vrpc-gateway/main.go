@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/codeallergy/value-rpc/valueclient"
+	"github.com/codeallergy/value-rpc/valuegateway"
+	"net/http"
+	"os"
+)
+
+var listen = flag.String("listen", ":8080", "HTTP listen address")
+var socks5 = flag.String("socks5", "", "SOCKS5 proxy address")
+var timeoutMls = flag.Int64("timeout", valueclient.DefaultTimeoutMls, "call timeout in milliseconds")
+var receiveCap = flag.Int("receive-cap", valuegateway.DefaultReceiveCap, "stream receive queue capacity")
+var metricsPath = flag.String("metrics", "/metrics", "path of Prometheus metrics of the client, empty disables")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: vrpc-gateway [flags] address\n\n")
+	flag.PrintDefaults()
+}
+
+func run(address string) error {
+
+	cli := valueclient.NewClient(address, *socks5)
+	defer cli.Close()
+
+	cli.SetTimeout(*timeoutMls)
+	if err := cli.Connect(); err != nil {
+		return err
+	}
+
+	gw := valuegateway.NewGateway(cli)
+	gw.SetReceiveCap(*receiveCap)
+
+	if *metricsPath == "" {
+		return http.ListenAndServe(*listen, gw)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(*metricsPath, cli.Metrics().Handler())
+	mux.Handle("/", gw)
+	return http.ListenAndServe(*listen, mux)
+}
+
+func doMain() int {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+		return 2
+	}
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error on run(), %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(doMain())
+}
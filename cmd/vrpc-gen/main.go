@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/codeallergy/value-rpc/valuegen"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+)
+
+var outFile = flag.String("out", "", "output go file, default is the contract file name with .go suffix")
+var pkgName = flag.String("package", "", "go package name, overrides the package of the contract file")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: vrpc-gen [flags] contract.vrpc\n\n")
+	flag.PrintDefaults()
+}
+
+func run(inFile string) error {
+
+	src, err := os.ReadFile(inFile)
+	if err != nil {
+		return err
+	}
+
+	file, err := valuegen.Parse(string(src))
+	if err != nil {
+		return errors.Errorf("parse %s, %v", inFile, err)
+	}
+
+	if *pkgName != "" {
+		file.Package = *pkgName
+	}
+
+	out, err := valuegen.Generate(file)
+	if err != nil {
+		return errors.Errorf("generate %s, %v", inFile, err)
+	}
+
+	target := *outFile
+	if target == "" {
+		target = strings.TrimSuffix(inFile, ".vrpc") + ".vrpc.go"
+	}
+
+	return os.WriteFile(target, out, 0644)
+}
+
+func doMain() int {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+		return 2
+	}
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error on run(), %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(doMain())
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/codeallergy/value-rpc/valuemock"
+	"github.com/codeallergy/value-rpc/valueserver"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+)
+
+var listen = flag.String("listen", "localhost:9999", "listen address, host:port or unix:/path/to/socket")
+var scriptFile = flag.String("script", "", "JSON mock script")
+var recordingFile = flag.String("recording", "", "traffic recording to build the script from")
+var dump = flag.Bool("dump", false, "print the script built from recording and exit")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: vrpc-mock [flags] -script file | -recording file\n\n")
+	flag.PrintDefaults()
+}
+
+func loadScript() (*valuemock.Script, error) {
+	if *scriptFile != "" {
+		return valuemock.LoadScript(*scriptFile)
+	}
+	return valuemock.LoadRecordings(*recordingFile)
+}
+
+// returns number of failed expectations
+func run() (int, error) {
+
+	script, err := loadScript()
+	if err != nil {
+		return 0, err
+	}
+
+	if *dump {
+		out, err := script.Marshal()
+		if err != nil {
+			return 0, err
+		}
+		_, err = fmt.Printf("%s\n", out)
+		return 0, err
+	}
+
+	logger, _ := zap.NewDevelopment()
+	srv, err := valueserver.NewServer(*listen, logger)
+	if err != nil {
+		return 0, err
+	}
+
+	mock, err := valuemock.NewMock(srv, script, logger)
+	if err != nil {
+		srv.Close()
+		return 0, err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		srv.Close()
+	}()
+
+	if err := srv.Run(); err != nil {
+		return 0, err
+	}
+
+	calls := mock.Calls()
+	names := make([]string, 0, len(calls))
+	for name := range calls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s called %d times\n", name, calls[name])
+	}
+
+	failures := mock.Failures()
+	for _, f := range failures {
+		fmt.Printf("FAILED %s\n", f)
+	}
+	return len(failures), nil
+}
+
+func doMain() int {
+	flag.Usage = usage
+	flag.Parse()
+	if (*scriptFile == "") == (*recordingFile == "") {
+		usage()
+		return 2
+	}
+	failures, err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error on run(), %v\n", err)
+		return 2
+	}
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(doMain())
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/pkg/errors"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var speed = flag.Float64("speed", 1.0, "replay speed factor, 2 is twice faster, 0 sends without delays")
+var wait = flag.Duration("wait", 2*time.Second, "time to wait for responses after the last sent message")
+var timeout = flag.Duration("timeout", 10*time.Second, "write timeout")
+var verbose = flag.Bool("v", false, "print every difference in full")
+var token = flag.String("token", "", "auth token of replayed handshakes, recordings keep only redacted one")
+
+// recordings keep only redacted metadata values
+var metadata = make(valuerpc.Metadata)
+
+type metadataFlag struct{}
+
+func (metadataFlag) String() string { return "" }
+
+func (metadataFlag) Set(s string) error {
+	i := strings.IndexAny(s, ":=")
+	if i <= 0 {
+		return errors.Errorf("expected key:value or key=value, got '%s'", s)
+	}
+	metadata.Set(strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]))
+	return nil
+}
+
+func init() {
+	flag.Var(metadataFlag{}, "H", "metadata key:value of replayed requests, sets or overrides recorded value, could be repeated")
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: vrpc-replay [flags] recording address\n\n")
+	fmt.Fprintf(os.Stderr, "Resends recorded client traffic to the server and compares responses with recorded ones.\n")
+	fmt.Fprintf(os.Stderr, "Recorded token and metadata values are redacted, supply them by -token and -H.\n\n")
+	flag.PrintDefaults()
+}
+
+type replayConn struct {
+	id       int64
+	sent     []valuerpc.Record
+	expected []value.Map
+
+	redacted int // requests sent with redacted metadata values
+
+	lock     sync.Mutex
+	received []value.Map
+}
+
+func (t *replayConn) receive(msg value.Map) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.received = append(t.received, msg)
+}
+
+func (t *replayConn) receivedCount() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.received)
+}
+
+func groupByConn(records []valuerpc.Record) []*replayConn {
+	conns := make(map[int64]*replayConn)
+	var list []*replayConn
+	for _, rec := range records {
+		c, ok := conns[rec.Conn]
+		if !ok {
+			c = &replayConn{id: rec.Conn}
+			conns[rec.Conn] = c
+			list = append(list, c)
+		}
+		if rec.Direction == valuerpc.ClientToServer {
+			c.sent = append(c.sent, rec)
+		} else {
+			c.expected = append(c.expected, rec.Message)
+		}
+	}
+	return list
+}
+
+func (t *replayConn) replay(address string, start, origin time.Time) error {
+	if len(t.sent) == 0 {
+		return nil
+	}
+
+	network, addr := valuerpc.SplitAddress(address)
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return err
+	}
+	msgConn := valuerpc.NewMsgConn(conn, *timeout)
+	defer msgConn.Close()
+
+	go func() {
+		for {
+			msg, err := msgConn.ReadMessage()
+			if err != nil {
+				return
+			}
+			// recorded responses are redacted, trailers would differ otherwise
+			t.receive(valuerpc.Redact(msg))
+		}
+	}()
+
+	// each replayed connection is a new client, recorded reconnects of one client must not replace each other
+	clientId := rand.Int63()
+
+	for _, rec := range t.sent {
+		if *speed > 0 {
+			at := start.Add(time.Duration(float64(rec.Time.Sub(origin)) / *speed))
+			time.Sleep(time.Until(at))
+		}
+		msg := rec.Message
+		if mt := msg.GetNumber(valuerpc.MessageTypeField); mt != nil {
+			switch valuerpc.MessageType(mt.Long()) {
+			case valuerpc.HandshakeRequest:
+				msg = msg.Put(valuerpc.ClientIdField, value.Long(clientId)).Remove(valuerpc.TokenField)
+				if *token != "" {
+					msg = msg.Put(valuerpc.TokenField, value.Utf8(*token))
+				}
+			case valuerpc.FunctionRequest, valuerpc.GetStreamRequest, valuerpc.PutStreamRequest, valuerpc.ChatRequest:
+				msg = t.replaceMetadata(msg)
+			}
+		}
+		if err := msgConn.WriteMessage(msg); err != nil {
+			return err
+		}
+	}
+
+	deadline := time.Now().Add(*wait)
+	for t.receivedCount() < len(t.expected) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// values of -H flags replace recorded ones, remaining redacted values are counted
+func (t *replayConn) replaceMetadata(msg value.Map) value.Map {
+	md := valuerpc.GetMetadata(msg, valuerpc.MetadataField)
+	if len(metadata) > 0 {
+		if md == nil {
+			md = make(valuerpc.Metadata)
+		}
+		md.Merge(metadata)
+		msg = valuerpc.PutMetadata(msg, valuerpc.MetadataField, md)
+	}
+	for _, v := range md {
+		if v == valuerpc.RedactedValue {
+			t.redacted++
+			break
+		}
+	}
+	return msg
+}
+
+func byRequest(list []value.Map) (map[int64][]value.Map, []int64) {
+	m := make(map[int64][]value.Map)
+	var ids []int64
+	for _, msg := range list {
+		rid := int64(0)
+		if n := msg.GetNumber(valuerpc.RequestIdField); n != nil {
+			rid = n.Long()
+		}
+		if _, ok := m[rid]; !ok {
+			ids = append(ids, rid)
+		}
+		m[rid] = append(m[rid], msg)
+	}
+	return m, ids
+}
+
+func messageString(msg value.Map) string {
+	if msg == nil {
+		return "none"
+	}
+	s := msg.String()
+	if !*verbose && len(s) > 200 {
+		return s[:200] + "..."
+	}
+	return s
+}
+
+// returns number of differences
+func (t *replayConn) compare() int {
+	t.lock.Lock()
+	received := t.received
+	t.lock.Unlock()
+
+	expected, ids := byRequest(t.expected)
+	actual, actualIds := byRequest(received)
+
+	diffs := 0
+	for _, rid := range ids {
+		exp, act := expected[rid], actual[rid]
+		if len(exp) != len(act) {
+			fmt.Printf("conn %d rid %d: expected %d messages, received %d\n", t.id, rid, len(exp), len(act))
+			diffs++
+		}
+		for i := 0; i < len(exp) && i < len(act); i++ {
+			if !exp[i].Equal(act[i]) {
+				fmt.Printf("conn %d rid %d message %d:\n  expected %s\n  received %s\n", t.id, rid, i, messageString(exp[i]), messageString(act[i]))
+				diffs++
+			}
+		}
+	}
+	for _, rid := range actualIds {
+		if _, ok := expected[rid]; !ok {
+			fmt.Printf("conn %d rid %d: unexpected %d messages, first %s\n", t.id, rid, len(actual[rid]), messageString(actual[rid][0]))
+			diffs++
+		}
+	}
+	return diffs
+}
+
+func run(path, address string) (int, error) {
+
+	records, err := valuerpc.ReadRecords(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+
+	conns := groupByConn(records)
+	origin := records[0].Time
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *replayConn) {
+			defer wg.Done()
+			if err := c.replay(address, start, origin); err != nil {
+				fmt.Fprintf(os.Stderr, "conn %d replay, %v\n", c.id, err)
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	diffs, sent, expected, redacted := 0, 0, 0, 0
+	for _, c := range conns {
+		diffs += c.compare()
+		sent += len(c.sent)
+		expected += len(c.expected)
+		redacted += c.redacted
+	}
+	if redacted > 0 {
+		fmt.Fprintf(os.Stderr, "%d requests were sent with %s metadata values, set them by -H key:value\n", redacted, valuerpc.RedactedValue)
+	}
+	fmt.Printf("replayed %d connections, sent %d messages, expected %d responses, %d differences in %v\n",
+		len(conns), sent, expected, diffs, time.Since(start).Round(time.Millisecond))
+	return diffs, nil
+}
+
+func doMain() int {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		usage()
+		return 2
+	}
+	diffs, err := run(flag.Arg(0), flag.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error on run(), %v\n", err)
+		return 2
+	}
+	if diffs > 0 {
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(doMain())
+}
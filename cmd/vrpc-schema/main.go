@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/codeallergy/value-rpc/valueclient"
+	"github.com/codeallergy/value-rpc/valueschema"
+	"os"
+)
+
+var socks5 = flag.String("socks5", "", "SOCKS5 proxy address")
+var timeoutMls = flag.Int64("timeout", valueclient.DefaultTimeoutMls, "call timeout in milliseconds")
+var title = flag.String("title", "", "schema title, default is the server address")
+var outFile = flag.String("out", "", "output file, default is stdout")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: vrpc-schema [flags] address\n\n")
+	flag.PrintDefaults()
+}
+
+func run(address string) error {
+
+	cli := valueclient.NewClient(address, *socks5)
+	defer cli.Close()
+
+	cli.SetTimeout(*timeoutMls)
+	if err := cli.Connect(); err != nil {
+		return err
+	}
+
+	list, err := valueclient.ListFunctions(cli)
+	if err != nil {
+		return err
+	}
+
+	name := *title
+	if name == "" {
+		name = address
+	}
+
+	out, err := valueschema.MarshalCatalog(name, list)
+	if err != nil {
+		return err
+	}
+	out = append(out, '\n')
+
+	if *outFile == "" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return os.WriteFile(*outFile, out, 0644)
+}
+
+func doMain() int {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+		return 2
+	}
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error on run(), %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(doMain())
+}
@@ -0,0 +1,391 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+)
+
+var timeoutMls = flag.Int64("timeout", valueclient.DefaultTimeoutMls, "call timeout in milliseconds")
+var socks5 = flag.String("socks5", "", "SOCKS5 proxy address")
+var token = flag.String("token", "", "auth token, default is $VRPC_TOKEN")
+var useTLS = flag.Bool("tls", false, "use TLS for TCP connection, use wss:// address for WebSocket")
+var insecure = flag.Bool("insecure", false, "skip TLS certificate verification")
+var caFile = flag.String("ca", "", "PEM file with CA certificates for TLS")
+var receiveCap = flag.Int("receive-cap", 100, "stream receive queue capacity")
+var pretty = flag.Bool("pretty", false, "indent JSON output")
+var jsonList = flag.Bool("json", false, "print function list as JSON lines")
+var showTrailer = flag.Bool("trailer", false, "print trailers of the call to stderr")
+
+var headers = make(valuerpc.Metadata)
+
+type headerFlag struct{}
+
+func (headerFlag) String() string { return "" }
+
+func (headerFlag) Set(s string) error {
+	i := strings.IndexAny(s, ":=")
+	if i <= 0 {
+		return errors.Errorf("expected key:value or key=value, got '%s'", s)
+	}
+	headers.Set(strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]))
+	return nil
+}
+
+func init() {
+	flag.Var(headerFlag{}, "H", "request metadata key:value, could be repeated")
+}
+
+var commands = map[string]bool{"call": true, "tail": true, "put": true, "chat": true}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: vrpc [flags] command address [function] [args]
+
+Commands:
+  call address function [args]   call function, args is JSON or '-' to read from stdin
+  tail address function [args]   print GET stream values as JSON lines
+  put address function [args]    send JSON lines from stdin to PUT stream
+  chat address function [args]   send JSON lines from stdin and print received values
+  list address                   list functions by introspection
+  shell address                  interactive shell with completion and background subscriptions
+
+Address is host:port, unix:/path/to/socket or ws:// and wss:// URL.
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+type tlsDialer struct {
+	config *tls.Config
+	socks5 string
+}
+
+func (t tlsDialer) Dial(network, address string) (net.Conn, error) {
+	var forward proxy.Dialer = proxy.Direct
+	if t.socks5 != "" {
+		d, err := proxy.SOCKS5("tcp", t.socks5, nil, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		forward = d
+	}
+	conn, err := forward.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	config := t.config.Clone()
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			config.ServerName = host
+		}
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func newTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: *insecure}
+	if *caFile != "" {
+		pem, err := os.ReadFile(*caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates in '%s'", *caFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+func connect(address string) (valueclient.Client, error) {
+	cli, err := newClient(address)
+	if err != nil {
+		return nil, err
+	}
+	if err := cli.Connect(); err != nil {
+		cli.Close()
+		return nil, err
+	}
+	return cli, nil
+}
+
+// not connected client configured by flags
+func newClient(address string) (valueclient.Client, error) {
+	var cli valueclient.Client
+	if *useTLS {
+		config, err := newTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		cli = valueclient.NewClient(address, "")
+		cli.SetDialer(tlsDialer{config: config, socks5: *socks5})
+	} else {
+		cli = valueclient.NewClient(address, *socks5)
+	}
+	cli.SetTimeout(*timeoutMls)
+	if *token != "" {
+		cli.SetToken(*token)
+	}
+	return cli, nil
+}
+
+func parseArgs(s string) (value.Value, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if s == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		return valuerpc.ParseJSON(data)
+	}
+	return valuerpc.ParseJSON([]byte(s))
+}
+
+func printValue(w io.Writer, val value.Value) error {
+	out, err := valuerpc.ToJSON(val)
+	if err != nil {
+		return err
+	}
+	if *pretty {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, out, "", "  "); err == nil {
+			out = buf.Bytes()
+		}
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+// sends JSON lines from stdin to the channel and closes it
+func readLines(putCh chan<- value.Value, errCh chan<- error) {
+	defer close(putCh)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		val, err := valuerpc.ParseJSON(line)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		putCh <- val
+	}
+	errCh <- scanner.Err()
+}
+
+// prints stream values until the end of stream or interrupt
+func printStream(cli valueclient.Client, readC <-chan value.Value, requestId int64) error {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	for {
+		select {
+		case <-interrupt:
+			cli.CancelRequest(requestId)
+			return nil
+		case val, ok := <-readC:
+			if !ok {
+				return nil
+			}
+			if err := printValue(os.Stdout, val); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func list(cli valueclient.Client) error {
+	functions, err := valueclient.ListFunctions(cli)
+	if err != nil {
+		return err
+	}
+	if *jsonList {
+		for _, info := range functions {
+			if err := printValue(os.Stdout, info.ToValue()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tKIND\tDESCRIPTION")
+	for _, info := range functions {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", info.Name, info.Kind, info.Description)
+	}
+	return w.Flush()
+}
+
+func run(command, address, name, argsJson string) error {
+
+	args, err := parseArgs(argsJson)
+	if err != nil {
+		return err
+	}
+
+	cli, err := connect(address)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	ctx := context.Background()
+	var trailer valuerpc.Metadata
+	opts := []valueclient.CallOption{valueclient.WithMetadata(headers), valueclient.WithTrailer(&trailer)}
+	defer func() {
+		if *showTrailer {
+			printTrailer(trailer)
+		}
+	}()
+
+	switch command {
+	case "list":
+		return list(cli)
+
+	case "call":
+		res, err := cli.CallFunctionContext(ctx, name, args, opts...)
+		if err != nil {
+			return err
+		}
+		return printValue(os.Stdout, res)
+
+	case "tail":
+		readC, requestId, err := cli.GetStreamContext(ctx, name, args, *receiveCap, opts...)
+		if err != nil {
+			return err
+		}
+		return printStream(cli, readC, requestId)
+
+	case "put":
+		putCh := make(chan value.Value, *receiveCap)
+		errCh := make(chan error, 1)
+		done := make(chan error, 1)
+		if err := cli.PutStreamContext(ctx, name, args, putCh, append(opts, valueclient.WithDone(done))...); err != nil {
+			return err
+		}
+		go readLines(putCh, errCh)
+		// done when server acknowledged the end of stream or closed the stream earlier
+		select {
+		case err := <-errCh:
+			if err != nil {
+				return err
+			}
+			return <-done
+		case err := <-done:
+			return err
+		}
+
+	case "chat":
+		putCh := make(chan value.Value, *receiveCap)
+		errCh := make(chan error, 1)
+		readC, requestId, err := cli.ChatContext(ctx, name, args, *receiveCap, putCh, opts...)
+		if err != nil {
+			return err
+		}
+		go readLines(putCh, errCh)
+		if err := printStream(cli, readC, requestId); err != nil {
+			return err
+		}
+		select {
+		case err := <-errCh:
+			return err
+		default:
+			return nil
+		}
+	}
+
+	return errors.Errorf("unknown command '%s'", command)
+}
+
+func printTrailer(trailer valuerpc.Metadata) {
+	for _, key := range trailer.Keys() {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", key, trailer[key])
+	}
+}
+
+func formatError(err error) string {
+	var out strings.Builder
+	var serverErr *valueclient.ServerError
+	if errors.As(err, &serverErr) {
+		fmt.Fprintf(&out, "Error %d, %s\n", serverErr.Code, serverErr.Message)
+		for _, v := range serverErr.Violations() {
+			fmt.Fprintf(&out, "  %s\n", v.String())
+		}
+		return out.String()
+	}
+	fmt.Fprintf(&out, "Error, %v\n", err)
+	return out.String()
+}
+
+func printError(err error) {
+	fmt.Fprint(os.Stderr, formatError(err))
+}
+
+func doMain() int {
+	flag.Usage = usage
+	flag.Parse()
+	if *token == "" {
+		*token = os.Getenv("VRPC_TOKEN")
+	}
+
+	command := flag.Arg(0)
+	var name, argsJson string
+	switch {
+	case command == "list" && flag.NArg() == 2:
+	case command == "shell" && flag.NArg() == 2:
+		if err := runShell(flag.Arg(1)); err != nil {
+			printError(err)
+			return 1
+		}
+		return 0
+	case commands[command] && (flag.NArg() == 3 || flag.NArg() == 4):
+		name = flag.Arg(2)
+		argsJson = flag.Arg(3)
+	default:
+		usage()
+		return 2
+	}
+
+	if err := run(command, flag.Arg(1), name, argsJson); err != nil {
+		printError(err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(doMain())
+}
@@ -0,0 +1,514 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var shellHelp = `Commands:
+  list                      list functions
+  describe function         show function types
+  call function [args]      call function and print result
+  sub function [args]       subscribe to GET stream in background
+  subs                      list subscriptions
+  unsub id                  cancel subscription
+  put function [args]       send next lines to PUT stream, finish by '.'
+  status                    connection, handshake and session stats
+  timeout mls               set call timeout
+  reconnect                 reconnect to the server
+  help                      show this help
+  exit                      exit the shell
+`
+
+var shellCommands = []string{"call", "describe", "exit", "help", "list", "put", "reconnect", "status", "sub", "subs", "timeout", "unsub"}
+
+// commands with function name as the first argument and kind of functions to complete
+var functionCommands = map[string]valuerpc.FunctionKind{
+	"call":     valuerpc.SingleFunctionKind,
+	"sub":      valuerpc.OutgoingStreamKind,
+	"put":      valuerpc.IncomingStreamKind,
+	"describe": "",
+}
+
+type subscription struct {
+	requestId int64
+	name      string
+	received  atomic.Int64
+}
+
+type shell struct {
+	address string
+	cli     valueclient.Client
+	term    *terminal
+	started time.Time
+
+	lock      sync.Mutex
+	functions map[string]valuerpc.FunctionInfo
+	watchGen  int64 // events of previous catalog watches are ignored
+	watchId   int64 // request of the current catalog watch
+	subs      map[int64]*subscription
+	handshake value.Map
+
+	calls    atomic.Int64
+	errors   atomic.Int64
+	received atomic.Int64
+}
+
+func runShell(address string) error {
+
+	cli, err := newClient(address)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	t := &shell{
+		address:   address,
+		cli:       cli,
+		started:   time.Now(),
+		functions: make(map[string]valuerpc.FunctionInfo),
+		subs:      make(map[int64]*subscription),
+	}
+	t.term = newTerminal(t.completeLine)
+	defer t.term.Close()
+
+	cli.SetConnectionHandler(func(resp value.Map) {
+		t.lock.Lock()
+		t.handshake = resp
+		t.lock.Unlock()
+	})
+
+	if err := cli.Connect(); err != nil {
+		return err
+	}
+	t.watchCatalog()
+
+	t.term.Printf("Connected to %s, type 'help' for commands\n", address)
+	for {
+		line, err := t.term.ReadLine("vrpc> ")
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if exit := t.execute(strings.TrimSpace(line)); exit {
+			return nil
+		}
+	}
+}
+
+// keeps function catalog for completion, servers without introspection have empty catalog,
+// the previous watch is canceled and its events are ignored
+func (t *shell) watchCatalog() {
+	t.lock.Lock()
+	prevId := t.watchId
+	t.functions = make(map[string]valuerpc.FunctionInfo)
+	t.watchGen++
+	gen := t.watchGen
+	t.lock.Unlock()
+
+	if prevId != 0 {
+		t.cli.CancelRequest(prevId)
+	}
+
+	eventC, requestId, err := valueclient.WatchFunctions(t.cli, 100)
+	if err != nil {
+		t.term.Printf("Introspection is not available, %v\n", err)
+		return
+	}
+	t.lock.Lock()
+	t.watchId = requestId
+	t.lock.Unlock()
+	go func() {
+		for event := range eventC {
+			t.lock.Lock()
+			if t.watchGen == gen {
+				if event.Op == valuerpc.RemoveEvent {
+					delete(t.functions, event.Function.Name)
+				} else {
+					t.functions[event.Function.Name] = event.Function
+				}
+			}
+			t.lock.Unlock()
+		}
+	}()
+}
+
+func (t *shell) function(name string) (valuerpc.FunctionInfo, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	info, ok := t.functions[name]
+	return info, ok
+}
+
+func (t *shell) functionNames(kind valuerpc.FunctionKind) []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var list []string
+	for name, info := range t.functions {
+		if kind == "" || info.Kind == kind {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// positions are in runes as the terminal edits runes
+func (t *shell) completeLine(line string) (int, []string) {
+	words := strings.Fields(line)
+	endsWithSpace := strings.HasSuffix(line, " ")
+
+	// command
+	if len(words) == 0 || (len(words) == 1 && !endsWithSpace) {
+		prefix := ""
+		if len(words) == 1 {
+			prefix = words[0]
+		}
+		return runeStart(line, prefix), filterPrefix(shellCommands, prefix)
+	}
+
+	kind, ok := functionCommands[words[0]]
+	if !ok {
+		return 0, nil
+	}
+
+	// function name
+	if len(words) == 1 || (len(words) == 2 && !endsWithSpace) {
+		prefix := ""
+		if len(words) == 2 {
+			prefix = words[1]
+		}
+		return runeStart(line, prefix), filterPrefix(t.functionNames(kind), prefix)
+	}
+
+	// param name in JSON args
+	info, ok := t.function(words[1])
+	if !ok {
+		return 0, nil
+	}
+	params, ok := info.Args.(valuerpc.ParamsDef)
+	if !ok {
+		return 0, nil
+	}
+	start := strings.LastIndexAny(line, " {,") + 1
+	prefix := strings.TrimPrefix(line[start:], "\"")
+	var candidates []string
+	for _, p := range params.Map {
+		if strings.HasPrefix(p.Name, prefix) {
+			candidates = append(candidates, strconv.Quote(p.Name)+":")
+		}
+	}
+	sort.Strings(candidates)
+	return utf8.RuneCountInString(line[:start]), candidates
+}
+
+// rune position of prefix at the end of line
+func runeStart(line, prefix string) int {
+	return utf8.RuneCountInString(line) - utf8.RuneCountInString(prefix)
+}
+
+func filterPrefix(list []string, prefix string) []string {
+	var out []string
+	for _, s := range list {
+		if strings.HasPrefix(s, prefix) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// splits line to command, function name and JSON args
+func splitCommand(line string) (string, string, string) {
+	command, rest := cut(line)
+	name, args := cut(rest)
+	return command, name, args
+}
+
+func cut(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i+1:])
+	}
+	return s, ""
+}
+
+// returns true to exit
+func (t *shell) execute(line string) bool {
+	if line == "" {
+		return false
+	}
+	command, name, argsJson := splitCommand(line)
+
+	var err error
+	switch command {
+	case "exit", "quit":
+		return true
+	case "help":
+		t.term.Printf("%s", shellHelp)
+	case "list":
+		err = t.list()
+	case "describe":
+		err = t.describe(name)
+	case "call":
+		err = t.call(name, argsJson)
+	case "sub":
+		err = t.subscribe(name, argsJson)
+	case "subs":
+		t.listSubscriptions()
+	case "unsub":
+		err = t.unsubscribe(name)
+	case "put":
+		err = t.put(name, argsJson)
+	case "status":
+		t.status()
+	case "timeout":
+		err = t.setTimeout(name)
+	case "reconnect":
+		if err = t.cli.Reconnect(); err == nil {
+			t.watchCatalog()
+		}
+	default:
+		err = errors.Errorf("unknown command '%s', type 'help' for commands", command)
+	}
+
+	if err != nil {
+		t.errors.Inc()
+		t.term.Printf("%s", formatError(err))
+	}
+	return false
+}
+
+func prettyJSON(val value.Value) string {
+	out, err := valuerpc.ToJSON(val)
+	if err != nil {
+		return val.String()
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, out, "", "  "); err != nil {
+		return string(out)
+	}
+	return buf.String()
+}
+
+func compactJSON(val value.Value) string {
+	out, err := valuerpc.ToJSON(val)
+	if err != nil {
+		return val.String()
+	}
+	return string(out)
+}
+
+func (t *shell) list() error {
+	list, err := valueclient.ListFunctions(t.cli)
+	if err != nil {
+		return err
+	}
+	var out strings.Builder
+	for _, info := range list {
+		fmt.Fprintf(&out, "  %-30s %-15s %s\n", info.Name, info.Kind, info.Description)
+	}
+	t.term.Printf("%s", out.String())
+	return nil
+}
+
+func (t *shell) describe(name string) error {
+	info, ok := t.function(name)
+	if !ok {
+		return errors.Errorf("function '%s' not found in catalog", name)
+	}
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s %s\n", info.Kind, info.Name)
+	if info.Description != "" {
+		fmt.Fprintf(&out, "  %s\n", info.Description)
+	}
+	if len(info.Tags) > 0 {
+		fmt.Fprintf(&out, "  tags: %s\n", strings.Join(info.Tags, ", "))
+	}
+	fmt.Fprintf(&out, "  args: %s\n", compactJSON(valuerpc.EncodeTypeDef(info.Args)))
+	fmt.Fprintf(&out, "  res:  %s\n", compactJSON(valuerpc.EncodeTypeDef(info.Res)))
+	fmt.Fprintf(&out, "  in:   %s\n", compactJSON(valuerpc.EncodeTypeDef(info.In)))
+	t.term.Printf("%s", out.String())
+	return nil
+}
+
+func (t *shell) call(name, argsJson string) error {
+	args, err := parseArgs(argsJson)
+	if err != nil {
+		return err
+	}
+	t.calls.Inc()
+	started := time.Now()
+	res, err := t.cli.CallFunction(name, args)
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		t.term.Printf("null\n")
+	} else {
+		t.term.Printf("%s\n", prettyJSON(res))
+	}
+	t.term.Printf("(%v)\n", time.Since(started).Round(time.Microsecond))
+	return nil
+}
+
+func (t *shell) subscribe(name, argsJson string) error {
+	args, err := parseArgs(argsJson)
+	if err != nil {
+		return err
+	}
+	t.calls.Inc()
+	readC, requestId, err := t.cli.GetStream(name, args, *receiveCap)
+	if err != nil {
+		return err
+	}
+
+	sub := &subscription{requestId: requestId, name: name}
+	t.lock.Lock()
+	t.subs[requestId] = sub
+	t.lock.Unlock()
+
+	t.term.Printf("subscribed %d to %s\n", requestId, name)
+	go func() {
+		for val := range readC {
+			sub.received.Inc()
+			t.received.Inc()
+			t.term.Printf("[%d %s] %s\n", requestId, name, compactJSON(val))
+		}
+		t.lock.Lock()
+		delete(t.subs, requestId)
+		t.lock.Unlock()
+		t.term.Printf("[%d %s] ended after %d values\n", requestId, name, sub.received.Load())
+	}()
+	return nil
+}
+
+func (t *shell) listSubscriptions() {
+	t.lock.Lock()
+	var list []*subscription
+	for _, sub := range t.subs {
+		list = append(list, sub)
+	}
+	t.lock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].requestId < list[j].requestId })
+	var out strings.Builder
+	for _, sub := range list {
+		fmt.Fprintf(&out, "  %d %s, received %d\n", sub.requestId, sub.name, sub.received.Load())
+	}
+	if len(list) == 0 {
+		out.WriteString("  no subscriptions\n")
+	}
+	t.term.Printf("%s", out.String())
+}
+
+func (t *shell) unsubscribe(id string) error {
+	requestId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return errors.Errorf("invalid subscription id '%s'", id)
+	}
+	t.lock.Lock()
+	_, ok := t.subs[requestId]
+	t.lock.Unlock()
+	if !ok {
+		return errors.Errorf("subscription %d not found", requestId)
+	}
+	t.cli.CancelRequest(requestId)
+	return nil
+}
+
+func (t *shell) put(name, argsJson string) error {
+	args, err := parseArgs(argsJson)
+	if err != nil {
+		return err
+	}
+	t.calls.Inc()
+	putCh := make(chan value.Value, *receiveCap)
+	if err := t.cli.PutStream(name, args, putCh); err != nil {
+		return err
+	}
+	defer close(putCh)
+
+	sent := 0
+	for {
+		line, err := t.term.ReadLine("put> ")
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "." {
+			t.term.Printf("sent %d values\n", sent)
+			return nil
+		}
+		if line == "" {
+			continue
+		}
+		val, err := valuerpc.ParseJSON([]byte(line))
+		if err != nil {
+			t.term.Printf("%s", formatError(err))
+			continue
+		}
+		putCh <- val
+		sent++
+	}
+}
+
+func (t *shell) setTimeout(mls string) error {
+	n, err := strconv.ParseInt(mls, 10, 64)
+	if err != nil || n <= 0 {
+		return errors.Errorf("invalid timeout '%s'", mls)
+	}
+	t.cli.SetTimeout(n)
+	return nil
+}
+
+func (t *shell) status() {
+	t.lock.Lock()
+	handshake := t.handshake
+	subs := len(t.subs)
+	functions := len(t.functions)
+	t.lock.Unlock()
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "  address:       %s\n", t.address)
+	fmt.Fprintf(&out, "  connected:     %v\n", t.cli.IsActive())
+	fmt.Fprintf(&out, "  client id:     %d\n", t.cli.ClientId())
+	if handshake != nil {
+		fmt.Fprintf(&out, "  handshake:     %s\n", compactJSON(handshake))
+	}
+	fmt.Fprintf(&out, "  functions:     %d\n", functions)
+	fmt.Fprintf(&out, "  session:       %v\n", time.Since(t.started).Round(time.Second))
+	fmt.Fprintf(&out, "  calls:         %d\n", t.calls.Load())
+	fmt.Fprintf(&out, "  errors:        %d\n", t.errors.Load())
+	fmt.Fprintf(&out, "  subscriptions: %d, received %d values\n", subs, t.received.Load())
+
+	stats := t.cli.Stats()
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&out, "  %-14s %d\n", key+":", stats[key])
+	}
+	t.term.Printf("%s", out.String())
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/**
+Minimal line editor for the shell: history by up and down arrows, tab completion, Ctrl-C clears line, Ctrl-D on empty line exits.
+Terminal is switched to non-canonical mode by stty, without terminal the input is read by lines.
+*/
+
+var historyFile = ".vrpc_history"
+var historyLimit = 1000
+
+// returns position in runes of line where the completed word starts and candidates for it
+type completer func(line string) (int, []string)
+
+type terminal struct {
+	in       *bufio.Reader
+	out      io.Writer
+	complete completer
+
+	lock    sync.Mutex // guards output, line and prompt
+	prompt  string
+	line    []rune
+	reading bool
+
+	ttyState string // stty state to restore, empty if not a terminal
+	history  []string
+	histPos  int
+}
+
+func newTerminal(complete completer) *terminal {
+	t := &terminal{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		complete: complete,
+	}
+	if state, err := stty("-g"); err == nil {
+		if _, err := stty("-icanon", "-echo", "-isig", "min", "1", "time", "0"); err == nil {
+			t.ttyState = strings.TrimSpace(state)
+		}
+	}
+	t.loadHistory()
+	return t
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+func (t *terminal) Close() {
+	if t.ttyState != "" {
+		stty(t.ttyState)
+	}
+}
+
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, historyFile)
+}
+
+func (t *terminal) loadHistory() {
+	path := historyPath()
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			t.history = append(t.history, line)
+		}
+	}
+	if len(t.history) > historyLimit {
+		t.history = t.history[len(t.history)-historyLimit:]
+	}
+}
+
+func (t *terminal) addHistory(line string) {
+	if line == "" || (len(t.history) > 0 && t.history[len(t.history)-1] == line) {
+		return
+	}
+	t.history = append(t.history, line)
+	if path := historyPath(); path != "" {
+		if f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err == nil {
+			fmt.Fprintln(f, line)
+			f.Close()
+		}
+	}
+}
+
+// prints message above the line being edited
+func (t *terminal) Printf(format string, args ...interface{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.reading && t.ttyState != "" {
+		io.WriteString(t.out, "\r\033[K")
+		fmt.Fprintf(t.out, format, args...)
+		t.redraw()
+	} else {
+		fmt.Fprintf(t.out, format, args...)
+	}
+}
+
+// must be called under lock
+func (t *terminal) redraw() {
+	io.WriteString(t.out, "\r\033[K"+t.prompt+string(t.line))
+}
+
+func (t *terminal) ReadLine(prompt string) (string, error) {
+	t.lock.Lock()
+	t.prompt = prompt
+	t.line = t.line[:0]
+	t.histPos = len(t.history)
+	t.reading = true
+	io.WriteString(t.out, prompt)
+	t.lock.Unlock()
+
+	defer func() {
+		t.lock.Lock()
+		t.reading = false
+		t.lock.Unlock()
+	}()
+
+	if t.ttyState == "" {
+		line, err := t.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	for {
+		r, _, err := t.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		if done, line, err := t.key(r); done {
+			return line, err
+		}
+	}
+}
+
+// handles key, returns true when the line is finished
+func (t *terminal) key(r rune) (bool, string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	switch r {
+	case '\r', '\n':
+		line := string(t.line)
+		io.WriteString(t.out, "\n")
+		t.addHistory(strings.TrimSpace(line))
+		return true, line, nil
+	case 4: // Ctrl-D
+		if len(t.line) == 0 {
+			io.WriteString(t.out, "\n")
+			return true, "", io.EOF
+		}
+	case 3: // Ctrl-C
+		io.WriteString(t.out, "^C\n")
+		t.line = t.line[:0]
+		io.WriteString(t.out, t.prompt)
+	case 21: // Ctrl-U
+		t.line = t.line[:0]
+		t.redraw()
+	case 127, 8: // backspace
+		if len(t.line) > 0 {
+			t.line = t.line[:len(t.line)-1]
+			t.redraw()
+		}
+	case '\t':
+		t.completeLine()
+	case 27: // escape sequence
+		t.escape()
+	default:
+		if r >= 32 {
+			t.line = append(t.line, r)
+			io.WriteString(t.out, string(r))
+		}
+	}
+	return false, "", nil
+}
+
+// must be called under lock, supports only up and down arrows
+func (t *terminal) escape() {
+	if b, err := t.in.ReadByte(); err != nil || b != '[' {
+		return
+	}
+	b, err := t.in.ReadByte()
+	if err != nil {
+		return
+	}
+	switch b {
+	case 'A':
+		if t.histPos > 0 {
+			t.histPos--
+			t.line = []rune(t.history[t.histPos])
+			t.redraw()
+		}
+	case 'B':
+		if t.histPos < len(t.history) {
+			t.histPos++
+			if t.histPos == len(t.history) {
+				t.line = t.line[:0]
+			} else {
+				t.line = []rune(t.history[t.histPos])
+			}
+			t.redraw()
+		}
+	}
+}
+
+// must be called under lock
+func (t *terminal) completeLine() {
+	if t.complete == nil {
+		return
+	}
+	start, candidates := t.complete(string(t.line))
+	if len(candidates) == 0 || start < 0 || start > len(t.line) {
+		return
+	}
+	prefix := []rune(commonPrefix(candidates))
+	if len(prefix) > len(t.line)-start {
+		t.line = append(t.line[:start:start], prefix...)
+		if len(candidates) == 1 && prefix[len(prefix)-1] != ':' {
+			t.line = append(t.line, ' ')
+		}
+		t.redraw()
+		return
+	}
+	if len(candidates) > 1 {
+		io.WriteString(t.out, "\n"+strings.Join(candidates, "  ")+"\n")
+		t.redraw()
+	}
+}
+
+func commonPrefix(list []string) string {
+	prefix := []rune(list[0])
+	for _, s := range list[1:] {
+		for !strings.HasPrefix(s, string(prefix)) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return string(prefix)
+}
@@ -0,0 +1,8 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package names
+
+//go:generate go run ../../cmd/vrpc-gen names.vrpc
@@ -6,12 +6,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/codeallergy/value"
 	"github.com/codeallergy/value-rpc/valueclient"
 	"github.com/codeallergy/value-rpc/valuerpc"
 	"github.com/codeallergy/value-rpc/valueserver"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 	"os"
 	"sync"
 	"time"
@@ -23,11 +25,15 @@ var testAddress = "localhost:9999"
 var firstName = ""
 var lastName = ""
 
-func setName(args value.Value) (value.Value, error) {
+type fullName struct {
+	FirstName string `vrpc:"0"`
+	LastName  string `vrpc:"1"`
+}
+
+func setName(name fullName) (value.Value, error) {
 
-	listArgs := args.(value.List)
-	firstName = listArgs.GetStringAt(0).String()
-	lastName = listArgs.GetStringAt(1).String()
+	firstName = name.FirstName
+	lastName = name.LastName
 
 	return nil, nil
 }
@@ -112,20 +118,22 @@ func run() error {
 	}
 	defer srv.Close()
 
-	srv.AddFunction("setName",
-		valuerpc.List(valuerpc.String, valuerpc.String),
-		valuerpc.Void, setName)
+	valueserver.AddTypedFunction(srv, "setName", setName)
 
 	srv.AddFunction("getName", valuerpc.Void, valuerpc.String, getName)
-	srv.AddOutgoingStream("scanNames", valuerpc.Void, scanNames)
-	srv.AddIncomingStream("uploadNames", valuerpc.Void, uploadNames)
-	srv.AddChat("echoChat", valuerpc.Void, echoChat)
+	srv.AddOutgoingStreamOf("scanNames", valuerpc.Void, valuerpc.String, scanNames)
+	srv.AddIncomingStreamOf("uploadNames", valuerpc.Void, valuerpc.String, uploadNames)
+	srv.AddChatOf("echoChat", valuerpc.Void, valuerpc.String, valuerpc.String, echoChat)
 
 	go srv.Run()
 
 	var wg sync.WaitGroup
 
 	cli := valueclient.NewClient(testAddress, "")
+	cli.SetReverseServer(valueserver.NewListenerServer(nil, zap.NewNop()))
+	cli.Registry().AddFunction("clientTime", valuerpc.Void, valuerpc.String, func(args value.Value) (value.Value, error) {
+		return value.Utf8(time.Now().Format(time.RFC3339)), nil
+	})
 	err = cli.Connect()
 	if err != nil {
 		return err
@@ -135,10 +143,10 @@ func run() error {
 	Simple call example
 	*/
 
-	nothing, err := cli.CallFunction("setName", value.Tuple(
-		value.Utf8("Alex"),
-		value.Utf8("Shu"),
-	))
+	nothing, err := valueclient.Call[fullName, value.Value](cli, "setName", fullName{
+		FirstName: "Alex",
+		LastName:  "Shu",
+	})
 
 	if nothing != nil || err != nil {
 		return errors.Errorf("something wrong, %v", err)
@@ -236,6 +244,22 @@ func run() error {
 	fmt.Println("Chat client send: <END>")
 
 	wg.Wait()
+
+	/**
+	Reverse call example, server calls function registered on client
+	*/
+
+	caller, ok := srv.Client(cli.ClientId())
+	if !ok {
+		return errors.Errorf("client %d not found on server", cli.ClientId())
+	}
+	fmt.Printf("Client functions: %v\n", caller.Functions())
+	clientTime, err := caller.CallFunction(context.Background(), "clientTime", nil)
+	if err != nil {
+		return errors.Errorf("reverse call failed, %v", err)
+	}
+	fmt.Println("Client time: " + clientTime.String())
+
 	fmt.Println("Client <END>")
 
 	// wait while server free session and see logs
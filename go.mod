@@ -1,6 +1,6 @@
 module github.com/codeallergy/value-rpc
 
-go 1.17
+go 1.18
 
 require (
 	github.com/codeallergy/value v1.1.0
@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuebench
+
+import (
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valueserver"
+	"strings"
+)
+
+/**
+Built-in benchmark handlers, all types are Any to measure framing and dispatch without user logic.
+*/
+
+var EchoFunction = "bench.echo"     // function, returns args
+var SourceFunction = "bench.source" // GET, args {"count": n, "size": bytes}, streams count values
+var SinkFunction = "bench.sink"     // PUT, drains incoming values
+var ChatFunction = "bench.chat"     // chat, echoes every incoming value
+
+var CountField = "count"
+var SizeField = "size"
+
+var MaxSourceSize = int64(16 << 20)
+
+func Payload(size int) value.Value {
+	return value.Utf8(strings.Repeat("x", size))
+}
+
+func SourceArgs(count, size int) value.Map {
+	return value.EmptyMap().
+		Put(CountField, value.Long(int64(count))).
+		Put(SizeField, value.Long(int64(size)))
+}
+
+func sourceArgs(args value.Value) (int64, int64) {
+	if args == nil || args.Kind() != value.MAP {
+		return 0, 0
+	}
+	m := args.(value.Map)
+	var count, size int64
+	if n := m.GetNumber(CountField); n != nil {
+		count = n.Long()
+	}
+	if n := m.GetNumber(SizeField); n != nil {
+		size = n.Long()
+	}
+	if size < 0 {
+		size = 0
+	}
+	if size > MaxSourceSize {
+		size = MaxSourceSize
+	}
+	return count, size
+}
+
+// registers benchmark handlers in the server
+func Register(srv valueserver.Server) error {
+
+	if err := srv.AddFunction(EchoFunction, valuerpc.Any, valuerpc.Any, func(args value.Value) (value.Value, error) {
+		return args, nil
+	}); err != nil {
+		return err
+	}
+
+	if err := srv.AddOutgoingStream(SourceFunction, valuerpc.Any, func(args value.Value) (<-chan value.Value, error) {
+		count, size := sourceArgs(args)
+		payload := Payload(int(size))
+		outC := make(chan value.Value)
+		go func() {
+			defer close(outC)
+			for i := int64(0); i < count; i++ {
+				outC <- payload
+			}
+		}()
+		return outC, nil
+	}); err != nil {
+		return err
+	}
+
+	if err := srv.AddIncomingStream(SinkFunction, valuerpc.Any, func(args value.Value, inC <-chan value.Value) error {
+		go func() {
+			for range inC {
+			}
+		}()
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := srv.AddChat(ChatFunction, valuerpc.Any, func(args value.Value, inC <-chan value.Value) (<-chan value.Value, error) {
+		outC := make(chan value.Value)
+		go func() {
+			defer close(outC)
+			for val := range inC {
+				outC <- val
+			}
+		}()
+		return outC, nil
+	}); err != nil {
+		return err
+	}
+
+	for name, description := range map[string]string{
+		EchoFunction:   "returns args",
+		SourceFunction: "streams count values of size bytes",
+		SinkFunction:   "drains incoming values",
+		ChatFunction:   "echoes every incoming value",
+	} {
+		srv.DescribeFunction(name, description, "bench")
+	}
+	return nil
+}
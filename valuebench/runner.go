@@ -0,0 +1,452 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuebench
+
+import (
+	"context"
+	"fmt"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+	"io"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type Op string
+
+const (
+	CallOp Op = "call"
+	GetOp  Op = "get"
+	PutOp  Op = "put"
+	ChatOp Op = "chat"
+)
+
+var Ops = []Op{CallOp, GetOp, PutOp, ChatOp}
+
+var StatsInterval = 10 * time.Millisecond
+
+type Config struct {
+	Mix          map[Op]int    // weights of operations
+	Concurrency  int           // parallel workers
+	Duration     time.Duration // stops after duration if positive
+	Requests     int64         // stops after number of operations if positive
+	PayloadSize  int           // bytes in args and stream values
+	StreamLength int           // values in GET, PUT and chat streams
+	ReceiveCap   int           // receive buffer of GET and chat
+}
+
+var DefaultConfig = Config{
+	Mix:          map[Op]int{CallOp: 1},
+	Concurrency:  8,
+	Duration:     10 * time.Second,
+	PayloadSize:  64,
+	StreamLength: 100,
+	ReceiveCap:   100,
+}
+
+// parses mix like "call=70,get=10,put=10,chat=10", weight is optional and is 1 by default
+func ParseMix(s string) (map[Op]int, error) {
+	mix := make(map[Op]int)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, weight, hasWeight := strings.Cut(item, "=")
+		op := Op(strings.TrimSpace(name))
+		known := false
+		for _, o := range Ops {
+			known = known || o == op
+		}
+		if !known {
+			return nil, errors.Errorf("unknown operation '%s'", op)
+		}
+		w := 1
+		if hasWeight {
+			var err error
+			if w, err = strconv.Atoi(strings.TrimSpace(weight)); err != nil || w < 0 {
+				return nil, errors.Errorf("invalid weight of '%s'", op)
+			}
+		}
+		mix[op] += w
+	}
+	return mix, nil
+}
+
+type OpReport struct {
+	Op        Op
+	Count     int64
+	Errors    int64
+	Values    int64           // stream values sent and received
+	Latencies []time.Duration `json:"-"`
+	P50       time.Duration
+	P90       time.Duration
+	P99       time.Duration
+	P999      time.Duration
+	Max       time.Duration
+	FirstErr  string `json:",omitempty"`
+}
+
+type Report struct {
+	Elapsed          time.Duration
+	Ops              []*OpReport
+	Mallocs          uint64 // in this process, includes server in local benchmark
+	AllocBytes       uint64
+	ThrottleSent     int64         // client asked server to slow down or speed up
+	ThrottleReceived int64         // server asked client to slow down or speed up
+	PutBlocked       time.Duration // total time PUT and chat producers waited for the client
+	MaxSendingLen    int64         // max sampled length of client sending queue
+}
+
+func (t *Report) Total() (count, errs int64) {
+	for _, op := range t.Ops {
+		count += op.Count
+		errs += op.Errors
+	}
+	return
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func (t *OpReport) finish() {
+	sort.Slice(t.Latencies, func(i, j int) bool { return t.Latencies[i] < t.Latencies[j] })
+	t.P50 = percentile(t.Latencies, 0.5)
+	t.P90 = percentile(t.Latencies, 0.9)
+	t.P99 = percentile(t.Latencies, 0.99)
+	t.P999 = percentile(t.Latencies, 0.999)
+	if n := len(t.Latencies); n > 0 {
+		t.Max = t.Latencies[n-1]
+	}
+}
+
+func (t *Report) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "OP\tCOUNT\tERRORS\tOPS/S\tVALUES/S\tP50\tP90\tP99\tP99.9\tMAX\t")
+	seconds := t.Elapsed.Seconds()
+	for _, op := range t.Ops {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.0f\t%.0f\t%v\t%v\t%v\t%v\t%v\t\n", op.Op, op.Count, op.Errors,
+			float64(op.Count)/seconds, float64(op.Values)/seconds,
+			round(op.P50), round(op.P90), round(op.P99), round(op.P999), round(op.Max))
+	}
+	tw.Flush()
+
+	count, errs := t.Total()
+	fmt.Fprintf(w, "\n%d operations, %d errors in %v, %.0f ops/s\n", count, errs, t.Elapsed.Round(time.Millisecond), float64(count)/seconds)
+	if count > 0 {
+		fmt.Fprintf(w, "allocations %d (%d per op), %d bytes (%d per op)\n", t.Mallocs, t.Mallocs/uint64(count), t.AllocBytes, t.AllocBytes/uint64(count))
+	}
+	fmt.Fprintf(w, "backpressure: throttle sent %d, received %d, producers blocked %v, max sending queue %d\n",
+		t.ThrottleSent, t.ThrottleReceived, t.PutBlocked.Round(time.Microsecond), t.MaxSendingLen)
+	for _, op := range t.Ops {
+		if op.FirstErr != "" {
+			fmt.Fprintf(w, "%s first error: %s\n", op.Op, op.FirstErr)
+		}
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+type runner struct {
+	cli     valueclient.Client
+	cfg     Config
+	payload value.Value
+	source  value.Value
+
+	ops       []Op // weighted list to pick from
+	issued    atomic.Int64
+	deadline  time.Time
+	blockedNs atomic.Int64
+}
+
+type workerStats map[Op]*OpReport
+
+func (t workerStats) get(op Op) *OpReport {
+	r, ok := t[op]
+	if !ok {
+		r = &OpReport{Op: op}
+		t[op] = r
+	}
+	return r
+}
+
+// runs benchmark on connected client against server with built-in handlers
+func Run(cli valueclient.Client, cfg Config) (*Report, error) {
+
+	if cfg.Concurrency <= 0 {
+		return nil, errors.New("concurrency must be positive")
+	}
+	if cfg.Duration <= 0 && cfg.Requests <= 0 {
+		return nil, errors.New("duration or number of requests is required")
+	}
+
+	t := &runner{
+		cli:     cli,
+		cfg:     cfg,
+		payload: Payload(cfg.PayloadSize),
+		source:  SourceArgs(cfg.StreamLength, cfg.PayloadSize),
+	}
+	for _, op := range Ops {
+		for i := 0; i < cfg.Mix[op]; i++ {
+			t.ops = append(t.ops, op)
+		}
+	}
+	if len(t.ops) == 0 {
+		return nil, errors.New("empty operations mix")
+	}
+
+	statsBefore := cli.Stats()
+	var memBefore, memAfter runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&memBefore)
+
+	var maxSendingLen atomic.Int64
+	stopSampling := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(StatsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopSampling:
+				return
+			case <-ticker.C:
+				if n := cli.Stats()["sendingLen"]; n > maxSendingLen.Load() {
+					maxSendingLen.Store(n)
+				}
+			}
+		}
+	}()
+
+	start := time.Now()
+	if cfg.Duration > 0 {
+		t.deadline = start.Add(cfg.Duration)
+	}
+
+	results := make([]workerStats, cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = t.work(rand.New(rand.NewSource(start.UnixNano() + int64(i))))
+		}(i)
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	close(stopSampling)
+	<-sampled
+	runtime.ReadMemStats(&memAfter)
+	statsAfter := cli.Stats()
+
+	report := &Report{
+		Elapsed:          elapsed,
+		Mallocs:          memAfter.Mallocs - memBefore.Mallocs,
+		AllocBytes:       memAfter.TotalAlloc - memBefore.TotalAlloc,
+		ThrottleSent:     statsAfter["throttleSent"] - statsBefore["throttleSent"],
+		ThrottleReceived: statsAfter["throttleReceived"] - statsBefore["throttleReceived"],
+		PutBlocked:       time.Duration(t.blockedNs.Load()),
+		MaxSendingLen:    maxSendingLen.Load(),
+	}
+	for _, op := range Ops {
+		if cfg.Mix[op] == 0 {
+			continue
+		}
+		total := &OpReport{Op: op}
+		for _, ws := range results {
+			r, ok := ws[op]
+			if !ok {
+				continue
+			}
+			total.Count += r.Count
+			total.Errors += r.Errors
+			total.Values += r.Values
+			total.Latencies = append(total.Latencies, r.Latencies...)
+			if total.FirstErr == "" {
+				total.FirstErr = r.FirstErr
+			}
+		}
+		total.finish()
+		report.Ops = append(report.Ops, total)
+	}
+	return report, nil
+}
+
+func (t *runner) next() bool {
+	if !t.deadline.IsZero() && time.Now().After(t.deadline) {
+		return false
+	}
+	if t.cfg.Requests > 0 && t.issued.Inc() > t.cfg.Requests {
+		return false
+	}
+	return true
+}
+
+func (t *runner) work(rnd *rand.Rand) workerStats {
+	stats := make(workerStats)
+	for t.next() {
+		op := t.ops[rnd.Intn(len(t.ops))]
+		start := time.Now()
+		values, err := t.do(op)
+		r := stats.get(op)
+		r.Count++
+		r.Values += values
+		if err != nil {
+			r.Errors++
+			if r.FirstErr == "" {
+				r.FirstErr = err.Error()
+			}
+			continue
+		}
+		r.Latencies = append(r.Latencies, time.Since(start))
+	}
+	return stats
+}
+
+// returns number of streamed values
+func (t *runner) do(op Op) (int64, error) {
+	switch op {
+	case CallOp:
+		_, err := t.cli.CallFunction(EchoFunction, t.payload)
+		return 0, err
+	case GetOp:
+		return t.get()
+	case PutOp:
+		return t.put()
+	case ChatOp:
+		return t.chat()
+	}
+	return 0, errors.Errorf("unknown operation '%s'", op)
+}
+
+func (t *runner) get() (int64, error) {
+	readC, _, err := t.cli.GetStream(SourceFunction, t.source, t.cfg.ReceiveCap)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for range readC {
+		n++
+	}
+	if n != int64(t.cfg.StreamLength) {
+		return n, errors.Errorf("received %d values of %d", n, t.cfg.StreamLength)
+	}
+	return n, nil
+}
+
+// sends value to the client stream, time waited is backpressure
+func (t *runner) send(putC chan<- value.Value, val value.Value) {
+	select {
+	case putC <- val:
+		return
+	default:
+	}
+	start := time.Now()
+	putC <- val
+	t.blockedNs.Add(int64(time.Since(start)))
+}
+
+// latency includes the acknowledgment of the end of stream, so the server received every value
+func (t *runner) put() (int64, error) {
+	putC := make(chan value.Value)
+	done := make(chan error, 1)
+	if err := t.cli.PutStreamContext(context.Background(), SinkFunction, nil, putC, valueclient.WithDone(done)); err != nil {
+		return 0, err
+	}
+	for i := 0; i < t.cfg.StreamLength; i++ {
+		t.send(putC, t.payload)
+	}
+	close(putC)
+	if err := <-done; err != nil {
+		return 0, err
+	}
+	return int64(t.cfg.StreamLength), nil
+}
+
+func (t *runner) chat() (int64, error) {
+	putC := make(chan value.Value)
+	readC, _, err := t.cli.Chat(ChatFunction, nil, t.cfg.ReceiveCap, putC)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	defer func() {
+		for range readC {
+		}
+	}()
+	defer close(putC)
+	for i := 0; i < t.cfg.StreamLength; i++ {
+		t.send(putC, t.payload)
+		n++
+		if _, ok := <-readC; !ok {
+			return n, errors.Errorf("chat closed after %d values of %d", i, t.cfg.StreamLength)
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (t *Report) op(op Op) *OpReport {
+	for _, r := range t.Ops {
+		if r.Op == op {
+			return r
+		}
+	}
+	return nil
+}
+
+// regressions of current report against baseline, tolerance 0.1 allows 10% worse throughput and p99 latency
+func Compare(baseline, current *Report, tolerance float64) []string {
+	var list []string
+	for _, base := range baseline.Ops {
+		cur := current.op(base.Op)
+		if cur == nil {
+			list = append(list, fmt.Sprintf("%s: missing in current run", base.Op))
+			continue
+		}
+		baseRate := float64(base.Count) / baseline.Elapsed.Seconds()
+		curRate := float64(cur.Count) / current.Elapsed.Seconds()
+		if curRate < baseRate*(1-tolerance) {
+			list = append(list, fmt.Sprintf("%s: throughput %.0f ops/s, baseline %.0f ops/s", base.Op, curRate, baseRate))
+		}
+		if float64(cur.P99) > float64(base.P99)*(1+tolerance) {
+			list = append(list, fmt.Sprintf("%s: p99 %v, baseline %v", base.Op, round(cur.P99), round(base.P99)))
+		}
+	}
+	baseCount, _ := baseline.Total()
+	curCount, _ := current.Total()
+	if baseCount > 0 && curCount > 0 {
+		baseAllocs := float64(baseline.Mallocs) / float64(baseCount)
+		curAllocs := float64(current.Mallocs) / float64(curCount)
+		if curAllocs > baseAllocs*(1+tolerance) {
+			list = append(list, fmt.Sprintf("allocations %.1f per op, baseline %.1f", curAllocs, baseAllocs))
+		}
+	}
+	return list
+}
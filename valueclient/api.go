@@ -7,13 +7,18 @@ package valueclient
 
 
 import (
+	"context"
 	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
 )
 
 // must be fast function
 type PerformanceMonitor func(name string, elapsed int64)
 type ConnectionHandler func(connected value.Map)
 
+// runs before every call is sent, md is the metadata of the request and could be changed, error fails the call
+type Interceptor func(ctx context.Context, name string, kind valuerpc.FunctionKind, md valuerpc.Metadata) error
+
 type Client interface {
 	ClientId() int64
 
@@ -25,6 +30,9 @@ type Client interface {
 
 	Stats() map[string]int64
 
+	// Prometheus metrics of the client, mount Handler() on /metrics
+	Metrics() *valuerpc.Metrics
+
 	SetMonitor(PerformanceMonitor)
 
 	SetConnectionHandler(ConnectionHandler)
@@ -33,6 +41,28 @@ type Client interface {
 
 	SetTimeout(timeoutMls int64)
 
+	// used on next Connect or Reconnect, socks5 proxy dials through it
+	SetDialer(Dialer)
+
+	// auth token sent in handshake on next Connect or Reconnect
+	SetToken(token string)
+
+	// records traffic of next connections, nil stops recording
+	SetRecorder(rec *valuerpc.Recorder)
+
+	// creates client spans of calls and streams, nil restores valuerpc.NoopTracer
+	SetTracer(tracer valuerpc.Tracer)
+
+	// interceptors run in order of adding
+	AddInterceptor(Interceptor)
+
+	// embedded server of reverse calls, e.g. valueserver.NewListenerServer(nil, logger), only the first one is used
+	SetReverseServer(srv valuerpc.ReverseServer)
+
+	// functions served for reverse calls of server, registered before Connect are advertised in handshake,
+	// adding fails with ErrReverseDisabled until SetReverseServer
+	Registry() valuerpc.Registry
+
 	CancelRequest(requestId int64)
 
 	CallFunction(name string, args value.Value) (value.Value, error)
@@ -43,5 +73,15 @@ type Client interface {
 
 	Chat(name string, args value.Value, receiveCap int, putCh <-chan value.Value) (<-chan value.Value, int64, error)
 
+	// ctx carries the trace and outgoing metadata of the caller, done ctx cancels waiting for the first response
+	CallFunctionContext(ctx context.Context, name string, args value.Value, opts ...CallOption) (value.Value, error)
+
+	GetStreamContext(ctx context.Context, name string, args value.Value, receiveCap int, opts ...CallOption) (<-chan value.Value, int64, error)
+
+	// done ctx cancels PUT stream and chat until the end of stream is sent
+	PutStreamContext(ctx context.Context, name string, args value.Value, putCh <-chan value.Value, opts ...CallOption) error
+
+	ChatContext(ctx context.Context, name string, args value.Value, receiveCap int, putCh <-chan value.Value, opts ...CallOption) (<-chan value.Value, int64, error)
+
 	Close() error
 }
@@ -6,9 +6,9 @@
 package valueclient
 
 import (
+	"context"
 	"github.com/codeallergy/value"
 	"github.com/codeallergy/value-rpc/valuerpc"
-	"github.com/pkg/errors"
 	"go.uber.org/atomic"
 	"log"
 	"math/rand"
@@ -20,6 +20,10 @@ import (
 
 type responseHandler func(resp value.Map)
 
+type dialerHolder struct {
+	value Dialer
+}
+
 var DefaultSendingCap = int64(1024)
 var DefaultTimeoutMls = int64(1000) // one second
 
@@ -31,14 +35,25 @@ type rpcClient struct {
 	conn              *syncConn
 	lastRequest       atomic.Int64
 	reconnects        atomic.Int64
+	throttleSent      atomic.Int64 // throttle messages asking server to slow down or speed up
+	throttleReceived  atomic.Int64
 	requestCtxMap     sync.Map
 	connectionHandler atomic.Value
 	errorHandler      atomic.Value
+	dialer            atomic.Value
+	token             atomic.String
+	recorder          atomic.Value // *valuerpc.Recorder
 	timeoutMls        atomic.Int64
 	perfMonitor       atomic.Value
 	shuttingDown      atomic.Bool
+	metrics           *clientMetrics
+	tracer            valuerpc.TracerHolder
+	interceptors      interceptorHolder
+	reverse           atomic.Value // *reverseServer
+	reverseOnce       sync.Once
 }
 
+// address is host:port for TCP, or ws:// and wss:// URL for WebSocket
 func NewClient(address, socks5 string) Client {
 
 	t := &rpcClient{
@@ -49,6 +64,7 @@ func NewClient(address, socks5 string) Client {
 		conn:       NewSyncConn(),
 	}
 
+	t.metrics = newClientMetrics(t)
 	t.timeoutMls.Store(DefaultTimeoutMls)
 	return t
 }
@@ -65,17 +81,23 @@ func (t *rpcClient) Stats() map[string]int64 {
 	}
 
 	return map[string]int64{
-		"requests":   t.lastRequest.Load(),
-		"reconnects": t.reconnects.Load(),
-		"sendingLen": int64(sendingLen),
-		"sendingCap": int64(sendingCap),
+		"requests":         t.lastRequest.Load(),
+		"reconnects":       t.reconnects.Load(),
+		"sendingLen":       int64(sendingLen),
+		"sendingCap":       int64(sendingCap),
+		"throttleSent":     t.throttleSent.Load(),
+		"throttleReceived": t.throttleReceived.Load(),
 	}
 }
 
 func (t *rpcClient) Close() error {
-	t.errorHandler.Store(t)
+	t.errorHandler.Store(errorHandlerHolder{t})
 	t.shuttingDown.Store(true)
+	if t.conn.hasConn() {
+		t.conn.getConn().flush(CloseFlushTimeout)
+	}
 	t.conn.reset()
+	t.closeReverse()
 	return nil
 }
 
@@ -93,16 +115,56 @@ func (t *rpcClient) SetConnectionHandler(ch ConnectionHandler) {
 	t.connectionHandler.Store(ch)
 }
 
+// atomic.Value requires the same concrete type in every Store
+type errorHandlerHolder struct {
+	ErrorHandler
+}
+
 func (t *rpcClient) getErrorHandler() ErrorHandler {
 	eh := t.errorHandler.Load()
 	if eh != nil {
-		return eh.(ErrorHandler)
+		return eh.(errorHandlerHolder).ErrorHandler
 	}
 	return t
 }
 
 func (t *rpcClient) SetErrorHandler(eh ErrorHandler) {
-	t.errorHandler.Store(eh)
+	t.errorHandler.Store(errorHandlerHolder{eh})
+}
+
+func (t *rpcClient) getDialer() Dialer {
+	if d := t.dialer.Load(); d != nil {
+		return d.(dialerHolder).value
+	}
+	return nil
+}
+
+func (t *rpcClient) SetDialer(dialer Dialer) {
+	t.dialer.Store(dialerHolder{dialer})
+}
+
+func (t *rpcClient) SetToken(token string) {
+	t.token.Store(token)
+}
+
+func (t *rpcClient) SetRecorder(rec *valuerpc.Recorder) {
+	t.recorder.Store(rec)
+}
+
+func (t *rpcClient) getRecorder() *valuerpc.Recorder {
+	rec, _ := t.recorder.Load().(*valuerpc.Recorder)
+	return rec
+}
+
+func (t *rpcClient) handshakeRequest() value.Map {
+	req := valuerpc.NewHandshakeRequest(t.clientId)
+	if token := t.token.Load(); token != "" {
+		req = req.Put(valuerpc.TokenField, value.Utf8(token))
+	}
+	if fns := t.advertisedFunctions(); fns != nil {
+		req = req.Put(valuerpc.FunctionsField, fns)
+	}
+	return req
 }
 
 func (t *rpcClient) SetMonitor(perfMonitor PerformanceMonitor) {
@@ -133,6 +195,11 @@ func (t *rpcClient) ProtocolError(rest value.Map, err error) {
 	log.Println(out.String())
 }
 
+func (t *rpcClient) protocolError(resp value.Map, err error) {
+	t.metrics.protocolErrors.Inc()
+	t.getErrorHandler().ProtocolError(resp, err)
+}
+
 func (t *rpcClient) StreamError(requestId int64, err error) {
 	log.Printf("ERROR: in-stream error for request %d, %v\n", requestId, err)
 }
@@ -145,7 +212,8 @@ func (t *rpcClient) Connect() error {
 	if t.conn.hasConn() {
 		return nil
 	}
-	return t.conn.connect(t.address, t.socks5, t.clientId, t.sendingCap, t.getResponseHandler(), t.getErrorHandler())
+	dialer := countingDialer{t.getDialer(), &t.metrics.bytes}
+	return t.conn.connect(dialer, t.getRecorder(), t.address, t.socks5, t.handshakeRequest(), t.sendingCap, t.getResponseHandler(), t.getErrorHandler())
 }
 
 func (t *rpcClient) Reconnect() error {
@@ -166,49 +234,58 @@ func (t *rpcClient) processResponse(mt valuerpc.MessageType, resp value.Map, req
 
 	case valuerpc.FunctionResponse:
 		result, _ := resp.Get(valuerpc.ResultField)
+		requestCtx.receiveTrailer(resp)
 		requestCtx.notifyResult(result)
 		t.sendMetrics(requestCtx)
 		requestCtx.Close()
-		t.requestCtxMap.Delete(requestCtx.requestId)
+		t.finishRequest(requestCtx)
 
 	case valuerpc.ErrorResponse:
-		err := resp.GetString(valuerpc.ErrorField)
-		serverErr := errors.Errorf("SERVER_FUNC_ERROR %v", err)
+		serverErr := NewServerError(resp)
+		requestCtx.receiveTrailer(resp)
 		requestCtx.SetError(serverErr)
+		requestCtx.span.SetAttributes(valuerpc.Attribute(valuerpc.ErrorCodeAttr, serverErr.Code.String()))
+		requestCtx.span.SetError(serverErr)
 		t.getErrorHandler().StreamError(requestCtx.requestId, serverErr)
 		requestCtx.Close()
-		t.requestCtxMap.Delete(requestCtx.requestId)
+		t.finishRequest(requestCtx)
 
 	case valuerpc.StreamReady:
 		requestCtx.notifyResult(nil)
 
 	case valuerpc.StreamValue:
 		value, _ := resp.Get(valuerpc.ValueField)
+		requestCtx.streamEvent(valuerpc.StreamValueEvent, valuerpc.InDirection)
 		requestCtx.notifyResult(value)
 		t.regulateIncomingStream(requestCtx)
 
 	case valuerpc.StreamEnd:
 		value, _ := resp.Get(valuerpc.ValueField)
+		requestCtx.streamEvent(valuerpc.StreamEndEvent, valuerpc.InDirection)
+		requestCtx.receiveTrailer(resp)
 		if value != nil {
 			requestCtx.notifyResult(value)
 		}
 		if requestCtx.TryGetClose() {
-			t.requestCtxMap.Delete(requestCtx.requestId)
+			t.finishRequest(requestCtx)
 		}
 
 	case valuerpc.CancelRequest:
+		requestCtx.span.AddEvent(valuerpc.CancelEvent, valuerpc.Attribute(valuerpc.DirectionAttr, valuerpc.InDirection))
 		if requestCtx.TryPutClose() {
-			t.requestCtxMap.Delete(requestCtx.requestId)
+			t.finishRequest(requestCtx)
 		}
 
 	case valuerpc.ThrottleIncrease:
-		requestCtx.throttleOutgoing.Inc()
+		t.throttleReceived.Inc()
+		requestCtx.span.AddEvent(valuerpc.ThrottleEvent, valuerpc.Attribute(valuerpc.ThrottleAttr, requestCtx.throttleOutgoing.Inc()))
 
 	case valuerpc.ThrottleDecrease:
-		requestCtx.throttleOutgoing.Dec()
+		t.throttleReceived.Inc()
+		requestCtx.span.AddEvent(valuerpc.ThrottleEvent, valuerpc.Attribute(valuerpc.ThrottleAttr, requestCtx.throttleOutgoing.Dec()))
 
 	default:
-		t.getErrorHandler().ProtocolError(resp, ErrUnsupportedMessageType)
+		t.protocolError(resp, ErrUnsupportedMessageType)
 
 	}
 
@@ -218,9 +295,11 @@ func (t *rpcClient) regulateIncomingStream(requestCtx *rpcRequestCtx) {
 	used, cap := requestCtx.Stats()
 	if used*3 > cap {
 		t.sendSystemRequest(requestCtx.requestId, valuerpc.ThrottleIncrease)
+		t.throttleSent.Inc()
 		requestCtx.throttleOnServer.Inc()
 	} else if used == 0 && requestCtx.throttleOnServer.Load() > 0 {
 		t.sendSystemRequest(requestCtx.requestId, valuerpc.ThrottleDecrease)
+		t.throttleSent.Inc()
 		requestCtx.throttleOnServer.Dec()
 	}
 }
@@ -230,7 +309,7 @@ func (t *rpcClient) getResponseHandler() responseHandler {
 
 		mt := resp.GetNumber(valuerpc.MessageTypeField)
 		if mt == nil {
-			t.getErrorHandler().ProtocolError(resp, ErrNoMessageType)
+			t.protocolError(resp, ErrNoMessageType)
 			return
 		}
 		msgType := valuerpc.MessageType(mt.Long())
@@ -242,7 +321,12 @@ func (t *rpcClient) getResponseHandler() responseHandler {
 
 		id := resp.GetNumber(valuerpc.RequestIdField)
 		if id == nil {
-			t.getErrorHandler().ProtocolError(resp, ErrIdFieldNotFound)
+			t.protocolError(resp, ErrIdFieldNotFound)
+			return
+		}
+
+		if rev := resp.GetBool(valuerpc.ReverseField); rev != nil && rev.Boolean() {
+			t.processReverse(msgType, id, resp)
 			return
 		}
 
@@ -250,13 +334,16 @@ func (t *rpcClient) getResponseHandler() responseHandler {
 			requestCtx := entry.(*rpcRequestCtx)
 			t.processResponse(msgType, resp, requestCtx)
 		} else {
-			t.getErrorHandler().ProtocolError(resp, ErrRequestNotFound)
+			t.protocolError(resp, ErrRequestNotFound)
 		}
 	}
 }
 
-func (t *rpcClient) newRequestCtx(requestId int64, req value.Map, receiveCap int) *rpcRequestCtx {
+func (t *rpcClient) newRequestCtx(requestId int64, req value.Map, receiveCap int, span valuerpc.Span, options *callOptions) *rpcRequestCtx {
 	requestCtx := NewRequestCtx(requestId, req, receiveCap)
+	requestCtx.span = span
+	requestCtx.trailer = options.trailer
+	requestCtx.done = options.done
 	t.requestCtxMap.Store(requestId, requestCtx)
 	return requestCtx
 }
@@ -270,7 +357,7 @@ func (t *rpcClient) ensureConnection() error {
 	return nil
 }
 
-func (t *rpcClient) sendRequest(req value.Map, receiveCap int) (*rpcRequestCtx, error) {
+func (t *rpcClient) sendRequest(req value.Map, receiveCap int, span valuerpc.Span, options *callOptions) (*rpcRequestCtx, error) {
 
 	err := t.ensureConnection()
 	if err != nil {
@@ -280,7 +367,8 @@ func (t *rpcClient) sendRequest(req value.Map, receiveCap int) (*rpcRequestCtx,
 	requestId := t.lastRequest.Inc()
 	req = req.Put(valuerpc.RequestIdField, value.Long(requestId))
 
-	requestCtx := t.newRequestCtx(requestId, req, receiveCap)
+	span.SetAttributes(valuerpc.Attribute(valuerpc.RequestIdAttr, requestId))
+	requestCtx := t.newRequestCtx(requestId, req, receiveCap, span, options)
 
 	t.conn.getConn().SendRequest(req)
 	return requestCtx, nil
@@ -302,102 +390,126 @@ func (t *rpcClient) sendSystemRequest(requestId int64, mt valuerpc.MessageType)
 }
 
 func (t *rpcClient) CancelRequest(requestId int64) {
+	if entry, ok := t.requestCtxMap.Load(requestId); ok {
+		entry.(*rpcRequestCtx).span.AddEvent(valuerpc.CancelEvent, valuerpc.Attribute(valuerpc.DirectionAttr, valuerpc.OutDirection))
+	}
 	t.sendSystemRequest(requestId, valuerpc.CancelRequest)
 }
 
 func (t *rpcClient) CallFunction(name string, args value.Value) (value.Value, error) {
+	return t.CallFunctionContext(context.Background(), name, args)
+}
 
-	req := t.constructRequest(valuerpc.FunctionRequest, name, args, t.timeoutMls.Load())
-
-	requestCtx, err := t.sendRequest(req, 1)
-	if err != nil {
-		return nil, err
-	}
+func (t *rpcClient) GetStream(name string, args value.Value, receiveCap int) (<-chan value.Value, int64, error) {
+	return t.GetStreamContext(context.Background(), name, args, receiveCap)
+}
 
-	res, err := requestCtx.SingleResp(t.timeoutMls.Load(), func() {
-		t.CancelRequest(requestCtx.requestId)
-	})
-	if err != nil {
-		requestCtx.Close()
-		return nil, err
-	}
+func (t *rpcClient) PutStream(name string, args value.Value, putCh <-chan value.Value) error {
+	return t.PutStreamContext(context.Background(), name, args, putCh)
+}
 
-	return res, err
+func (t *rpcClient) Chat(name string, args value.Value, receiveCap int, putCh <-chan value.Value) (<-chan value.Value, int64, error) {
+	return t.ChatContext(context.Background(), name, args, receiveCap, putCh)
 }
 
-func (t *rpcClient) GetStream(name string, args value.Value, receiveCap int) (<-chan value.Value, int64, error) {
+// sends request with span of the call and waits for the first response, span of failed request is ended
+func (t *rpcClient) openRequest(ctx context.Context, mt valuerpc.MessageType, name string, args value.Value, receiveCap int, opts []CallOption) (requestCtx *rpcRequestCtx, res value.Value, err error) {
+	kind := kindOfRequest(mt)
+	start := time.Now()
+	defer func() { t.metrics.observeRequest(name, kind, start, err) }()
+
+	ctx, span := t.startSpan(ctx, name, kind)
+	options := applyOptions(opts)
+	md, err := t.requestMetadata(ctx, name, kind, options)
+	if err != nil {
+		span.SetError(err)
+		span.End()
+		return nil, nil, err
+	}
 
-	req := t.constructRequest(valuerpc.GetStreamRequest, name, args, t.timeoutMls.Load())
+	req := valuerpc.InjectTrace(ctx, t.constructRequest(mt, name, args, t.timeoutMls.Load()))
+	req = valuerpc.PutMetadata(req, valuerpc.MetadataField, md)
 
-	requestCtx, err := t.sendRequest(req, receiveCap)
+	requestCtx, err = t.sendRequest(req, receiveCap, span, options)
 	if err != nil {
-		return nil, 0, err
+		span.SetError(err)
+		span.End()
+		return nil, nil, err
 	}
 
-	_, err = requestCtx.SingleResp(t.timeoutMls.Load(), func() {
+	res, err = requestCtx.SingleResp(ctx, t.timeoutMls.Load(), func() {
 		t.CancelRequest(requestCtx.requestId)
 	})
 	if err != nil {
+		span.SetError(err)
+		span.End()
 		requestCtx.Close()
-		return nil, 0, err
+		return nil, nil, err
 	}
 
-	return requestCtx.MultiResp(), requestCtx.requestId, err
+	return requestCtx, res, nil
 }
 
-func (t *rpcClient) PutStream(name string, args value.Value, putCh <-chan value.Value) error {
-
-	req := t.constructRequest(valuerpc.PutStreamRequest, name, args, t.timeoutMls.Load())
+func (t *rpcClient) CallFunctionContext(ctx context.Context, name string, args value.Value, opts ...CallOption) (value.Value, error) {
+	_, res, err := t.openRequest(ctx, valuerpc.FunctionRequest, name, args, 1, opts)
+	return res, err
+}
 
-	requestCtx, err := t.sendRequest(req, 1)
+func (t *rpcClient) GetStreamContext(ctx context.Context, name string, args value.Value, receiveCap int, opts ...CallOption) (<-chan value.Value, int64, error) {
+	requestCtx, _, err := t.openRequest(ctx, valuerpc.GetStreamRequest, name, args, receiveCap, opts)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
+	return requestCtx.MultiResp(), requestCtx.requestId, nil
+}
 
-	_, err = requestCtx.SingleResp(t.timeoutMls.Load(), func() {
-		t.CancelRequest(requestCtx.requestId)
-	})
+func (t *rpcClient) PutStreamContext(ctx context.Context, name string, args value.Value, putCh <-chan value.Value, opts ...CallOption) error {
+	requestCtx, _, err := t.openRequest(ctx, valuerpc.PutStreamRequest, name, args, 1, opts)
 	if err != nil {
-		requestCtx.Close()
 		return err
 	}
-
-	go t.streamOut(requestCtx, putCh)
-
+	go t.streamOut(ctx, requestCtx, putCh)
 	return nil
 }
 
-func (t *rpcClient) Chat(name string, args value.Value, receiveCap int, putCh <-chan value.Value) (<-chan value.Value, int64, error) {
-
-	req := t.constructRequest(valuerpc.ChatRequest, name, args, t.timeoutMls.Load())
-
-	requestCtx, err := t.sendRequest(req, receiveCap+1)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	_, err = requestCtx.SingleResp(t.timeoutMls.Load(), func() {
-		t.CancelRequest(requestCtx.requestId)
-	})
+func (t *rpcClient) ChatContext(ctx context.Context, name string, args value.Value, receiveCap int, putCh <-chan value.Value, opts ...CallOption) (<-chan value.Value, int64, error) {
+	requestCtx, _, err := t.openRequest(ctx, valuerpc.ChatRequest, name, args, receiveCap+1, opts)
 	if err != nil {
-		requestCtx.Close()
 		return nil, 0, err
 	}
-
-	go t.streamOut(requestCtx, putCh)
-
+	go t.streamOut(ctx, requestCtx, putCh)
 	return requestCtx.MultiResp(), requestCtx.requestId, nil
 }
 
-func (t *rpcClient) streamOut(requestCtx *rpcRequestCtx, putCh <-chan value.Value) {
+// done ctx cancels the request instead of sending the end of stream
+func (t *rpcClient) streamOut(ctx context.Context, requestCtx *rpcRequestCtx, putCh <-chan value.Value) {
 
 	for requestCtx.IsPutOpen() {
 
-		val, ok := <-putCh
+		var val value.Value
+		var ok bool
+		select {
+		case val, ok = <-putCh:
+		case <-ctx.Done():
+			err := ctx.Err()
+			if failure := streamFailureOf(ctx); failure != nil {
+				err = failure
+				t.getErrorHandler().StreamError(requestCtx.requestId, err)
+			}
+			if requestCtx.IsPutOpen() {
+				t.CancelRequest(requestCtx.requestId)
+				requestCtx.SetError(err)
+			}
+			requestCtx.Close()
+			t.finishRequest(requestCtx)
+			return
+		}
+
 		if !ok {
 			endReq := value.EmptyMap().
 				Put(valuerpc.MessageTypeField, valuerpc.StreamEnd.Long()).
 				Put(valuerpc.RequestIdField, value.Long(requestCtx.requestId))
+			requestCtx.streamEvent(valuerpc.StreamEndEvent, valuerpc.OutDirection)
 			t.conn.getConn().SendRequest(endReq)
 			break
 		}
@@ -407,6 +519,7 @@ func (t *rpcClient) streamOut(requestCtx *rpcRequestCtx, putCh <-chan value.Valu
 			Put(valuerpc.RequestIdField, value.Long(requestCtx.requestId)).
 			Put(valuerpc.ValueField, val)
 
+		requestCtx.streamEvent(valuerpc.StreamValueEvent, valuerpc.OutDirection)
 		t.conn.getConn().SendRequest(nextReq)
 
 		th := requestCtx.throttleOutgoing.Load()
@@ -417,7 +530,7 @@ func (t *rpcClient) streamOut(requestCtx *rpcRequestCtx, putCh <-chan value.Valu
 	}
 
 	if requestCtx.TryPutClose() {
-		t.requestCtxMap.Delete(requestCtx.requestId)
+		t.finishRequest(requestCtx)
 	}
 
 }
@@ -8,48 +8,76 @@ package valueclient
 import (
 	"github.com/codeallergy/value"
 	"github.com/codeallergy/value-rpc/valuerpc"
+	"go.uber.org/atomic"
 	"golang.org/x/net/proxy"
 	"net"
 	"time"
 )
 
 var DefaultTimeout  = 10 * time.Second
+var CloseFlushTimeout = time.Second // waiting for queued messages on Close
 
 type rpcConn struct {
 	conn         valuerpc.MsgConn
 	reqCh        chan value.Map
 	respHandler  responseHandler
 	errorHandler ErrorHandler
+	queued       atomic.Int64
+	written      atomic.Int64 // including failed writes
 }
 
-func dial(address, socks5 string) (net.Conn, error) {
+// creates connections for the client, net.Dialer and proxy.Dialer implement it
+type Dialer interface {
+	Dial(network, address string) (net.Conn, error)
+}
+
+// address is host:port or unix:/path/to/socket
+func dial(dialer Dialer, address, socks5 string) (net.Conn, error) {
+	if dialer == nil {
+		dialer = proxy.Direct
+	}
+	network, addr := valuerpc.SplitAddress(address)
 	if socks5 != "" {
-		d, err := proxy.SOCKS5("tcp", socks5, nil, proxy.Direct)
+		d, err := proxy.SOCKS5("tcp", socks5, nil, dialer)
 		if err != nil {
 			return nil, err
 		}
-		return d.Dial("tcp", address)
+		return d.Dial(network, addr)
 	} else {
-		return net.Dial("tcp", address)
+		return dialer.Dial(network, addr)
 	}
 }
 
-func newConn(address, socks5 string, clientId int64, sendingCap int64, respHandler responseHandler, errorHandler ErrorHandler) (*rpcConn, error) {
+func newConn(dialer Dialer, recorder *valuerpc.Recorder, address, socks5 string, handshake value.Map, sendingCap int64, respHandler responseHandler, errorHandler ErrorHandler) (*rpcConn, error) {
+
+	var msgConn valuerpc.MsgConn
+	if valuerpc.IsWebSocketAddress(address) {
+		conn, err := dialWebSocket(dialer, address, socks5, handshake)
+		if err != nil {
+			return nil, err
+		}
+		msgConn = conn
+	} else {
+		conn, err := dial(dialer, address, socks5)
+		if err != nil {
+			return nil, err
+		}
+		msgConn = valuerpc.NewMsgConn(conn, DefaultTimeout)
+	}
 
-	conn, err := dial(address, socks5)
-	if err != nil {
-		return nil, err
+	if recorder != nil {
+		msgConn = valuerpc.NewRecordingMsgConn(msgConn, recorder, valuerpc.ClientSide)
 	}
 
 	t := &rpcConn{
-		conn:         valuerpc.NewMsgConn(conn, DefaultTimeout),
+		conn:         msgConn,
 		reqCh:        make(chan value.Map, sendingCap),
 		respHandler:  respHandler,
 		errorHandler: errorHandler,
 	}
 
 	go t.requestLoop()
-	t.SendRequest(valuerpc.NewHandshakeRequest(clientId))
+	t.SendRequest(handshake)
 	go t.responseLoop()
 
 	return t, nil
@@ -74,6 +102,7 @@ func (t *rpcConn) requestLoop() {
 		}
 
 		err := t.conn.WriteMessage(req)
+		t.written.Inc()
 		if err != nil {
 			t.errorHandler.BadConnection(err)
 		}
@@ -98,5 +127,18 @@ func (t *rpcConn) responseLoop() error {
 }
 
 func (t *rpcConn) SendRequest(req value.Map) {
+	t.queued.Inc()
 	t.reqCh <- req
 }
+
+// waits until queued messages are written, false on timeout
+func (t *rpcConn) flush(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for t.written.Load() < t.queued.Load() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
@@ -8,6 +8,7 @@ package valueclient
 import (
 	"errors"
 	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
 )
 
 
@@ -17,6 +18,8 @@ var ErrIdFieldNotFound = errors.New("request id not found")
 var ErrTimeoutError = errors.New("timeout error")
 var ErrRequestNotFound = errors.New("request not found")
 var ErrUnsupportedMessageType = errors.New("message type not supported")
+var ErrReverseDisabled = errors.New("reverse server not set")
+var ErrReverseQueueFull = errors.New("reverse queue is full")
 
 type ErrorHandler interface {
 	BadConnection(err error)
@@ -25,3 +28,50 @@ type ErrorHandler interface {
 
 	StreamError(requestId int64, err error)
 }
+
+// error returned by the server in ErrorResponse
+type ServerError struct {
+	Code    valuerpc.ErrorCode
+	Message string
+	Details value.Value
+	Trailer valuerpc.Metadata // trailers of error response, nil if not sent
+}
+
+func NewServerError(resp value.Map) *ServerError {
+	t := &ServerError{}
+	if code := resp.GetNumber(valuerpc.ErrorCodeField); code != nil {
+		t.Code = valuerpc.ErrorCode(code.Long())
+	}
+	if msg := resp.GetString(valuerpc.ErrorField); msg != nil {
+		t.Message = msg.String()
+	}
+	t.Details, _ = resp.Get(valuerpc.ErrorDetailsField)
+	t.Trailer = valuerpc.GetMetadata(resp, valuerpc.TrailerField)
+	return t
+}
+
+func (t *ServerError) Error() string {
+	return "SERVER_FUNC_ERROR " + t.Message
+}
+
+// violations reported by server on args, result or stream value verification
+func (t *ServerError) Violations() []valuerpc.Violation {
+	if t.Details == nil || t.Details.Kind() != value.LIST {
+		return nil
+	}
+	return valuerpc.ViolationsFromValue(t.Details.(value.List))
+}
+
+// error on mapping of received value to go type
+type DecodeError struct {
+	Name string
+	Err  error
+}
+
+func (t *DecodeError) Error() string {
+	return "decode result of '" + t.Name + "', " + t.Err.Error()
+}
+
+func (t *DecodeError) Unwrap() error {
+	return t.Err
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueclient
+
+import (
+	"github.com/codeallergy/value-rpc/valuerpc"
+)
+
+func ListFunctions(cli Client) ([]valuerpc.FunctionInfo, error) {
+	res, err := cli.CallFunction(valuerpc.IntrospectFunction, nil)
+	if err != nil {
+		return nil, err
+	}
+	list, err := valuerpc.FunctionInfoList(res)
+	if err != nil {
+		return nil, &DecodeError{Name: valuerpc.IntrospectFunction, Err: err}
+	}
+	return list, nil
+}
+
+// first events describe the current catalog, the channel is closed when the stream ends,
+// event that can not be decoded is reported to the error handler and ends the watch, catalog of the watcher is stale since then
+func WatchFunctions(cli Client, receiveCap int) (<-chan valuerpc.FunctionEvent, int64, error) {
+	readC, requestId, err := cli.GetStream(valuerpc.WatchStream, nil, receiveCap)
+	if err != nil {
+		return nil, 0, err
+	}
+	eventC := make(chan valuerpc.FunctionEvent, receiveCap)
+	go func() {
+		defer close(eventC)
+		for val := range readC {
+			event, err := valuerpc.FunctionEventFromValue(val)
+			if err != nil {
+				reportStreamError(cli, requestId, &DecodeError{Name: valuerpc.WatchStream, Err: err})
+				cli.CancelRequest(requestId)
+				for range readC {
+				}
+				break
+			}
+			eventC <- event
+		}
+	}()
+	return eventC, requestId, nil
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueclient
+
+import (
+	"errors"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"testing"
+)
+
+// client with scripted watch stream, records canceled requests and stream errors
+type watchClient struct {
+	Client
+	readC    chan value.Value
+	canceled []int64
+	errs     []error
+	errIds   []int64
+}
+
+func (t *watchClient) GetStream(name string, args value.Value, receiveCap int) (<-chan value.Value, int64, error) {
+	return t.readC, 7, nil
+}
+
+func (t *watchClient) CancelRequest(requestId int64) {
+	t.canceled = append(t.canceled, requestId)
+	close(t.readC)
+}
+
+func (t *watchClient) getErrorHandler() ErrorHandler {
+	return t
+}
+
+func (t *watchClient) BadConnection(err error) {
+}
+
+func (t *watchClient) ProtocolError(resp value.Map, err error) {
+}
+
+func (t *watchClient) StreamError(requestId int64, err error) {
+	t.errs = append(t.errs, err)
+	t.errIds = append(t.errIds, requestId)
+}
+
+func TestWatchFunctionsDecodeError(t *testing.T) {
+	cli := &watchClient{readC: make(chan value.Value, 3)}
+	info := valuerpc.FunctionInfo{Name: "greet", Kind: valuerpc.SingleFunctionKind, Args: valuerpc.Void, Res: valuerpc.String, In: valuerpc.Void}
+	cli.readC <- valuerpc.NewFunctionEvent(valuerpc.AddEvent, info)
+	cli.readC <- value.Utf8("broken")
+	cli.readC <- valuerpc.NewFunctionEvent(valuerpc.RemoveEvent, info)
+
+	eventC, _, err := WatchFunctions(cli, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []valuerpc.FunctionEvent
+	for event := range eventC {
+		events = append(events, event)
+	}
+
+	// stale catalog is not updated by events after the broken one
+	if len(events) != 1 || events[0].Op != valuerpc.AddEvent {
+		t.Errorf("expected only the first event, actual %v", events)
+	}
+	if len(cli.canceled) != 1 || cli.canceled[0] != 7 {
+		t.Errorf("expected cancel of watch 7, actual %v", cli.canceled)
+	}
+	if len(cli.errs) != 1 || cli.errIds[0] != 7 || !errors.As(cli.errs[0], new(*DecodeError)) {
+		t.Errorf("expected DecodeError of watch, actual %v of %v", cli.errs, cli.errIds)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueclient_test
+
+import (
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"testing"
+	"time"
+)
+
+var greetArgs = vrpc.Map(vrpc.Param("name", value.STRING, true, vrpc.MinLen(1)))
+
+func findInfo(list []vrpc.FunctionInfo, name string) (vrpc.FunctionInfo, bool) {
+	for _, info := range list {
+		if info.Name == name {
+			return info, true
+		}
+	}
+	return vrpc.FunctionInfo{}, false
+}
+
+func TestListFunctions(t *testing.T) {
+	h := valuetest.Start(t)
+	h.FakeFunction("greet", greetArgs, vrpc.String, value.Utf8("hi"), nil)
+	h.ScriptStream("ticks", vrpc.Void, vrpc.Number)
+	if err := h.Server.DescribeFunction("greet", "says hi", "demo", "text"); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := valueclient.ListFunctions(h.Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{vrpc.IntrospectFunction, vrpc.WatchStream} {
+		if _, ok := findInfo(list, name); !ok {
+			t.Errorf("reserved function '%s' is not listed", name)
+		}
+	}
+
+	greet, ok := findInfo(list, "greet")
+	if !ok {
+		t.Fatalf("function greet is not listed in %v", list)
+	}
+	if greet.Kind != vrpc.SingleFunctionKind || greet.Description != "says hi" || len(greet.Tags) != 2 || greet.Tags[1] != "text" {
+		t.Errorf("unexpected info %+v", greet)
+	}
+	if !vrpc.EncodeTypeDef(greet.Args).Equal(vrpc.EncodeTypeDef(greetArgs)) {
+		t.Errorf("expected args %v, actual %v", vrpc.EncodeTypeDef(greetArgs), vrpc.EncodeTypeDef(greet.Args))
+	}
+
+	ticks, ok := findInfo(list, "ticks")
+	if !ok || ticks.Kind != vrpc.OutgoingStreamKind || !vrpc.EncodeTypeDef(ticks.Res).Equal(vrpc.EncodeTypeDef(vrpc.Number)) {
+		t.Errorf("unexpected info %+v", ticks)
+	}
+}
+
+func nextEvent(t *testing.T, eventC <-chan vrpc.FunctionEvent) vrpc.FunctionEvent {
+	t.Helper()
+	select {
+	case event, ok := <-eventC:
+		if !ok {
+			t.Fatal("watch is ended")
+		}
+		return event
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Fatal("no function event")
+	}
+	return vrpc.FunctionEvent{}
+}
+
+func TestWatchFunctions(t *testing.T) {
+	h := valuetest.Start(t)
+	h.FakeFunction("greet", greetArgs, vrpc.String, value.Utf8("hi"), nil)
+
+	eventC, requestId, err := valueclient.WatchFunctions(h.Client, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// current catalog first
+	catalog := h.Server.Functions()
+	for range catalog {
+		if event := nextEvent(t, eventC); event.Op != vrpc.AddEvent {
+			t.Errorf("expected add event of the catalog, actual %s", event.Op)
+		}
+	}
+
+	tests := []struct {
+		change func() error
+		op     string
+		name   string
+	}{
+		{func() error {
+			return h.Server.AddFunction("late", vrpc.Void, vrpc.Void, func(value.Value) (value.Value, error) { return nil, nil })
+		}, vrpc.AddEvent, "late"},
+		{func() error { return h.Server.DescribeFunction("greet", "says hi") }, vrpc.UpdateEvent, "greet"},
+		{func() error { return h.Server.RemoveFunction("late") }, vrpc.RemoveEvent, "late"},
+	}
+	for _, tt := range tests {
+		if err := tt.change(); err != nil {
+			t.Fatal(err)
+		}
+		event := nextEvent(t, eventC)
+		if event.Op != tt.op || event.Function.Name != tt.name {
+			t.Errorf("expected %s of %s, actual %s of %s", tt.op, tt.name, event.Op, event.Function.Name)
+		}
+	}
+	h.Client.CancelRequest(requestId)
+	for range eventC {
+	}
+}
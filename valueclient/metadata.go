@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueclient
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"sync"
+)
+
+type callOptions struct {
+	metadata valuerpc.Metadata
+	trailer  *valuerpc.Metadata
+	done     chan<- error
+}
+
+type CallOption func(*callOptions)
+
+// metadata of the call, merged over the outgoing metadata of context
+func WithMetadata(md valuerpc.Metadata) CallOption {
+	return func(opts *callOptions) {
+		if opts.metadata == nil {
+			opts.metadata = make(valuerpc.Metadata)
+		}
+		opts.metadata.Merge(md)
+	}
+}
+
+func WithHeader(key, val string) CallOption {
+	return WithMetadata(valuerpc.NewMetadata(key, val))
+}
+
+// receives trailers of function result, error response or end of stream,
+// for streams trailer is filled before the result channel is closed
+func WithTrailer(trailer *valuerpc.Metadata) CallOption {
+	return func(opts *callOptions) {
+		opts.trailer = trailer
+	}
+}
+
+// receives nil or error of the call when it is finished, for PUT streams after server acknowledged the end of stream,
+// channel should be buffered, the notification is dropped otherwise
+func WithDone(done chan<- error) CallOption {
+	return func(opts *callOptions) {
+		opts.done = done
+	}
+}
+
+type interceptorHolder struct {
+	lock sync.RWMutex
+	list []Interceptor
+}
+
+func (t *rpcClient) AddInterceptor(interceptor Interceptor) {
+	t.interceptors.lock.Lock()
+	defer t.interceptors.lock.Unlock()
+	t.interceptors.list = append(t.interceptors.list, interceptor)
+}
+
+// metadata of the request from context, call options and interceptors in this order
+func (t *rpcClient) requestMetadata(ctx context.Context, name string, kind valuerpc.FunctionKind, opts *callOptions) (valuerpc.Metadata, error) {
+	md := make(valuerpc.Metadata)
+	md.Merge(valuerpc.OutgoingMetadata(ctx))
+	md.Merge(opts.metadata)
+
+	t.interceptors.lock.RLock()
+	list := t.interceptors.list
+	t.interceptors.lock.RUnlock()
+
+	for _, interceptor := range list {
+		if err := interceptor(ctx, name, kind, md); err != nil {
+			return nil, err
+		}
+	}
+	return md, nil
+}
+
+func applyOptions(opts []CallOption) *callOptions {
+	o := &callOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// fills trailer of the call from final response
+func (t *rpcRequestCtx) receiveTrailer(resp value.Map) {
+	if t.trailer != nil {
+		if md := valuerpc.GetMetadata(resp, valuerpc.TrailerField); md != nil {
+			*t.trailer = md
+		}
+	}
+}
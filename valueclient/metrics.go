@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueclient
+
+import (
+	"errors"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"golang.org/x/net/proxy"
+	"net"
+	"time"
+)
+
+var OkCodeLabel = "ok"
+var TimeoutCodeLabel = "Timeout"
+var ConnectionCodeLabel = "ConnectionError"
+
+type clientMetrics struct {
+	registry       *valuerpc.Metrics
+	requests       *valuerpc.Counter
+	latency        *valuerpc.Histogram
+	protocolErrors *valuerpc.Counter
+	bytes          valuerpc.ByteCounters
+}
+
+func newClientMetrics(t *rpcClient) *clientMetrics {
+	m := valuerpc.NewMetrics()
+	cm := &clientMetrics{
+		registry: m,
+		requests: m.Counter("vrpc_client_requests_total",
+			"Requests by function, kind and result code, streams are counted when opened.", "function", "kind", "code"),
+		latency: m.Histogram("vrpc_client_request_duration_seconds",
+			"Time of function call or stream opening.", nil, "function", "kind"),
+		protocolErrors: m.Counter("vrpc_client_protocol_errors_total",
+			"Malformed or unexpected messages from server."),
+	}
+	m.GaugeFunc("vrpc_client_in_flight_calls", "Function calls waiting for response.", []string{"function"}, func(observe valuerpc.Observe) {
+		for name, n := range t.countRequests(true) {
+			observe(n, name[0])
+		}
+	})
+	m.GaugeFunc("vrpc_client_open_streams", "Open GET, PUT and chat streams.", []string{"function", "kind"}, func(observe valuerpc.Observe) {
+		for name, n := range t.countRequests(false) {
+			observe(n, name[0], name[1])
+		}
+	})
+	m.GaugeFunc("vrpc_client_sending_queue_length", "Messages waiting to be written to the connection.", nil, func(observe valuerpc.Observe) {
+		observe(float64(t.Stats()["sendingLen"]))
+	})
+	m.GaugeFunc("vrpc_client_sending_queue_capacity", "Capacity of the sending queue.", nil, func(observe valuerpc.Observe) {
+		observe(float64(t.sendingCap))
+	})
+	m.GaugeFunc("vrpc_client_connected", "1 if the client has active connection.", nil, func(observe valuerpc.Observe) {
+		if t.IsActive() {
+			observe(1)
+		} else {
+			observe(0)
+		}
+	})
+	m.CounterFunc("vrpc_client_reconnects_total", "Reconnects after connection errors.", nil, func(observe valuerpc.Observe) {
+		observe(float64(t.reconnects.Load()))
+	})
+	m.CounterFunc("vrpc_client_throttle_total", "Throttle messages sent to and received from server.", []string{"direction"}, func(observe valuerpc.Observe) {
+		observe(float64(t.throttleSent.Load()), "sent")
+		observe(float64(t.throttleReceived.Load()), "received")
+	})
+	m.CounterFunc("vrpc_client_received_bytes_total", "Bytes read from connections.", nil, func(observe valuerpc.Observe) {
+		observe(float64(cm.bytes.In.Load()))
+	})
+	m.CounterFunc("vrpc_client_sent_bytes_total", "Bytes written to connections.", nil, func(observe valuerpc.Observe) {
+		observe(float64(cm.bytes.Out.Load()))
+	})
+	return cm
+}
+
+func resultCode(err error) string {
+	if err == nil {
+		return OkCodeLabel
+	}
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr.Code.String()
+	}
+	if err == ErrTimeoutError {
+		return TimeoutCodeLabel
+	}
+	return ConnectionCodeLabel
+}
+
+func (t *clientMetrics) observeRequest(name string, kind valuerpc.FunctionKind, start time.Time, err error) {
+	t.requests.Inc(name, string(kind), resultCode(err))
+	t.latency.Observe(time.Since(start).Seconds(), name, string(kind))
+}
+
+func kindOfRequest(mt valuerpc.MessageType) valuerpc.FunctionKind {
+	switch mt {
+	case valuerpc.GetStreamRequest:
+		return valuerpc.OutgoingStreamKind
+	case valuerpc.PutStreamRequest:
+		return valuerpc.IncomingStreamKind
+	case valuerpc.ChatRequest:
+		return valuerpc.ChatKind
+	default:
+		return valuerpc.SingleFunctionKind
+	}
+}
+
+// counts active requests by function and kind, calls or streams
+func (t *rpcClient) countRequests(calls bool) map[[2]string]float64 {
+	counts := make(map[[2]string]float64)
+	t.requestCtxMap.Range(func(key, value interface{}) bool {
+		requestCtx := value.(*rpcRequestCtx)
+		kind := valuerpc.SingleFunctionKind
+		if mt := requestCtx.req.GetNumber(valuerpc.MessageTypeField); mt != nil {
+			kind = kindOfRequest(valuerpc.MessageType(mt.Long()))
+		}
+		if (kind == valuerpc.SingleFunctionKind) == calls {
+			counts[[2]string{requestCtx.Name(), string(kind)}]++
+		}
+		return true
+	})
+	return counts
+}
+
+func (t *rpcClient) Metrics() *valuerpc.Metrics {
+	return t.metrics.registry
+}
+
+// counts bytes of every dialed connection, including WebSocket and connections through socks5
+type countingDialer struct {
+	dialer   Dialer
+	counters *valuerpc.ByteCounters
+}
+
+func (t countingDialer) Dial(network, address string) (net.Conn, error) {
+	dialer := t.dialer
+	if dialer == nil {
+		dialer = proxy.Direct
+	}
+	conn, err := dialer.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return valuerpc.NewCountingConn(conn, t.counters), nil
+}
@@ -6,6 +6,7 @@
 package valueclient
 
 import (
+	"context"
 	"github.com/codeallergy/value"
 	"github.com/codeallergy/value-rpc/valuerpc"
 	"go.uber.org/atomic"
@@ -25,16 +26,30 @@ type rpcRequestCtx struct {
 	resultErr        atomic.Error
 	throttleOutgoing atomic.Int64
 	throttleOnServer atomic.Int64
+	span             valuerpc.Span // ended when request is finished
+	inSeq            atomic.Int64
+	outSeq           atomic.Int64
+	trailer          *valuerpc.Metadata // destination of trailers, optional
+	done             chan<- error       // notified when request is finished, optional
 }
 
 func NewRequestCtx(requestId int64, req value.Map, receiveCap int) *rpcRequestCtx {
 	t := &rpcRequestCtx{
+		span:      valuerpc.SpanFromContext(context.Background()),
 		requestId: requestId,
 		req:       req,
 		start:     time.Now(),
 		resultCh:  make(chan value.Value, receiveCap),
 	}
-	t.state.Store(getStreamFlag + putStreamFlag)
+	// outgoing side of PUT stream and chat is open until the end of stream is sent
+	state := int32(getStreamFlag)
+	if mt := req.GetNumber(valuerpc.MessageTypeField); mt != nil {
+		switch valuerpc.MessageType(mt.Long()) {
+		case valuerpc.PutStreamRequest, valuerpc.ChatRequest:
+			state += putStreamFlag
+		}
+	}
+	t.state.Store(state)
 	return t
 }
 
@@ -61,25 +76,12 @@ func (t *rpcRequestCtx) notifyResult(res value.Value) {
 	}
 }
 
+// closes both sides of the request
 func (t *rpcRequestCtx) Close() {
-	doClose := false
-
-	for {
-		st := t.state.Load()
-		if st & getStreamFlag > 0 {
-			if t.state.CAS(st, 0) {
-				doClose = true
-				break
-			}
-		} else {
-			break
-		}
-	}
-
-	if doClose {
+	st := t.state.Swap(0)
+	if st&getStreamFlag > 0 {
 		close(t.resultCh)
 	}
-
 }
 
 func (t *rpcRequestCtx) IsGetOpen() bool {
@@ -87,24 +89,9 @@ func (t *rpcRequestCtx) IsGetOpen() bool {
 	return st&getStreamFlag > 0
 }
 
+// closes incoming side, true if the request is finished
 func (t *rpcRequestCtx) TryGetClose() bool {
-
-	closed := false
-	for {
-		st := t.state.Load()
-		if st & getStreamFlag > 0 {
-			if t.state.CAS(st, st - getStreamFlag) {
-				close(t.resultCh)
-				closed = true
-				break
-			}
-		} else {
-			closed = true
-			break
-		}
-	}
-
-	return closed
+	return t.tryClose(getStreamFlag)
 }
 
 func (t *rpcRequestCtx) IsPutOpen() bool {
@@ -112,24 +99,24 @@ func (t *rpcRequestCtx) IsPutOpen() bool {
 	return st&putStreamFlag > 0
 }
 
+// closes outgoing side, true if the request is finished
 func (t *rpcRequestCtx) TryPutClose() bool {
+	return t.tryClose(putStreamFlag)
+}
 
-	closed := false
+func (t *rpcRequestCtx) tryClose(flag int32) bool {
 	for {
 		st := t.state.Load()
-		if st & putStreamFlag > 0 {
-			if t.state.CAS(st, st - putStreamFlag) {
+		if st&flag == 0 {
+			return st == 0
+		}
+		if t.state.CAS(st, st-flag) {
+			if flag == getStreamFlag {
 				close(t.resultCh)
-				closed = true
-				break
 			}
-		} else {
-			closed = true
-			break
+			return st == flag
 		}
 	}
-
-	return closed
 }
 
 func (t *rpcRequestCtx) SetError(err error) {
@@ -144,8 +131,25 @@ func (t *rpcRequestCtx) Error(defaultError error) error {
 	return defaultError
 }
 
-func (t *rpcRequestCtx) SingleResp(timeoutMls int64, onTimeout func()) (value.Value, error) {
+// span event of received or sent stream value, the end event has no sequence number
+func (t *rpcRequestCtx) streamEvent(name, direction string) {
+	attrs := []valuerpc.Attr{valuerpc.Attribute(valuerpc.DirectionAttr, direction)}
+	if name == valuerpc.StreamValueEvent {
+		seq := &t.inSeq
+		if direction == valuerpc.OutDirection {
+			seq = &t.outSeq
+		}
+		attrs = append(attrs, valuerpc.Attribute(valuerpc.SeqAttr, seq.Inc()-1))
+	}
+	t.span.AddEvent(name, attrs...)
+}
+
+// done context cancels the request the same way as timeout
+func (t *rpcRequestCtx) SingleResp(ctx context.Context, timeoutMls int64, onTimeout func()) (value.Value, error) {
 	select {
+	case <-ctx.Done():
+		onTimeout()
+		return nil, ctx.Err()
 	case result, ok := <-t.resultCh:
 		if !ok {
 			return nil, t.Error(ErrNoResponse)
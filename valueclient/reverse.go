@@ -0,0 +1,240 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueclient
+
+import (
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"io"
+	"net"
+	"sync"
+)
+
+/**
+Functions registered on the client are served for reverse calls started by server,
+they are advertised in the handshake. Requests of reverse calls are served by the embedded
+valuerpc.ReverseServer over the client connection, messages are marked by valuerpc.ReverseField.
+Client does not depend on valueserver, the application sets the server by SetReverseServer.
+*/
+
+// reverse messages waiting for the embedded server, new reverse requests are rejected with valuerpc.RequestRejected when it is reached
+var ReverseQueueCap = 1024
+
+// message connection of the embedded server, reads reverse messages from server and writes to client connection
+type reverseConn struct {
+	cli       *rpcClient
+	lock      sync.Mutex
+	pending   []value.Map   // messages waiting for the embedded server, never blocks the read loop of client
+	wake      chan struct{} // signaled when pending is not empty
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (t *reverseConn) ReadMessage() (value.Map, error) {
+	for {
+		t.lock.Lock()
+		if len(t.pending) > 0 {
+			msg := t.pending[0]
+			t.pending[0] = nil
+			t.pending = t.pending[1:]
+			t.lock.Unlock()
+			return msg, nil
+		}
+		t.lock.Unlock()
+		select {
+		case <-t.wake:
+		case <-t.closed:
+			return nil, io.EOF
+		}
+	}
+}
+
+// messages without connection are dropped, server fails running reverse calls on disconnect
+func (t *reverseConn) WriteMessage(msg value.Map) error {
+	select {
+	case <-t.closed:
+		return io.ErrClosedPipe
+	default:
+	}
+	if t.cli.conn.hasConn() {
+		t.cli.conn.getConn().SendRequest(msg.Put(valuerpc.ReverseField, value.Boolean(true)))
+	}
+	return nil
+}
+
+func (t *reverseConn) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+	return nil
+}
+
+func (t *reverseConn) Conn() net.Conn {
+	return nil
+}
+
+// called by the read loop of client, returns false if the new request is rejected because of ReverseQueueCap,
+// messages of running calls are always queued, server limits stream values by throttling
+func (t *reverseConn) deliver(msg value.Map, request bool) bool {
+	select {
+	case <-t.closed:
+		return true
+	default:
+	}
+	t.lock.Lock()
+	if request && len(t.pending) >= ReverseQueueCap {
+		t.lock.Unlock()
+		return false
+	}
+	t.pending = append(t.pending, msg.Remove(valuerpc.ReverseField))
+	t.lock.Unlock()
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+type reverseServer struct {
+	srv  valuerpc.ReverseServer
+	conn *reverseConn
+}
+
+// the first server wins, it starts serving immediately
+func (t *rpcClient) SetReverseServer(srv valuerpc.ReverseServer) {
+	t.reverseOnce.Do(func() {
+		conn := &reverseConn{
+			cli:    t,
+			wake:   make(chan struct{}, 1),
+			closed: make(chan struct{}),
+		}
+		t.reverse.Store(&reverseServer{srv: srv, conn: conn})
+		go srv.ServeMsgConn(t.clientId, conn)
+	})
+}
+
+func (t *rpcClient) getReverse() (*reverseServer, bool) {
+	rev, ok := t.reverse.Load().(*reverseServer)
+	return rev, ok
+}
+
+func (t *rpcClient) Registry() valuerpc.Registry {
+	if rev, ok := t.getReverse(); ok {
+		return rev.srv
+	}
+	return disabledRegistry{}
+}
+
+// serves reverse message by the embedded server, requests are rejected if there is no one or it is overloaded
+func (t *rpcClient) processReverse(msgType valuerpc.MessageType, reqId value.Number, msg value.Map) {
+	request := false
+	switch msgType {
+	case valuerpc.FunctionRequest, valuerpc.GetStreamRequest, valuerpc.PutStreamRequest, valuerpc.ChatRequest:
+		request = true
+	}
+	if rev, ok := t.getReverse(); ok {
+		if !rev.conn.deliver(msg, request) {
+			t.rejectReverse(reqId, valuerpc.RequestRejected, ErrReverseQueueFull)
+		}
+		return
+	}
+	if request {
+		t.rejectReverse(reqId, valuerpc.FunctionNotFound, ErrReverseDisabled)
+	}
+}
+
+func (t *rpcClient) rejectReverse(reqId value.Number, code valuerpc.ErrorCode, err error) {
+	if t.conn.hasConn() {
+		resp := value.EmptyMap().
+			Put(valuerpc.MessageTypeField, valuerpc.ErrorResponse.Long()).
+			Put(valuerpc.RequestIdField, reqId).
+			Put(valuerpc.ErrorCodeField, code.Long()).
+			Put(valuerpc.ErrorField, value.Utf8(err.Error())).
+			Put(valuerpc.ReverseField, value.Boolean(true))
+		t.conn.getConn().SendRequest(resp)
+	}
+}
+
+// functions for the handshake, reserved ones are known to server
+func (t *rpcClient) advertisedFunctions() value.List {
+	rev, ok := t.getReverse()
+	if !ok {
+		return nil
+	}
+	list := value.EmptyList()
+	for _, info := range rev.srv.Functions() {
+		if !valuerpc.IsReserved(info.Name) {
+			list = list.Append(info.ToValue())
+		}
+	}
+	return list
+}
+
+func (t *rpcClient) closeReverse() {
+	if rev, ok := t.getReverse(); ok {
+		rev.conn.Close()
+		rev.srv.Close()
+	}
+}
+
+// registry of client without reverse server
+type disabledRegistry struct{}
+
+func (disabledRegistry) AddFunction(string, valuerpc.TypeDef, valuerpc.TypeDef, valuerpc.Function) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) AddOutgoingStream(string, valuerpc.TypeDef, valuerpc.OutgoingStream) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) AddIncomingStream(string, valuerpc.TypeDef, valuerpc.IncomingStream) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) AddChat(string, valuerpc.TypeDef, valuerpc.Chat) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) AddOutgoingStreamOf(string, valuerpc.TypeDef, valuerpc.TypeDef, valuerpc.OutgoingStream) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) AddIncomingStreamOf(string, valuerpc.TypeDef, valuerpc.TypeDef, valuerpc.IncomingStream) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) AddChatOf(string, valuerpc.TypeDef, valuerpc.TypeDef, valuerpc.TypeDef, valuerpc.Chat) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) AddContextFunction(string, valuerpc.TypeDef, valuerpc.TypeDef, valuerpc.ContextFunction) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) AddContextOutgoingStream(string, valuerpc.TypeDef, valuerpc.TypeDef, valuerpc.ContextOutgoingStream) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) AddContextIncomingStream(string, valuerpc.TypeDef, valuerpc.TypeDef, valuerpc.ContextIncomingStream) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) AddContextChat(string, valuerpc.TypeDef, valuerpc.TypeDef, valuerpc.TypeDef, valuerpc.ContextChat) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) RemoveFunction(string) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) DescribeFunction(string, string, ...string) error {
+	return ErrReverseDisabled
+}
+
+func (disabledRegistry) Functions() []valuerpc.FunctionInfo {
+	return nil
+}
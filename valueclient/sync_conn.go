@@ -6,6 +6,8 @@
 package valueclient
 
 import (
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
 	"sync"
 	"sync/atomic"
 )
@@ -29,7 +31,7 @@ func NewSyncConn() *syncConn {
 	return t
 }
 
-func (t *syncConn) connect(address, socks5 string, clientId, sendingCap int64, respHandler responseHandler, errorHandler ErrorHandler) error {
+func (t *syncConn) connect(dialer Dialer, recorder *valuerpc.Recorder, address, socks5 string, handshake value.Map, sendingCap int64, respHandler responseHandler, errorHandler ErrorHandler) error {
 
 	t.connecting.Lock()
 	defer t.connecting.Unlock()
@@ -38,7 +40,7 @@ func (t *syncConn) connect(address, socks5 string, clientId, sendingCap int64, r
 		return nil
 	}
 
-	conn, err := newConn(address, socks5, clientId, sendingCap, respHandler, errorHandler)
+	conn, err := newConn(dialer, recorder, address, socks5, handshake, sendingCap, respHandler, errorHandler)
 	if err != nil {
 		return err
 	}
@@ -53,13 +55,19 @@ func (t *syncConn) hasConn() bool {
 	return t.conn.Load().(connHolder).value != nil
 }
 
+// waits for connection, cond requires the lock to be held by waiter
 func (t *syncConn) getConn() *rpcConn {
-	conn := t.conn.Load().(connHolder)
-	if conn.value == nil {
+	if conn := t.conn.Load().(connHolder); conn.value != nil {
+		return conn.value
+	}
+	t.connecting.Lock()
+	defer t.connecting.Unlock()
+	for {
+		if conn := t.conn.Load().(connHolder); conn.value != nil {
+			return conn.value
+		}
 		t.active.Wait()
-		return t.getConn()
 	}
-	return conn.value
 }
 
 func (t *syncConn) reset() {
@@ -0,0 +1,38 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueclient
+
+import (
+	"context"
+	"github.com/codeallergy/value-rpc/valuerpc"
+)
+
+func (t *rpcClient) SetTracer(tracer valuerpc.Tracer) {
+	t.tracer.Set(tracer)
+}
+
+// client span of the call, child of the span in ctx
+func (t *rpcClient) startSpan(ctx context.Context, name string, kind valuerpc.FunctionKind) (context.Context, valuerpc.Span) {
+	return t.tracer.Get().Start(ctx, name, valuerpc.SpanKindClient,
+		valuerpc.Attribute(valuerpc.RPCSystemAttr, valuerpc.RPCSystemVrpc),
+		valuerpc.Attribute(valuerpc.RPCMethodAttr, name),
+		valuerpc.Attribute(valuerpc.KindAttr, string(kind)),
+		valuerpc.Attribute(valuerpc.ClientIdAttr, t.clientId))
+}
+
+// removes finished request, ends its span and notifies WithDone channel
+func (t *rpcClient) finishRequest(requestCtx *rpcRequestCtx) {
+	if _, loaded := t.requestCtxMap.LoadAndDelete(requestCtx.requestId); !loaded {
+		return
+	}
+	requestCtx.span.End()
+	if requestCtx.done != nil {
+		select {
+		case requestCtx.done <- requestCtx.Error(nil):
+		default:
+		}
+	}
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueclient
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+)
+
+/**
+Typed helpers map go structs to values and back, see valuerpc.Marshal
+*/
+
+type errorHandlerProvider interface {
+	getErrorHandler() ErrorHandler
+}
+
+func Call[Req, Resp any](cli Client, name string, req Req) (Resp, error) {
+	var resp Resp
+	args, err := encodeArgs(name, req)
+	if err != nil {
+		return resp, err
+	}
+	res, err := cli.CallFunction(name, args)
+	if err != nil {
+		return resp, err
+	}
+	if err := valuerpc.Unmarshal(res, &resp); err != nil {
+		return resp, &DecodeError{Name: name, Err: err}
+	}
+	return resp, nil
+}
+
+func GetTypedStream[Req, Out any](cli Client, name string, req Req, receiveCap int) (<-chan Out, int64, error) {
+	args, err := encodeArgs(name, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	readC, requestId, err := cli.GetStream(name, args, receiveCap)
+	if err != nil {
+		return nil, 0, err
+	}
+	return decodeStream[Out](cli, name, requestId, readC, receiveCap), requestId, nil
+}
+
+// value that can not be encoded cancels the request, the error is received by WithDone
+func PutTypedStream[Req, In any](cli Client, name string, req Req, putCh <-chan In, opts ...CallOption) error {
+	args, err := encodeArgs(name, req)
+	if err != nil {
+		return err
+	}
+	ctx, fail := withStreamFailure(context.Background())
+	if err := cli.PutStreamContext(ctx, name, args, encodeStream(ctx, fail, name, putCh), opts...); err != nil {
+		return streamFailureOr(ctx, err)
+	}
+	return nil
+}
+
+// value that can not be encoded cancels the request, the error is received by WithDone
+func TypedChat[Req, In, Out any](cli Client, name string, req Req, receiveCap int, putCh <-chan In, opts ...CallOption) (<-chan Out, int64, error) {
+	args, err := encodeArgs(name, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctx, fail := withStreamFailure(context.Background())
+	readC, requestId, err := cli.ChatContext(ctx, name, args, receiveCap, encodeStream(ctx, fail, name, putCh), opts...)
+	if err != nil {
+		return nil, 0, streamFailureOr(ctx, err)
+	}
+	return decodeStream[Out](cli, name, requestId, readC, receiveCap), requestId, nil
+}
+
+func encodeArgs(name string, req interface{}) (value.Value, error) {
+	args, err := valuerpc.Marshal(req)
+	if err != nil {
+		return nil, errors.Errorf("encode args of '%s', %v", name, err)
+	}
+	return args, nil
+}
+
+// on decode error reports it to the error handler, cancels the request and closes the channel
+func decodeStream[T any](cli Client, name string, requestId int64, readC <-chan value.Value, receiveCap int) <-chan T {
+	outC := make(chan T, receiveCap)
+	go func() {
+		defer close(outC)
+		for val := range readC {
+			var item T
+			if err := valuerpc.Unmarshal(val, &item); err != nil {
+				reportStreamError(cli, requestId, &DecodeError{Name: name, Err: err})
+				cli.CancelRequest(requestId)
+				for range readC {
+				}
+				break
+			}
+			outC <- item
+		}
+	}()
+	return outC
+}
+
+// on encode error fails the request and drains putCh, the stream is not ended, so truncated stream never looks complete
+func encodeStream[T any](ctx context.Context, fail func(error), name string, putCh <-chan T) <-chan value.Value {
+	valC := make(chan value.Value, cap(putCh))
+	go func() {
+		for item := range putCh {
+			val, err := valuerpc.Marshal(item)
+			if err != nil {
+				fail(errors.Errorf("encode stream value of '%s', %v", name, err))
+				for range putCh {
+				}
+				return
+			}
+			select {
+			case valC <- val:
+			case <-ctx.Done():
+				for range putCh {
+				}
+				return
+			}
+		}
+		close(valC)
+	}()
+	return valC
+}
+
+type streamFailureKey struct{}
+
+type streamFailure struct {
+	err atomic.Error
+}
+
+// ctx of the stream canceled by producer with the error, streamOut cancels the request with it
+func withStreamFailure(parent context.Context) (context.Context, func(error)) {
+	f := &streamFailure{}
+	ctx, cancel := context.WithCancel(context.WithValue(parent, streamFailureKey{}, f))
+	return ctx, func(err error) {
+		f.err.Store(err)
+		cancel()
+	}
+}
+
+// the first value could fail before the request is opened
+func streamFailureOr(ctx context.Context, err error) error {
+	if failure := streamFailureOf(ctx); failure != nil {
+		return failure
+	}
+	return err
+}
+
+// error of the producer that canceled ctx, nil if ctx is canceled otherwise
+func streamFailureOf(ctx context.Context) error {
+	if f, ok := ctx.Value(streamFailureKey{}).(*streamFailure); ok {
+		return f.err.Load()
+	}
+	return nil
+}
+
+func reportStreamError(cli Client, requestId int64, err error) {
+	if p, ok := cli.(errorHandlerProvider); ok {
+		p.getErrorHandler().StreamError(requestId, err)
+	}
+}
@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueclient_test
+
+import (
+	"errors"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valueserver"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type point struct {
+	X int64 `vrpc:"x"`
+	Y int64 `vrpc:"y"`
+}
+
+type smallPoint struct {
+	X int8 `vrpc:"x"`
+	Y int8 `vrpc:"y"`
+}
+
+// records stream errors reported to the client
+type streamErrors struct {
+	lock sync.Mutex
+	list []error
+	ids  []int64
+}
+
+func (t *streamErrors) BadConnection(err error) {
+}
+
+func (t *streamErrors) ProtocolError(resp value.Map, err error) {
+}
+
+func (t *streamErrors) StreamError(requestId int64, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.list = append(t.list, err)
+	t.ids = append(t.ids, requestId)
+}
+
+func (t *streamErrors) get() ([]error, []int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]error(nil), t.list...), append([]int64(nil), t.ids...)
+}
+
+func startTyped(t *testing.T) *valuetest.Harness {
+	h := valuetest.Start(t)
+	if err := valueserver.AddTypedFunction(h.Server, "add", func(req point) (int64, error) {
+		return req.X + req.Y, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := valueserver.AddTypedOutgoingStream(h.Server, "points", func(n int64) (<-chan point, error) {
+		outC := make(chan point, int(n))
+		for i := int64(0); i < n; i++ {
+			outC <- point{i, i * 200}
+		}
+		close(outC)
+		return outC, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := valueserver.AddTypedChat(h.Server, "mirror", func(req struct{}, inC <-chan point) (<-chan point, error) {
+		outC := make(chan point)
+		go func() {
+			defer close(outC)
+			for p := range inC {
+				outC <- point{-p.X, -p.Y}
+			}
+		}()
+		return outC, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestTypedCalls(t *testing.T) {
+	h := startTyped(t)
+	collector := h.CollectStream("collect", vrpc.Any, vrpc.Any)
+
+	sum, err := valueclient.Call[point, int64](h.Client, "add", point{2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 5 {
+		t.Errorf("expected 5, actual %d", sum)
+	}
+
+	readC, _, err := valueclient.GetTypedStream[int64, point](h.Client, "points", 3, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var points []point
+	for p := range readC {
+		points = append(points, p)
+	}
+	if len(points) != 3 || points[2] != (point{2, 400}) {
+		t.Errorf("unexpected points %v", points)
+	}
+
+	putC := make(chan point, 2)
+	putC <- point{1, 2}
+	putC <- point{3, 4}
+	close(putC)
+	done := make(chan error, 1)
+	if err := valueclient.PutTypedStream[struct{}, point](h.Client, "collect", struct{}{}, putC, valueclient.WithDone(done)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	collector.WaitEnds(1, valuetest.DefaultWaitTimeout)
+	h.AssertValues([]value.Value{
+		value.EmptyMap().Put("x", value.Long(1)).Put("y", value.Long(2)),
+		value.EmptyMap().Put("x", value.Long(3)).Put("y", value.Long(4)),
+	}, collector.Values())
+
+	chatC := make(chan point)
+	mirrorC, _, err := valueclient.TypedChat[struct{}, point, point](h.Client, "mirror", struct{}{}, 10, chatC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatC <- point{5, 6}
+	if p := <-mirrorC; p != (point{-5, -6}) {
+		t.Errorf("expected mirrored point, actual %v", p)
+	}
+	close(chatC)
+	for range mirrorC {
+	}
+}
+
+func TestTypedDecodeError(t *testing.T) {
+	h := startTyped(t)
+	errs := &streamErrors{}
+	h.Client.SetErrorHandler(errs)
+
+	if _, err := valueclient.Call[point, string](h.Client, "add", point{2, 3}); !errors.As(err, new(*valueclient.DecodeError)) {
+		t.Errorf("expected DecodeError, actual %v", err)
+	}
+
+	// the second point does not fit into int8, the stream is canceled
+	readC, requestId, err := valueclient.GetTypedStream[int64, smallPoint](h.Client, "points", 3, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var points []smallPoint
+	for p := range readC {
+		points = append(points, p)
+	}
+	if len(points) != 1 {
+		t.Errorf("expected one point before decode error, actual %v", points)
+	}
+	list, ids := errs.get()
+	if len(list) != 1 || ids[0] != requestId || !errors.As(list[0], new(*valueclient.DecodeError)) {
+		t.Errorf("expected DecodeError of request %d, actual %v of %v", requestId, list, ids)
+	}
+}
+
+func TestTypedEncodeError(t *testing.T) {
+	h := startTyped(t)
+	errs := &streamErrors{}
+	h.Client.SetErrorHandler(errs)
+	collector := h.CollectStream("collect", vrpc.Any, vrpc.Any)
+
+	// function values can not be encoded, the stream is canceled instead of ended
+	putC := make(chan interface{}, 3)
+	putC <- "a"
+	putC <- func() {}
+	putC <- "c"
+	close(putC)
+	done := make(chan error, 1)
+	err := valueclient.PutTypedStream[struct{}, interface{}](h.Client, "collect", struct{}{}, putC, valueclient.WithDone(done))
+	expectEncodeError(t, "collect", err, done)
+	if v := collector.Values(); len(v) > 1 {
+		t.Errorf("values after encode error are sent, %v", v)
+	}
+
+	chatC := make(chan interface{}, 1)
+	chatC <- make(chan int)
+	done = make(chan error, 1)
+	readC, _, err := valueclient.TypedChat[struct{}, interface{}, point](h.Client, "mirror", struct{}{}, 10, chatC, valueclient.WithDone(done))
+	if err == nil {
+		for range readC {
+		}
+	}
+	expectEncodeError(t, "mirror", err, done)
+	close(chatC)
+
+	// errors reported by opened requests have request id
+	list, ids := errs.get()
+	for i := range list {
+		if ids[i] == 0 {
+			t.Errorf("stream error without request id, %v", list[i])
+		}
+	}
+
+	if _, err := valueclient.Call[interface{}, int64](h.Client, "add", func() {}); err == nil {
+		t.Error("expected encode error of args")
+	}
+}
+
+// the first value could fail before the request is opened, then the error is returned instead of done
+func expectEncodeError(t *testing.T, name string, err error, done <-chan error) {
+	t.Helper()
+	if err == nil {
+		select {
+		case err = <-done:
+		case <-time.After(valuetest.DefaultWaitTimeout):
+			t.Fatal("stream is not finished")
+		}
+	}
+	if expected := "encode stream value of '" + name + "'"; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected encode error, actual %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueclient
+
+import (
+	"crypto/tls"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+	"net"
+	"net/url"
+)
+
+// address is ws://host:port/path or wss://host:port/path, socks5 proxy is supported, auth token is also sent as bearer
+func dialWebSocket(dialer Dialer, address, socks5 string, handshake value.Map) (valuerpc.MsgConn, error) {
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.Errorf("invalid websocket address '%s', %v", address, err)
+	}
+
+	secure := u.Scheme == "wss"
+	origin := "http://" + u.Host
+	if secure {
+		origin = "https://" + u.Host
+	}
+
+	config, err := websocket.NewConfig(address, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	if token := handshake.GetString(valuerpc.TokenField); token != nil {
+		config.Header.Set("Authorization", "Bearer "+token.String())
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		if secure {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	conn, err := dial(dialer, host, socks5)
+	if err != nil {
+		return nil, err
+	}
+
+	if secure {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return valuerpc.NewWebSocketMsgConn(ws, DefaultTimeout), nil
+}
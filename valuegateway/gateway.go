@@ -0,0 +1,320 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuegateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valueschema"
+	"io"
+	"net/http"
+	"strings"
+)
+
+/**
+HTTP/JSON gateway in front of a vRPC server:
+
+	POST /fn/{name}       calls function, body is JSON args, response is JSON result
+	GET  /fn/{name}       GET stream, query parameter 'args' is JSON args,
+	                      response is Server-Sent Events if accepted, otherwise NDJSON
+	PUT  /fn/{name}       PUT stream, query parameter 'args' is JSON args, body is NDJSON values,
+	                      response is sent after server received the end of stream
+	GET  /schema          JSON Schema of the server functions
+*/
+
+var FunctionPrefix = "/fn/"
+var SchemaPath = "/schema"
+var ArgsParam = "args"
+
+var DefaultReceiveCap = 100
+var MaxBodySize = int64(16 << 20)
+
+var eventStreamType = "text/event-stream"
+var ndjsonType = "application/x-ndjson"
+var jsonType = "application/json"
+
+type Gateway struct {
+	cli        valueclient.Client
+	receiveCap int
+}
+
+func NewGateway(cli valueclient.Client) *Gateway {
+	return &Gateway{cli: cli, receiveCap: DefaultReceiveCap}
+}
+
+func (t *Gateway) SetReceiveCap(receiveCap int) {
+	t.receiveCap = receiveCap
+}
+
+func (t *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.URL.Path == SchemaPath && r.Method == http.MethodGet {
+		t.serveSchema(w)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, FunctionPrefix) {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, FunctionPrefix)
+	if name == "" {
+		writeError(w, http.StatusNotFound, errors.New("function name is empty"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		t.serveFunction(w, r, name)
+	case http.MethodGet:
+		t.serveGetStream(w, r, name)
+	case http.MethodPut:
+		t.servePutStream(w, r, name)
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (t *Gateway) serveSchema(w http.ResponseWriter) {
+	list, err := valueclient.ListFunctions(t.cli)
+	if err != nil {
+		writeError(w, HTTPStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, valueschema.CatalogSchema("vRPC gateway", list))
+}
+
+func (t *Gateway) serveFunction(w http.ResponseWriter, r *http.Request, name string) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var args value.Value
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if args, err = valuerpc.ParseJSON(body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	res, err := t.cli.CallFunction(name, args)
+	if err != nil {
+		writeError(w, HTTPStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, valuerpc.ToNative(res))
+}
+
+func queryArgs(r *http.Request) (value.Value, error) {
+	s := r.URL.Query().Get(ArgsParam)
+	if s == "" {
+		return nil, nil
+	}
+	return valuerpc.ParseJSON([]byte(s))
+}
+
+func (t *Gateway) serveGetStream(w http.ResponseWriter, r *http.Request, name string) {
+	args, err := queryArgs(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	done := make(chan error, 1)
+	readC, requestId, err := t.cli.GetStreamContext(r.Context(), name, args, t.receiveCap, valueclient.WithDone(done))
+	if err != nil {
+		writeError(w, HTTPStatus(err), err)
+		return
+	}
+
+	sse := strings.Contains(r.Header.Get("Accept"), eventStreamType)
+	if sse {
+		w.Header().Set("Content-Type", eventStreamType)
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", ndjsonType)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		select {
+		case <-r.Context().Done():
+			t.cli.CancelRequest(requestId)
+			return
+		case val, ok := <-readC:
+			if !ok {
+				// status is already sent, the error of stream is the last event
+				select {
+				case err := <-done:
+					writeStreamEnd(w, sse, err)
+				case <-r.Context().Done():
+				}
+				return
+			}
+			line, err := valuerpc.ToJSON(val)
+			if err != nil {
+				t.cli.CancelRequest(requestId)
+				writeStreamEnd(w, sse, err)
+				return
+			}
+			if sse {
+				io.WriteString(w, "data: ")
+				w.Write(line)
+				io.WriteString(w, "\n\n")
+			} else {
+				w.Write(line)
+				io.WriteString(w, "\n")
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// SSE stream ends with 'end' or 'error' event, NDJSON stream ends with error line only on error
+func writeStreamEnd(w io.Writer, sse bool, err error) {
+	if err == nil {
+		if sse {
+			io.WriteString(w, "event: end\ndata: {}\n\n")
+		}
+		return
+	}
+	line, _ := json.Marshal(errorBody(err))
+	if sse {
+		io.WriteString(w, "event: error\ndata: ")
+		w.Write(line)
+		io.WriteString(w, "\n\n")
+	} else {
+		w.Write(line)
+		io.WriteString(w, "\n")
+	}
+}
+
+func (t *Gateway) servePutStream(w http.ResponseWriter, r *http.Request, name string) {
+	args, err := queryArgs(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// canceling ctx cancels the stream on server instead of ending it
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	putCh := make(chan value.Value, t.receiveCap)
+	done := make(chan error, 1)
+	if err := t.cli.PutStreamContext(ctx, name, args, putCh, valueclient.WithDone(done)); err != nil {
+		writeError(w, HTTPStatus(err), err)
+		return
+	}
+
+	count := 0
+	scanner := bufio.NewScanner(io.LimitReader(r.Body, MaxBodySize))
+	scanner.Buffer(make([]byte, 64*1024), int(MaxBodySize))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		val, err := valuerpc.ParseJSON([]byte(line))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		select {
+		case putCh <- val:
+			count++
+		case err := <-done:
+			// server closed the stream before the end of body
+			if err == nil {
+				err = errors.New("stream closed by server")
+			}
+			writeError(w, HTTPStatus(err), err)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	close(putCh)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			writeError(w, HTTPStatus(err), err)
+			return
+		}
+	case <-ctx.Done():
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sent": count})
+}
+
+// maps server error codes to HTTP status
+func HTTPStatus(err error) int {
+	var serverErr *valueclient.ServerError
+	if errors.As(err, &serverErr) {
+		switch serverErr.Code {
+		case valuerpc.BadRequest, valuerpc.InvalidArgs, valuerpc.InvalidStreamValue:
+			return http.StatusBadRequest
+		case valuerpc.FunctionNotFound:
+			return http.StatusNotFound
+		case valuerpc.WrongFunctionType:
+			return http.StatusMethodNotAllowed
+		case valuerpc.RequestCanceled:
+			return http.StatusConflict
+		case valuerpc.InvalidResult:
+			return http.StatusBadGateway
+		default:
+			return http.StatusInternalServerError
+		}
+	}
+	if errors.Is(err, valueclient.ErrTimeoutError) {
+		return http.StatusGatewayTimeout
+	}
+	// connection failures and undecodable responses
+	return http.StatusBadGateway
+}
+
+func errorBody(err error) map[string]interface{} {
+	body := map[string]interface{}{"error": err.Error()}
+	var serverErr *valueclient.ServerError
+	if errors.As(err, &serverErr) {
+		body["error"] = serverErr.Message
+		body["code"] = int64(serverErr.Code)
+		if serverErr.Details != nil {
+			body["details"] = valuerpc.ToNative(serverErr.Details)
+		}
+	}
+	return body
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorBody(err))
+}
+
+func writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", jsonType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(obj)
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuegateway_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	"github.com/codeallergy/value-rpc/valuegateway"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func startGateway(t *testing.T) (*valuetest.Harness, *httptest.Server) {
+	h := valuetest.Start(t)
+	srv := httptest.NewServer(valuegateway.NewGateway(h.Client))
+	t.Cleanup(srv.Close)
+	return h, srv
+}
+
+func do(t *testing.T, srv *httptest.Server, method, path, accept, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+func errorCode(t *testing.T, body string) vrpc.ErrorCode {
+	var obj struct {
+		Code  int64  `json:"code"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &obj); err != nil {
+		t.Fatalf("error body %q, %v", body, err)
+	}
+	if obj.Error == "" {
+		t.Errorf("error body without message %q", body)
+	}
+	return vrpc.ErrorCode(obj.Code)
+}
+
+func TestFunction(t *testing.T) {
+	h, srv := startGateway(t)
+	h.HandleFunction("add", vrpc.List(vrpc.Number, vrpc.Number), vrpc.Number, func(args value.Value) (value.Value, error) {
+		list := args.(value.List)
+		return value.Long(list.GetNumberAt(0).Long() + list.GetNumberAt(1).Long()), nil
+	})
+	h.FakeFunction("fail", vrpc.Void, vrpc.Void, nil, fmt.Errorf("boom"))
+	h.ScriptStream("src", vrpc.Void, vrpc.Number)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+		code   vrpc.ErrorCode
+		result string
+	}{
+		{"result", "/fn/add", "[1, 2]", http.StatusOK, 0, "3\n"},
+		{"invalid json", "/fn/add", "[1,", http.StatusBadRequest, 0, ""},
+		{"invalid args", "/fn/add", `["a", 2]`, http.StatusBadRequest, vrpc.InvalidArgs, ""},
+		{"not found", "/fn/missing", "", http.StatusNotFound, vrpc.FunctionNotFound, ""},
+		{"wrong type", "/fn/src", "", http.StatusMethodNotAllowed, vrpc.WrongFunctionType, ""},
+		{"failed", "/fn/fail", "", http.StatusInternalServerError, vrpc.FunctionFailed, ""},
+		{"empty name", "/fn/", "", http.StatusNotFound, 0, ""},
+		{"outside of prefix", "/other", "", http.StatusNotFound, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := do(t, srv, http.MethodPost, tt.path, "", tt.body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("expected status %d, actual %d %s", tt.status, resp.StatusCode, body)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("content type %q", ct)
+			}
+			if tt.status == http.StatusOK {
+				if body != tt.result {
+					t.Errorf("expected %q, actual %q", tt.result, body)
+				}
+				return
+			}
+			if code := errorCode(t, body); code != tt.code {
+				t.Errorf("expected code %d, actual %d", tt.code, code)
+			}
+		})
+	}
+
+	h.AssertCallCount("add", 1)
+
+	resp, _ := do(t, srv, http.MethodDelete, "/fn/add", "", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") == "" {
+		t.Errorf("delete expected %d with Allow header, actual %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func TestGetStream(t *testing.T) {
+	h, srv := startGateway(t)
+	h.ScriptStream("src", vrpc.Any, vrpc.Number, value.Long(1), value.Long(2))
+	h.ScriptStream("bad", vrpc.Any, vrpc.Number, value.Long(1), value.Utf8("x"))
+
+	tests := []struct {
+		name        string
+		path        string
+		accept      string
+		contentType string
+		expected    string
+	}{
+		{"sse", "/fn/src", "text/event-stream", "text/event-stream",
+			"data: 1\n\ndata: 2\n\nevent: end\ndata: {}\n\n"},
+		{"ndjson", "/fn/src", "", "application/x-ndjson",
+			"1\n2\n"},
+		{"sse error", "/fn/bad", "text/event-stream", "text/event-stream",
+			"data: 1\n\nevent: error\ndata: {\"code\":6,"},
+		{"ndjson error", "/fn/bad", "", "application/x-ndjson",
+			"1\n{\"code\":6,"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := do(t, srv, http.MethodGet, tt.path+"?args="+url.QueryEscape(`{"from":1}`), tt.accept, "")
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d %s", resp.StatusCode, body)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("expected content type %q, actual %q", tt.contentType, ct)
+			}
+			if !strings.HasPrefix(body, tt.expected) {
+				t.Errorf("expected %q, actual %q", tt.expected, body)
+			}
+		})
+	}
+
+	h.AssertCalled("src", value.EmptyMap().Put("from", value.Long(1)))
+
+	resp, body := do(t, srv, http.MethodGet, "/fn/src?args=%7B", "", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid args expected %d, actual %d %s", http.StatusBadRequest, resp.StatusCode, body)
+	}
+}
+
+func TestPutStream(t *testing.T) {
+	h, srv := startGateway(t)
+	c := h.CollectStream("sink", vrpc.Any, vrpc.Number)
+
+	resp, body := do(t, srv, http.MethodPut, "/fn/sink", "", "1\n\n2\n3\n")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d %s", resp.StatusCode, body)
+	}
+	if body != "{\"sent\":3}\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+	h.AssertValues([]value.Value{value.Long(1), value.Long(2), value.Long(3)}, c.Values())
+	if c.Ends() != 1 {
+		t.Errorf("expected end of stream, actual ends %d", c.Ends())
+	}
+
+	resp, body = do(t, srv, http.MethodPut, "/fn/sink", "", "4\n\"x\"\n5\n")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid value expected %d, actual %d %s", http.StatusBadRequest, resp.StatusCode, body)
+	}
+	if code := errorCode(t, body); code != vrpc.InvalidStreamValue {
+		t.Errorf("expected code %d, actual %d", vrpc.InvalidStreamValue, code)
+	}
+
+	resp, body = do(t, srv, http.MethodPut, "/fn/sink", "", "6\n{\n")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid json expected %d, actual %d %s", http.StatusBadRequest, resp.StatusCode, body)
+	}
+
+	resp, body = do(t, srv, http.MethodPut, "/fn/missing", "", "1\n")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing function expected %d, actual %d %s", http.StatusNotFound, resp.StatusCode, body)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	h, srv := startGateway(t)
+	h.FakeFunction("hello", vrpc.String, vrpc.String, value.Utf8("hi"), nil)
+
+	resp, body := do(t, srv, http.MethodGet, "/schema", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(body, `"hello"`) {
+		t.Errorf("function is not in schema %s", body)
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{&valueclient.ServerError{Code: vrpc.BadRequest}, http.StatusBadRequest},
+		{&valueclient.ServerError{Code: vrpc.InvalidArgs}, http.StatusBadRequest},
+		{&valueclient.ServerError{Code: vrpc.InvalidStreamValue}, http.StatusBadRequest},
+		{&valueclient.ServerError{Code: vrpc.FunctionNotFound}, http.StatusNotFound},
+		{&valueclient.ServerError{Code: vrpc.WrongFunctionType}, http.StatusMethodNotAllowed},
+		{&valueclient.ServerError{Code: vrpc.RequestCanceled}, http.StatusConflict},
+		{&valueclient.ServerError{Code: vrpc.InvalidResult}, http.StatusBadGateway},
+		{&valueclient.ServerError{Code: vrpc.FunctionFailed}, http.StatusInternalServerError},
+		{&valueclient.ServerError{Code: vrpc.UnknownError}, http.StatusInternalServerError},
+		{fmt.Errorf("call, %w", &valueclient.ServerError{Code: vrpc.FunctionNotFound}), http.StatusNotFound},
+		{valueclient.ErrTimeoutError, http.StatusGatewayTimeout},
+		{fmt.Errorf("connection refused"), http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		if actual := valuegateway.HTTPStatus(tt.err); actual != tt.status {
+			t.Errorf("%v expected %d, actual %d", tt.err, tt.status, actual)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuegen
+
+/**
+Contract file describes records and services:
+
+	package names
+
+	// first and last name
+	record FullName tuple {
+		firstName string
+		lastName  string
+	}
+
+	service Names {
+		function setName(FullName) void
+		function getName(void) string
+		get scanNames(void) string        // outgoing stream of strings
+		put uploadNames(void) string      // incoming stream of strings
+		chat echoChat(void) string string // incoming and outgoing types
+	}
+
+Types are bool, long, double, number, string, binary, any, void, list<T>, map<T> and record names.
+Field type with suffix '?' is optional. Records marked 'tuple' are lists of fields, others are maps.
+*/
+
+type Method string
+
+const (
+	FunctionMethod Method = "function"
+	GetMethod      Method = "get"
+	PutMethod      Method = "put"
+	ChatMethod     Method = "chat"
+)
+
+type File struct {
+	Package  string
+	Records  []*Record
+	Services []*Service
+}
+
+type Type struct {
+	Name string // builtin or record name
+	Elem *Type  // element of list and map
+}
+
+type Field struct {
+	Name     string
+	Type     *Type
+	Optional bool
+	Doc      string
+}
+
+type Record struct {
+	Name   string
+	Tuple  bool
+	Fields []*Field
+	Doc    string
+}
+
+type Service struct {
+	Name      string
+	Functions []*Function
+	Doc       string
+}
+
+type Function struct {
+	Name   string
+	Method Method
+	Args   *Type
+	In     *Type // incoming values of put and chat
+	Out    *Type // result of function, outgoing values of get and chat
+	Doc    string
+}
+
+func (t *File) FindRecord(name string) (*Record, bool) {
+	for _, r := range t.Records {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return nil, false
+}
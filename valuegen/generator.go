@@ -0,0 +1,288 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuegen
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"go/format"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var valuePkg = "github.com/codeallergy/value"
+var clientPkg = "github.com/codeallergy/value-rpc/valueclient"
+var serverPkg = "github.com/codeallergy/value-rpc/valueserver"
+
+type generator struct {
+	file     *File
+	out      strings.Builder
+	useValue bool
+}
+
+// generates go source with records, typed client stubs and server interfaces
+func Generate(file *File) ([]byte, error) {
+	g := &generator{file: file}
+	g.scanTypes()
+
+	g.printf("// Code generated by vrpc-gen. DO NOT EDIT.\n\n")
+	g.printf("package %s\n\n", file.Package)
+	g.printf("import (\n")
+	if g.useValue {
+		g.printf("%q\n", valuePkg)
+	}
+	if len(file.Services) > 0 {
+		g.printf("%q\n%q\n", clientPkg, serverPkg)
+	}
+	g.printf(")\n\n")
+
+	for _, r := range file.Records {
+		g.genRecord(r)
+	}
+	for _, s := range file.Services {
+		g.genServer(s)
+		g.genClient(s)
+	}
+
+	src, err := format.Source([]byte(g.out.String()))
+	if err != nil {
+		return nil, errors.Errorf("format generated code, %v", err)
+	}
+	return src, nil
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.out, format, args...)
+}
+
+func (g *generator) doc(doc string) {
+	if doc == "" {
+		return
+	}
+	for _, line := range strings.Split(doc, "\n") {
+		g.printf("// %s\n", line)
+	}
+}
+
+func (g *generator) scanTypes() {
+	var scan func(t *Type)
+	scan = func(t *Type) {
+		if t == nil {
+			return
+		}
+		if t.Name == "any" {
+			g.useValue = true
+		}
+		scan(t.Elem)
+	}
+	for _, r := range g.file.Records {
+		for _, f := range r.Fields {
+			scan(f.Type)
+		}
+	}
+	for _, s := range g.file.Services {
+		for _, fn := range s.Functions {
+			scan(fn.Args)
+			scan(fn.In)
+			scan(fn.Out)
+		}
+	}
+}
+
+func (g *generator) goType(t *Type) string {
+	switch t.Name {
+	case "bool":
+		return "bool"
+	case "long":
+		return "int64"
+	case "double", "number":
+		return "float64"
+	case "string":
+		return "string"
+	case "binary":
+		return "[]byte"
+	case "any":
+		return "value.Value"
+	case "void":
+		return "struct{}"
+	case "list":
+		return "[]" + g.goType(t.Elem)
+	case "map":
+		return "map[string]" + g.goType(t.Elem)
+	}
+	return exported(t.Name)
+}
+
+func (g *generator) genRecord(r *Record) {
+	g.doc(r.Doc)
+	g.printf("type %s struct {\n", exported(r.Name))
+	for i, f := range r.Fields {
+		g.doc(f.Doc)
+		goType := g.goType(f.Type)
+		tag := f.Name
+		if r.Tuple {
+			tag = strconv.Itoa(i)
+		}
+		if f.Optional {
+			if pointerOptional(f.Type) {
+				goType = "*" + goType
+			} else {
+				tag += ",opt"
+			}
+		}
+		g.printf("%s %s `vrpc:\"%s\"`\n", exported(f.Name), goType, tag)
+	}
+	g.printf("}\n\n")
+}
+
+// scalars and records are optional by pointer, lists, maps and values by nil
+func pointerOptional(t *Type) bool {
+	switch t.Name {
+	case "list", "map", "any", "binary", "void":
+		return false
+	}
+	return true
+}
+
+func isVoid(t *Type) bool {
+	return t == nil || t.Name == "void"
+}
+
+func (g *generator) genServer(s *Service) {
+	name := exported(s.Name) + "Server"
+
+	g.doc(s.Doc)
+	g.printf("type %s interface {\n", name)
+	for _, fn := range s.Functions {
+		g.doc(fn.Doc)
+		g.printf("%s(%s) %s\n", exported(fn.Name), g.serverParams(fn), g.serverResults(fn))
+	}
+	g.printf("}\n\n")
+
+	g.printf("// registers all functions of %s in the server\n", s.Name)
+	g.printf("func Register%s(srv valueserver.Server, impl %s) error {\n", name, name)
+	for _, fn := range s.Functions {
+		g.printf("if err := %s; err != nil {\nreturn err\n}\n", g.registration(fn))
+		if fn.Doc != "" {
+			g.printf("if err := srv.DescribeFunction(%q, %q); err != nil {\nreturn err\n}\n", fn.Name, fn.Doc)
+		}
+	}
+	g.printf("return nil\n}\n\n")
+}
+
+func (g *generator) serverParams(fn *Function) string {
+	var params []string
+	if !isVoid(fn.Args) {
+		params = append(params, "req "+g.goType(fn.Args))
+	}
+	if fn.Method == PutMethod || fn.Method == ChatMethod {
+		params = append(params, "inC <-chan "+g.goType(fn.In))
+	}
+	return strings.Join(params, ", ")
+}
+
+func (g *generator) serverResults(fn *Function) string {
+	switch fn.Method {
+	case GetMethod, ChatMethod:
+		return fmt.Sprintf("(<-chan %s, error)", g.goType(fn.Out))
+	case PutMethod:
+		return "error"
+	}
+	if isVoid(fn.Out) {
+		return "error"
+	}
+	return fmt.Sprintf("(%s, error)", g.goType(fn.Out))
+}
+
+// wraps implementation method to the typed handler expected by valueserver
+func (g *generator) registration(fn *Function) string {
+	method := "impl." + exported(fn.Name)
+	req := g.goType(fn.Args)
+	switch fn.Method {
+	case GetMethod:
+		if isVoid(fn.Args) {
+			method = fmt.Sprintf("func(req struct{}) (<-chan %s, error) {\nreturn %s()\n}", g.goType(fn.Out), method)
+		}
+		return fmt.Sprintf("valueserver.AddTypedOutgoingStream(srv, %q, %s)", fn.Name, method)
+	case PutMethod:
+		if isVoid(fn.Args) {
+			method = fmt.Sprintf("func(req struct{}, inC <-chan %s) error {\nreturn %s(inC)\n}", g.goType(fn.In), method)
+		}
+		return fmt.Sprintf("valueserver.AddTypedIncomingStream(srv, %q, %s)", fn.Name, method)
+	case ChatMethod:
+		if isVoid(fn.Args) {
+			method = fmt.Sprintf("func(req struct{}, inC <-chan %s) (<-chan %s, error) {\nreturn %s(inC)\n}", g.goType(fn.In), g.goType(fn.Out), method)
+		}
+		return fmt.Sprintf("valueserver.AddTypedChat(srv, %q, %s)", fn.Name, method)
+	}
+	if isVoid(fn.Args) || isVoid(fn.Out) {
+		call := method + "(req)"
+		if isVoid(fn.Args) {
+			call = method + "()"
+		}
+		if isVoid(fn.Out) {
+			method = fmt.Sprintf("func(req %s) (struct{}, error) {\nreturn struct{}{}, %s\n}", req, call)
+		} else {
+			method = fmt.Sprintf("func(req %s) (%s, error) {\nreturn %s\n}", req, g.goType(fn.Out), call)
+		}
+	}
+	return fmt.Sprintf("valueserver.AddTypedFunction(srv, %q, %s)", fn.Name, method)
+}
+
+func (g *generator) genClient(s *Service) {
+	name := exported(s.Name) + "Client"
+
+	g.printf("// typed client of %s\n", s.Name)
+	g.printf("type %s struct {\ncli valueclient.Client\n}\n\n", name)
+	g.printf("func New%s(cli valueclient.Client) *%s {\nreturn &%s{cli: cli}\n}\n\n", name, name, name)
+
+	for _, fn := range s.Functions {
+		req := g.goType(fn.Args)
+		var params []string
+		arg := "struct{}{}"
+		if !isVoid(fn.Args) {
+			params = append(params, "req "+req)
+			arg = "req"
+		}
+		g.doc(fn.Doc)
+		switch fn.Method {
+		case FunctionMethod:
+			out := g.goType(fn.Out)
+			if isVoid(fn.Out) {
+				g.printf("func (t *%s) %s(%s) error {\n", name, exported(fn.Name), strings.Join(params, ", "))
+				g.printf("_, err := valueclient.Call[%s, %s](t.cli, %q, %s)\nreturn err\n}\n\n", req, out, fn.Name, arg)
+			} else {
+				g.printf("func (t *%s) %s(%s) (%s, error) {\n", name, exported(fn.Name), strings.Join(params, ", "), out)
+				g.printf("return valueclient.Call[%s, %s](t.cli, %q, %s)\n}\n\n", req, out, fn.Name, arg)
+			}
+		case GetMethod:
+			out := g.goType(fn.Out)
+			params = append(params, "receiveCap int")
+			g.printf("func (t *%s) %s(%s) (<-chan %s, int64, error) {\n", name, exported(fn.Name), strings.Join(params, ", "), out)
+			g.printf("return valueclient.GetTypedStream[%s, %s](t.cli, %q, %s, receiveCap)\n}\n\n", req, out, fn.Name, arg)
+		case PutMethod:
+			in := g.goType(fn.In)
+			params = append(params, "putCh <-chan "+in)
+			g.printf("func (t *%s) %s(%s) error {\n", name, exported(fn.Name), strings.Join(params, ", "))
+			g.printf("return valueclient.PutTypedStream[%s, %s](t.cli, %q, %s, putCh)\n}\n\n", req, in, fn.Name, arg)
+		case ChatMethod:
+			in, out := g.goType(fn.In), g.goType(fn.Out)
+			params = append(params, "receiveCap int", "putCh <-chan "+in)
+			g.printf("func (t *%s) %s(%s) (<-chan %s, int64, error) {\n", name, exported(fn.Name), strings.Join(params, ", "), out)
+			g.printf("return valueclient.TypedChat[%s, %s, %s](t.cli, %q, %s, receiveCap, putCh)\n}\n\n", req, in, out, fn.Name, arg)
+		}
+	}
+}
+
+func exported(name string) string {
+	if name == "" {
+		return name
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
@@ -0,0 +1,367 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuegen
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"unicode"
+)
+
+var builtinTypes = map[string]bool{
+	"bool":   true,
+	"long":   true,
+	"double": true,
+	"number": true,
+	"string": true,
+	"binary": true,
+	"any":    true,
+	"void":   true,
+	"list":   true,
+	"map":    true,
+}
+
+type tokenKind int
+
+const (
+	identToken tokenKind = iota
+	symbolToken
+	commentToken
+	eofToken
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	doc    string // comments collected before the current declaration
+}
+
+func Parse(src string) (*File, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	file, err := p.parseFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	line := 1
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			line++
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			j := i + 2
+			for j < len(runes) && runes[j] != '\n' {
+				j++
+			}
+			tokens = append(tokens, token{commentToken, strings.TrimSpace(string(runes[i+2 : j])), line})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{identToken, string(runes[i:j]), line})
+			i = j
+		case strings.ContainsRune("{}()<>?,;", r):
+			tokens = append(tokens, token{symbolToken, string(r), line})
+			i++
+		default:
+			return nil, errors.Errorf("line %d: unexpected character '%c'", line, r)
+		}
+	}
+	return append(tokens, token{eofToken, "", line}), nil
+}
+
+// skips comments, leading ones are collected as documentation
+func (p *parser) peek() token {
+	for p.tokens[p.pos].kind == commentToken {
+		if p.doc != "" {
+			p.doc += "\n"
+		}
+		p.doc += p.tokens[p.pos].text
+		p.pos++
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.peek()
+	if tok.kind != eofToken {
+		p.pos++
+	}
+	return tok
+}
+
+// trailing comment on the same line as the previous token
+func (p *parser) trailingComment() string {
+	if p.pos == 0 || p.pos >= len(p.tokens) {
+		return ""
+	}
+	tok := p.tokens[p.pos]
+	if tok.kind == commentToken && tok.line == p.tokens[p.pos-1].line {
+		p.pos++
+		return tok.text
+	}
+	return ""
+}
+
+func (p *parser) takeDoc() string {
+	doc := p.doc
+	p.doc = ""
+	return doc
+}
+
+func (p *parser) expect(text string) error {
+	tok := p.next()
+	if tok.text != text || tok.kind == commentToken {
+		return errors.Errorf("line %d: expected '%s', found '%s'", tok.line, text, tok.text)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	tok := p.next()
+	if tok.kind != identToken {
+		return "", errors.Errorf("line %d: expected identifier, found '%s'", tok.line, tok.text)
+	}
+	return tok.text, nil
+}
+
+// looks at the next token without collecting comments
+func (p *parser) raw(text string) bool {
+	tok := p.tokens[p.pos]
+	return tok.kind == symbolToken && tok.text == text
+}
+
+func (p *parser) skipSeparator() {
+	if p.raw(";") || p.raw(",") {
+		p.pos++
+	}
+}
+
+func (p *parser) parseFile() (*File, error) {
+	if err := p.expect("package"); err != nil {
+		return nil, err
+	}
+	p.takeDoc()
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	file := &File{Package: name}
+
+	for {
+		tok := p.peek()
+		switch {
+		case tok.kind == eofToken:
+			return file, nil
+		case tok.text == "record":
+			r, err := p.parseRecord()
+			if err != nil {
+				return nil, err
+			}
+			file.Records = append(file.Records, r)
+		case tok.text == "service":
+			s, err := p.parseService()
+			if err != nil {
+				return nil, err
+			}
+			file.Services = append(file.Services, s)
+		default:
+			return nil, errors.Errorf("line %d: expected 'record' or 'service', found '%s'", tok.line, tok.text)
+		}
+	}
+}
+
+func (p *parser) parseRecord() (*Record, error) {
+	p.next()
+	r := &Record{Doc: p.takeDoc()}
+	var err error
+	if r.Name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if p.peek().text == "tuple" {
+		p.next()
+		r.Tuple = true
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	p.takeDoc()
+	for p.peek().text != "}" {
+		if p.peek().kind == eofToken {
+			return nil, errors.Errorf("record '%s' is not closed", r.Name)
+		}
+		f := &Field{}
+		if f.Name, err = p.ident(); err != nil {
+			return nil, err
+		}
+		f.Doc = p.takeDoc()
+		if f.Type, err = p.parseType(); err != nil {
+			return nil, err
+		}
+		if p.raw("?") {
+			p.pos++
+			f.Optional = true
+		}
+		p.skipSeparator()
+		if c := p.trailingComment(); c != "" && f.Doc == "" {
+			f.Doc = c
+		}
+		r.Fields = append(r.Fields, f)
+	}
+	p.next()
+	p.takeDoc()
+	return r, nil
+}
+
+func (p *parser) parseService() (*Service, error) {
+	p.next()
+	s := &Service{Doc: p.takeDoc()}
+	var err error
+	if s.Name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	p.takeDoc()
+	for p.peek().text != "}" {
+		tok := p.next()
+		if tok.kind == eofToken {
+			return nil, errors.Errorf("service '%s' is not closed", s.Name)
+		}
+		fn := &Function{Method: Method(tok.text), Doc: p.takeDoc()}
+		switch fn.Method {
+		case FunctionMethod, GetMethod, PutMethod, ChatMethod:
+		default:
+			return nil, errors.Errorf("line %d: expected function, get, put or chat, found '%s'", tok.line, tok.text)
+		}
+		if fn.Name, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if fn.Args, err = p.parseType(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		switch fn.Method {
+		case FunctionMethod, GetMethod:
+			fn.Out, err = p.parseType()
+		case PutMethod:
+			fn.In, err = p.parseType()
+		case ChatMethod:
+			if fn.In, err = p.parseType(); err == nil {
+				fn.Out, err = p.parseType()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.skipSeparator()
+		if c := p.trailingComment(); c != "" && fn.Doc == "" {
+			fn.Doc = c
+		}
+		s.Functions = append(s.Functions, fn)
+	}
+	p.next()
+	p.takeDoc()
+	return s, nil
+}
+
+func (p *parser) parseType() (*Type, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	t := &Type{Name: name}
+	if name == "list" || name == "map" {
+		if err := p.expect("<"); err != nil {
+			return nil, err
+		}
+		if t.Elem, err = p.parseType(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(">"); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func validate(file *File) error {
+	names := make(map[string]bool)
+	for _, r := range file.Records {
+		if builtinTypes[r.Name] || names[r.Name] {
+			return errors.Errorf("duplicate or reserved record name '%s'", r.Name)
+		}
+		names[r.Name] = true
+	}
+	for _, r := range file.Records {
+		for _, f := range r.Fields {
+			if err := validateType(file, f.Type); err != nil {
+				return errors.Errorf("record '%s' field '%s', %v", r.Name, f.Name, err)
+			}
+		}
+	}
+	functions := make(map[string]bool)
+	for _, s := range file.Services {
+		for _, fn := range s.Functions {
+			if functions[fn.Name] {
+				return errors.Errorf("duplicate function '%s'", fn.Name)
+			}
+			functions[fn.Name] = true
+			for _, t := range []*Type{fn.Args, fn.In, fn.Out} {
+				if t == nil {
+					continue
+				}
+				if err := validateType(file, t); err != nil {
+					return errors.Errorf("function '%s', %v", fn.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateType(file *File, t *Type) error {
+	if builtinTypes[t.Name] {
+		if t.Elem != nil {
+			return validateType(file, t.Elem)
+		}
+		return nil
+	}
+	if _, ok := file.FindRecord(t.Name); !ok {
+		return errors.Errorf("unknown type '%s'", t.Name)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuegen
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files of generator")
+
+func TestGenerateGolden(t *testing.T) {
+	tests := []struct {
+		src    string
+		golden string
+	}{
+		{"testdata/types.vrpc", "testdata/types.vrpc.golden"},
+		{"../example/names/names.vrpc", "../example/names/names.vrpc.go"},
+	}
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.src), func(t *testing.T) {
+			src, err := os.ReadFile(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := Parse(string(src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := Generate(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *update {
+				if err := os.WriteFile(tt.golden, out, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := os.ReadFile(tt.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, expected) {
+				t.Errorf("generated code differs from %s, run go test -update\n%s", tt.golden, out)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	file, err := Parse(`package p
+
+// doc of record
+record R tuple {
+	a string   // doc of field
+	b list<R>?
+}
+
+service S {
+	// doc of function
+	function f(R) map<long>
+	chat c(void) string R
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Package != "p" || len(file.Records) != 1 || len(file.Services) != 1 {
+		t.Fatalf("unexpected file %+v", file)
+	}
+	r := file.Records[0]
+	if r.Name != "R" || !r.Tuple || r.Doc != "doc of record" || len(r.Fields) != 2 {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if f := r.Fields[0]; f.Name != "a" || f.Type.Name != "string" || f.Optional || f.Doc != "doc of field" {
+		t.Errorf("unexpected field %+v", f)
+	}
+	if f := r.Fields[1]; f.Type.Name != "list" || f.Type.Elem.Name != "R" || !f.Optional {
+		t.Errorf("unexpected field %+v", f)
+	}
+	s := file.Services[0]
+	if s.Name != "S" || len(s.Functions) != 2 {
+		t.Fatalf("unexpected service %+v", s)
+	}
+	if fn := s.Functions[0]; fn.Method != FunctionMethod || fn.Args.Name != "R" || fn.Out.Name != "map" || fn.Out.Elem.Name != "long" || fn.Doc != "doc of function" {
+		t.Errorf("unexpected function %+v", fn)
+	}
+	if fn := s.Functions[1]; fn.Method != ChatMethod || fn.Args.Name != "void" || fn.In.Name != "string" || fn.Out.Name != "R" {
+		t.Errorf("unexpected chat %+v", fn)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		message string
+	}{
+		{"no package", "record R {}", "expected 'package'"},
+		{"unexpected character", "package p\n@", "unexpected character '@'"},
+		{"unknown declaration", "package p\nenum E {}", "expected 'record' or 'service'"},
+		{"record not closed", "package p\nrecord R {\n a string", "record 'R' is not closed"},
+		{"service not closed", "package p\nservice S {", "service 'S' is not closed"},
+		{"unknown method", "package p\nservice S {\n call f(void) void\n}", "expected function, get, put or chat"},
+		{"unknown type", "package p\nrecord R {\n a uuid\n}", "unknown type 'uuid'"},
+		{"duplicate record", "package p\nrecord R {}\nrecord R {}", "duplicate or reserved record name 'R'"},
+		{"reserved record", "package p\nrecord string {}", "duplicate or reserved record name 'string'"},
+		{"duplicate function", "package p\nservice S {\n function f(void) void\n get f(void) string\n}", "duplicate function 'f'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.src)
+			if err == nil {
+				t.Fatalf("expected error with '%s'", tt.message)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected error with '%s', actual '%v'", tt.message, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuemock
+
+import (
+	"context"
+	"fmt"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valueserver"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+var ErrNoCase = errors.New("no scripted case for args")
+
+var VerifyTimeout = 5 * time.Second // Failures waits for incoming streams to end
+
+type mockCase struct {
+	args    value.Value
+	hasArgs bool
+	result  value.Value
+	err     error
+	delay   time.Duration
+	values  []value.Value
+	expect  []value.Value
+}
+
+type Mock struct {
+	logger *zap.Logger
+
+	lock     sync.Mutex
+	failures []string
+	calls    map[string]int
+
+	verifying sync.WaitGroup // incoming streams with expectations
+}
+
+// registers scripted functions in the server, all with any args and results
+func NewMock(srv valueserver.Server, script *Script, logger *zap.Logger) (*Mock, error) {
+	t := &Mock{
+		logger: logger,
+		calls:  make(map[string]int),
+	}
+	for _, fn := range script.Functions {
+		if err := t.register(srv, fn); err != nil {
+			return nil, errors.Errorf("function '%s', %v", fn.Name, err)
+		}
+		if fn.Description != "" {
+			srv.DescribeFunction(fn.Name, fn.Description, "mock")
+		}
+	}
+	return t, nil
+}
+
+// mismatches of expected incoming values, waits up to VerifyTimeout for open incoming streams,
+// client of the stream receives InvalidStreamValue error on the first mismatch if the stream is still open
+func (t *Mock) Failures() []string {
+	done := make(chan struct{})
+	go func() {
+		t.verifying.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(VerifyTimeout):
+		t.fail("mock", "incoming streams are not finished in %v", VerifyTimeout)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]string(nil), t.failures...)
+}
+
+// number of calls by function name
+func (t *Mock) Calls() map[string]int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	out := make(map[string]int, len(t.calls))
+	for name, n := range t.calls {
+		out[name] = n
+	}
+	return out
+}
+
+func (t *Mock) fail(name, format string, args ...interface{}) error {
+	msg := fmt.Sprintf("%s: %s", name, fmt.Sprintf(format, args...))
+	t.logger.Warn("mock expectation failed", zap.String("failure", msg))
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.failures = append(t.failures, msg)
+	return errors.New(msg)
+}
+
+func (t *Mock) called(name string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.calls[name]++
+}
+
+func parseCases(cases []Case) ([]mockCase, error) {
+	var list []mockCase
+	for i, c := range cases {
+		mc := mockCase{delay: time.Duration(c.Delay)}
+		var err error
+		if len(c.Args) > 0 {
+			mc.hasArgs = true
+			if mc.args, err = valuerpc.ParseJSON(c.Args); err != nil {
+				return nil, errors.Errorf("case %d args, %v", i, err)
+			}
+		}
+		if len(c.Result) > 0 {
+			if mc.result, err = valuerpc.ParseJSON(c.Result); err != nil {
+				return nil, errors.Errorf("case %d result, %v", i, err)
+			}
+		}
+		if c.Error != "" {
+			mc.err = errors.New(c.Error)
+		}
+		for j, raw := range c.Values {
+			val, err := valuerpc.ParseJSON(raw)
+			if err != nil {
+				return nil, errors.Errorf("case %d value %d, %v", i, j, err)
+			}
+			mc.values = append(mc.values, val)
+		}
+		for j, raw := range c.Expect {
+			val, err := valuerpc.ParseJSON(raw)
+			if err != nil {
+				return nil, errors.Errorf("case %d expected value %d, %v", i, j, err)
+			}
+			mc.expect = append(mc.expect, val)
+		}
+		list = append(list, mc)
+	}
+	return list, nil
+}
+
+func equal(a, b value.Value) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(b)
+}
+
+func findCase(cases []mockCase, args value.Value) (*mockCase, error) {
+	for i := range cases {
+		if !cases[i].hasArgs || equal(cases[i].args, args) {
+			return &cases[i], nil
+		}
+	}
+	return nil, ErrNoCase
+}
+
+func (t *Mock) register(srv valueserver.Server, fn FunctionScript) error {
+	cases, err := parseCases(fn.Cases)
+	if err != nil {
+		return err
+	}
+	name := fn.Name
+
+	switch fn.Kind {
+	case "", valuerpc.SingleFunctionKind:
+		return srv.AddFunction(name, valuerpc.Any, valuerpc.Any, func(args value.Value) (value.Value, error) {
+			t.called(name)
+			c, err := findCase(cases, args)
+			if err != nil {
+				return nil, err
+			}
+			time.Sleep(c.delay)
+			return c.result, c.err
+		})
+
+	case valuerpc.OutgoingStreamKind:
+		return srv.AddContextOutgoingStream(name, valuerpc.Any, valuerpc.Any, func(ctx context.Context, args value.Value) (<-chan value.Value, error) {
+			t.called(name)
+			c, err := findCase(cases, args)
+			if err != nil {
+				return nil, err
+			}
+			if c.err != nil {
+				return nil, c.err
+			}
+			outC := make(chan value.Value)
+			go func() {
+				defer close(outC)
+				for _, val := range c.values {
+					if !send(ctx, outC, val, c.delay) {
+						return
+					}
+				}
+			}()
+			return outC, nil
+		})
+
+	case valuerpc.IncomingStreamKind:
+		return srv.AddContextIncomingStream(name, valuerpc.Any, valuerpc.Any, func(ctx context.Context, args value.Value, inC <-chan value.Value) error {
+			t.called(name)
+			c, err := findCase(cases, args)
+			if err != nil {
+				return err
+			}
+			if c.err != nil {
+				return c.err
+			}
+			t.verifying.Add(1)
+			go func() {
+				defer t.verifying.Done()
+				v := t.newVerifier(ctx, name, c.expect)
+				for val := range inC {
+					v.receive(val)
+				}
+				v.end()
+			}()
+			return nil
+		})
+
+	case valuerpc.ChatKind:
+		return srv.AddContextChat(name, valuerpc.Any, valuerpc.Any, valuerpc.Any, func(ctx context.Context, args value.Value, inC <-chan value.Value) (<-chan value.Value, error) {
+			t.called(name)
+			c, err := findCase(cases, args)
+			if err != nil {
+				return nil, err
+			}
+			if c.err != nil {
+				return nil, c.err
+			}
+			outC := make(chan value.Value)
+			t.verifying.Add(1)
+			go func() {
+				defer t.verifying.Done()
+				defer close(outC)
+				v := t.newVerifier(ctx, name, c.expect)
+				for val := range inC {
+					// answers follow incoming values in order, unexpected value is not answered
+					i := v.received
+					v.receive(val)
+					if i < len(c.values) && !v.failed {
+						send(ctx, outC, c.values[i], c.delay)
+					}
+				}
+				v.end()
+			}()
+			return outC, nil
+		})
+	}
+
+	return errors.Errorf("unknown kind '%s'", fn.Kind)
+}
+
+// sends value after delay, false if ctx is done
+func send(ctx context.Context, outC chan<- value.Value, val value.Value, delay time.Duration) bool {
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+	}
+	select {
+	case outC <- val:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// checks incoming values while they arrive, the first mismatch fails the stream
+type verifier struct {
+	mock     *Mock
+	ctx      context.Context
+	name     string
+	expected []value.Value
+	received int
+	failed   bool
+}
+
+// nil expectation accepts any incoming values
+func (t *Mock) newVerifier(ctx context.Context, name string, expected []value.Value) *verifier {
+	return &verifier{mock: t, ctx: ctx, name: name, expected: expected}
+}
+
+func (t *verifier) receive(val value.Value) {
+	i := t.received
+	t.received++
+	if t.expected == nil || t.failed {
+		return
+	}
+	if i >= len(t.expected) {
+		t.fail(t.mock.fail(t.name, "expected %d incoming values, received more", len(t.expected)))
+	} else if !equal(t.expected[i], val) {
+		t.fail(t.mock.fail(t.name, "incoming value %d expected %v, received %v", i, t.expected[i], val))
+	}
+}
+
+func (t *verifier) end() {
+	if t.expected == nil || t.failed {
+		return
+	}
+	if t.received != len(t.expected) {
+		t.fail(t.mock.fail(t.name, "expected %d incoming values, received %d", len(t.expected), t.received))
+	}
+}
+
+// stream is still open for chat and for PUT until client ends it
+func (t *verifier) fail(err error) {
+	t.failed = true
+	valueserver.FailStream(t.ctx, valuerpc.InvalidStreamValue, err)
+}
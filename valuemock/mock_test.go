@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuemock_test
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	"github.com/codeallergy/value-rpc/valuemock"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+var script = `{"functions": [
+	{"name": "getName", "cases": [{"args": 1, "result": "Alex", "delay": "10ms"}, {"args": 2, "error": "not found"}]},
+	{"name": "ticks", "kind": "outgoingStream", "cases": [{"values": [1, 2, 3], "delay": 5}]},
+	{"name": "upload", "kind": "incomingStream", "cases": [{"expect": ["a", "b"]}]},
+	{"name": "echo", "kind": "chat", "cases": [{"expect": ["hi", "bye"], "values": ["hello", "goodbye"]}]},
+	{"name": "confirm", "kind": "chat", "cases": [{"expect": ["yes"], "values": ["ok"], "delay": "1h"}]}
+]}`
+
+func startMock(t *testing.T) (*valuetest.Harness, *valuemock.Mock) {
+	s, err := valuemock.ParseScript([]byte(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := valuetest.Start(t)
+	m, err := valuemock.NewMock(h.Server, s, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, m
+}
+
+func TestMockFunction(t *testing.T) {
+	h, m := startMock(t)
+
+	tests := []struct {
+		args    value.Value
+		result  value.Value
+		message string
+	}{
+		{value.Long(1), value.Utf8("Alex"), ""},
+		{value.Long(2), nil, "not found"},
+		{value.Long(3), nil, valuemock.ErrNoCase.Error()},
+	}
+	for _, tt := range tests {
+		res, err := h.Client.CallFunction("getName", tt.args)
+		if tt.message != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("args %v, expected error with '%s', actual '%v'", tt.args, tt.message, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("args %v, %v", tt.args, err)
+		} else if !valuetest.Equal(tt.result, res) {
+			t.Errorf("args %v, expected %v, actual %v", tt.args, tt.result, res)
+		}
+	}
+
+	if n := m.Calls()["getName"]; n != len(tests) {
+		t.Errorf("expected %d calls, actual %d", len(tests), n)
+	}
+	if failures := m.Failures(); len(failures) != 0 {
+		t.Errorf("unexpected failures %v", failures)
+	}
+}
+
+func TestMockStreams(t *testing.T) {
+	h, m := startMock(t)
+
+	readC, _, err := h.Client.GetStream("ticks", nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.AssertValues([]value.Value{value.Long(1), value.Long(2), value.Long(3)}, h.Drain(readC, valuetest.DefaultWaitTimeout))
+
+	putC := make(chan value.Value, 2)
+	putC <- value.Utf8("a")
+	putC <- value.Utf8("b")
+	close(putC)
+	done := make(chan error, 1)
+	if err := h.Client.PutStreamContext(context.Background(), "upload", nil, putC, valueclient.WithDone(done)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("upload, %v", err)
+	}
+
+	chatC := make(chan value.Value)
+	readC, _, err = h.Client.Chat("echo", nil, 10, chatC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatC <- value.Utf8("hi")
+	if v := <-readC; !valuetest.Equal(value.Utf8("hello"), v) {
+		t.Errorf("expected hello, actual %v", v)
+	}
+	chatC <- value.Utf8("bye")
+	if v := <-readC; !valuetest.Equal(value.Utf8("goodbye"), v) {
+		t.Errorf("expected goodbye, actual %v", v)
+	}
+	close(chatC)
+	h.Drain(readC, valuetest.DefaultWaitTimeout)
+
+	if failures := m.Failures(); len(failures) != 0 {
+		t.Errorf("unexpected failures %v", failures)
+	}
+}
+
+func TestMockUnexpectedValue(t *testing.T) {
+	h, m := startMock(t)
+
+	putC := make(chan value.Value, 2)
+	putC <- value.Utf8("a")
+	putC <- value.Utf8("c")
+	done := make(chan error, 1)
+	if err := h.Client.PutStreamContext(context.Background(), "upload", nil, putC, valueclient.WithDone(done)); err != nil {
+		t.Fatal(err)
+	}
+
+	// stream fails on the first mismatch while it is still open
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "incoming value 1 expected") {
+			t.Errorf("expected mismatch error, actual '%v'", err)
+		}
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Fatal("stream is not failed on unexpected value")
+	}
+	close(putC)
+
+	failures := m.Failures()
+	if len(failures) != 1 || !strings.HasPrefix(failures[0], "upload: incoming value 1 expected") {
+		t.Errorf("unexpected failures %v", failures)
+	}
+}
+
+func TestMockUnexpectedChatValue(t *testing.T) {
+	h, m := startMock(t)
+
+	chatC := make(chan value.Value)
+	defer close(chatC)
+	done := make(chan error, 1)
+	readC, _, err := h.Client.ChatContext(context.Background(), "confirm", nil, 10, chatC, valueclient.WithDone(done))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unexpected value fails the chat at once, the delayed answer is not sent
+	chatC <- value.Utf8("no")
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "incoming value 0 expected") {
+			t.Errorf("expected mismatch error, actual '%v'", err)
+		}
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Fatal("chat is not failed on unexpected value")
+	}
+	if values := h.Drain(readC, valuetest.DefaultWaitTimeout); len(values) != 0 {
+		t.Errorf("expected no answer to unexpected value, actual %v", values)
+	}
+
+	failures := m.Failures()
+	if len(failures) != 1 || !strings.HasPrefix(failures[0], "confirm: incoming value 0 expected") {
+		t.Errorf("unexpected failures %v", failures)
+	}
+}
+
+func TestMockCancel(t *testing.T) {
+	h, m := startMock(t)
+
+	readC, requestId, err := h.Client.GetStream("ticks", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-readC
+	h.Client.CancelRequest(requestId)
+	h.Drain(readC, valuetest.DefaultWaitTimeout)
+
+	if failures := m.Failures(); len(failures) != 0 {
+		t.Errorf("unexpected failures %v", failures)
+	}
+}
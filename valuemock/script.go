@@ -0,0 +1,286 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuemock
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/pkg/errors"
+	"os"
+	"sort"
+	"time"
+)
+
+/**
+Declarative script of the mock server in JSON:
+
+	{"functions": [
+	  {"name": "getName", "cases": [{"args": [1], "result": "Alex", "delay": "10ms"}, {"error": "not found"}]},
+	  {"name": "ticks", "kind": "outgoingStream", "cases": [{"values": [1, 2, 3], "delay": "1s"}]},
+	  {"name": "upload", "kind": "incomingStream", "cases": [{"expect": ["a", "b"]}]},
+	  {"name": "echo", "kind": "chat", "cases": [{"expect": ["hi"], "values": ["hello"]}]}
+	]}
+
+The first case with equal args is used, case without args matches any call.
+*/
+
+type Script struct {
+	Functions []FunctionScript `json:"functions"`
+}
+
+type FunctionScript struct {
+	Name        string                `json:"name"`
+	Kind        valuerpc.FunctionKind `json:"kind,omitempty"` // default is function
+	Description string                `json:"description,omitempty"`
+	Cases       []Case                `json:"cases"`
+}
+
+type Case struct {
+	Args   json.RawMessage   `json:"args,omitempty"`
+	Result json.RawMessage   `json:"result,omitempty"`
+	Error  string            `json:"error,omitempty"`
+	Delay  Duration          `json:"delay,omitempty"`  // before the result or each outgoing value
+	Values []json.RawMessage `json:"values,omitempty"` // outgoing stream values, chat answers to incoming values in order
+	Expect []json.RawMessage `json:"expect,omitempty"` // expected incoming stream values
+}
+
+// duration in JSON is a string like "150ms" or number of milliseconds
+type Duration time.Duration
+
+func (t Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(t).String())
+}
+
+func (t *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*t = Duration(d)
+		return nil
+	}
+	var mls float64
+	if err := json.Unmarshal(data, &mls); err != nil {
+		return errors.Errorf("duration expected string or milliseconds, %s", string(data))
+	}
+	*t = Duration(time.Duration(mls * float64(time.Millisecond)))
+	return nil
+}
+
+func ParseScript(data []byte) (*Script, error) {
+	var script Script
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&script); err != nil {
+		return nil, errors.Errorf("parse mock script, %v", err)
+	}
+	for i, fn := range script.Functions {
+		if fn.Name == "" {
+			return nil, errors.Errorf("function %d has no name", i)
+		}
+		switch fn.Kind {
+		case "", valuerpc.SingleFunctionKind, valuerpc.OutgoingStreamKind, valuerpc.IncomingStreamKind, valuerpc.ChatKind:
+		default:
+			return nil, errors.Errorf("function '%s' has unknown kind '%s'", fn.Name, fn.Kind)
+		}
+	}
+	return &script, nil
+}
+
+func LoadScript(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScript(data)
+}
+
+func (t *Script) Marshal() ([]byte, error) {
+	return json.MarshalIndent(t, "", "  ")
+}
+
+type recordedRequest struct {
+	at       time.Time
+	kind     valuerpc.FunctionKind
+	name     string
+	args     value.Value
+	result   value.Value
+	err      string
+	answered time.Time
+	incoming []value.Value
+	outgoing []time.Time
+	values   []value.Value
+}
+
+func kindOfRequest(mt valuerpc.MessageType) (valuerpc.FunctionKind, bool) {
+	switch mt {
+	case valuerpc.FunctionRequest:
+		return valuerpc.SingleFunctionKind, true
+	case valuerpc.GetStreamRequest:
+		return valuerpc.OutgoingStreamKind, true
+	case valuerpc.PutStreamRequest:
+		return valuerpc.IncomingStreamKind, true
+	case valuerpc.ChatRequest:
+		return valuerpc.ChatKind, true
+	}
+	return "", false
+}
+
+// builds script from recorded traffic, the first call of the function with the same args wins
+func FromRecords(records []valuerpc.Record) (*Script, error) {
+
+	type requestKey struct {
+		conn int64
+		rid  int64
+	}
+	requests := make(map[requestKey]*recordedRequest)
+	var order []*recordedRequest
+
+	for _, rec := range records {
+		msg := rec.Message
+		mt := msg.GetNumber(valuerpc.MessageTypeField)
+		rid := msg.GetNumber(valuerpc.RequestIdField)
+		if mt == nil || rid == nil {
+			continue
+		}
+		key := requestKey{rec.Conn, rid.Long()}
+		msgType := valuerpc.MessageType(mt.Long())
+
+		if kind, ok := kindOfRequest(msgType); ok && rec.Direction == valuerpc.ClientToServer {
+			name := msg.GetString(valuerpc.FunctionNameField)
+			if name == nil {
+				continue
+			}
+			args, _ := msg.Get(valuerpc.ArgumentsField)
+			req := &recordedRequest{at: rec.Time, kind: kind, name: name.String(), args: args}
+			requests[key] = req
+			order = append(order, req)
+			continue
+		}
+
+		req, ok := requests[key]
+		if !ok {
+			continue
+		}
+		val, hasValue := msg.Get(valuerpc.ValueField)
+
+		switch msgType {
+		case valuerpc.FunctionResponse:
+			req.result, _ = msg.Get(valuerpc.ResultField)
+			req.answered = rec.Time
+		case valuerpc.ErrorResponse:
+			if s := msg.GetString(valuerpc.ErrorField); s != nil {
+				req.err = s.String()
+			}
+			req.answered = rec.Time
+		case valuerpc.StreamValue, valuerpc.StreamEnd:
+			if !hasValue {
+				continue
+			}
+			if rec.Direction == valuerpc.ClientToServer {
+				req.incoming = append(req.incoming, val)
+			} else {
+				req.values = append(req.values, val)
+				req.outgoing = append(req.outgoing, rec.Time)
+			}
+		}
+	}
+
+	functions := make(map[string]*FunctionScript)
+	seen := make(map[string]bool)
+	for _, req := range order {
+		fn, ok := functions[req.name]
+		if !ok {
+			fn = &FunctionScript{Name: req.name, Kind: req.kind}
+			functions[req.name] = fn
+		}
+		c, err := req.toCase()
+		if err != nil {
+			return nil, errors.Errorf("function '%s', %v", req.name, err)
+		}
+		key := req.name + "\x00" + string(c.Args)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		fn.Cases = append(fn.Cases, c)
+	}
+
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	script := &Script{}
+	for _, name := range names {
+		script.Functions = append(script.Functions, *functions[name])
+	}
+	return script, nil
+}
+
+func rawJSON(val value.Value) (json.RawMessage, error) {
+	if val == nil {
+		return nil, nil
+	}
+	return valuerpc.ToJSON(val)
+}
+
+func rawJSONList(list []value.Value) ([]json.RawMessage, error) {
+	var out []json.RawMessage
+	for _, val := range list {
+		raw, err := rawJSON(val)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, raw)
+	}
+	return out, nil
+}
+
+func (t *recordedRequest) toCase() (Case, error) {
+	var c Case
+	var err error
+	if c.Args, err = rawJSON(t.args); err != nil {
+		return c, err
+	}
+	if t.args == nil {
+		// recorded call without args matches only calls without args
+		c.Args = json.RawMessage("null")
+	}
+	if c.Result, err = rawJSON(t.result); err != nil {
+		return c, err
+	}
+	if c.Values, err = rawJSONList(t.values); err != nil {
+		return c, err
+	}
+	if c.Expect, err = rawJSONList(t.incoming); err != nil {
+		return c, err
+	}
+	c.Error = t.err
+
+	switch {
+	case len(t.outgoing) > 1:
+		c.Delay = Duration(t.outgoing[len(t.outgoing)-1].Sub(t.outgoing[0]) / time.Duration(len(t.outgoing)-1))
+	case len(t.outgoing) == 1:
+		c.Delay = Duration(t.outgoing[0].Sub(t.at))
+	case !t.answered.IsZero():
+		c.Delay = Duration(t.answered.Sub(t.at))
+	}
+	return c, nil
+}
+
+func LoadRecordings(path string) (*Script, error) {
+	records, err := valuerpc.ReadRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromRecords(records)
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuemock
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+func message(mt valuerpc.MessageType, rid int64) value.Map {
+	return value.EmptyMap().
+		Put(valuerpc.MessageTypeField, value.Long(int64(mt))).
+		Put(valuerpc.RequestIdField, value.Long(rid))
+}
+
+func TestFromRecords(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	at := func(ms int) time.Time {
+		return t0.Add(time.Duration(ms) * time.Millisecond)
+	}
+	request := func(mt valuerpc.MessageType, rid int64, name string, args value.Value) value.Map {
+		msg := message(mt, rid).Put(valuerpc.FunctionNameField, value.Utf8(name))
+		if args != nil {
+			msg = msg.Put(valuerpc.ArgumentsField, args)
+		}
+		return msg
+	}
+	c2s, s2c := valuerpc.ClientToServer, valuerpc.ServerToClient
+
+	records := []valuerpc.Record{
+		{Time: at(0), Conn: 1, Direction: c2s, Message: message(valuerpc.HandshakeRequest, 0)},
+		{Time: at(0), Conn: 1, Direction: c2s, Message: request(valuerpc.FunctionRequest, 1, "getName", value.Long(1))},
+		{Time: at(10), Conn: 1, Direction: s2c, Message: message(valuerpc.FunctionResponse, 1).Put(valuerpc.ResultField, value.Utf8("Alex"))},
+		// the same args again, the first call wins
+		{Time: at(20), Conn: 1, Direction: c2s, Message: request(valuerpc.FunctionRequest, 2, "getName", value.Long(1))},
+		{Time: at(25), Conn: 1, Direction: s2c, Message: message(valuerpc.FunctionResponse, 2).Put(valuerpc.ResultField, value.Utf8("Bob"))},
+		{Time: at(30), Conn: 1, Direction: c2s, Message: request(valuerpc.FunctionRequest, 3, "getName", value.Long(2))},
+		{Time: at(35), Conn: 1, Direction: s2c, Message: message(valuerpc.ErrorResponse, 3).Put(valuerpc.ErrorField, value.Utf8("not found"))},
+		// the same request id on another connection is a different request
+		{Time: at(40), Conn: 2, Direction: s2c, Message: message(valuerpc.FunctionResponse, 1).Put(valuerpc.ResultField, value.Utf8("Other"))},
+		{Time: at(100), Conn: 1, Direction: c2s, Message: request(valuerpc.GetStreamRequest, 4, "ticks", nil)},
+		{Time: at(200), Conn: 1, Direction: s2c, Message: message(valuerpc.StreamValue, 4).Put(valuerpc.ValueField, value.Long(1))},
+		{Time: at(400), Conn: 1, Direction: s2c, Message: message(valuerpc.StreamValue, 4).Put(valuerpc.ValueField, value.Long(2))},
+		{Time: at(400), Conn: 1, Direction: s2c, Message: message(valuerpc.StreamEnd, 4)},
+		{Time: at(500), Conn: 1, Direction: c2s, Message: request(valuerpc.PutStreamRequest, 5, "upload", nil)},
+		{Time: at(510), Conn: 1, Direction: c2s, Message: message(valuerpc.StreamValue, 5).Put(valuerpc.ValueField, value.Utf8("a"))},
+		{Time: at(520), Conn: 1, Direction: c2s, Message: message(valuerpc.StreamEnd, 5).Put(valuerpc.ValueField, value.Utf8("b"))},
+	}
+
+	script, err := FromRecords(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := json.Marshal(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"functions":[
+		{"name":"getName","kind":"function","cases":[
+			{"args":1,"result":"Alex","delay":"10ms"},
+			{"args":2,"error":"not found","delay":"5ms"}]},
+		{"name":"ticks","kind":"outgoingStream","cases":[
+			{"args":null,"delay":"200ms","values":[1,2]}]},
+		{"name":"upload","kind":"incomingStream","cases":[
+			{"args":null,"expect":["a","b"]}]}]}`
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(expected)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(actual) {
+		t.Errorf("expected script\n%s\nactual\n%s", buf.String(), actual)
+	}
+
+	// the script is accepted by the mock
+	if _, err := ParseScript(actual); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		message string
+	}{
+		{"valid", `{"functions":[{"name":"f","cases":[{"delay":15}]},{"name":"g","kind":"chat","cases":[]}]}`, ""},
+		{"no name", `{"functions":[{"cases":[]}]}`, "function 0 has no name"},
+		{"unknown kind", `{"functions":[{"name":"f","kind":"stream"}]}`, "function 'f' has unknown kind 'stream'"},
+		{"unknown field", `{"functions":[{"name":"f","result":1}]}`, "unknown field"},
+		{"invalid delay", `{"functions":[{"name":"f","cases":[{"delay":"soon"}]}]}`, "parse mock script"},
+		{"invalid delay type", `{"functions":[{"name":"f","cases":[{"delay":true}]}]}`, "duration expected string or milliseconds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseScript([]byte(tt.src))
+			if tt.message == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected error with '%s', actual '%v'", tt.message, err)
+			}
+		})
+	}
+}
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected time.Duration
+	}{
+		{`"150ms"`, 150 * time.Millisecond},
+		{`"2s"`, 2 * time.Second},
+		{`15`, 15 * time.Millisecond},
+		{`0.5`, 500 * time.Microsecond},
+	}
+	for _, tt := range tests {
+		var d Duration
+		if err := json.Unmarshal([]byte(tt.src), &d); err != nil {
+			t.Errorf("%s, %v", tt.src, err)
+			continue
+		}
+		if time.Duration(d) != tt.expected {
+			t.Errorf("%s, expected %v, actual %v", tt.src, tt.expected, time.Duration(d))
+		}
+		data, err := json.Marshal(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, expected := string(data), `"`+tt.expected.String()+`"`; data != expected {
+			t.Errorf("marshal %s, expected %s, actual %s", tt.src, expected, data)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import "strings"
+
+var UnixScheme = "unix:"
+
+// returns network and address for net.Listen and net.Dial, unix:/path/to/socket is a Unix domain socket, otherwise tcp
+func SplitAddress(address string) (string, string) {
+	if strings.HasPrefix(address, UnixScheme) {
+		return "unix", strings.TrimPrefix(strings.TrimPrefix(address, UnixScheme), "//")
+	}
+	return "tcp", address
+}
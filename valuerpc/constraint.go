@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"github.com/codeallergy/value"
+	"github.com/pkg/errors"
+	"regexp"
+	"unicode/utf8"
+)
+
+type Constraint interface {
+	Check(val value.Value) error
+}
+
+type MinConstraint struct {
+	Min float64
+}
+
+func Min(n float64) MinConstraint {
+	return MinConstraint{n}
+}
+
+func (t MinConstraint) Check(val value.Value) error {
+	num, err := numberOf(val, "min")
+	if err != nil {
+		return err
+	}
+	if num.Double() < t.Min {
+		return errors.Errorf("value %s is less than min %v", num.String(), t.Min)
+	}
+	return nil
+}
+
+type MaxConstraint struct {
+	Max float64
+}
+
+func Max(n float64) MaxConstraint {
+	return MaxConstraint{n}
+}
+
+func (t MaxConstraint) Check(val value.Value) error {
+	num, err := numberOf(val, "max")
+	if err != nil {
+		return err
+	}
+	if num.Double() > t.Max {
+		return errors.Errorf("value %s is greater than max %v", num.String(), t.Max)
+	}
+	return nil
+}
+
+// length in runes for strings, number of elements for lists and maps
+type MinLenConstraint struct {
+	Min int
+}
+
+func MinLen(n int) MinLenConstraint {
+	return MinLenConstraint{n}
+}
+
+func (t MinLenConstraint) Check(val value.Value) error {
+	n, err := lengthOf(val, "min length")
+	if err != nil {
+		return err
+	}
+	if n < t.Min {
+		return errors.Errorf("length %d is less than min length %d", n, t.Min)
+	}
+	return nil
+}
+
+type MaxLenConstraint struct {
+	Max int
+}
+
+func MaxLen(n int) MaxLenConstraint {
+	return MaxLenConstraint{n}
+}
+
+func (t MaxLenConstraint) Check(val value.Value) error {
+	n, err := lengthOf(val, "max length")
+	if err != nil {
+		return err
+	}
+	if n > t.Max {
+		return errors.Errorf("length %d is greater than max length %d", n, t.Max)
+	}
+	return nil
+}
+
+// size in bytes of utf8 or raw string
+type MinSizeConstraint struct {
+	Min int
+}
+
+func MinSize(n int) MinSizeConstraint {
+	return MinSizeConstraint{n}
+}
+
+func (t MinSizeConstraint) Check(val value.Value) error {
+	n, err := sizeOf(val, "min size")
+	if err != nil {
+		return err
+	}
+	if n < t.Min {
+		return errors.Errorf("size %d is less than min size %d", n, t.Min)
+	}
+	return nil
+}
+
+type MaxSizeConstraint struct {
+	Max int
+}
+
+func MaxSize(n int) MaxSizeConstraint {
+	return MaxSizeConstraint{n}
+}
+
+func (t MaxSizeConstraint) Check(val value.Value) error {
+	n, err := sizeOf(val, "max size")
+	if err != nil {
+		return err
+	}
+	if n > t.Max {
+		return errors.Errorf("size %d is greater than max size %d", n, t.Max)
+	}
+	return nil
+}
+
+type PatternConstraint struct {
+	Pattern *regexp.Regexp
+}
+
+// panics if expression is invalid, same as regexp.MustCompile
+func Pattern(expr string) PatternConstraint {
+	return PatternConstraint{regexp.MustCompile(expr)}
+}
+
+func (t PatternConstraint) Check(val value.Value) error {
+	if val.Kind() != value.STRING {
+		return errors.Errorf("pattern constraint expected string, actual %s", KindName(val.Kind()))
+	}
+	s := val.(value.String).Utf8()
+	if !t.Pattern.MatchString(s) {
+		return errors.Errorf("value '%s' does not match pattern '%s'", s, t.Pattern.String())
+	}
+	return nil
+}
+
+// custom validator, decoded definitions of remote functions have nil Validator and accept any value
+type ValidatorConstraint struct {
+	Validator func(val value.Value) error
+}
+
+func Validator(fn func(val value.Value) error) ValidatorConstraint {
+	return ValidatorConstraint{fn}
+}
+
+func (t ValidatorConstraint) Check(val value.Value) error {
+	if t.Validator == nil {
+		return nil
+	}
+	return t.Validator(val)
+}
+
+func CheckConstraints(val value.Value, constraints []Constraint) error {
+	for _, c := range constraints {
+		if err := c.Check(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func KindName(kind value.Kind) string {
+	switch kind {
+	case AnyKind:
+		return "any"
+	case value.BOOL:
+		return "bool"
+	case value.NUMBER:
+		return "number"
+	case value.STRING:
+		return "string"
+	case value.LIST:
+		return "list"
+	case value.MAP:
+		return "map"
+	default:
+		return "unknown"
+	}
+}
+
+func numberOf(val value.Value, name string) (value.Number, error) {
+	if val.Kind() != value.NUMBER {
+		return nil, errors.Errorf("%s constraint expected number, actual %s", name, KindName(val.Kind()))
+	}
+	return val.(value.Number), nil
+}
+
+func lengthOf(val value.Value, name string) (int, error) {
+	switch val.Kind() {
+	case value.STRING:
+		s := val.(value.String)
+		if s.Type() == value.RAW {
+			return len(s.Raw()), nil
+		}
+		return utf8.RuneCountInString(s.Utf8()), nil
+	case value.LIST:
+		return val.(value.List).Len(), nil
+	case value.MAP:
+		return val.(value.Map).Len(), nil
+	default:
+		return 0, errors.Errorf("%s constraint expected string, list or map, actual %s", name, KindName(val.Kind()))
+	}
+}
+
+func sizeOf(val value.Value, name string) (int, error) {
+	if val.Kind() != value.STRING {
+		return 0, errors.Errorf("%s constraint expected string, actual %s", name, KindName(val.Kind()))
+	}
+	s := val.(value.String)
+	if s.Type() == value.RAW {
+		return len(s.Raw()), nil
+	}
+	return len(s.Utf8()), nil
+}
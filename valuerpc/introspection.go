@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"github.com/codeallergy/value"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// functions with this prefix are reserved by the server
+var ReservedPrefix = "vrpc."
+
+var IntrospectFunction = "vrpc.functions" // returns list of function descriptors
+var WatchStream = "vrpc.watch"            // streams function events, starts with the current catalog
+
+type FunctionKind string
+
+const (
+	SingleFunctionKind FunctionKind = "function"
+	OutgoingStreamKind FunctionKind = "outgoingStream"
+	IncomingStreamKind FunctionKind = "incomingStream"
+	ChatKind           FunctionKind = "chat"
+)
+
+var InfoNameField = "name"
+var InfoKindField = "kind"
+var InfoArgsField = "args"
+var InfoResultField = "res" // result of function or type of outgoing stream values
+var InfoInField = "in"      // type of incoming stream values
+var InfoDescriptionField = "desc"
+var InfoTagsField = "tags"
+
+var EventOpField = "op"
+var EventFunctionField = "fn"
+
+var AddEvent = "add"
+var RemoveEvent = "remove"
+var UpdateEvent = "update" // description or tags changed
+
+func IsReserved(name string) bool {
+	return strings.HasPrefix(name, ReservedPrefix)
+}
+
+type FunctionInfo struct {
+	Name        string
+	Kind        FunctionKind
+	Args        TypeDef
+	Res         TypeDef
+	In          TypeDef
+	Description string
+	Tags        []string
+}
+
+func (t FunctionInfo) ToValue() value.Map {
+	m := value.EmptyMap().
+		Put(InfoNameField, value.Utf8(t.Name)).
+		Put(InfoKindField, value.Utf8(string(t.Kind))).
+		Put(InfoArgsField, EncodeTypeDef(t.Args)).
+		Put(InfoResultField, EncodeTypeDef(t.Res)).
+		Put(InfoInField, EncodeTypeDef(t.In))
+	if t.Description != "" {
+		m = m.Put(InfoDescriptionField, value.Utf8(t.Description))
+	}
+	if len(t.Tags) > 0 {
+		tags := value.EmptyList()
+		for _, tag := range t.Tags {
+			tags = tags.Append(value.Utf8(tag))
+		}
+		m = m.Put(InfoTagsField, tags)
+	}
+	return m
+}
+
+func FunctionInfoFromValue(val value.Value) (FunctionInfo, error) {
+	var t FunctionInfo
+	if val == nil || val.Kind() != value.MAP {
+		return t, errors.New("function info expected map")
+	}
+	m := val.(value.Map)
+	t.Name = getString(m, InfoNameField)
+	t.Kind = FunctionKind(getString(m, InfoKindField))
+	t.Description = getString(m, InfoDescriptionField)
+	var err error
+	if t.Args, err = decodeInfoTypeDef(m, InfoArgsField); err != nil {
+		return t, errors.Errorf("function '%s' args, %v", t.Name, err)
+	}
+	if t.Res, err = decodeInfoTypeDef(m, InfoResultField); err != nil {
+		return t, errors.Errorf("function '%s' res, %v", t.Name, err)
+	}
+	if t.In, err = decodeInfoTypeDef(m, InfoInField); err != nil {
+		return t, errors.Errorf("function '%s' in, %v", t.Name, err)
+	}
+	if tags := m.GetList(InfoTagsField); tags != nil {
+		for _, tag := range tags.Values() {
+			if tag != nil && tag.Kind() == value.STRING {
+				t.Tags = append(t.Tags, tag.String())
+			}
+		}
+	}
+	return t, nil
+}
+
+func decodeInfoTypeDef(m value.Map, key string) (TypeDef, error) {
+	val, ok := m.Get(key)
+	if !ok || val == nil {
+		return Void, nil
+	}
+	return DecodeTypeDef(val)
+}
+
+func FunctionInfoList(list value.Value) ([]FunctionInfo, error) {
+	if list == nil || list.Kind() != value.LIST {
+		return nil, errors.New("function catalog expected list")
+	}
+	var out []FunctionInfo
+	for _, item := range list.(value.List).Values() {
+		info, err := FunctionInfoFromValue(item)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+type FunctionEvent struct {
+	Op       string
+	Function FunctionInfo
+}
+
+func NewFunctionEvent(op string, info FunctionInfo) value.Map {
+	return value.EmptyMap().
+		Put(EventOpField, value.Utf8(op)).
+		Put(EventFunctionField, info.ToValue())
+}
+
+func FunctionEventFromValue(val value.Value) (FunctionEvent, error) {
+	var t FunctionEvent
+	if val == nil || val.Kind() != value.MAP {
+		return t, errors.New("function event expected map")
+	}
+	m := val.(value.Map)
+	t.Op = getString(m, EventOpField)
+	fn, _ := m.Get(EventFunctionField)
+	info, err := FunctionInfoFromValue(fn)
+	if err != nil {
+		return t, err
+	}
+	t.Function = info
+	return t, nil
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/codeallergy/value"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+// parses JSON document, integers become long numbers, other numbers double
+func ParseJSON(data []byte) (value.Value, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var obj interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, errors.Errorf("parse json, %v", err)
+	}
+	if dec.More() {
+		return nil, errors.New("parse json, unexpected data after document")
+	}
+	return FromNative(obj)
+}
+
+// converts decoded JSON object to value
+func FromNative(obj interface{}) (value.Value, error) {
+	switch v := obj.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return value.Boolean(v), nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return value.Long(n), nil
+		}
+		d, err := v.Float64()
+		if err != nil {
+			return nil, errors.Errorf("invalid number '%s'", v.String())
+		}
+		return value.Double(d), nil
+	case float64:
+		if v == float64(int64(v)) {
+			return value.Long(int64(v)), nil
+		}
+		return value.Double(v), nil
+	case int:
+		return value.Long(int64(v)), nil
+	case int64:
+		return value.Long(v), nil
+	case string:
+		return value.Utf8(v), nil
+	case []interface{}:
+		list := value.EmptyList()
+		for _, item := range v {
+			val, err := FromNative(item)
+			if err != nil {
+				return nil, err
+			}
+			list = list.Append(val)
+		}
+		return list, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		m := value.EmptyMap()
+		for _, key := range keys {
+			val, err := FromNative(v[key])
+			if err != nil {
+				return nil, err
+			}
+			if val != nil {
+				m = m.Put(key, val)
+			}
+		}
+		return m, nil
+	}
+	return nil, errors.Errorf("unsupported json type %T", obj)
+}
+
+// converts value to JSON object, raw strings become base64 strings by encoding/json
+func ToNative(val value.Value) interface{} {
+	if val == nil {
+		return nil
+	}
+	switch val.Kind() {
+	case value.BOOL:
+		return val.(value.Bool).Boolean()
+	case value.NUMBER:
+		n := val.(value.Number)
+		if n.Type() == value.LONG {
+			return n.Long()
+		}
+		return n.Double()
+	case value.STRING:
+		s := val.(value.String)
+		if s.Type() == value.RAW {
+			return s.Raw()
+		}
+		return s.Utf8()
+	case value.LIST:
+		list := val.(value.List)
+		out := make([]interface{}, list.Len())
+		for i, item := range list.Values() {
+			out[i] = ToNative(item)
+		}
+		return out
+	case value.MAP:
+		out := make(map[string]interface{})
+		for _, entry := range val.(value.Map).Entries() {
+			out[entry.Key] = ToNative(entry.Value)
+		}
+		return out
+	}
+	return val.String()
+}
+
+func ToJSON(val value.Value) ([]byte, error) {
+	return json.Marshal(ToNative(val))
+}
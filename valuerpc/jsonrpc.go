@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import "encoding/json"
+
+/**
+JSON-RPC 2.0 messages, see https://www.jsonrpc.org/specification
+Request without id is a notification and gets no response.
+Metadata and trailers of vRPC calls are passed in the optional "md" and "trl" members.
+*/
+
+var JSONRPCVersion = "2.0"
+
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+	JSONRPCServerError    = -32000 // server errors are in range -32000 to -32099
+)
+
+type JSONRPCRequest struct {
+	Version  string            `json:"jsonrpc"`
+	Method   string            `json:"method"`
+	Params   json.RawMessage   `json:"params,omitempty"`
+	Id       json.RawMessage   `json:"id,omitempty"`
+	Metadata map[string]string `json:"md,omitempty"`
+}
+
+func (t *JSONRPCRequest) IsNotification() bool {
+	return len(t.Id) == 0
+}
+
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type JSONRPCResponse struct {
+	Version string            `json:"jsonrpc"`
+	Result  json.RawMessage   `json:"result,omitempty"`
+	Error   *JSONRPCError     `json:"error,omitempty"`
+	Id      json.RawMessage   `json:"id"`
+	Trailer map[string]string `json:"trl,omitempty"`
+}
+
+// data of JSON-RPC error keeps the original vRPC error code and details
+var JSONRPCCodeField = "code"
+var JSONRPCDetailsField = "details"
+
+func JSONRPCCode(code ErrorCode) int {
+	switch code {
+	case BadRequest:
+		return JSONRPCInvalidRequest
+	case FunctionNotFound:
+		return JSONRPCMethodNotFound
+	case InvalidArgs:
+		return JSONRPCInvalidParams
+	case InvalidResult:
+		return JSONRPCInternalError
+	}
+	return JSONRPCServerError - int(code)
+}
+
+func NewJSONRPCError(code ErrorCode, message string, details interface{}) *JSONRPCError {
+	data := map[string]interface{}{JSONRPCCodeField: int64(code)}
+	if details != nil {
+		data[JSONRPCDetailsField] = details
+	}
+	return &JSONRPCError{
+		Code:    JSONRPCCode(code),
+		Message: message,
+		Data:    data,
+	}
+}
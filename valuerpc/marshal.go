@@ -0,0 +1,467 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"fmt"
+	"github.com/codeallergy/value"
+	"github.com/pkg/errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/**
+Struct fields are mapped by the tag `vrpc:"name,opt"`, untagged exported fields use the field name.
+Structs with numeric tags `vrpc:"0"`, `vrpc:"1"`... are mapped to value.List, others to value.Map.
+Fields with option 'opt' and pointer fields are optional, tag "-" skips the field.
+*/
+
+var TagName = "vrpc"
+
+// kind of value.Value and interface{} fields, accepts any kind on verification
+var AnyKind = value.Kind(-1)
+
+var valueType = reflect.TypeOf((*value.Value)(nil)).Elem()
+
+type structField struct {
+	index    int
+	name     string
+	optional bool
+}
+
+type structInfo struct {
+	fields []structField
+	tuple  bool
+}
+
+var structInfoCache sync.Map // key is reflect.Type, value *structInfo
+
+func Marshal(v interface{}) (value.Value, error) {
+	return marshalValue(reflect.ValueOf(v), "")
+}
+
+// obj must be a not nil pointer
+func Unmarshal(val value.Value, obj interface{}) error {
+	ptr := reflect.ValueOf(obj)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return errors.Errorf("unmarshal expected not nil pointer, actual %T", obj)
+	}
+	return unmarshalValue(val, ptr.Elem(), "")
+}
+
+func TypeDefFor[T any]() (TypeDef, error) {
+	return TypeDefOf(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// derives type definition from go type: struct to ArgsDef or ParamsDef, empty struct to Void, value.Value to Any
+func TypeDefOf(t reflect.Type) (TypeDef, error) {
+	if t == valueType || (t.Kind() == reflect.Interface && t.NumMethod() == 0) {
+		return Any, nil
+	}
+	if t.Kind() == reflect.Ptr {
+		return TypeDefOf(t.Elem())
+	}
+	if t.Kind() != reflect.Struct {
+		kind, err := kindOf(t)
+		if err != nil {
+			return nil, err
+		}
+		return Arg(kind, true), nil
+	}
+	info, err := structInfoOf(t)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.fields) == 0 {
+		return Void, nil
+	}
+	if info.tuple {
+		list := make([]ArgDef, len(info.fields))
+		for i, f := range info.fields {
+			kind, err := kindOf(t.Field(f.index).Type)
+			if err != nil {
+				return nil, errors.Errorf("field '%s' of %v, %v", t.Field(f.index).Name, t, err)
+			}
+			list[i] = Arg(kind, !f.optional)
+		}
+		return List(list...), nil
+	}
+	params := make([]ParamDef, len(info.fields))
+	for i, f := range info.fields {
+		kind, err := kindOf(t.Field(f.index).Type)
+		if err != nil {
+			return nil, errors.Errorf("field '%s' of %v, %v", t.Field(f.index).Name, t, err)
+		}
+		params[i] = Param(f.name, kind, !f.optional)
+	}
+	return Map(params...), nil
+}
+
+func kindOf(t reflect.Type) (value.Kind, error) {
+	if t == valueType {
+		return AnyKind, nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return value.BOOL, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return value.NUMBER, nil
+	case reflect.String:
+		return value.STRING, nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return value.STRING, nil
+		}
+		return value.LIST, nil
+	case reflect.Array:
+		return value.LIST, nil
+	case reflect.Map:
+		return value.MAP, nil
+	case reflect.Struct:
+		info, err := structInfoOf(t)
+		if err != nil {
+			return 0, err
+		}
+		if info.tuple {
+			return value.LIST, nil
+		}
+		return value.MAP, nil
+	case reflect.Ptr:
+		return kindOf(t.Elem())
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return AnyKind, nil
+		}
+	}
+	return 0, errors.Errorf("unsupported type %v", t)
+}
+
+func structInfoOf(t reflect.Type) (*structInfo, error) {
+	if info, ok := structInfoCache.Load(t); ok {
+		return info.(*structInfo), nil
+	}
+
+	info := &structInfo{}
+	positions := make(map[int]structField)
+	named := 0
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := field.Tag.Get(TagName)
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		f := structField{
+			index:    i,
+			name:     parts[0],
+			optional: field.Type.Kind() == reflect.Ptr,
+		}
+		for _, opt := range parts[1:] {
+			if opt == "opt" {
+				f.optional = true
+			}
+		}
+		if f.name == "" {
+			f.name = field.Name
+		}
+		if pos, err := strconv.Atoi(f.name); err == nil {
+			if _, ok := positions[pos]; ok || pos < 0 {
+				return nil, errors.Errorf("invalid position %d of field '%s' in %v", pos, field.Name, t)
+			}
+			positions[pos] = f
+		} else {
+			named++
+		}
+		info.fields = append(info.fields, f)
+	}
+
+	if len(positions) > 0 {
+		if named > 0 {
+			return nil, errors.Errorf("mixed positional and named fields in %v", t)
+		}
+		for i := range info.fields {
+			f, ok := positions[i]
+			if !ok {
+				return nil, errors.Errorf("missing position %d in %v", i, t)
+			}
+			info.fields[i] = f
+		}
+		info.tuple = true
+	}
+
+	structInfoCache.Store(t, info)
+	return info, nil
+}
+
+func marshalValue(v reflect.Value, path string) (value.Value, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	if v.Type().Implements(valueType) {
+		if (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && v.IsNil() {
+			return nil, nil
+		}
+		return v.Interface().(value.Value), nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return marshalValue(v.Elem(), path)
+	case reflect.Bool:
+		return value.Boolean(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Long(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value.Long(int64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return value.Double(v.Float()), nil
+	case reflect.String:
+		return value.Utf8(v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return value.Raw(v.Bytes(), true), nil
+		}
+		return marshalList(v, path)
+	case reflect.Array:
+		return marshalList(v, path)
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, errors.Errorf("%s: unsupported map key type %v", pathOrRoot(path), v.Type().Key())
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		m := value.EmptyMap()
+		for _, key := range keys {
+			item, err := marshalValue(v.MapIndex(key), path+"."+key.String())
+			if err != nil {
+				return nil, err
+			}
+			if item != nil {
+				m = m.Put(key.String(), item)
+			}
+		}
+		return m, nil
+	case reflect.Struct:
+		return marshalStruct(v, path)
+	}
+	return nil, errors.Errorf("%s: unsupported type %v", pathOrRoot(path), v.Type())
+}
+
+func marshalList(v reflect.Value, path string) (value.Value, error) {
+	list := value.EmptyList()
+	for i := 0; i < v.Len(); i++ {
+		item, err := marshalValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		list = list.Append(item)
+	}
+	return list, nil
+}
+
+func marshalStruct(v reflect.Value, path string) (value.Value, error) {
+	info, err := structInfoOf(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	if info.tuple {
+		list := value.EmptyList()
+		for i, f := range info.fields {
+			item, err := marshalValue(v.Field(f.index), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			list = list.Append(item)
+		}
+		return list, nil
+	}
+	m := value.EmptyMap()
+	for _, f := range info.fields {
+		item, err := marshalValue(v.Field(f.index), path+"."+f.name)
+		if err != nil {
+			return nil, err
+		}
+		if item != nil {
+			m = m.Put(f.name, item)
+		}
+	}
+	return m, nil
+}
+
+func unmarshalValue(val value.Value, v reflect.Value, path string) error {
+	if val == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if v.Type() == valueType || (v.Kind() == reflect.Interface && v.NumMethod() == 0) {
+		v.Set(reflect.ValueOf(val))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if err := unmarshalValue(val, elem.Elem(), path); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Bool:
+		if val.Kind() != value.BOOL {
+			return mismatch(path, value.BOOL, val)
+		}
+		v.SetBool(val.(value.Bool).Boolean())
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if val.Kind() != value.NUMBER {
+			return mismatch(path, value.NUMBER, val)
+		}
+		n := val.(value.Number).Long()
+		if v.OverflowInt(n) {
+			return errors.Errorf("%s: number %d overflows %v", pathOrRoot(path), n, v.Type())
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if val.Kind() != value.NUMBER {
+			return mismatch(path, value.NUMBER, val)
+		}
+		n := val.(value.Number).Long()
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			return errors.Errorf("%s: number %d overflows %v", pathOrRoot(path), n, v.Type())
+		}
+		v.SetUint(uint64(n))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if val.Kind() != value.NUMBER {
+			return mismatch(path, value.NUMBER, val)
+		}
+		v.SetFloat(val.(value.Number).Double())
+		return nil
+	case reflect.String:
+		if val.Kind() != value.STRING {
+			return mismatch(path, value.STRING, val)
+		}
+		v.SetString(val.(value.String).Utf8())
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if val.Kind() != value.STRING {
+				return mismatch(path, value.STRING, val)
+			}
+			raw := val.(value.String).Raw()
+			v.SetBytes(append([]byte(nil), raw...))
+			return nil
+		}
+		if val.Kind() != value.LIST {
+			return mismatch(path, value.LIST, val)
+		}
+		list := val.(value.List)
+		slice := reflect.MakeSlice(v.Type(), list.Len(), list.Len())
+		for i := 0; i < list.Len(); i++ {
+			if err := unmarshalValue(list.GetAt(i), slice.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Array:
+		if val.Kind() != value.LIST {
+			return mismatch(path, value.LIST, val)
+		}
+		list := val.(value.List)
+		if list.Len() != v.Len() {
+			return errors.Errorf("%s: expected list of %d, actual list of %d", pathOrRoot(path), v.Len(), list.Len())
+		}
+		for i := 0; i < list.Len(); i++ {
+			if err := unmarshalValue(list.GetAt(i), v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return errors.Errorf("%s: unsupported map key type %v", pathOrRoot(path), v.Type().Key())
+		}
+		if val.Kind() != value.MAP {
+			return mismatch(path, value.MAP, val)
+		}
+		m := reflect.MakeMap(v.Type())
+		for _, entry := range val.(value.Map).Entries() {
+			item := reflect.New(v.Type().Elem()).Elem()
+			if err := unmarshalValue(entry.Value, item, path+"."+entry.Key); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(entry.Key).Convert(v.Type().Key()), item)
+		}
+		v.Set(m)
+		return nil
+	case reflect.Struct:
+		return unmarshalStruct(val, v, path)
+	}
+	return errors.Errorf("%s: unsupported type %v", pathOrRoot(path), v.Type())
+}
+
+func unmarshalStruct(val value.Value, v reflect.Value, path string) error {
+	info, err := structInfoOf(v.Type())
+	if err != nil {
+		return err
+	}
+	if info.tuple {
+		if val.Kind() != value.LIST {
+			return mismatch(path, value.LIST, val)
+		}
+		list := val.(value.List)
+		for i, f := range info.fields {
+			var item value.Value
+			if i < list.Len() {
+				item = list.GetAt(i)
+			}
+			if err := unmarshalValue(item, v.Field(f.index), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if val.Kind() != value.MAP {
+		return mismatch(path, value.MAP, val)
+	}
+	m := val.(value.Map)
+	for _, f := range info.fields {
+		item, _ := m.Get(f.name)
+		if err := unmarshalValue(item, v.Field(f.index), path+"."+f.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mismatch(path string, expected value.Kind, actual value.Value) error {
+	return errors.Errorf("%s: expected %s, actual %s", pathOrRoot(path), KindName(expected), KindName(actual.Kind()))
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "value"
+	}
+	return strings.TrimPrefix(path, ".")
+}
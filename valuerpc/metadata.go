@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/**
+Metadata of calls, like headers in HTTP. Client sends metadata in the request,
+server sends trailers with the function result, error response or the end of outgoing stream.
+Keys are case insensitive and stored in lower case, values are strings.
+*/
+
+var MetadataField = "md" // optional request metadata
+var TrailerField = "trl" // optional trailers of response or stream end
+
+type Metadata map[string]string
+
+func NewMetadata(kv ...string) Metadata {
+	md := make(Metadata, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		md.Set(kv[i], kv[i+1])
+	}
+	return md
+}
+
+func (t Metadata) Get(key string) string {
+	return t[strings.ToLower(key)]
+}
+
+func (t Metadata) Set(key, val string) {
+	t[strings.ToLower(key)] = val
+}
+
+func (t Metadata) Delete(key string) {
+	delete(t, strings.ToLower(key))
+}
+
+func (t Metadata) Copy() Metadata {
+	md := make(Metadata, len(t))
+	for k, v := range t {
+		md[k] = v
+	}
+	return md
+}
+
+// copies entries of other metadata, other values win
+func (t Metadata) Merge(other Metadata) {
+	for k, v := range other {
+		t[k] = v
+	}
+}
+
+func (t Metadata) Keys() []string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// nil for empty metadata, so the field is not sent
+func (t Metadata) ToValue() value.Map {
+	if len(t) == 0 {
+		return nil
+	}
+	m := value.EmptyMap()
+	for _, k := range t.Keys() {
+		m = m.Put(k, value.Utf8(t[k]))
+	}
+	return m
+}
+
+// metadata of the field in message, not string values are ignored
+func GetMetadata(msg value.Map, field string) Metadata {
+	m := msg.GetMap(field)
+	if m == nil {
+		return nil
+	}
+	md := make(Metadata)
+	for _, e := range m.Entries() {
+		if s, ok := e.Value.(value.String); ok {
+			md.Set(e.Key, s.String())
+		}
+	}
+	return md
+}
+
+// puts not empty metadata to the field of message
+func PutMetadata(msg value.Map, field string, md Metadata) value.Map {
+	if v := md.ToValue(); v != nil {
+		return msg.Put(field, v)
+	}
+	return msg
+}
+
+type incomingMetadataKey struct{}
+type outgoingMetadataKey struct{}
+type trailerKey struct{}
+
+// metadata received by server, available to interceptors and handlers
+func ContextWithIncomingMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, incomingMetadataKey{}, md)
+}
+
+// metadata of the request, nil if client did not send it
+func IncomingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(incomingMetadataKey{}).(Metadata)
+	return md
+}
+
+// metadata sent with every call made by client with ctx, merged with metadata of the call
+func ContextWithOutgoingMetadata(ctx context.Context, md Metadata) context.Context {
+	if prev := OutgoingMetadata(ctx); prev != nil {
+		merged := prev.Copy()
+		merged.Merge(md)
+		md = merged
+	}
+	return context.WithValue(ctx, outgoingMetadataKey{}, md)
+}
+
+func OutgoingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(outgoingMetadataKey{}).(Metadata)
+	return md
+}
+
+// trailers written by handler, safe for concurrent use by stream goroutines
+type Trailer struct {
+	lock sync.Mutex
+	md   Metadata
+}
+
+func (t *Trailer) Set(key, val string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.md == nil {
+		t.md = make(Metadata)
+	}
+	t.md.Set(key, val)
+}
+
+func (t *Trailer) Metadata() Metadata {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.md.Copy()
+}
+
+func ContextWithTrailer(ctx context.Context) (context.Context, *Trailer) {
+	trailer := new(Trailer)
+	return context.WithValue(ctx, trailerKey{}, trailer), trailer
+}
+
+// trailer of the request served with ctx or nil
+func TrailerFromContext(ctx context.Context) *Trailer {
+	trailer, _ := ctx.Value(trailerKey{}).(*Trailer)
+	return trailer
+}
+
+// sets trailer of the request served with ctx, false if ctx has no trailer
+func SetTrailer(ctx context.Context, key, val string) bool {
+	if trailer := TrailerFromContext(ctx); trailer != nil {
+		trailer.Set(key, val)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	"reflect"
+	"testing"
+)
+
+func TestMetadata(t *testing.T) {
+	md := NewMetadata("X-User", "bob", "Tenant", "a", "odd")
+	if md.Get("x-user") != "bob" || md.Get("TENANT") != "a" {
+		t.Errorf("keys are not case insensitive, %v", md)
+	}
+	if len(md) != 2 {
+		t.Errorf("odd key is ignored, %v", md)
+	}
+	if keys := md.Keys(); !reflect.DeepEqual(keys, []string{"tenant", "x-user"}) {
+		t.Errorf("sorted keys %v", keys)
+	}
+	md.Delete("Tenant")
+	if _, ok := md["tenant"]; ok {
+		t.Errorf("key is not deleted")
+	}
+
+	cp := md.Copy()
+	cp.Set("x-user", "alice")
+	if md.Get("x-user") != "bob" {
+		t.Errorf("copy shares entries")
+	}
+	md.Merge(NewMetadata("x-user", "carol", "k", "v"))
+	if md.Get("x-user") != "carol" || md.Get("k") != "v" {
+		t.Errorf("merge %v", md)
+	}
+}
+
+func TestMetadataMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		md   Metadata
+	}{
+		{"nil", nil},
+		{"empty", Metadata{}},
+		{"entries", NewMetadata("a", "1", "b", "2")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := PutMetadata(value.EmptyMap(), MetadataField, tt.md)
+			if len(tt.md) == 0 {
+				if msg.Len() != 0 {
+					t.Errorf("empty metadata is sent, %v", msg)
+				}
+				if GetMetadata(msg, MetadataField) != nil {
+					t.Errorf("expected nil metadata")
+				}
+				return
+			}
+			if actual := GetMetadata(msg, MetadataField); !reflect.DeepEqual(actual, tt.md) {
+				t.Errorf("expected %v, actual %v", tt.md, actual)
+			}
+		})
+	}
+}
+
+func TestMetadataContext(t *testing.T) {
+	ctx := ContextWithOutgoingMetadata(context.Background(), NewMetadata("a", "1", "b", "1"))
+	ctx = ContextWithOutgoingMetadata(ctx, NewMetadata("b", "2"))
+	if md := OutgoingMetadata(ctx); !reflect.DeepEqual(md, NewMetadata("a", "1", "b", "2")) {
+		t.Errorf("outgoing metadata is not merged, %v", md)
+	}
+	if IncomingMetadata(ctx) != nil {
+		t.Errorf("outgoing metadata is visible as incoming")
+	}
+
+	if SetTrailer(context.Background(), "k", "v") {
+		t.Errorf("trailer is set without context trailer")
+	}
+	ctx, trailer := ContextWithTrailer(context.Background())
+	if !SetTrailer(ctx, "K", "v") {
+		t.Fatalf("trailer is not set")
+	}
+	md := trailer.Metadata()
+	md.Set("other", "x")
+	if actual := trailer.Metadata(); !reflect.DeepEqual(actual, NewMetadata("k", "v")) {
+		t.Errorf("trailer %v", actual)
+	}
+}
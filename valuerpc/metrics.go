@@ -0,0 +1,324 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"bufio"
+	"fmt"
+	"go.uber.org/atomic"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/**
+Metrics registry exposed in the Prometheus text format, version 0.0.4.
+Registering the same name again returns the existing metric, so server, client and application share one registry.
+*/
+
+var MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// seconds
+var DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metricKind string
+
+const (
+	counterKind   metricKind = "counter"
+	gaugeKind     metricKind = "gauge"
+	histogramKind metricKind = "histogram"
+)
+
+// reports current value of metric with label values in the order of registration
+type Observe func(v float64, labelValues ...string)
+
+type Metrics struct {
+	lock     sync.Mutex
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    metricKind
+	labels  []string
+	buckets []float64
+	collect func(Observe) // set for metrics computed on scrape
+	series  sync.Map      // key is joined label values, value *metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       atomic.Float64
+	counts      []atomic.Uint64 // per bucket, not cumulative
+	count       atomic.Uint64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{families: make(map[string]*metricFamily)}
+}
+
+func (t *Metrics) register(name, help string, kind metricKind, labels []string, buckets []float64, collect func(Observe)) *metricFamily {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if f, ok := t.families[name]; ok {
+		if f.kind != kind || len(f.labels) != len(labels) {
+			panic(fmt.Sprintf("metric '%s' already registered as %s with labels %v", name, f.kind, f.labels))
+		}
+		return f
+	}
+	f := &metricFamily{name: name, help: help, kind: kind, labels: labels, buckets: buckets, collect: collect}
+	t.families[name] = f
+	return f
+}
+
+func (t *metricFamily) get(labelValues []string) *metricSeries {
+	if len(labelValues) != len(t.labels) {
+		panic(fmt.Sprintf("metric '%s' expected %d label values, got %d", t.name, len(t.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	if s, ok := t.series.Load(key); ok {
+		return s.(*metricSeries)
+	}
+	s := &metricSeries{labelValues: append([]string(nil), labelValues...)}
+	if t.kind == histogramKind {
+		s.counts = make([]atomic.Uint64, len(t.buckets)+1)
+	}
+	actual, _ := t.series.LoadOrStore(key, s)
+	return actual.(*metricSeries)
+}
+
+type Counter struct {
+	family *metricFamily
+}
+
+func (t *Metrics) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{t.register(name, help, counterKind, labels, nil, nil)}
+}
+
+func (t *Counter) Inc(labelValues ...string) {
+	t.family.get(labelValues).value.Add(1)
+}
+
+// delta must not be negative
+func (t *Counter) Add(delta float64, labelValues ...string) {
+	t.family.get(labelValues).value.Add(delta)
+}
+
+type Gauge struct {
+	family *metricFamily
+}
+
+func (t *Metrics) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{t.register(name, help, gaugeKind, labels, nil, nil)}
+}
+
+func (t *Gauge) Set(v float64, labelValues ...string) {
+	t.family.get(labelValues).value.Store(v)
+}
+
+func (t *Gauge) Add(delta float64, labelValues ...string) {
+	t.family.get(labelValues).value.Add(delta)
+}
+
+func (t *Gauge) Inc(labelValues ...string) {
+	t.Add(1, labelValues...)
+}
+
+func (t *Gauge) Dec(labelValues ...string) {
+	t.Add(-1, labelValues...)
+}
+
+type Histogram struct {
+	family *metricFamily
+}
+
+// buckets are upper bounds in increasing order, nil is DefaultLatencyBuckets
+func (t *Metrics) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	return &Histogram{t.register(name, help, histogramKind, labels, buckets, nil)}
+}
+
+func (t *Histogram) Observe(v float64, labelValues ...string) {
+	s := t.family.get(labelValues)
+	i := sort.SearchFloat64s(t.family.buckets, v)
+	s.counts[i].Inc()
+	s.count.Inc()
+	s.value.Add(v)
+}
+
+// gauge computed on every scrape, for example length of a queue
+func (t *Metrics) GaugeFunc(name, help string, labels []string, collect func(Observe)) {
+	t.register(name, help, gaugeKind, labels, nil, collect)
+}
+
+// counter computed on every scrape from existing monotonic counters
+func (t *Metrics) CounterFunc(name, help string, labels []string, collect func(Observe)) {
+	t.register(name, help, counterKind, labels, nil, collect)
+}
+
+func (t *Metrics) sortedFamilies() []*metricFamily {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	list := make([]*metricFamily, 0, len(t.families))
+	for _, f := range t.families {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (t *countingWriter) printf(format string, args ...interface{}) {
+	n, _ := fmt.Fprintf(t.w, format, args...)
+	t.n += int64(n)
+}
+
+// writes all metrics in the Prometheus text format sorted by name
+func (t *Metrics) WriteTo(w io.Writer) (int64, error) {
+	out := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range t.sortedFamilies() {
+		out.printf("# HELP %s %s\n", f.name, escapeHelp(f.help))
+		out.printf("# TYPE %s %s\n", f.name, f.kind)
+		for _, s := range f.snapshot() {
+			if f.kind == histogramKind {
+				f.writeHistogram(out, s)
+				continue
+			}
+			out.printf("%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatFloat(s.value.Load()))
+		}
+	}
+	return out.n, out.w.Flush()
+}
+
+func (t *metricFamily) snapshot() []*metricSeries {
+	var list []*metricSeries
+	if t.collect != nil {
+		t.collect(func(v float64, labelValues ...string) {
+			s := &metricSeries{labelValues: labelValues}
+			s.value.Store(v)
+			list = append(list, s)
+		})
+	} else {
+		t.series.Range(func(key, value interface{}) bool {
+			list = append(list, value.(*metricSeries))
+			return true
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].labelValues, "\xff") < strings.Join(list[j].labelValues, "\xff")
+	})
+	return list
+}
+
+func (t *metricFamily) writeHistogram(out *countingWriter, s *metricSeries) {
+	var cumulative uint64
+	for i, bound := range t.buckets {
+		cumulative += s.counts[i].Load()
+		out.printf("%s_bucket%s %d\n", t.name, formatLabels(t.labels, s.labelValues, "le", formatFloat(bound)), cumulative)
+	}
+	cumulative += s.counts[len(t.buckets)].Load()
+	out.printf("%s_bucket%s %d\n", t.name, formatLabels(t.labels, s.labelValues, "le", "+Inf"), cumulative)
+	out.printf("%s_sum%s %s\n", t.name, formatLabels(t.labels, s.labelValues, "", ""), formatFloat(s.value.Load()))
+	out.printf("%s_count%s %d\n", t.name, formatLabels(t.labels, s.labelValues, "", ""), s.count.Load())
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var out strings.Builder
+	out.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		out.WriteString(name)
+		out.WriteString(`="`)
+		out.WriteString(escapeLabel(values[i]))
+		out.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			out.WriteByte(',')
+		}
+		out.WriteString(extraName)
+		out.WriteString(`="`)
+		out.WriteString(extraValue)
+		out.WriteByte('"')
+	}
+	out.WriteByte('}')
+	return out.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// serves metrics for Prometheus scrape, mount it on /metrics
+func (t *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MetricsContentType)
+		t.WriteTo(w)
+	})
+}
+
+// bytes passed through counted connections
+type ByteCounters struct {
+	In  atomic.Int64
+	Out atomic.Int64
+}
+
+func NewCountingConn(conn net.Conn, counters *ByteCounters) net.Conn {
+	return &countingConn{Conn: conn, counters: counters}
+}
+
+type countingConn struct {
+	net.Conn
+	counters *ByteCounters
+}
+
+func (t *countingConn) Read(b []byte) (int, error) {
+	n, err := t.Conn.Read(b)
+	t.counters.In.Add(int64(n))
+	return n, err
+}
+
+func (t *countingConn) Write(b []byte) (int, error) {
+	n, err := t.Conn.Write(b)
+	t.counters.Out.Add(int64(n))
+	return n, err
+}
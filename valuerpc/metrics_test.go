@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsText(t *testing.T) {
+	m := NewMetrics()
+	requests := m.Counter("vrpc_requests_total", "Requests by function.", "function", "code")
+	requests.Inc("b", "ok")
+	requests.Inc("a", "ok")
+	requests.Add(2, "a", "ok")
+	m.Counter("vrpc_requests_total", "registered again", "function", "code").Inc("a", "err")
+
+	open := m.Gauge("vrpc_open", "Open streams\nby kind.")
+	open.Inc()
+	open.Inc()
+	open.Dec()
+
+	latency := m.Histogram("vrpc_latency_seconds", "Latency.", []float64{0.1, 1}, "function")
+	latency.Observe(0.1, `say "hi"`)
+	latency.Observe(0.5, `say "hi"`)
+	latency.Observe(3, `say "hi"`)
+
+	m.GaugeFunc("vrpc_queue", "Queue length.", []string{"client"}, func(observe Observe) {
+		observe(math.Inf(1), "2")
+		observe(1.5, "1")
+	})
+
+	expected := `# HELP vrpc_latency_seconds Latency.
+# TYPE vrpc_latency_seconds histogram
+vrpc_latency_seconds_bucket{function="say \"hi\"",le="0.1"} 1
+vrpc_latency_seconds_bucket{function="say \"hi\"",le="1"} 2
+vrpc_latency_seconds_bucket{function="say \"hi\"",le="+Inf"} 3
+vrpc_latency_seconds_sum{function="say \"hi\""} 3.6
+vrpc_latency_seconds_count{function="say \"hi\""} 3
+# HELP vrpc_open Open streams\nby kind.
+# TYPE vrpc_open gauge
+vrpc_open 1
+# HELP vrpc_queue Queue length.
+# TYPE vrpc_queue gauge
+vrpc_queue{client="1"} 1.5
+vrpc_queue{client="2"} +Inf
+# HELP vrpc_requests_total Requests by function.
+# TYPE vrpc_requests_total counter
+vrpc_requests_total{function="a",code="err"} 1
+vrpc_requests_total{function="a",code="ok"} 3
+vrpc_requests_total{function="b",code="ok"} 1
+`
+	var out strings.Builder
+	n, err := m.WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected {
+		t.Errorf("expected\n%s\nactual\n%s", expected, out.String())
+	}
+	if n != int64(out.Len()) {
+		t.Errorf("expected %d written bytes, actual %d", out.Len(), n)
+	}
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != MetricsContentType {
+		t.Errorf("content type %q", ct)
+	}
+	if rec.Body.String() != expected {
+		t.Errorf("handler body differs from WriteTo")
+	}
+}
+
+func TestMetricsRegisterConflict(t *testing.T) {
+	m := NewMetrics()
+	m.Counter("x", "help", "a")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic on registration of other kind")
+		}
+	}()
+	m.Gauge("x", "help", "a")
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"github.com/codeallergy/value"
+	"strconv"
+	"strings"
+)
+
+// applies defaults and coercion declared in the definition and verifies the result
+func Prepare(args value.Value, def TypeDef) (value.Value, []Violation) {
+	args = Normalize(args, def)
+	return args, VerifyDetailed(args, def)
+}
+
+// applies defaults and coercion declared in the definition, never fails
+func Normalize(args value.Value, def TypeDef) value.Value {
+	if argsDef, ok := def.(ArgsDef); ok {
+		return normalizeArgs(args, argsDef)
+	}
+	if paramsDef, ok := def.(ParamsDef); ok {
+		return normalizeParams(args, paramsDef)
+	}
+	return args
+}
+
+func normalizeArgs(args value.Value, argsDef ArgsDef) value.Value {
+	if !argsDef.Coerce || args == nil {
+		return args
+	}
+	if args.Kind() != value.LIST {
+		if len(argsDef.List) == 1 {
+			coerced, _ := Coerce(args, argsDef.List[0].Kind)
+			return value.Tuple(coerced)
+		}
+		return args
+	}
+	list := args.(value.List)
+	for i, def := range argsDef.List {
+		if i >= list.Len() {
+			break
+		}
+		if arg := list.GetAt(i); arg != nil {
+			if coerced, ok := Coerce(arg, def.Kind); ok {
+				list = list.PutAt(i, coerced)
+			}
+		}
+	}
+	return list
+}
+
+func normalizeParams(args value.Value, paramsDef ParamsDef) value.Value {
+	if args == nil {
+		if !hasDefaults(paramsDef) {
+			return args
+		}
+		args = value.EmptyMap()
+	}
+	if args.Kind() != value.MAP {
+		return args
+	}
+	cache := args.(value.Map)
+	for _, paramDef := range paramsDef.Map {
+		val, ok := cache.Get(paramDef.Name)
+		if !ok || val == nil {
+			if paramDef.Default != nil {
+				cache = cache.Put(paramDef.Name, paramDef.Default)
+			}
+			continue
+		}
+		if paramsDef.Coerce {
+			if coerced, ok := Coerce(val, paramDef.Kind); ok {
+				cache = cache.Put(paramDef.Name, coerced)
+			}
+		}
+	}
+	return cache
+}
+
+func hasDefaults(paramsDef ParamsDef) bool {
+	for _, paramDef := range paramsDef.Map {
+		if paramDef.Default != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// converts numeric and boolean strings and wraps single values in a list,
+// returns the same value and false if not applicable
+func Coerce(val value.Value, kind value.Kind) (value.Value, bool) {
+	if val == nil || val.Kind() == kind {
+		return val, false
+	}
+	switch kind {
+	case value.NUMBER:
+		if val.Kind() == value.STRING {
+			s := strings.TrimSpace(val.(value.String).Utf8())
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return value.Long(n), true
+			}
+			if d, err := strconv.ParseFloat(s, 64); err == nil {
+				return value.Double(d), true
+			}
+		}
+	case value.BOOL:
+		if val.Kind() == value.STRING {
+			if b, err := strconv.ParseBool(strings.TrimSpace(val.(value.String).Utf8())); err == nil {
+				return value.Boolean(b), true
+			}
+		}
+	case value.LIST:
+		return value.Tuple(val), true
+	}
+	return val, false
+}
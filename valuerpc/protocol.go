@@ -6,6 +6,7 @@
 package valuerpc
 
 import (
+	"fmt"
 	"github.com/codeallergy/value"
 )
 
@@ -33,6 +34,69 @@ func (t MessageType) Long() value.Number {
 	return value.Long(int64(t))
 }
 
+var messageTypeNames = []string{
+	"HandshakeRequest",
+	"HandshakeResponse",
+	"FunctionRequest",
+	"FunctionResponse",
+	"GetStreamRequest",
+	"PutStreamRequest",
+	"ChatRequest",
+	"ErrorResponse",
+	"StreamReady",
+	"StreamValue",
+	"StreamEnd",
+	"CancelRequest",
+	"ThrottleIncrease",
+	"ThrottleDecrease",
+}
+
+func (t MessageType) String() string {
+	if t >= 0 && int(t) < len(messageTypeNames) {
+		return messageTypeNames[t]
+	}
+	return fmt.Sprintf("MessageType(%d)", int64(t))
+}
+
+type ErrorCode int64
+
+const (
+	UnknownError ErrorCode = iota
+	BadRequest
+	FunctionNotFound
+	WrongFunctionType
+	InvalidArgs
+	InvalidResult
+	InvalidStreamValue
+	RequestCanceled
+	FunctionFailed
+	RequestRejected // by server interceptor
+)
+
+func (t ErrorCode) Long() value.Number {
+	return value.Long(int64(t))
+}
+
+var errorCodeNames = []string{
+	"UnknownError",
+	"BadRequest",
+	"FunctionNotFound",
+	"WrongFunctionType",
+	"InvalidArgs",
+	"InvalidResult",
+	"InvalidStreamValue",
+	"RequestCanceled",
+	"FunctionFailed",
+	"RequestRejected",
+}
+
+func (t ErrorCode) String() string {
+	if t >= 0 && int(t) < len(errorCodeNames) {
+		return errorCodeNames[t]
+	}
+	return fmt.Sprintf("ErrorCode(%d)", int64(t))
+}
+
 var Magic = "vRPC"
 var Version = 1.0
 
@@ -42,11 +106,16 @@ var VersionField = "v"
 var RequestIdField = "rid"
 var TimeoutField = "sla"
 var ClientIdField = "cid"
+var TokenField = "tok" // optional auth token in handshake request
 var FunctionNameField = "fn"
 var ArgumentsField = "args" // allow multiple args if List value in function call
 var ResultField = "res"     // allow multiple results if List in function call
 var ErrorField = "err"
+var ErrorCodeField = "code"
+var ErrorDetailsField = "det" // structured error details, list of violations for invalid args
 var ValueField = "val" // streaming value field
+var ReverseField = "rev" // true in messages of reverse calls, started by server to functions of client
+var FunctionsField = "fns" // functions registered on client, advertised in handshake request
 
 var HandshakeRequestId = int64(-1)
 
@@ -72,7 +141,7 @@ func ValidMagicAndVersion(req value.Map) bool {
 	if magic == nil || magic.String() != Magic {
 		return false
 	}
-	version := req.GetNumber(MagicField)
+	version := req.GetNumber(VersionField)
 	if version == nil || version.Double() > Version {
 		return false
 	}
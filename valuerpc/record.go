@@ -0,0 +1,264 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/codeallergy/value"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+/**
+Traffic recording, file is a sequence of records framed the same way as protocol messages:
+4 bytes big endian length and msgpack table with fields ts, conn, dir and msg.
+Direction is recorded from the protocol point of view, so recordings on client and server sides are comparable.
+Auth token, metadata and trailer values are replaced by RedactedValue, recordings could be shared without credentials.
+*/
+
+type Direction string
+
+const (
+	ClientToServer Direction = "c2s"
+	ServerToClient Direction = "s2c"
+)
+
+type Side int
+
+const (
+	ClientSide Side = iota
+	ServerSide
+)
+
+var RecordTimeField = "ts" // unix time in nanoseconds
+var RecordConnField = "conn"
+var RecordDirectionField = "dir"
+var RecordMessageField = "msg"
+
+var MaxRecordSize = 64 << 20
+
+var RedactedValue = "REDACTED"
+
+type Record struct {
+	Time      time.Time
+	Conn      int64 // sequence number of recorded connection
+	Direction Direction
+	Message   value.Map
+}
+
+func (t Record) ToValue() value.Map {
+	return value.EmptyMap().
+		Put(RecordTimeField, value.Long(t.Time.UnixNano())).
+		Put(RecordConnField, value.Long(t.Conn)).
+		Put(RecordDirectionField, value.Utf8(string(t.Direction))).
+		Put(RecordMessageField, t.Message)
+}
+
+func RecordFromValue(val value.Value) (Record, error) {
+	var t Record
+	if val == nil || val.Kind() != value.MAP {
+		return t, errors.New("record expected map")
+	}
+	m := val.(value.Map)
+	ts := m.GetNumber(RecordTimeField)
+	conn := m.GetNumber(RecordConnField)
+	msg := m.GetMap(RecordMessageField)
+	if ts == nil || conn == nil || msg == nil {
+		return t, errors.Errorf("record fields %s, %s and %s are required", RecordTimeField, RecordConnField, RecordMessageField)
+	}
+	t.Time = time.Unix(0, ts.Long())
+	t.Conn = conn.Long()
+	t.Direction = Direction(getString(m, RecordDirectionField))
+	t.Message = msg
+	if t.Direction != ClientToServer && t.Direction != ServerToClient {
+		return t, errors.Errorf("unknown record direction '%s'", t.Direction)
+	}
+	return t, nil
+}
+
+type Recorder struct {
+	w       io.Writer
+	closer  io.Closer
+	lock    sync.Mutex
+	connSeq atomic.Int64
+	err     error // first write error, recording stops after it
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+func CreateRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(f)
+	return &Recorder{w: bw, closer: fileCloser{bw, f}}, nil
+}
+
+type fileCloser struct {
+	bw *bufio.Writer
+	f  *os.File
+}
+
+func (t fileCloser) Close() error {
+	if err := t.bw.Flush(); err != nil {
+		t.f.Close()
+		return err
+	}
+	return t.f.Close()
+}
+
+// replaces token, metadata and trailer values, keys of metadata and trailers are kept
+func Redact(msg value.Map) value.Map {
+	if msg == nil {
+		return msg
+	}
+	if _, ok := msg.Get(TokenField); ok {
+		msg = msg.Put(TokenField, value.Utf8(RedactedValue))
+	}
+	for _, field := range []string{MetadataField, TrailerField} {
+		if md := GetMetadata(msg, field); len(md) > 0 {
+			for k := range md {
+				md[k] = RedactedValue
+			}
+			msg = msg.Put(field, md.ToValue())
+		}
+	}
+	return msg
+}
+
+// message of the record is redacted
+func (t *Recorder) Record(rec Record) error {
+	rec.Message = Redact(rec.Message)
+	payload, err := value.Pack(rec.ToValue())
+	if err != nil {
+		return errors.Errorf("msgpack pack, %v", err)
+	}
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	if _, err := t.w.Write(header[:]); err != nil {
+		t.err = err
+		return err
+	}
+	if _, err := t.w.Write(payload); err != nil {
+		t.err = err
+		return err
+	}
+	return nil
+}
+
+// first write error
+func (t *Recorder) Err() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.err
+}
+
+func (t *Recorder) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closer != nil {
+		return t.closer.Close()
+	}
+	return nil
+}
+
+// records every message read and written by the connection
+func NewRecordingMsgConn(conn MsgConn, rec *Recorder, side Side) MsgConn {
+	return &recordingConn{
+		MsgConn: conn,
+		rec:     rec,
+		side:    side,
+		connId:  rec.connSeq.Inc(),
+	}
+}
+
+type recordingConn struct {
+	MsgConn
+	rec    *Recorder
+	side   Side
+	connId int64
+}
+
+func (t *recordingConn) record(written bool, msg value.Map) {
+	dir := ServerToClient
+	if written == (t.side == ClientSide) {
+		dir = ClientToServer
+	}
+	// recording must not break the traffic, errors are available by Recorder.Err
+	t.rec.Record(Record{Time: time.Now(), Conn: t.connId, Direction: dir, Message: msg})
+}
+
+func (t *recordingConn) ReadMessage() (value.Map, error) {
+	msg, err := t.MsgConn.ReadMessage()
+	if err == nil {
+		t.record(false, msg)
+	}
+	return msg, err
+}
+
+func (t *recordingConn) WriteMessage(msg value.Map) error {
+	err := t.MsgConn.WriteMessage(msg)
+	if err == nil {
+		t.record(true, msg)
+	}
+	return err
+}
+
+type RecordReader struct {
+	r *bufio.Reader
+}
+
+func NewRecordReader(r io.Reader) *RecordReader {
+	return &RecordReader{r: bufio.NewReader(r)}
+}
+
+// returns io.EOF at the end of recording
+func (t *RecordReader) Next() (Record, error) {
+	payload, err := ReadFrame(t.r, MaxRecordSize)
+	if err != nil {
+		return Record{}, err
+	}
+	val, err := value.Unpack(payload, false)
+	if err != nil {
+		return Record{}, errors.Errorf("msgpack unpack, %v", err)
+	}
+	return RecordFromValue(val)
+}
+
+func ReadRecords(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := NewRecordReader(f)
+	var list []Record
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return list, nil
+		}
+		if err != nil {
+			return list, errors.Errorf("record %d, %v", len(list), err)
+		}
+		list = append(list, rec)
+	}
+}
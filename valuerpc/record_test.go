@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"bytes"
+	"github.com/codeallergy/value"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	handshake := value.EmptyMap().
+		Put(MessageTypeField, HandshakeRequest.Long()).
+		Put(TokenField, value.Utf8("secret"))
+	call := PutMetadata(value.EmptyMap().
+		Put(MessageTypeField, FunctionRequest.Long()).
+		Put(FunctionNameField, value.Utf8("f")), MetadataField, NewMetadata("authorization", "Bearer secret"))
+	result := value.EmptyMap().
+		Put(MessageTypeField, FunctionResponse.Long()).
+		Put(ResultField, value.Long(1))
+	trailed := PutMetadata(result, TrailerField, NewMetadata("session", "secret"))
+
+	tests := []struct {
+		name     string
+		rec      Record
+		expected value.Map
+	}{
+		{"token is redacted", Record{Conn: 1, Direction: ClientToServer, Message: handshake},
+			handshake.Put(TokenField, value.Utf8(RedactedValue))},
+		{"metadata values are redacted", Record{Conn: 1, Direction: ClientToServer, Message: call},
+			PutMetadata(call, MetadataField, NewMetadata("authorization", RedactedValue))},
+		{"trailer values are redacted", Record{Conn: 2, Direction: ServerToClient, Message: trailed},
+			PutMetadata(result, TrailerField, NewMetadata("session", RedactedValue))},
+		{"other messages are kept", Record{Conn: 2, Direction: ServerToClient, Message: result}, result},
+	}
+
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	now := time.Unix(0, time.Now().UnixNano())
+	for _, tt := range tests {
+		tt.rec.Time = now
+		if err := recorder.Record(tt.rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader := NewRecordReader(&buf)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, err := reader.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !rec.Time.Equal(now) || rec.Conn != tt.rec.Conn || rec.Direction != tt.rec.Direction {
+				t.Errorf("expected %v %d %s, actual %v %d %s", now, tt.rec.Conn, tt.rec.Direction, rec.Time, rec.Conn, rec.Direction)
+			}
+			if !rec.Message.Equal(tt.expected) {
+				t.Errorf("expected %v, actual %v", tt.expected, rec.Message)
+			}
+		})
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("expected EOF, actual %v", err)
+	}
+}
+
+func TestRecordFromValue(t *testing.T) {
+	msg := value.EmptyMap().Put(MessageTypeField, FunctionRequest.Long())
+	valid := Record{Time: time.Unix(1, 0), Conn: 1, Direction: ClientToServer, Message: msg}.ToValue()
+	tests := []struct {
+		name  string
+		val   value.Value
+		valid bool
+	}{
+		{"valid", valid, true},
+		{"not a map", value.Long(1), false},
+		{"nil", nil, false},
+		{"no message", valid.Remove(RecordMessageField), false},
+		{"no time", valid.Remove(RecordTimeField), false},
+		{"unknown direction", valid.Put(RecordDirectionField, value.Utf8("up")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := RecordFromValue(tt.val); (err == nil) != tt.valid {
+				t.Errorf("expected valid %v, error %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestRecordingMsgConn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.rec")
+	recorder, err := CreateRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, s := net.Pipe()
+	client := NewRecordingMsgConn(NewMsgConn(c, time.Second), recorder, ClientSide)
+	server := NewMsgConn(s, time.Second)
+	defer client.Close()
+	defer server.Close()
+
+	req := value.EmptyMap().Put(MessageTypeField, FunctionRequest.Long())
+	resp := value.EmptyMap().Put(MessageTypeField, FunctionResponse.Long())
+	go func() {
+		if _, err := server.ReadMessage(); err == nil {
+			server.WriteMessage(resp)
+		}
+	}()
+	if err := client.WriteMessage(req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := ReadRecords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 records, actual %d", len(list))
+	}
+	if list[0].Direction != ClientToServer || !list[0].Message.Equal(req) {
+		t.Errorf("first record %v %v", list[0].Direction, list[0].Message)
+	}
+	if list[1].Direction != ServerToClient || !list[1].Message.Equal(resp) {
+		t.Errorf("second record %v %v", list[1].Direction, list[1].Message)
+	}
+	if list[0].Conn != list[1].Conn {
+		t.Errorf("records of one connection have different numbers")
+	}
+}
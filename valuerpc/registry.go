@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+)
+
+/**
+Handlers and registry of functions are shared by server and client,
+client serves reverse calls of server by the embedded ReverseServer.
+*/
+
+type Function func(args value.Value) (value.Value, error)
+type OutgoingStream func(args value.Value) (<-chan value.Value, error)
+type IncomingStream func(args value.Value, inC <-chan value.Value) error
+type Chat func(args value.Value, inC <-chan value.Value) (<-chan value.Value, error)
+
+// handlers with context, it carries the trace of the request to pass into calls of other services,
+// ctx is done when client cancels the request or disconnects, when the stream is closed or the timeout of function call expires,
+// valueserver.FailStream(ctx, ...) ends the stream by error response
+type ContextFunction func(ctx context.Context, args value.Value) (value.Value, error)
+type ContextOutgoingStream func(ctx context.Context, args value.Value) (<-chan value.Value, error)
+type ContextIncomingStream func(ctx context.Context, args value.Value, inC <-chan value.Value) error
+type ContextChat func(ctx context.Context, args value.Value, inC <-chan value.Value) (<-chan value.Value, error)
+
+// functions served for the peer, server registers them for clients and client for reverse calls of server
+type Registry interface {
+	AddFunction(name string, args TypeDef, res TypeDef, cb Function) error
+
+	// GET for client
+	AddOutgoingStream(name string, args TypeDef, cb OutgoingStream) error
+
+	// PUT for client
+	AddIncomingStream(name string, args TypeDef, cb IncomingStream) error
+
+	// Dual channel chat
+	AddChat(name string, args TypeDef, cb Chat) error
+
+	// GET for client, out is the type of each streamed value
+	AddOutgoingStreamOf(name string, args TypeDef, out TypeDef, cb OutgoingStream) error
+
+	// PUT for client, in is the type of each received value
+	AddIncomingStreamOf(name string, args TypeDef, in TypeDef, cb IncomingStream) error
+
+	// Dual channel chat with types of received and sent values
+	AddChatOf(name string, args TypeDef, in TypeDef, out TypeDef, cb Chat) error
+
+	AddContextFunction(name string, args TypeDef, res TypeDef, cb ContextFunction) error
+
+	AddContextOutgoingStream(name string, args TypeDef, out TypeDef, cb ContextOutgoingStream) error
+
+	AddContextIncomingStream(name string, args TypeDef, in TypeDef, cb ContextIncomingStream) error
+
+	AddContextChat(name string, args TypeDef, in TypeDef, out TypeDef, cb ContextChat) error
+
+	RemoveFunction(name string) error
+
+	// description and tags are visible through introspection
+	DescribeFunction(name string, description string, tags ...string) error
+
+	// registered functions sorted by name, including reserved ones
+	Functions() []FunctionInfo
+}
+
+// serves reverse calls on the client, valueserver.NewListenerServer(nil, logger) implements it
+type ReverseServer interface {
+	Registry
+
+	// serves requests of the peer without handshake until conn is closed
+	ServeMsgConn(clientId int64, conn MsgConn) error
+
+	Close() error
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/smallnest/goframe"
 	"go.uber.org/atomic"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -36,6 +37,26 @@ var decoderConfig = goframe.DecoderConfig{
 	InitialBytesToStrip: 4,
 }
 
+// reads one frame with the same length field framing as MsgConn, used for files and captured traffic
+func ReadFrame(r io.Reader, maxSize int) ([]byte, error) {
+	header := make([]byte, decoderConfig.LengthFieldLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	size := decoderConfig.ByteOrder.Uint32(header)
+	if int64(size) > int64(maxSize) {
+		return nil, errors.Errorf("frame size %d exceeds limit %d", size, maxSize)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
+
 type MsgConn interface {
 	ReadMessage() (value.Map, error)
 
@@ -0,0 +1,228 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/codeallergy/value"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+	"strings"
+)
+
+/**
+Tracing with W3C trace context. Client puts traceparent of the call span to the request,
+server continues the trace in the handler context. Tracer is pluggable, by default spans are not recorded
+but the trace context is still propagated through chains of services.
+*/
+
+var TraceField = "trc" // W3C traceparent of the caller span in request
+
+// span event names and attributes
+var (
+	StreamValueEvent = "stream.value"
+	StreamEndEvent   = "stream.end"
+	CancelEvent      = "cancel"
+	ThrottleEvent    = "throttle"
+
+	RPCSystemAttr = "rpc.system"
+	RPCMethodAttr = "rpc.method"
+	KindAttr      = "vrpc.kind"
+	ClientIdAttr  = "vrpc.client_id"
+	RequestIdAttr = "vrpc.request_id"
+	DirectionAttr = "vrpc.direction"
+	SeqAttr       = "vrpc.seq"
+	ThrottleAttr  = "vrpc.throttle"
+	ErrorCodeAttr = "vrpc.error_code"
+	RPCSystemVrpc = "vrpc"
+	InDirection   = "in"
+	OutDirection  = "out"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t SpanID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+const sampledFlag = 0x01
+
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	Remote  bool // received from the other side
+}
+
+func (t SpanContext) IsValid() bool {
+	return t.TraceID != TraceID{} && t.SpanID != SpanID{}
+}
+
+func (t SpanContext) IsSampled() bool {
+	return t.Flags&sampledFlag != 0
+}
+
+// W3C traceparent header value, version 00
+func (t SpanContext) TraceParent() string {
+	return "00-" + t.TraceID.String() + "-" + t.SpanID.String() + "-" + hex.EncodeToString([]byte{t.Flags})
+}
+
+func ParseTraceParent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, errors.Errorf("invalid traceparent '%s'", s)
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, errors.Errorf("invalid traceparent '%s'", s)
+	}
+	if err := decodeHex(parts[1], sc.TraceID[:]); err != nil {
+		return sc, errors.Errorf("traceparent trace id, %v", err)
+	}
+	if err := decodeHex(parts[2], sc.SpanID[:]); err != nil {
+		return sc, errors.Errorf("traceparent span id, %v", err)
+	}
+	var flags [1]byte
+	if err := decodeHex(parts[3], flags[:]); err != nil {
+		return sc, errors.Errorf("traceparent flags, %v", err)
+	}
+	sc.Flags = flags[0]
+	sc.Remote = true
+	if !sc.IsValid() {
+		return sc, errors.Errorf("traceparent '%s' has zero ids", s)
+	}
+	return sc, nil
+}
+
+func decodeHex(s string, dst []byte) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return errors.Errorf("expected %d lowercase hex digits, got '%s'", hex.EncodedLen(len(dst)), s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota
+	SpanKindServer
+	SpanKindClient
+)
+
+type Attr struct {
+	Key   string
+	Value interface{} // string, bool, int64, float64
+}
+
+func Attribute(key string, value interface{}) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+type Span interface {
+	SpanContext() SpanContext
+
+	AddEvent(name string, attrs ...Attr)
+
+	SetAttributes(attrs ...Attr)
+
+	SetError(err error)
+
+	// safe to call more than once
+	End()
+}
+
+// implementations create child of the span in ctx, or of the remote span context in ctx
+type Tracer interface {
+	Start(ctx context.Context, name string, kind SpanKind, attrs ...Attr) (context.Context, Span)
+}
+
+type spanKey struct{}
+
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// span of the context, or not recording span with empty span context
+func SpanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		return span
+	}
+	return noopSpan{}
+}
+
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	sc.Remote = true
+	return ContextWithSpan(ctx, noopSpan{sc})
+}
+
+// puts traceparent of the span in context to the request
+func InjectTrace(ctx context.Context, req value.Map) value.Map {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return req
+	}
+	return req.Put(TraceField, value.Utf8(sc.TraceParent()))
+}
+
+// context with remote span context of the request, invalid traceparent is ignored
+func ExtractTrace(ctx context.Context, req value.Map) context.Context {
+	tp := req.GetString(TraceField)
+	if tp == nil {
+		return ctx
+	}
+	sc, err := ParseTraceParent(tp.String())
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// tracer replaceable at runtime, shared by client and server
+type TracerHolder struct {
+	value atomic.Value // tracerBox, tracers of different types could not be stored directly
+}
+
+type tracerBox struct {
+	tracer Tracer
+}
+
+// NoopTracer if tracer is not set
+func (t *TracerHolder) Get() Tracer {
+	if box, ok := t.value.Load().(tracerBox); ok && box.tracer != nil {
+		return box.tracer
+	}
+	return NoopTracer{}
+}
+
+// nil restores NoopTracer
+func (t *TracerHolder) Set(tracer Tracer) {
+	t.value.Store(tracerBox{tracer})
+}
+
+// does not record spans, keeps the parent span context so the trace is propagated further
+type NoopTracer struct{}
+
+func (NoopTracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...Attr) (context.Context, Span) {
+	span := noopSpan{SpanFromContext(ctx).SpanContext()}
+	return ContextWithSpan(ctx, span), span
+}
+
+type noopSpan struct {
+	sc SpanContext
+}
+
+func (t noopSpan) SpanContext() SpanContext            { return t.sc }
+func (t noopSpan) AddEvent(name string, attrs ...Attr) {}
+func (t noopSpan) SetAttributes(attrs ...Attr)         {}
+func (t noopSpan) SetError(err error)                  {}
+func (t noopSpan) End()                                {}
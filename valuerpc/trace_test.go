@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"spaces", " 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ", true, true},
+		{"future version with extra field", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"version 00 with extra field", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"forbidden version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"short trace id", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01", false, false},
+		{"empty", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceParent(tt.in)
+			if (err == nil) != tt.valid {
+				t.Fatalf("expected valid %v, error %v", tt.valid, err)
+			}
+			if !tt.valid {
+				return
+			}
+			if !sc.Remote || sc.IsSampled() != tt.sampled {
+				t.Errorf("remote %v, sampled %v", sc.Remote, sc.IsSampled())
+			}
+			if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+				t.Errorf("ids %s %s", sc.TraceID, sc.SpanID)
+			}
+		})
+	}
+}
+
+func TestTraceRoundTrip(t *testing.T) {
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceParent(tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceParent() != tp {
+		t.Errorf("expected %s, actual %s", tp, sc.TraceParent())
+	}
+
+	req := InjectTrace(ContextWithRemoteSpanContext(context.Background(), sc), value.EmptyMap())
+	if s := req.GetString(TraceField); s == nil || s.String() != tp {
+		t.Fatalf("traceparent is not injected, %v", req)
+	}
+	if actual := SpanFromContext(ExtractTrace(context.Background(), req)).SpanContext(); actual != sc {
+		t.Errorf("expected %v, actual %v", sc, actual)
+	}
+
+	if req := InjectTrace(context.Background(), value.EmptyMap()); req.Len() != 0 {
+		t.Errorf("context without span injected %v", req)
+	}
+	bad := value.EmptyMap().Put(TraceField, value.Utf8("bad"))
+	if SpanFromContext(ExtractTrace(context.Background(), bad)).SpanContext().IsValid() {
+		t.Errorf("invalid traceparent is extracted")
+	}
+}
+
+func TestTracerHolder(t *testing.T) {
+	var h TracerHolder
+	if _, ok := h.Get().(NoopTracer); !ok {
+		t.Errorf("expected noop tracer by default, actual %T", h.Get())
+	}
+	h.Set(nil)
+	if _, ok := h.Get().(NoopTracer); !ok {
+		t.Errorf("expected noop tracer after nil, actual %T", h.Get())
+	}
+}
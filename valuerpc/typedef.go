@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"github.com/codeallergy/value"
+	"github.com/pkg/errors"
+	"regexp"
+)
+
+/**
+Serialized form of TypeDef used by introspection, custom validators are reported and restored without function
+*/
+
+var TypeField = "type"
+var KindField = "kind"
+var RequiredField = "required"
+var ConstraintsField = "constraints"
+var ArgsListField = "args"
+var ParamsListField = "params"
+var NameField = "name"
+var DefaultField = "default"
+var StrictField = "strict"
+var CoerceField = "coerce"
+
+var AnyType = "any"
+var VoidType = "void"
+var ArgType = "arg"
+var ListType = "list"
+var MapType = "map"
+
+var MinField = "min"
+var MaxField = "max"
+var MinLenField = "minLen"
+var MaxLenField = "maxLen"
+var MinSizeField = "minSize"
+var MaxSizeField = "maxSize"
+var PatternField = "pattern"
+var ValidatorField = "validator"
+
+func EncodeTypeDef(def TypeDef) value.Map {
+	switch d := def.(type) {
+	case AnyDef:
+		return value.EmptyMap().Put(TypeField, value.Utf8(AnyType))
+	case VoidDef:
+		return value.EmptyMap().Put(TypeField, value.Utf8(VoidType))
+	case ArgDef:
+		return encodeKind(value.EmptyMap().Put(TypeField, value.Utf8(ArgType)), d.Kind, d.Required, d.Constraints)
+	case ArgsDef:
+		list := value.EmptyList()
+		for _, arg := range d.List {
+			list = list.Append(encodeKind(value.EmptyMap(), arg.Kind, arg.Required, arg.Constraints))
+		}
+		return value.EmptyMap().
+			Put(TypeField, value.Utf8(ListType)).
+			Put(ArgsListField, list).
+			Put(CoerceField, value.Boolean(d.Coerce))
+	case ParamsDef:
+		list := value.EmptyList()
+		for _, param := range d.Map {
+			m := encodeKind(value.EmptyMap().Put(NameField, value.Utf8(param.Name)), param.Kind, param.Required, param.Constraints)
+			if param.Default != nil {
+				m = m.Put(DefaultField, param.Default)
+			}
+			list = list.Append(m)
+		}
+		return value.EmptyMap().
+			Put(TypeField, value.Utf8(MapType)).
+			Put(ParamsListField, list).
+			Put(StrictField, value.Boolean(d.Strict)).
+			Put(CoerceField, value.Boolean(d.Coerce))
+	}
+	return value.EmptyMap().Put(TypeField, value.Utf8(AnyType))
+}
+
+func encodeKind(m value.Map, kind value.Kind, required bool, constraints []Constraint) value.Map {
+	m = m.Put(KindField, value.Utf8(KindName(kind))).
+		Put(RequiredField, value.Boolean(required))
+	if len(constraints) > 0 {
+		list := value.EmptyList()
+		for _, c := range constraints {
+			list = list.Append(EncodeConstraint(c))
+		}
+		m = m.Put(ConstraintsField, list)
+	}
+	return m
+}
+
+func EncodeConstraint(c Constraint) value.Map {
+	m := value.EmptyMap()
+	switch v := c.(type) {
+	case MinConstraint:
+		return m.Put(MinField, value.Double(v.Min))
+	case MaxConstraint:
+		return m.Put(MaxField, value.Double(v.Max))
+	case MinLenConstraint:
+		return m.Put(MinLenField, value.Long(int64(v.Min)))
+	case MaxLenConstraint:
+		return m.Put(MaxLenField, value.Long(int64(v.Max)))
+	case MinSizeConstraint:
+		return m.Put(MinSizeField, value.Long(int64(v.Min)))
+	case MaxSizeConstraint:
+		return m.Put(MaxSizeField, value.Long(int64(v.Max)))
+	case PatternConstraint:
+		return m.Put(PatternField, value.Utf8(v.Pattern.String()))
+	}
+	return m.Put(ValidatorField, value.Boolean(true))
+}
+
+func DecodeTypeDef(val value.Value) (TypeDef, error) {
+	if val == nil || val.Kind() != value.MAP {
+		return nil, errors.New("type definition expected map")
+	}
+	m := val.(value.Map)
+	switch getString(m, TypeField) {
+	case AnyType:
+		return Any, nil
+	case VoidType:
+		return Void, nil
+	case ArgType:
+		kind, required, constraints, err := decodeKind(m)
+		if err != nil {
+			return nil, err
+		}
+		return Arg(kind, required, constraints...), nil
+	case ListType:
+		def := ArgsDef{Coerce: getBool(m, CoerceField)}
+		if list := m.GetList(ArgsListField); list != nil {
+			for i, item := range list.Values() {
+				if item == nil || item.Kind() != value.MAP {
+					return nil, errors.Errorf("arg %d expected map", i)
+				}
+				kind, required, constraints, err := decodeKind(item.(value.Map))
+				if err != nil {
+					return nil, errors.Errorf("arg %d, %v", i, err)
+				}
+				def.List = append(def.List, Arg(kind, required, constraints...))
+			}
+		}
+		return def, nil
+	case MapType:
+		def := ParamsDef{Strict: getBool(m, StrictField), Coerce: getBool(m, CoerceField)}
+		if list := m.GetList(ParamsListField); list != nil {
+			for i, item := range list.Values() {
+				if item == nil || item.Kind() != value.MAP {
+					return nil, errors.Errorf("param %d expected map", i)
+				}
+				pm := item.(value.Map)
+				kind, required, constraints, err := decodeKind(pm)
+				if err != nil {
+					return nil, errors.Errorf("param %d, %v", i, err)
+				}
+				param := Param(getString(pm, NameField), kind, required, constraints...)
+				param.Default, _ = pm.Get(DefaultField)
+				def.Map = append(def.Map, param)
+			}
+		}
+		return def, nil
+	}
+	return nil, errors.Errorf("unknown type definition '%s'", getString(m, TypeField))
+}
+
+func decodeKind(m value.Map) (value.Kind, bool, []Constraint, error) {
+	kind, ok := KindByName(getString(m, KindField))
+	if !ok {
+		return 0, false, nil, errors.Errorf("unknown kind '%s'", getString(m, KindField))
+	}
+	var constraints []Constraint
+	if list := m.GetList(ConstraintsField); list != nil {
+		for _, item := range list.Values() {
+			if item == nil || item.Kind() != value.MAP {
+				continue
+			}
+			c, err := DecodeConstraint(item.(value.Map))
+			if err != nil {
+				return 0, false, nil, err
+			}
+			if c != nil {
+				constraints = append(constraints, c)
+			}
+		}
+	}
+	return kind, getBool(m, RequiredField), constraints, nil
+}
+
+// custom validators are decoded without function, they are checked by the server only,
+// returns nil for unknown constraints
+func DecodeConstraint(m value.Map) (Constraint, error) {
+	if n := m.GetNumber(MinField); n != nil {
+		return Min(n.Double()), nil
+	}
+	if n := m.GetNumber(MaxField); n != nil {
+		return Max(n.Double()), nil
+	}
+	if n := m.GetNumber(MinLenField); n != nil {
+		return MinLen(int(n.Long())), nil
+	}
+	if n := m.GetNumber(MaxLenField); n != nil {
+		return MaxLen(int(n.Long())), nil
+	}
+	if n := m.GetNumber(MinSizeField); n != nil {
+		return MinSize(int(n.Long())), nil
+	}
+	if n := m.GetNumber(MaxSizeField); n != nil {
+		return MaxSize(int(n.Long())), nil
+	}
+	if s := m.GetString(PatternField); s != nil {
+		re, err := regexp.Compile(s.String())
+		if err != nil {
+			return nil, errors.Errorf("invalid pattern '%s', %v", s.String(), err)
+		}
+		return PatternConstraint{re}, nil
+	}
+	if b := m.GetBool(ValidatorField); b != nil && b.Boolean() {
+		return ValidatorConstraint{}, nil
+	}
+	return nil, nil
+}
+
+func KindByName(name string) (value.Kind, bool) {
+	for _, kind := range []value.Kind{AnyKind, value.BOOL, value.NUMBER, value.STRING, value.LIST, value.MAP} {
+		if KindName(kind) == name {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+func getString(m value.Map, key string) string {
+	if s := m.GetString(key); s != nil {
+		return s.String()
+	}
+	return ""
+}
+
+func getBool(m value.Map, key string) bool {
+	if b := m.GetBool(key); b != nil {
+		return b.Boolean()
+	}
+	return false
+}
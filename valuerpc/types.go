@@ -25,47 +25,97 @@ func (t VoidDef) UserTypeDef() {
 }
 
 type ArgsDef struct {
-	List []ArgDef
+	List   []ArgDef
+	Coerce bool // convert numeric strings to numbers and single values to lists
 }
 
 func (t ArgsDef) UserTypeDef() {
 }
 
 func List(args ...ArgDef) ArgsDef {
-	return ArgsDef{args}
+	return ArgsDef{List: args}
+}
+
+func (t ArgsDef) WithCoercion() ArgsDef {
+	t.Coerce = true
+	return t
 }
 
 type ParamsDef struct {
-	Map []ParamDef
+	Map    []ParamDef
+	Strict bool // reject unknown params
+	Coerce bool // convert numeric strings to numbers and single values to lists
 }
 
 func (t ParamsDef) UserTypeDef() {
 }
 
 func Map(params ...ParamDef) ParamsDef {
-	return ParamsDef{params}
+	return ParamsDef{Map: params}
+}
+
+func StrictMap(params ...ParamDef) ParamsDef {
+	return ParamsDef{Map: params, Strict: true}
+}
+
+func (t ParamsDef) WithStrict() ParamsDef {
+	t.Strict = true
+	return t
+}
+
+func (t ParamsDef) WithCoercion() ParamsDef {
+	t.Coerce = true
+	return t
 }
 
 type ArgDef struct {
-	Kind     value.Kind
-	Required bool
+	Kind        value.Kind
+	Required    bool
+	Constraints []Constraint
 }
 
 func (t ArgDef) UserTypeDef() {
 }
 
-func Arg(kind value.Kind, required bool) ArgDef {
-	return ArgDef{kind, required}
+func Arg(kind value.Kind, required bool, constraints ...Constraint) ArgDef {
+	return ArgDef{kind, required, constraints}
+}
+
+// returns copy of the definition with additional constraints
+func (t ArgDef) With(constraints ...Constraint) ArgDef {
+	t.Constraints = appendConstraints(t.Constraints, constraints)
+	return t
 }
 
 type ParamDef struct {
-	Name     string
-	Kind     value.Kind
-	Required bool
+	Name        string
+	Kind        value.Kind
+	Required    bool
+	Constraints []Constraint
+	Default     value.Value // used when param is missing
+}
+
+func Param(name string, kind value.Kind, required bool, constraints ...Constraint) ParamDef {
+	return ParamDef{Name: name, Kind: kind, Required: required, Constraints: constraints}
+}
+
+// returns copy of the optional definition with default value
+func (t ParamDef) WithDefault(val value.Value) ParamDef {
+	t.Required = false
+	t.Default = val
+	return t
+}
+
+// returns copy of the definition with additional constraints
+func (t ParamDef) With(constraints ...Constraint) ParamDef {
+	t.Constraints = appendConstraints(t.Constraints, constraints)
+	return t
 }
 
-func Param(name string, kind value.Kind, required bool) ParamDef {
-	return ParamDef{name, kind, required}
+func appendConstraints(list []Constraint, constraints []Constraint) []Constraint {
+	out := make([]Constraint, 0, len(list)+len(constraints))
+	out = append(out, list...)
+	return append(out, constraints...)
 }
 
 var (
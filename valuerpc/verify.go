@@ -5,88 +5,243 @@
 
 package valuerpc
 
-import "github.com/codeallergy/value"
+import (
+	"fmt"
+	"github.com/codeallergy/value"
+	"strings"
+)
+
+var ArgumentsPath = "args"
+var ResultPath = "res"
+var IncomingPath = "in"
+var OutgoingPath = "out"
+
+var ViolationPathField = "path"
+var ViolationExpectedField = "exp"
+var ViolationActualField = "act"
+var ViolationMessageField = "msg"
+
+type Violation struct {
+	Path     string
+	Expected string
+	Actual   string
+	Message  string
+}
+
+func (t Violation) String() string {
+	if t.Message != "" {
+		return fmt.Sprintf("%s: %s", t.Path, t.Message)
+	}
+	return fmt.Sprintf("%s: expected %s, actual %s", t.Path, t.Expected, t.Actual)
+}
+
+func (t Violation) ToValue() value.Map {
+	m := value.EmptyMap().
+		Put(ViolationPathField, value.Utf8(t.Path)).
+		Put(ViolationExpectedField, value.Utf8(t.Expected)).
+		Put(ViolationActualField, value.Utf8(t.Actual))
+	if t.Message != "" {
+		m = m.Put(ViolationMessageField, value.Utf8(t.Message))
+	}
+	return m
+}
+
+func ViolationFromValue(m value.Map) Violation {
+	var t Violation
+	if s := m.GetString(ViolationPathField); s != nil {
+		t.Path = s.String()
+	}
+	if s := m.GetString(ViolationExpectedField); s != nil {
+		t.Expected = s.String()
+	}
+	if s := m.GetString(ViolationActualField); s != nil {
+		t.Actual = s.String()
+	}
+	if s := m.GetString(ViolationMessageField); s != nil {
+		t.Message = s.String()
+	}
+	return t
+}
+
+func ViolationsToValue(list []Violation) value.List {
+	out := value.EmptyList()
+	for _, v := range list {
+		out = out.Append(v.ToValue())
+	}
+	return out
+}
+
+func ViolationsFromValue(list value.List) []Violation {
+	var out []Violation
+	for _, v := range list.Values() {
+		if v != nil && v.Kind() == value.MAP {
+			out = append(out, ViolationFromValue(v.(value.Map)))
+		}
+	}
+	return out
+}
+
+func JoinViolations(list []Violation) string {
+	var out strings.Builder
+	for i, v := range list {
+		if i > 0 {
+			out.WriteString("; ")
+		}
+		out.WriteString(v.String())
+	}
+	return out.String()
+}
 
 func Verify(args value.Value, def TypeDef) bool {
+	return len(VerifyDetailed(args, def)) == 0
+}
+
+// returns all violations found in arguments, empty if args match the definition
+func VerifyDetailed(args value.Value, def TypeDef) []Violation {
+	return VerifyAt(ArgumentsPath, args, def)
+}
+
+// same as VerifyDetailed with custom root path, for example ResultPath
+func VerifyAt(path string, val value.Value, def TypeDef) []Violation {
 	if def == Any {
-		return true
+		return nil
 	}
 	if def == Void {
-		if args == nil {
-			return true
-		}
-		switch args.Kind() {
-		case value.LIST:
-			list := args.(value.List)
-			return list.Len() == 0
-		case value.MAP:
-			m := args.(value.Map)
-			return m.Len() == 0
-		default:
-			return false
-		}
+		return verifyVoid(path, val)
 	}
 	if argDef, ok := def.(ArgDef); ok {
-		return VerifyArg(args, argDef)
+		return verifyArg(path, val, argDef, nil)
 	}
 	if argsDef, ok := def.(ArgsDef); ok {
-		return VerifyArgs(args, argsDef)
+		return verifyArgs(path, val, argsDef, nil)
 	}
 	if paramsDef, ok := def.(ParamsDef); ok {
-		return VerifyParams(args, paramsDef)
+		return verifyParams(path, val, paramsDef, nil)
 	}
-	return false
+	return []Violation{{Path: path, Expected: "known type definition", Actual: actualOf(val), Message: fmt.Sprintf("unsupported type definition %T", def)}}
 }
 
 func VerifyArgs(args value.Value, argsDef ArgsDef) bool {
+	return len(verifyArgs(ArgumentsPath, args, argsDef, nil)) == 0
+}
+
+func VerifyParams(args value.Value, paramsDef ParamsDef) bool {
+	return len(verifyParams(ArgumentsPath, args, paramsDef, nil)) == 0
+}
+
+func VerifyArg(arg value.Value, def ArgDef) bool {
+	return len(verifyArg(ArgumentsPath, arg, def, nil)) == 0
+}
+
+func VerifyParam(value value.Value, def ParamDef) bool {
+	return len(verifyKind(ArgumentsPath, value, def.Kind, def.Required, def.Constraints, nil)) == 0
+}
+
+func verifyVoid(path string, args value.Value) []Violation {
 	if args == nil {
-		return len(argsDef.List) == 0
+		return nil
+	}
+	switch args.Kind() {
+	case value.LIST:
+		if args.(value.List).Len() == 0 {
+			return nil
+		}
+	case value.MAP:
+		if args.(value.Map).Len() == 0 {
+			return nil
+		}
+	}
+	return []Violation{{Path: path, Expected: "void", Actual: actualOf(args)}}
+}
+
+func verifyArgs(path string, args value.Value, argsDef ArgsDef, out []Violation) []Violation {
+	if args == nil {
+		if len(argsDef.List) == 0 {
+			return out
+		}
+		return append(out, Violation{Path: path, Expected: fmt.Sprintf("list of %d", len(argsDef.List)), Actual: "nil"})
 	}
 	if args.Kind() != value.LIST {
-		return false
+		return append(out, Violation{Path: path, Expected: "list", Actual: actualOf(args)})
 	}
 	list := args.(value.List)
 	if list.Len() != len(argsDef.List) {
-		return false
+		out = append(out, Violation{Path: path, Expected: fmt.Sprintf("list of %d", len(argsDef.List)), Actual: fmt.Sprintf("list of %d", list.Len())})
 	}
 	for i, def := range argsDef.List {
-		if !VerifyArg(list.GetAt(i), def) {
-			return false
+		var arg value.Value
+		if i < list.Len() {
+			arg = list.GetAt(i)
 		}
+		out = verifyArg(fmt.Sprintf("%s[%d]", path, i), arg, def, out)
 	}
-	return true
+	return out
 }
 
-func VerifyParams(args value.Value, paramsDef ParamsDef) bool {
+func verifyParams(path string, args value.Value, paramsDef ParamsDef, out []Violation) []Violation {
 	if args == nil {
-		return len(paramsDef.Map) == 0
+		for _, paramDef := range paramsDef.Map {
+			if paramDef.Required {
+				return append(out, Violation{Path: path, Expected: "map", Actual: "nil"})
+			}
+		}
+		return out
 	}
 	if args.Kind() != value.MAP {
-		return false
+		return append(out, Violation{Path: path, Expected: "map", Actual: actualOf(args)})
 	}
 	cache := args.(value.Map)
 	for _, paramDef := range paramsDef.Map {
+		paramPath := path + "." + paramDef.Name
 		if val, ok := cache.Get(paramDef.Name); ok {
-			if !VerifyParam(val, paramDef) {
-				return false
+			out = verifyKind(paramPath, val, paramDef.Kind, paramDef.Required, paramDef.Constraints, out)
+		} else if paramDef.Required {
+			out = append(out, Violation{Path: paramPath, Expected: KindName(paramDef.Kind), Actual: "missing"})
+		}
+	}
+	if paramsDef.Strict {
+		for _, key := range cache.Keys() {
+			if !hasParam(paramsDef, key) {
+				val, _ := cache.Get(key)
+				out = append(out, Violation{Path: path + "." + key, Expected: "no param", Actual: actualOf(val), Message: "unknown param"})
 			}
-		} else {
-			return false
 		}
 	}
-	return true
+	return out
 }
 
-func VerifyArg(arg value.Value, def ArgDef) bool {
-	if arg == nil {
-		return !def.Required
+func hasParam(paramsDef ParamsDef, name string) bool {
+	for _, paramDef := range paramsDef.Map {
+		if paramDef.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyArg(path string, arg value.Value, def ArgDef, out []Violation) []Violation {
+	return verifyKind(path, arg, def.Kind, def.Required, def.Constraints, out)
+}
+
+func verifyKind(path string, val value.Value, kind value.Kind, required bool, constraints []Constraint, out []Violation) []Violation {
+	if val == nil {
+		if required {
+			return append(out, Violation{Path: path, Expected: KindName(kind), Actual: "nil"})
+		}
+		return out
+	}
+	if kind != AnyKind && val.Kind() != kind {
+		return append(out, Violation{Path: path, Expected: KindName(kind), Actual: actualOf(val)})
 	}
-	return arg.Kind() == def.Kind
+	if err := CheckConstraints(val, constraints); err != nil {
+		return append(out, Violation{Path: path, Expected: KindName(kind), Actual: actualOf(val), Message: err.Error()})
+	}
+	return out
 }
 
-func VerifyParam(value value.Value, def ParamDef) bool {
-	if value == nil {
-		return !def.Required
+func actualOf(val value.Value) string {
+	if val == nil {
+		return "nil"
 	}
-	return value.Kind() == def.Kind
+	return KindName(val.Kind())
 }
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"github.com/codeallergy/value"
+	"testing"
+)
+
+func paths(list []Violation) []string {
+	var out []string
+	for _, v := range list {
+		out = append(out, v.Path)
+	}
+	return out
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestVerify(t *testing.T) {
+	user := StrictMap(
+		Param("name", value.STRING, true, MinLen(2)),
+		Param("age", value.NUMBER, false, Min(0), Max(150)),
+	)
+	tests := []struct {
+		name  string
+		args  value.Value
+		def   TypeDef
+		paths []string
+	}{
+		{"any accepts nil", nil, Any, nil},
+		{"any accepts string", value.Utf8("x"), Any, nil},
+		{"void accepts nil", nil, Void, nil},
+		{"void accepts empty list", value.EmptyList(), Void, nil},
+		{"void rejects value", value.Long(1), Void, []string{"args"}},
+		{"arg kind", value.Long(1), String, []string{"args"}},
+		{"arg required", nil, Number, []string{"args"}},
+		{"arg optional", nil, NumberOpt, nil},
+		{"arg constraint", value.Long(5), Arg(value.NUMBER, true, Max(3)), []string{"args"}},
+		{"list", value.Tuple(value.Utf8("a"), value.Long(1)), List(String, Number), nil},
+		{"list length", value.Tuple(value.Utf8("a")), List(String, Number), []string{"args", "args[1]"}},
+		{"list element kind", value.Tuple(value.Long(1), value.Long(1)), List(String, Number), []string{"args[0]"}},
+		{"list of nil", nil, List(String), []string{"args"}},
+		{"map", value.EmptyMap().Put("name", value.Utf8("bob")), user, nil},
+		{"map missing required", value.EmptyMap().Put("age", value.Long(3)), user, []string{"args.name"}},
+		{"map constraint", value.EmptyMap().Put("name", value.Utf8("b")).Put("age", value.Long(200)), user, []string{"args.name", "args.age"}},
+		{"map unknown param of strict", value.EmptyMap().Put("name", value.Utf8("bob")).Put("x", value.Long(1)), user, []string{"args.x"}},
+		{"map not strict", value.EmptyMap().Put("x", value.Long(1)), Map(Param("name", value.STRING, false)), nil},
+		{"map expected", value.Long(1), user, []string{"args"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := VerifyDetailed(tt.args, tt.def)
+			if actual := paths(list); !equalStrings(actual, tt.paths) {
+				t.Errorf("expected violations at %v, actual %v", tt.paths, JoinViolations(list))
+			}
+			if Verify(tt.args, tt.def) != (len(tt.paths) == 0) {
+				t.Errorf("Verify does not match VerifyDetailed")
+			}
+		})
+	}
+}
+
+func TestViolationsValue(t *testing.T) {
+	list := []Violation{
+		{Path: "args.name", Expected: "string", Actual: "number"},
+		{Path: "args", Expected: "map", Actual: "nil", Message: "unknown param"},
+	}
+	actual := ViolationsFromValue(ViolationsToValue(list))
+	if len(actual) != len(list) {
+		t.Fatalf("expected %d violations, actual %d", len(list), len(actual))
+	}
+	for i := range list {
+		if actual[i] != list[i] {
+			t.Errorf("violation %d expected %v, actual %v", i, list[i], actual[i])
+		}
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     value.Value
+		def      TypeDef
+		expected value.Value
+		valid    bool
+	}{
+		{"default of missing param", value.EmptyMap(), Map(Param("n", value.NUMBER, true).WithDefault(value.Long(7))),
+			value.EmptyMap().Put("n", value.Long(7)), true},
+		{"default of nil args", nil, Map(Param("n", value.NUMBER, true).WithDefault(value.Long(7))),
+			value.EmptyMap().Put("n", value.Long(7)), true},
+		{"no coercion by default", value.EmptyMap().Put("n", value.Utf8("5")), Map(Param("n", value.NUMBER, true)),
+			value.EmptyMap().Put("n", value.Utf8("5")), false},
+		{"coerce param", value.EmptyMap().Put("n", value.Utf8("5")), Map(Param("n", value.NUMBER, true)).WithCoercion(),
+			value.EmptyMap().Put("n", value.Long(5)), true},
+		{"coerce list element", value.Tuple(value.Utf8("true")), List(Bool).WithCoercion(),
+			value.Tuple(value.Boolean(true)), true},
+		{"wrap single value", value.Utf8("1.5"), List(Number).WithCoercion(),
+			value.Tuple(value.Double(1.5)), true},
+		{"invalid string stays", value.EmptyMap().Put("n", value.Utf8("x")), Map(Param("n", value.NUMBER, true)).WithCoercion(),
+			value.EmptyMap().Put("n", value.Utf8("x")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, violations := Prepare(tt.args, tt.def)
+			if !actual.Equal(tt.expected) {
+				t.Errorf("expected %v, actual %v", tt.expected, actual)
+			}
+			if (len(violations) == 0) != tt.valid {
+				t.Errorf("expected valid %v, violations %s", tt.valid, JoinViolations(violations))
+			}
+		})
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	tests := []struct {
+		name     string
+		val      value.Value
+		kind     value.Kind
+		expected value.Value
+		ok       bool
+	}{
+		{"long", value.Utf8(" 42 "), value.NUMBER, value.Long(42), true},
+		{"double", value.Utf8("2.5"), value.NUMBER, value.Double(2.5), true},
+		{"not a number", value.Utf8("abc"), value.NUMBER, value.Utf8("abc"), false},
+		{"bool", value.Utf8("false"), value.BOOL, value.Boolean(false), true},
+		{"not a bool", value.Utf8("maybe"), value.BOOL, value.Utf8("maybe"), false},
+		{"same kind", value.Long(1), value.NUMBER, value.Long(1), false},
+		{"wrap in list", value.Long(1), value.LIST, value.Tuple(value.Long(1)), true},
+		{"number to string", value.Long(1), value.STRING, value.Long(1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, ok := Coerce(tt.val, tt.kind)
+			if ok != tt.ok {
+				t.Errorf("expected coerced %v, actual %v", tt.ok, ok)
+			}
+			if !actual.Equal(tt.expected) {
+				t.Errorf("expected %v, actual %v", tt.expected, actual)
+			}
+		})
+	}
+	if actual, ok := Coerce(nil, value.NUMBER); actual != nil || ok {
+		t.Errorf("nil is not coerced, actual %v, %v", actual, ok)
+	}
+}
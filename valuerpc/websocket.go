@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuerpc
+
+import (
+	"github.com/codeallergy/value"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+	"golang.org/x/net/websocket"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+WebSocket transport, one msgpack message per binary frame.
+*/
+
+var WebSocketScheme = "ws://"
+var SecureWebSocketScheme = "wss://"
+
+func IsWebSocketAddress(address string) bool {
+	return strings.HasPrefix(address, WebSocketScheme) || strings.HasPrefix(address, SecureWebSocketScheme)
+}
+
+func NewWebSocketMsgConn(ws *websocket.Conn, timeout time.Duration) MsgConn {
+	ws.PayloadType = websocket.BinaryFrame
+	return &webSocketConnAdapter{ws: ws, timeout: timeout}
+}
+
+type webSocketConnAdapter struct {
+	ws        *websocket.Conn
+	timeout   time.Duration
+	writeLock sync.Mutex
+	shutdown  atomic.Bool
+}
+
+func (t *webSocketConnAdapter) ReadMessage() (value.Map, error) {
+	var frame []byte
+	if err := websocket.Message.Receive(t.ws, &frame); err != nil {
+		return nil, err
+	}
+	msg, err := value.Unpack(frame, false)
+	if err != nil {
+		return nil, errors.Errorf("msgpack unpack, %v", err)
+	}
+	if msg.Kind() != value.MAP {
+		return nil, errors.New("expected msgpack table")
+	}
+	return msg.(value.Map), nil
+}
+
+func (t *webSocketConnAdapter) WriteMessage(msg value.Map) error {
+	if t.shutdown.Load() {
+		return ErrClientClosed
+	}
+	payload, err := value.Pack(msg)
+	if err != nil {
+		return errors.Errorf("msgpack pack, %v", err)
+	}
+	t.writeLock.Lock()
+	defer t.writeLock.Unlock()
+	if err := t.ws.SetWriteDeadline(time.Now().Add(t.timeout)); err != nil {
+		return err
+	}
+	return websocket.Message.Send(t.ws, payload)
+}
+
+func (t *webSocketConnAdapter) Close() error {
+	t.shutdown.Store(true)
+	return t.ws.Close()
+}
+
+func (t *webSocketConnAdapter) Conn() net.Conn {
+	return t.ws
+}
@@ -0,0 +1,364 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueschema
+
+import (
+	"fmt"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+)
+
+/**
+Compares catalogs of the running (old) and the new server builds from the point of view of old clients.
+Args and incoming stream values are sent by clients, the new server must accept everything the old one accepted.
+Results and outgoing stream values are received by clients, the new server must not send what old clients do not expect.
+*/
+
+type Severity string
+
+const (
+	Compatible Severity = "compatible"
+	Warning    Severity = "warning" // compatible by schema, but old clients may observe different behaviour
+	Breaking   Severity = "breaking"
+)
+
+type Change struct {
+	Function string
+	Path     string
+	Severity Severity
+	Message  string
+}
+
+func (t Change) String() string {
+	if t.Path == "" {
+		return fmt.Sprintf("%s %s: %s", t.Severity, t.Function, t.Message)
+	}
+	return fmt.Sprintf("%s %s %s: %s", t.Severity, t.Function, t.Path, t.Message)
+}
+
+func HasBreaking(changes []Change) bool {
+	for _, c := range changes {
+		if c.Severity == Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+type comparator struct {
+	function string
+	changes  []Change
+}
+
+func (t *comparator) add(severity Severity, path string, format string, args ...interface{}) {
+	t.changes = append(t.changes, Change{
+		Function: t.function,
+		Path:     path,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func Compare(oldList, newList []valuerpc.FunctionInfo) []Change {
+	var changes []Change
+
+	newMap := make(map[string]valuerpc.FunctionInfo)
+	for _, info := range newList {
+		newMap[info.Name] = info
+	}
+	oldMap := make(map[string]valuerpc.FunctionInfo)
+	for _, info := range oldList {
+		oldMap[info.Name] = info
+	}
+
+	for _, oldInfo := range oldList {
+		c := &comparator{function: oldInfo.Name}
+		newInfo, ok := newMap[oldInfo.Name]
+		if !ok {
+			c.add(Breaking, "", "function removed")
+		} else {
+			c.compareFunction(oldInfo, newInfo)
+		}
+		changes = append(changes, c.changes...)
+	}
+
+	for _, newInfo := range newList {
+		if _, ok := oldMap[newInfo.Name]; !ok {
+			c := &comparator{function: newInfo.Name}
+			c.add(Compatible, "", "function added")
+			changes = append(changes, c.changes...)
+		}
+	}
+
+	return changes
+}
+
+func (t *comparator) compareFunction(oldInfo, newInfo valuerpc.FunctionInfo) {
+	if oldInfo.Kind != newInfo.Kind {
+		t.add(Breaking, "", "kind changed from %s to %s", oldInfo.Kind, newInfo.Kind)
+		return
+	}
+	t.compareDef(valuerpc.ArgumentsPath, oldInfo.Args, newInfo.Args, true)
+	t.compareDef(valuerpc.ResultPath, oldInfo.Res, newInfo.Res, false)
+	t.compareDef(valuerpc.IncomingPath, oldInfo.In, newInfo.In, true)
+	if oldInfo.Description != newInfo.Description {
+		t.add(Compatible, "", "description changed")
+	}
+}
+
+func defName(def valuerpc.TypeDef) string {
+	switch def.(type) {
+	case valuerpc.AnyDef:
+		return valuerpc.AnyType
+	case valuerpc.VoidDef:
+		return valuerpc.VoidType
+	case valuerpc.ArgDef:
+		return valuerpc.ArgType
+	case valuerpc.ArgsDef:
+		return valuerpc.ListType
+	case valuerpc.ParamsDef:
+		return valuerpc.MapType
+	}
+	return "unknown"
+}
+
+// input is true for values sent by clients
+func (t *comparator) compareDef(path string, oldDef, newDef valuerpc.TypeDef, input bool) {
+	oldName, newName := defName(oldDef), defName(newDef)
+
+	if oldName != newName {
+		switch {
+		case input && newName == valuerpc.AnyType, !input && oldName == valuerpc.AnyType:
+			t.add(Compatible, path, "type changed from %s to %s", oldName, newName)
+		case input && oldName == valuerpc.VoidType && newName == valuerpc.MapType && !hasRequired(newDef.(valuerpc.ParamsDef)):
+			t.add(Compatible, path, "optional params added")
+		default:
+			t.add(Breaking, path, "type changed from %s to %s", oldName, newName)
+		}
+		return
+	}
+
+	switch o := oldDef.(type) {
+	case valuerpc.ArgDef:
+		n := newDef.(valuerpc.ArgDef)
+		t.compareKind(path, o.Kind, n.Kind, o.Required, n.Required, o.Constraints, n.Constraints, input)
+	case valuerpc.ArgsDef:
+		n := newDef.(valuerpc.ArgsDef)
+		if len(o.List) != len(n.List) {
+			t.add(Breaking, path, "number of args changed from %d to %d", len(o.List), len(n.List))
+			return
+		}
+		if input && o.Coerce && !n.Coerce {
+			t.add(Breaking, path, "coercion disabled")
+		}
+		for i := range o.List {
+			t.compareKind(fmt.Sprintf("%s[%d]", path, i), o.List[i].Kind, n.List[i].Kind, o.List[i].Required, n.List[i].Required, o.List[i].Constraints, n.List[i].Constraints, input)
+		}
+	case valuerpc.ParamsDef:
+		t.compareParams(path, o, newDef.(valuerpc.ParamsDef), input)
+	}
+}
+
+func hasRequired(def valuerpc.ParamsDef) bool {
+	for _, p := range def.Map {
+		if p.Required {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *comparator) compareParams(path string, o, n valuerpc.ParamsDef, input bool) {
+	if input {
+		if !o.Strict && n.Strict {
+			t.add(Breaking, path, "strict mode enabled, unknown params are rejected")
+		}
+		if o.Coerce && !n.Coerce {
+			t.add(Breaking, path, "coercion disabled")
+		}
+	}
+
+	newParams := make(map[string]valuerpc.ParamDef)
+	for _, p := range n.Map {
+		newParams[p.Name] = p
+	}
+	oldParams := make(map[string]valuerpc.ParamDef)
+	for _, p := range o.Map {
+		oldParams[p.Name] = p
+	}
+
+	for _, op := range o.Map {
+		paramPath := path + "." + op.Name
+		np, ok := newParams[op.Name]
+		if !ok {
+			switch {
+			case input && n.Strict:
+				t.add(Breaking, paramPath, "param removed, rejected by strict mode")
+			case !input && op.Required:
+				t.add(Breaking, paramPath, "required param removed")
+			default:
+				t.add(Compatible, paramPath, "param removed")
+			}
+			continue
+		}
+		t.compareKind(paramPath, op.Kind, np.Kind, op.Required, np.Required, op.Constraints, np.Constraints, input)
+		if input && !np.Required {
+			t.compareDefault(paramPath, op.Default, np.Default)
+		}
+	}
+
+	for _, np := range n.Map {
+		if _, ok := oldParams[np.Name]; ok {
+			continue
+		}
+		paramPath := path + "." + np.Name
+		if input && np.Required {
+			t.add(Breaking, paramPath, "required param added")
+		} else {
+			t.add(Compatible, paramPath, "param added")
+		}
+	}
+}
+
+// default is applied by the server to the missing param, so the change is visible to clients that omit it
+func (t *comparator) compareDefault(path string, oldDefault, newDefault value.Value) {
+	switch {
+	case oldDefault == nil && newDefault == nil:
+	case oldDefault == nil:
+		t.add(Warning, path, "default %s added", newDefault.String())
+	case newDefault == nil:
+		t.add(Warning, path, "default %s removed", oldDefault.String())
+	case !oldDefault.Equal(newDefault):
+		t.add(Warning, path, "default changed from %s to %s", oldDefault.String(), newDefault.String())
+	}
+}
+
+func (t *comparator) compareKind(path string, oldKind, newKind value.Kind, oldRequired, newRequired bool, oldConstraints, newConstraints []valuerpc.Constraint, input bool) {
+	if oldKind != newKind {
+		widened := (input && newKind == valuerpc.AnyKind) || (!input && oldKind == valuerpc.AnyKind)
+		if widened {
+			t.add(Compatible, path, "kind changed from %s to %s", valuerpc.KindName(oldKind), valuerpc.KindName(newKind))
+		} else {
+			t.add(Breaking, path, "kind changed from %s to %s", valuerpc.KindName(oldKind), valuerpc.KindName(newKind))
+		}
+	}
+	if oldRequired != newRequired {
+		switch {
+		case input && newRequired:
+			t.add(Breaking, path, "made required")
+		case !input && !newRequired:
+			t.add(Breaking, path, "made optional, may be missing in results")
+		default:
+			t.add(Compatible, path, "required changed to %v", newRequired)
+		}
+	}
+	t.compareConstraints(path, oldConstraints, newConstraints, input)
+}
+
+type bounds struct {
+	min, max         *float64
+	minLen, maxLen   *int
+	minSize, maxSize *int
+	pattern          string
+	validators       int
+}
+
+func boundsOf(constraints []valuerpc.Constraint) bounds {
+	var b bounds
+	for _, c := range constraints {
+		switch v := c.(type) {
+		case valuerpc.MinConstraint:
+			b.min = &v.Min
+		case valuerpc.MaxConstraint:
+			b.max = &v.Max
+		case valuerpc.MinLenConstraint:
+			b.minLen = &v.Min
+		case valuerpc.MaxLenConstraint:
+			b.maxLen = &v.Max
+		case valuerpc.MinSizeConstraint:
+			b.minSize = &v.Min
+		case valuerpc.MaxSizeConstraint:
+			b.maxSize = &v.Max
+		case valuerpc.PatternConstraint:
+			b.pattern = v.Pattern.String()
+		default:
+			// custom validators with or without function and constraints of other types, encoded as validators
+			b.validators++
+		}
+	}
+	return b
+}
+
+// tightened constraints on inputs reject values old clients send, clients do not verify results by constraints
+func (t *comparator) compareConstraints(path string, oldConstraints, newConstraints []valuerpc.Constraint, input bool) {
+	o, n := boundsOf(oldConstraints), boundsOf(newConstraints)
+
+	severity := func(tightened bool) Severity {
+		if tightened && input {
+			return Breaking
+		}
+		return Compatible
+	}
+
+	compareLower := func(name string, old, new *float64) {
+		switch {
+		case old == nil && new == nil:
+		case old == nil:
+			t.add(severity(true), path, "%s %v added", name, *new)
+		case new == nil:
+			t.add(severity(false), path, "%s %v removed", name, *old)
+		case *new > *old:
+			t.add(severity(true), path, "%s raised from %v to %v", name, *old, *new)
+		case *new < *old:
+			t.add(severity(false), path, "%s lowered from %v to %v", name, *old, *new)
+		}
+	}
+	compareUpper := func(name string, old, new *float64) {
+		switch {
+		case old == nil && new == nil:
+		case old == nil:
+			t.add(severity(true), path, "%s %v added", name, *new)
+		case new == nil:
+			t.add(severity(false), path, "%s %v removed", name, *old)
+		case *new < *old:
+			t.add(severity(true), path, "%s lowered from %v to %v", name, *old, *new)
+		case *new > *old:
+			t.add(severity(false), path, "%s raised from %v to %v", name, *old, *new)
+		}
+	}
+
+	compareLower("min", o.min, n.min)
+	compareUpper("max", o.max, n.max)
+	compareLower("min length", toFloat(o.minLen), toFloat(n.minLen))
+	compareUpper("max length", toFloat(o.maxLen), toFloat(n.maxLen))
+	compareLower("min size", toFloat(o.minSize), toFloat(n.minSize))
+	compareUpper("max size", toFloat(o.maxSize), toFloat(n.maxSize))
+
+	if o.pattern != n.pattern {
+		switch {
+		case o.pattern == "":
+			t.add(severity(true), path, "pattern '%s' added", n.pattern)
+		case n.pattern == "":
+			t.add(severity(false), path, "pattern '%s' removed", o.pattern)
+		default:
+			t.add(severity(true), path, "pattern changed from '%s' to '%s'", o.pattern, n.pattern)
+		}
+	}
+
+	if n.validators > o.validators {
+		t.add(severity(true), path, "custom validator added")
+	} else if n.validators < o.validators {
+		t.add(severity(false), path, "custom validator removed")
+	}
+}
+
+func toFloat(n *int) *float64 {
+	if n == nil {
+		return nil
+	}
+	f := float64(*n)
+	return &f
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueschema
+
+import (
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"testing"
+)
+
+func function(args, res valuerpc.TypeDef) valuerpc.FunctionInfo {
+	return valuerpc.FunctionInfo{Name: "f", Kind: valuerpc.SingleFunctionKind, Args: args, Res: res, In: valuerpc.Void}
+}
+
+func TestCompare(t *testing.T) {
+	name := valuerpc.Param("name", value.STRING, true)
+	age := valuerpc.Param("age", value.NUMBER, false)
+	lang := valuerpc.Param("lang", value.STRING, false)
+
+	tests := []struct {
+		name     string
+		old, new []valuerpc.FunctionInfo
+		expected []Change
+	}{
+		{"same",
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(name), valuerpc.String)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(name), valuerpc.String)},
+			nil},
+		{"function removed",
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.Void)},
+			nil,
+			[]Change{{Function: "f", Severity: Breaking}}},
+		{"function added",
+			nil,
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.Void)},
+			[]Change{{Function: "f", Severity: Compatible}}},
+		{"kind changed",
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.Void)},
+			[]valuerpc.FunctionInfo{{Name: "f", Kind: valuerpc.OutgoingStreamKind, Args: valuerpc.Void, Res: valuerpc.Void, In: valuerpc.Void}},
+			[]Change{{Function: "f", Severity: Breaking}}},
+		{"args widened to any",
+			[]valuerpc.FunctionInfo{function(valuerpc.String, valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Any, valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args", Severity: Compatible}}},
+		{"result widened to any",
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.String)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.Any)},
+			[]Change{{Function: "f", Path: "res", Severity: Breaking}}},
+		{"optional params instead of void",
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(age), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args", Severity: Compatible}}},
+		{"required param added",
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(age), valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(age, name), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args.name", Severity: Breaking}}},
+		{"optional param added",
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(name), valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(name, age), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args.age", Severity: Compatible}}},
+		{"param removed in strict mode",
+			[]valuerpc.FunctionInfo{function(valuerpc.StrictMap(name, age), valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.StrictMap(name), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args.age", Severity: Breaking}}},
+		{"strict mode enabled",
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(name), valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.StrictMap(name), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args", Severity: Breaking}}},
+		{"required result param removed",
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.Map(name))},
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.Map())},
+			[]Change{{Function: "f", Path: "res.name", Severity: Breaking}}},
+		{"result made optional",
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.String)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.StringOpt)},
+			[]Change{{Function: "f", Path: "res", Severity: Breaking}}},
+		{"arg made optional",
+			[]valuerpc.FunctionInfo{function(valuerpc.String, valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.StringOpt, valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args", Severity: Compatible}}},
+		{"number of args changed",
+			[]valuerpc.FunctionInfo{function(valuerpc.List(valuerpc.String), valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.List(valuerpc.String, valuerpc.Number), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args", Severity: Breaking}}},
+		{"arg kind changed",
+			[]valuerpc.FunctionInfo{function(valuerpc.List(valuerpc.String), valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.List(valuerpc.Number), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args[0]", Severity: Breaking}}},
+		{"input max lowered",
+			[]valuerpc.FunctionInfo{function(valuerpc.Number.With(valuerpc.Max(10)), valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Number.With(valuerpc.Max(5)), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args", Severity: Breaking}}},
+		{"input min lowered",
+			[]valuerpc.FunctionInfo{function(valuerpc.Number.With(valuerpc.Min(10)), valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Number.With(valuerpc.Min(5)), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args", Severity: Compatible}}},
+		{"result pattern added",
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.String)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.String.With(valuerpc.Pattern("^a")))},
+			[]Change{{Function: "f", Path: "res", Severity: Compatible}}},
+		{"input validator added",
+			[]valuerpc.FunctionInfo{function(valuerpc.String, valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.String.With(valuerpc.ValidatorConstraint{}), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args", Severity: Breaking}}},
+		{"same default",
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(lang.WithDefault(value.Utf8("en"))), valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(lang.WithDefault(value.Utf8("en"))), valuerpc.Void)},
+			nil},
+		{"default changed",
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(lang.WithDefault(value.Utf8("en"))), valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(lang.WithDefault(value.Utf8("de"))), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args.lang", Severity: Warning}}},
+		{"default added",
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(lang), valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(lang.WithDefault(value.Utf8("en"))), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args.lang", Severity: Warning}}},
+		{"default removed",
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(lang.WithDefault(value.Utf8("en"))), valuerpc.Void)},
+			[]valuerpc.FunctionInfo{function(valuerpc.Map(lang), valuerpc.Void)},
+			[]Change{{Function: "f", Path: "args.lang", Severity: Warning}}},
+		{"result default changed",
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.Map(lang.WithDefault(value.Utf8("en"))))},
+			[]valuerpc.FunctionInfo{function(valuerpc.Void, valuerpc.Map(lang.WithDefault(value.Utf8("de"))))},
+			nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := Compare(tt.old, tt.new)
+			if len(actual) != len(tt.expected) {
+				t.Fatalf("expected %v, actual %v", tt.expected, actual)
+			}
+			for i, c := range actual {
+				e := tt.expected[i]
+				if c.Function != e.Function || c.Path != e.Path || c.Severity != e.Severity {
+					t.Errorf("change %d expected %s %s %s, actual %v", i, e.Severity, e.Function, e.Path, c)
+				}
+			}
+			if HasBreaking(actual) != HasBreaking(tt.expected) {
+				t.Errorf("HasBreaking mismatch")
+			}
+		})
+	}
+}
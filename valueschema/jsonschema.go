@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valueserver"
+)
+
+/**
+Converts type definitions and function catalogs to JSON Schema (draft 2020-12).
+Keywords with prefix "x-vrpc-" keep information that JSON Schema can not express.
+*/
+
+type Schema map[string]interface{}
+
+var SchemaVersion = "https://json-schema.org/draft/2020-12/schema"
+
+var DefKeyword = "x-vrpc-def" // kind of TypeDef: any, void, arg, list, map
+var KindKeyword = "x-vrpc-kind"
+var TagsKeyword = "x-vrpc-tags"
+var CoerceKeyword = "x-vrpc-coerce"
+var MinSizeKeyword = "x-vrpc-minSize"
+var MaxSizeKeyword = "x-vrpc-maxSize"
+var ValidatorKeyword = "x-vrpc-validator"
+
+func TypeDefSchema(def valuerpc.TypeDef) Schema {
+	switch d := def.(type) {
+	case valuerpc.AnyDef:
+		return Schema{DefKeyword: valuerpc.AnyType}
+	case valuerpc.VoidDef:
+		return Schema{
+			DefKeyword: valuerpc.VoidType,
+			"anyOf": []interface{}{
+				Schema{"type": "null"},
+				Schema{"type": "array", "maxItems": 0},
+				Schema{"type": "object", "maxProperties": 0},
+			},
+		}
+	case valuerpc.ArgDef:
+		s := kindSchema(d.Kind, d.Required, d.Constraints)
+		s[DefKeyword] = valuerpc.ArgType
+		return s
+	case valuerpc.ArgsDef:
+		items := make([]interface{}, len(d.List))
+		for i, arg := range d.List {
+			items[i] = kindSchema(arg.Kind, arg.Required, arg.Constraints)
+		}
+		s := Schema{
+			DefKeyword:    valuerpc.ListType,
+			"type":        "array",
+			"prefixItems": items,
+			"items":       false,
+			"minItems":    len(d.List),
+			"maxItems":    len(d.List),
+		}
+		if d.Coerce {
+			s[CoerceKeyword] = true
+		}
+		return s
+	case valuerpc.ParamsDef:
+		properties := Schema{}
+		required := []string{}
+		for _, param := range d.Map {
+			p := kindSchema(param.Kind, param.Required, param.Constraints)
+			if param.Default != nil {
+				if data, err := valuerpc.ToJSON(param.Default); err == nil {
+					p["default"] = json.RawMessage(data)
+				} else {
+					// catalog stays valid JSON, the default is applied by server anyway
+					p["$comment"] = fmt.Sprintf("default %s is not representable in JSON, %v", param.Default.String(), err)
+				}
+			}
+			properties[param.Name] = p
+			if param.Required {
+				required = append(required, param.Name)
+			}
+		}
+		s := Schema{
+			DefKeyword:             valuerpc.MapType,
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": !d.Strict,
+		}
+		if d.Coerce {
+			s[CoerceKeyword] = true
+		}
+		return s
+	}
+	return Schema{DefKeyword: valuerpc.AnyType}
+}
+
+func jsonType(kind value.Kind) string {
+	switch kind {
+	case value.BOOL:
+		return "boolean"
+	case value.NUMBER:
+		return "number"
+	case value.STRING:
+		return "string"
+	case value.LIST:
+		return "array"
+	case value.MAP:
+		return "object"
+	}
+	return ""
+}
+
+func kindSchema(kind value.Kind, required bool, constraints []valuerpc.Constraint) Schema {
+	s := Schema{}
+	if t := jsonType(kind); t != "" {
+		if required {
+			s["type"] = t
+		} else {
+			s["type"] = []string{t, "null"}
+		}
+	}
+	for _, c := range constraints {
+		switch v := c.(type) {
+		case valuerpc.MinConstraint:
+			s["minimum"] = v.Min
+		case valuerpc.MaxConstraint:
+			s["maximum"] = v.Max
+		case valuerpc.MinLenConstraint:
+			s[lengthKeyword(kind, "min")] = v.Min
+		case valuerpc.MaxLenConstraint:
+			s[lengthKeyword(kind, "max")] = v.Max
+		case valuerpc.MinSizeConstraint:
+			s[MinSizeKeyword] = v.Min
+		case valuerpc.MaxSizeConstraint:
+			s[MaxSizeKeyword] = v.Max
+		case valuerpc.PatternConstraint:
+			s["pattern"] = v.Pattern.String()
+		default:
+			s[ValidatorKeyword] = true
+		}
+	}
+	return s
+}
+
+func lengthKeyword(kind value.Kind, prefix string) string {
+	switch kind {
+	case value.LIST:
+		return prefix + "Items"
+	case value.MAP:
+		return prefix + "Properties"
+	}
+	return prefix + "Length"
+}
+
+// function schema has properties args, res and in, see valuerpc.FunctionInfo
+func FunctionSchema(info valuerpc.FunctionInfo) Schema {
+	s := Schema{
+		KindKeyword: string(info.Kind),
+		"type":      "object",
+		"properties": Schema{
+			valuerpc.InfoArgsField:   TypeDefSchema(info.Args),
+			valuerpc.InfoResultField: TypeDefSchema(info.Res),
+			valuerpc.InfoInField:     TypeDefSchema(info.In),
+		},
+	}
+	if info.Description != "" {
+		s["description"] = info.Description
+	}
+	if len(info.Tags) > 0 {
+		s[TagsKeyword] = info.Tags
+	}
+	return s
+}
+
+// document with function schemas in $defs by function name
+func CatalogSchema(title string, list []valuerpc.FunctionInfo) Schema {
+	defs := Schema{}
+	for _, info := range list {
+		defs[info.Name] = FunctionSchema(info)
+	}
+	return Schema{
+		"$schema": SchemaVersion,
+		"title":   title,
+		"$defs":   defs,
+	}
+}
+
+func MarshalCatalog(title string, list []valuerpc.FunctionInfo) ([]byte, error) {
+	return json.MarshalIndent(CatalogSchema(title, list), "", "  ")
+}
+
+// schema of functions registered in the server of this process
+func ServerSchema(title string, srv valueserver.Server) Schema {
+	return CatalogSchema(title, srv.Functions())
+}
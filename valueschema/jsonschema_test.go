@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"math"
+	"strings"
+	"testing"
+)
+
+func compactJSON(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(s)); err != nil {
+		t.Fatalf("invalid expected JSON %s, %v", s, err)
+	}
+	return buf.String()
+}
+
+func TestTypeDefSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		def      valuerpc.TypeDef
+		expected string
+	}{
+		{"any", valuerpc.Any, `{"x-vrpc-def":"any"}`},
+		{"void", valuerpc.Void, `{"anyOf":[{"type":"null"},{"maxItems":0,"type":"array"},{"maxProperties":0,"type":"object"}],"x-vrpc-def":"void"}`},
+		{"required arg", valuerpc.String, `{"type":"string","x-vrpc-def":"arg"}`},
+		{"optional arg", valuerpc.NumberOpt, `{"type":["number","null"],"x-vrpc-def":"arg"}`},
+		{"constraints", valuerpc.Arg(value.NUMBER, true, valuerpc.Min(1), valuerpc.Max(10)),
+			`{"maximum":10,"minimum":1,"type":"number","x-vrpc-def":"arg"}`},
+		{"lengths", valuerpc.Arg(value.STRING, true, valuerpc.MinLen(2), valuerpc.Pattern("^a")),
+			`{"minLength":2,"pattern":"^a","type":"string","x-vrpc-def":"arg"}`},
+		{"items", valuerpc.Arg(value.LIST, true, valuerpc.MinLen(1)), `{"minItems":1,"type":"array","x-vrpc-def":"arg"}`},
+		{"size and validator", valuerpc.Arg(value.STRING, true, valuerpc.MinSize(4), valuerpc.Validator(func(value.Value) error { return nil })),
+			`{"type":"string","x-vrpc-def":"arg","x-vrpc-minSize":4,"x-vrpc-validator":true}`},
+		{"list", valuerpc.List(valuerpc.String, valuerpc.NumberOpt).WithCoercion(),
+			`{"items":false,"maxItems":2,"minItems":2,"prefixItems":[{"type":"string"},{"type":["number","null"]}],"type":"array","x-vrpc-coerce":true,"x-vrpc-def":"list"}`},
+		{"map", valuerpc.StrictMap(
+			valuerpc.Param("name", value.STRING, true),
+			valuerpc.Param("page", value.NUMBER, false).WithDefault(value.Long(1)),
+			valuerpc.Param("key", value.STRING, false).WithDefault(value.Raw([]byte{1, 2}, false)),
+		), `{"additionalProperties":false,"properties":{"key":{"default":"AQI=","type":["string","null"]},"name":{"type":"string"},"page":{"default":1,"type":["number","null"]}},"required":["name"],"type":"object","x-vrpc-def":"map"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := json.Marshal(TypeDefSchema(tt.def))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := compactJSON(t, tt.expected); string(actual) != expected {
+				t.Errorf("expected %s\nactual   %s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestDefaultNotRepresentable(t *testing.T) {
+	info := valuerpc.FunctionInfo{
+		Name: "scale",
+		Kind: valuerpc.SingleFunctionKind,
+		Args: valuerpc.Map(valuerpc.Param("factor", value.NUMBER, false).WithDefault(value.Double(math.NaN()))),
+		Res:  valuerpc.Number,
+		In:   valuerpc.Void,
+	}
+	data, err := MarshalCatalog("test", []valuerpc.FunctionInfo{info})
+	if err != nil {
+		t.Fatalf("catalog with NaN default is not marshaled, %v", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	factor := doc["$defs"].(map[string]interface{})["scale"].(map[string]interface{})["properties"].(map[string]interface{})[valuerpc.InfoArgsField].(map[string]interface{})["properties"].(map[string]interface{})["factor"].(map[string]interface{})
+	if _, ok := factor["default"]; ok {
+		t.Errorf("unexpected default %v", factor["default"])
+	}
+	if comment, _ := factor["$comment"].(string); !strings.Contains(comment, "not representable in JSON") {
+		t.Errorf("expected comment about default, actual %v", factor)
+	}
+}
+
+func TestCatalogSchema(t *testing.T) {
+	info := valuerpc.FunctionInfo{
+		Name:        "ticks",
+		Kind:        valuerpc.OutgoingStreamKind,
+		Args:        valuerpc.Void,
+		Res:         valuerpc.Number,
+		In:          valuerpc.Void,
+		Description: "counts",
+		Tags:        []string{"demo"},
+	}
+	s := CatalogSchema("clock", []valuerpc.FunctionInfo{info})
+	if s["$schema"] != SchemaVersion || s["title"] != "clock" {
+		t.Errorf("unexpected document %v", s)
+	}
+	fn, ok := s["$defs"].(Schema)["ticks"].(Schema)
+	if !ok {
+		t.Fatalf("function is not in $defs, %v", s)
+	}
+	if fn[KindKeyword] != string(valuerpc.OutgoingStreamKind) || fn["description"] != "counts" {
+		t.Errorf("unexpected function schema %v", fn)
+	}
+	if tags, _ := fn[TagsKeyword].([]string); len(tags) != 1 || tags[0] != "demo" {
+		t.Errorf("unexpected tags %v", fn[TagsKeyword])
+	}
+	props := fn["properties"].(Schema)
+	for _, field := range []string{valuerpc.InfoArgsField, valuerpc.InfoResultField, valuerpc.InfoInField} {
+		if _, ok := props[field]; !ok {
+			t.Errorf("property %s is missing", field)
+		}
+	}
+}
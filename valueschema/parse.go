@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/pkg/errors"
+	"regexp"
+	"sort"
+)
+
+/**
+Restores function catalog from the document produced by CatalogSchema.
+Custom validators can not be restored, they are kept as valuerpc.ValidatorConstraint without function,
+the same as in decoded introspection, so they always pass.
+*/
+
+func ParseCatalog(data []byte) ([]valuerpc.FunctionInfo, error) {
+	var doc map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.Errorf("parse schema, %v", err)
+	}
+	defs, _ := doc["$defs"].(map[string]interface{})
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list []valuerpc.FunctionInfo
+	for _, name := range names {
+		fs, ok := defs[name].(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("function '%s' schema expected object", name)
+		}
+		info, err := parseFunction(name, fs)
+		if err != nil {
+			return nil, errors.Errorf("function '%s', %v", name, err)
+		}
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+func parseFunction(name string, s map[string]interface{}) (valuerpc.FunctionInfo, error) {
+	info := valuerpc.FunctionInfo{Name: name}
+	info.Kind = valuerpc.FunctionKind(stringOf(s[KindKeyword]))
+	info.Description = stringOf(s["description"])
+	if tags, ok := s[TagsKeyword].([]interface{}); ok {
+		for _, tag := range tags {
+			info.Tags = append(info.Tags, stringOf(tag))
+		}
+	}
+	properties, _ := s["properties"].(map[string]interface{})
+	var err error
+	if info.Args, err = parseTypeDef(properties[valuerpc.InfoArgsField]); err != nil {
+		return info, errors.Errorf("args, %v", err)
+	}
+	if info.Res, err = parseTypeDef(properties[valuerpc.InfoResultField]); err != nil {
+		return info, errors.Errorf("res, %v", err)
+	}
+	if info.In, err = parseTypeDef(properties[valuerpc.InfoInField]); err != nil {
+		return info, errors.Errorf("in, %v", err)
+	}
+	return info, nil
+}
+
+func parseTypeDef(obj interface{}) (valuerpc.TypeDef, error) {
+	if obj == nil {
+		return valuerpc.Void, nil
+	}
+	s, ok := obj.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("type schema expected object")
+	}
+	switch stringOf(s[DefKeyword]) {
+	case valuerpc.AnyType:
+		return valuerpc.Any, nil
+	case valuerpc.VoidType:
+		return valuerpc.Void, nil
+	case valuerpc.ArgType:
+		kind, required, constraints, err := parseKind(s)
+		if err != nil {
+			return nil, err
+		}
+		return valuerpc.Arg(kind, required, constraints...), nil
+	case valuerpc.ListType:
+		def := valuerpc.ArgsDef{Coerce: s[CoerceKeyword] == true}
+		items, _ := s["prefixItems"].([]interface{})
+		for i, item := range items {
+			is, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, errors.Errorf("item %d expected object", i)
+			}
+			kind, required, constraints, err := parseKind(is)
+			if err != nil {
+				return nil, errors.Errorf("item %d, %v", i, err)
+			}
+			def.List = append(def.List, valuerpc.Arg(kind, required, constraints...))
+		}
+		return def, nil
+	case valuerpc.MapType:
+		def := valuerpc.ParamsDef{
+			Strict: s["additionalProperties"] == false,
+			Coerce: s[CoerceKeyword] == true,
+		}
+		properties, _ := s["properties"].(map[string]interface{})
+		// params of any kind have no type to be nullable, required array of the object is the source
+		requiredList, hasRequired := s["required"].([]interface{})
+		requiredNames := make(map[string]bool, len(requiredList))
+		for _, name := range requiredList {
+			requiredNames[stringOf(name)] = true
+		}
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ps, ok := properties[name].(map[string]interface{})
+			if !ok {
+				return nil, errors.Errorf("property '%s' expected object", name)
+			}
+			kind, required, constraints, err := parseKind(ps)
+			if err != nil {
+				return nil, errors.Errorf("property '%s', %v", name, err)
+			}
+			if hasRequired {
+				required = requiredNames[name]
+			}
+			param := valuerpc.Param(name, kind, required, constraints...)
+			if dv, ok := ps["default"]; ok {
+				if param.Default, err = valuerpc.FromNative(dv); err != nil {
+					return nil, errors.Errorf("property '%s' default, %v", name, err)
+				}
+			}
+			def.Map = append(def.Map, param)
+		}
+		return def, nil
+	}
+	return nil, errors.Errorf("unknown %s '%s'", DefKeyword, stringOf(s[DefKeyword]))
+}
+
+func parseKind(s map[string]interface{}) (value.Kind, bool, []valuerpc.Constraint, error) {
+	kind := valuerpc.AnyKind
+	required := true
+	var jsonTypes []string
+	switch t := s["type"].(type) {
+	case string:
+		jsonTypes = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			jsonTypes = append(jsonTypes, stringOf(item))
+		}
+	}
+	for _, t := range jsonTypes {
+		if t == "null" {
+			required = false
+			continue
+		}
+		k, ok := kindOfJsonType(t)
+		if !ok {
+			return 0, false, nil, errors.Errorf("unsupported type '%s'", t)
+		}
+		kind = k
+	}
+
+	var constraints []valuerpc.Constraint
+	if n, ok := numberOf(s["minimum"]); ok {
+		constraints = append(constraints, valuerpc.Min(n))
+	}
+	if n, ok := numberOf(s["maximum"]); ok {
+		constraints = append(constraints, valuerpc.Max(n))
+	}
+	for _, key := range []string{"minLength", "minItems", "minProperties"} {
+		if n, ok := numberOf(s[key]); ok {
+			constraints = append(constraints, valuerpc.MinLen(int(n)))
+		}
+	}
+	for _, key := range []string{"maxLength", "maxItems", "maxProperties"} {
+		if n, ok := numberOf(s[key]); ok {
+			constraints = append(constraints, valuerpc.MaxLen(int(n)))
+		}
+	}
+	if n, ok := numberOf(s[MinSizeKeyword]); ok {
+		constraints = append(constraints, valuerpc.MinSize(int(n)))
+	}
+	if n, ok := numberOf(s[MaxSizeKeyword]); ok {
+		constraints = append(constraints, valuerpc.MaxSize(int(n)))
+	}
+	if p, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return 0, false, nil, errors.Errorf("invalid pattern '%s', %v", p, err)
+		}
+		constraints = append(constraints, valuerpc.PatternConstraint{Pattern: re})
+	}
+	if s[ValidatorKeyword] == true {
+		constraints = append(constraints, valuerpc.ValidatorConstraint{})
+	}
+	return kind, required, constraints, nil
+}
+
+func kindOfJsonType(t string) (value.Kind, bool) {
+	for _, kind := range []value.Kind{value.BOOL, value.NUMBER, value.STRING, value.LIST, value.MAP} {
+		if jsonType(kind) == t {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+func numberOf(obj interface{}) (float64, bool) {
+	switch v := obj.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func stringOf(obj interface{}) string {
+	if s, ok := obj.(string); ok {
+		return s
+	}
+	return ""
+}
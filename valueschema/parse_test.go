@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueschema
+
+import (
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"testing"
+)
+
+func TestParseCatalogRoundTrip(t *testing.T) {
+	catalog := []valuerpc.FunctionInfo{
+		{Name: "chat", Kind: valuerpc.ChatKind, Args: valuerpc.Void, Res: valuerpc.String, In: valuerpc.String.With(valuerpc.MaxLen(100))},
+		{Name: "getUser", Kind: valuerpc.SingleFunctionKind,
+			// params are restored in the order of names
+			Args: valuerpc.StrictMap(
+				valuerpc.Param("fields", value.LIST, false, valuerpc.MaxSize(10)),
+				valuerpc.Param("id", value.NUMBER, true, valuerpc.Min(1)),
+				valuerpc.Param("lang", value.STRING, false, valuerpc.Pattern("^[a-z]{2}$")).WithDefault(value.Utf8("en")),
+			).WithCoercion(),
+			Res:         valuerpc.Map(valuerpc.Param("name", value.STRING, true, valuerpc.MinLen(1))),
+			In:          valuerpc.Void,
+			Description: "finds user by id",
+			Tags:        []string{"users"}},
+		{Name: "scan", Kind: valuerpc.OutgoingStreamKind, Args: valuerpc.List(valuerpc.String, valuerpc.NumberOpt.With(valuerpc.Max(5))).WithCoercion(), Res: valuerpc.Any, In: valuerpc.Void},
+		{Name: "upload", Kind: valuerpc.IncomingStreamKind, Args: valuerpc.Any, Res: valuerpc.Void, In: valuerpc.Arg(value.MAP, true, valuerpc.Validator(func(value.Value) error { return nil }))},
+	}
+
+	data, err := MarshalCatalog("test", catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseCatalog(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(catalog) {
+		t.Fatalf("expected %d functions, actual %d", len(catalog), len(parsed))
+	}
+	for i, expected := range catalog {
+		actual := parsed[i]
+		t.Run(expected.Name, func(t *testing.T) {
+			if actual.Name != expected.Name || actual.Kind != expected.Kind || actual.Description != expected.Description {
+				t.Errorf("expected %s %s %q, actual %s %s %q", expected.Name, expected.Kind, expected.Description, actual.Name, actual.Kind, actual.Description)
+			}
+			if len(actual.Tags) != len(expected.Tags) {
+				t.Errorf("expected tags %v, actual %v", expected.Tags, actual.Tags)
+			}
+			for _, pair := range [][2]valuerpc.TypeDef{{expected.Args, actual.Args}, {expected.Res, actual.Res}, {expected.In, actual.In}} {
+				e, a := valuerpc.EncodeTypeDef(pair[0]), valuerpc.EncodeTypeDef(pair[1])
+				if !e.Equal(a) {
+					t.Errorf("expected %v, actual %v", e, a)
+				}
+			}
+		})
+	}
+	if changes := Compare(catalog, parsed); len(changes) != 0 {
+		t.Errorf("parsed catalog differs, %v", changes)
+	}
+}
+
+func TestParseCatalogErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not json", `{`},
+		{"function is not object", `{"$defs":{"f":1}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCatalog([]byte(tt.data)); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+	list, err := ParseCatalog([]byte(`{}`))
+	if err != nil || len(list) != 0 {
+		t.Errorf("empty catalog, %v %v", list, err)
+	}
+}
@@ -6,30 +6,102 @@
 package valueserver
 
 import (
+	"context"
 	"github.com/codeallergy/value"
 	"github.com/codeallergy/value-rpc/valuerpc"
+	"net"
+	"net/http"
 )
 
 
-type Function func(args value.Value) (value.Value, error)
-type OutgoingStream func(args value.Value) (<-chan value.Value, error)
-type IncomingStream func(args value.Value, inC <-chan value.Value) error
-type Chat func(args value.Value, inC <-chan value.Value) (<-chan value.Value, error)
+// handlers and registry are declared in valuerpc to keep the server out of client binaries
+type Function = valuerpc.Function
+type OutgoingStream = valuerpc.OutgoingStream
+type IncomingStream = valuerpc.IncomingStream
+type Chat = valuerpc.Chat
+
+type ContextFunction = valuerpc.ContextFunction
+type ContextOutgoingStream = valuerpc.ContextOutgoingStream
+type ContextIncomingStream = valuerpc.ContextIncomingStream
+type ContextChat = valuerpc.ContextChat
+
+// runs before handler of every vRPC request, reads metadata by valuerpc.IncomingMetadata and writes trailers by valuerpc.SetTrailer,
+// returned context is passed to the handler, error rejects the request with valuerpc.RequestRejected
+type Interceptor func(ctx context.Context, name string, kind valuerpc.FunctionKind) (context.Context, error)
+
+// rejects client on handshake by returning error, token is empty if client did not send it
+type Authenticator func(clientId int64, token string) error
+
+// functions registered on the connected client, done ctx cancels the call or open stream
+type ClientCaller interface {
+	ClientId() int64
+
+	IsConnected() bool
+
+	// functions advertised by client in the last handshake, call valuerpc.IntrospectFunction for the current catalog
+	Functions() []valuerpc.FunctionInfo
+
+	CallFunction(ctx context.Context, name string, args value.Value) (value.Value, error)
+
+	// client is throttled when more than a third of receiveCap values are waiting for the reader
+	GetStream(ctx context.Context, name string, args value.Value, receiveCap int) (<-chan value.Value, error)
+
+	PutStream(ctx context.Context, name string, args value.Value, putCh <-chan value.Value) error
+
+	Chat(ctx context.Context, name string, args value.Value, receiveCap int, putCh <-chan value.Value) (<-chan value.Value, error)
+}
+
+type Registry = valuerpc.Registry
 
 type Server interface {
-	AddFunction(name string, args valuerpc.TypeDef, res valuerpc.TypeDef, cb Function) error
+	Registry
 
-	// GET for client
-	AddOutgoingStream(name string, args valuerpc.TypeDef, cb OutgoingStream) error
+	// vRPC messages over WebSocket binary frames, mount it to share the port with other handlers
+	WebSocketHandler() http.Handler
 
-	// PUT for client
-	AddIncomingStream(name string, args valuerpc.TypeDef, cb IncomingStream) error
+	// JSON-RPC 2.0 endpoint over HTTP POST, serves only functions
+	JSONRPCHandler() http.Handler
 
-	// Dual channel chat
-	AddChat(name string, args valuerpc.TypeDef, cb Chat) error
+	// JSON-RPC 2.0 over TCP, newline delimited messages, returns when listener or server is closed
+	ServeJSONRPC(lis net.Listener) error
 
+	// checks auth token of new connections, nil disables authentication
+	SetAuthenticator(Authenticator)
+
+	// interceptors run in order of adding
+	AddInterceptor(Interceptor)
+
+	// records traffic of new connections, nil stops recording
+	SetRecorder(rec *valuerpc.Recorder)
+
+	// creates server spans of requests, nil restores valuerpc.NoopTracer
+	SetTracer(tracer valuerpc.Tracer)
+
+	// Prometheus metrics of the server, applications could register own metrics, mount Handler() on /metrics
+	Metrics() *valuerpc.Metrics
+
+	// client known by handshake, reverse calls fail with ErrClientNotConnected until it reconnects
+	Client(clientId int64) (ClientCaller, bool)
+
+	// ids of clients with active connections
+	ClientIds() []int64
+
+	// address of the primary listener or nil
+	Addr() net.Addr
+
+	// serves the primary listener until Close
 	Run() error
 
+	// serves additional listener until Close or listener is closed
+	Serve(lis net.Listener) error
+
+	// serves single connection, returns when connection is closed, ErrServerClosed after Close
+	ServeConn(conn net.Conn) error
+
+	// serves requests of the peer without handshake until conn is closed, clients use it to serve reverse calls
+	ServeMsgConn(clientId int64, conn valuerpc.MsgConn) error
+
+	// closes listeners and served connections, waits for them up to CloseTimeout
 	Close() error
 }
 
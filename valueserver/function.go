@@ -6,12 +6,16 @@
 package valueserver
 
 import (
+	"context"
 	"errors"
+	"github.com/codeallergy/value"
 	vrpc "github.com/codeallergy/value-rpc/valuerpc"
 )
 
 
 var ErrFunctionAlreadyExist = errors.New("function already exist")
+var ErrFunctionNotFound = errors.New("function not found")
+var ErrReservedFunction = errors.New("function name is reserved")
 
 type functionType int
 
@@ -22,15 +26,43 @@ const (
 	chat
 )
 
+func (t functionType) Kind() vrpc.FunctionKind {
+	switch t {
+	case outgoingStream:
+		return vrpc.OutgoingStreamKind
+	case incomingStream:
+		return vrpc.IncomingStreamKind
+	case chat:
+		return vrpc.ChatKind
+	default:
+		return vrpc.SingleFunctionKind
+	}
+}
+
 type function struct {
-	name      string
-	args      vrpc.TypeDef
-	res       vrpc.TypeDef
-	ft        functionType
-	singleFn  Function
-	outStream OutgoingStream
-	inStream  IncomingStream
-	chat      Chat
+	name        string
+	args        vrpc.TypeDef
+	res         vrpc.TypeDef // result of function or type of outgoing stream values
+	in          vrpc.TypeDef // type of incoming stream values
+	ft          functionType
+	singleFn    ContextFunction
+	outStream   ContextOutgoingStream
+	inStream    ContextIncomingStream
+	chat        ContextChat
+	description string
+	tags        []string
+}
+
+func (t *function) info() vrpc.FunctionInfo {
+	return vrpc.FunctionInfo{
+		Name:        t.name,
+		Kind:        t.ft.Kind(),
+		Args:        t.args,
+		Res:         t.res,
+		In:          t.in,
+		Description: t.description,
+		Tags:        t.tags,
+	}
 }
 
 func (t *rpcServer) hasFunction(name string) bool {
@@ -40,72 +72,157 @@ func (t *rpcServer) hasFunction(name string) bool {
 	return false
 }
 
-func (t *rpcServer) AddFunction(name string, args vrpc.TypeDef, res vrpc.TypeDef, cb Function) error {
-	if t.hasFunction(name) {
+func (t *rpcServer) addFunction(fn *function) error {
+	t.watchLock.Lock()
+	defer t.watchLock.Unlock()
+
+	if _, loaded := t.functionMap.LoadOrStore(fn.name, fn); loaded {
 		return ErrFunctionAlreadyExist
 	}
+	t.notifyWatchers(vrpc.AddEvent, fn)
+	return nil
+}
+
+func (t *rpcServer) AddContextFunction(name string, args vrpc.TypeDef, res vrpc.TypeDef, cb ContextFunction) error {
+	if vrpc.IsReserved(name) {
+		return ErrReservedFunction
+	}
 
 	fn := &function{
 		name:     name,
 		args:     args,
 		res:      res,
+		in:       vrpc.Void,
 		ft:       singleFunction,
 		singleFn: cb,
 	}
 
-	t.functionMap.Store(name, fn)
-	return nil
+	return t.addFunction(fn)
 }
 
 // GET for client
-func (t *rpcServer) AddOutgoingStream(name string, args vrpc.TypeDef, cb OutgoingStream) error {
-	if t.hasFunction(name) {
-		return ErrFunctionAlreadyExist
+func (t *rpcServer) AddContextOutgoingStream(name string, args vrpc.TypeDef, out vrpc.TypeDef, cb ContextOutgoingStream) error {
+	if vrpc.IsReserved(name) {
+		return ErrReservedFunction
 	}
 
 	fn := &function{
 		name:      name,
 		args:      args,
-		res:       vrpc.Void,
+		res:       out,
+		in:        vrpc.Void,
 		ft:        outgoingStream,
 		outStream: cb,
 	}
 
-	t.functionMap.Store(name, fn)
-	return nil
+	return t.addFunction(fn)
 }
 
 // PUT for client
-func (t *rpcServer) AddIncomingStream(name string, args vrpc.TypeDef, cb IncomingStream) error {
-	if t.hasFunction(name) {
-		return ErrFunctionAlreadyExist
+func (t *rpcServer) AddContextIncomingStream(name string, args vrpc.TypeDef, in vrpc.TypeDef, cb ContextIncomingStream) error {
+	if vrpc.IsReserved(name) {
+		return ErrReservedFunction
 	}
 
 	fn := &function{
 		name:     name,
 		args:     args,
 		res:      vrpc.Void,
+		in:       in,
 		ft:       incomingStream,
 		inStream: cb,
 	}
 
-	t.functionMap.Store(name, fn)
-	return nil
+	return t.addFunction(fn)
 }
 
-func (t *rpcServer) AddChat(name string, args vrpc.TypeDef, cb Chat) error {
-	if t.hasFunction(name) {
-		return ErrFunctionAlreadyExist
+func (t *rpcServer) AddContextChat(name string, args vrpc.TypeDef, in vrpc.TypeDef, out vrpc.TypeDef, cb ContextChat) error {
+	if vrpc.IsReserved(name) {
+		return ErrReservedFunction
 	}
 
 	fn := &function{
 		name: name,
 		args: args,
-		res:  vrpc.Void,
+		res:  out,
+		in:   in,
 		ft:   chat,
 		chat: cb,
 	}
 
-	t.functionMap.Store(name, fn)
+	return t.addFunction(fn)
+}
+
+func (t *rpcServer) AddFunction(name string, args vrpc.TypeDef, res vrpc.TypeDef, cb Function) error {
+	return t.AddContextFunction(name, args, res, func(ctx context.Context, args value.Value) (value.Value, error) {
+		return cb(args)
+	})
+}
+
+// values of streams are not verified
+func (t *rpcServer) AddOutgoingStream(name string, args vrpc.TypeDef, cb OutgoingStream) error {
+	return t.AddOutgoingStreamOf(name, args, vrpc.Any, cb)
+}
+
+func (t *rpcServer) AddIncomingStream(name string, args vrpc.TypeDef, cb IncomingStream) error {
+	return t.AddIncomingStreamOf(name, args, vrpc.Any, cb)
+}
+
+func (t *rpcServer) AddChat(name string, args vrpc.TypeDef, cb Chat) error {
+	return t.AddChatOf(name, args, vrpc.Any, vrpc.Any, cb)
+}
+
+func (t *rpcServer) AddOutgoingStreamOf(name string, args vrpc.TypeDef, out vrpc.TypeDef, cb OutgoingStream) error {
+	return t.AddContextOutgoingStream(name, args, out, func(ctx context.Context, args value.Value) (<-chan value.Value, error) {
+		return cb(args)
+	})
+}
+
+func (t *rpcServer) AddIncomingStreamOf(name string, args vrpc.TypeDef, in vrpc.TypeDef, cb IncomingStream) error {
+	return t.AddContextIncomingStream(name, args, in, func(ctx context.Context, args value.Value, inC <-chan value.Value) error {
+		return cb(args, inC)
+	})
+}
+
+func (t *rpcServer) AddChatOf(name string, args vrpc.TypeDef, in vrpc.TypeDef, out vrpc.TypeDef, cb Chat) error {
+	return t.AddContextChat(name, args, in, out, func(ctx context.Context, args value.Value, inC <-chan value.Value) (<-chan value.Value, error) {
+		return cb(args, inC)
+	})
+}
+
+func (t *rpcServer) RemoveFunction(name string) error {
+	if vrpc.IsReserved(name) {
+		return ErrReservedFunction
+	}
+
+	t.watchLock.Lock()
+	defer t.watchLock.Unlock()
+
+	fn, ok := t.functionMap.LoadAndDelete(name)
+	if !ok {
+		return ErrFunctionNotFound
+	}
+
+	t.notifyWatchers(vrpc.RemoveEvent, fn.(*function))
+	return nil
+}
+
+func (t *rpcServer) DescribeFunction(name string, description string, tags ...string) error {
+	// serializes changes of functions, so concurrent remove is not reverted
+	t.watchLock.Lock()
+	defer t.watchLock.Unlock()
+
+	fn, ok := t.functionMap.Load(name)
+	if !ok {
+		return ErrFunctionNotFound
+	}
+
+	// copy on write, serving clients read functions without locks
+	described := *fn.(*function)
+	described.description = description
+	described.tags = tags
+
+	t.functionMap.Store(name, &described)
+	t.notifyWatchers(vrpc.UpdateEvent, &described)
 	return nil
 }
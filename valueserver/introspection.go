@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"sort"
+)
+
+var WatchQueueCap = 1024
+
+func (t *rpcServer) registerIntrospection() {
+
+	t.addFunction(&function{
+		name:        vrpc.IntrospectFunction,
+		args:        vrpc.Void,
+		res:         vrpc.Any,
+		in:          vrpc.Void,
+		ft:          singleFunction,
+		singleFn:    t.listFunctions,
+		description: "list of registered functions with type definitions",
+	})
+
+	t.addFunction(&function{
+		name:        vrpc.WatchStream,
+		args:        vrpc.Void,
+		res:         vrpc.Any,
+		in:          vrpc.Void,
+		ft:          outgoingStream,
+		outStream:   t.watchFunctions,
+		description: "current catalog followed by add, remove and update events of functions",
+	})
+
+}
+
+func (t *rpcServer) catalog() []*function {
+	var list []*function
+	t.functionMap.Range(func(key, fn interface{}) bool {
+		list = append(list, fn.(*function))
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].name < list[j].name
+	})
+	return list
+}
+
+func (t *rpcServer) Functions() []vrpc.FunctionInfo {
+	var list []vrpc.FunctionInfo
+	for _, fn := range t.catalog() {
+		list = append(list, fn.info())
+	}
+	return list
+}
+
+func (t *rpcServer) listFunctions(ctx context.Context, args value.Value) (value.Value, error) {
+	list := value.EmptyList()
+	for _, fn := range t.catalog() {
+		list = list.Append(fn.info().ToValue())
+	}
+	return list, nil
+}
+
+func (t *rpcServer) watchFunctions(ctx context.Context, args value.Value) (<-chan value.Value, error) {
+	id := t.watcherSeq.Inc()
+
+	t.watchLock.Lock()
+	list := t.catalog()
+	outC := make(chan value.Value, len(list)+WatchQueueCap)
+	for _, fn := range list {
+		outC <- vrpc.NewFunctionEvent(vrpc.AddEvent, fn.info())
+	}
+	t.watchers.Store(id, outC)
+	t.watchLock.Unlock()
+
+	// ctx is done when the stream is canceled or the client disconnects
+	go func() {
+		<-ctx.Done()
+		t.removeWatcher(id)
+	}()
+
+	return outC, nil
+}
+
+func (t *rpcServer) removeWatcher(id int64) {
+	t.watchLock.Lock()
+	defer t.watchLock.Unlock()
+
+	if ch, ok := t.watchers.LoadAndDelete(id); ok {
+		close(ch.(chan value.Value))
+	}
+}
+
+// caller holds watchLock, so events are sent in order of changes,
+// watcher that does not read events anymore is removed when its queue is full
+func (t *rpcServer) notifyWatchers(op string, fn *function) {
+	event := vrpc.NewFunctionEvent(op, fn.info())
+
+	t.watchers.Range(func(key, ch interface{}) bool {
+		outC := ch.(chan value.Value)
+		select {
+		case outC <- event:
+		default:
+			t.watchers.Delete(key)
+			close(outC)
+		}
+		return true
+	})
+}
+
+func (t *rpcServer) closeWatchers() {
+	t.watchLock.Lock()
+	defer t.watchLock.Unlock()
+
+	t.watchers.Range(func(key, ch interface{}) bool {
+		t.watchers.Delete(key)
+		close(ch.(chan value.Value))
+		return true
+	})
+}
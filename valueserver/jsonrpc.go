@@ -0,0 +1,308 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/codeallergy/value"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+/**
+JSON-RPC 2.0 compatibility endpoint, serves only single functions.
+Params are converted by valuerpc.ParseJSON and verified the same way as vRPC function requests.
+Calls pass interceptors with metadata of "md" member and HTTP headers with MetadataHeaderPrefix,
+trailers of handler are returned in "trl" member of the response.
+*/
+
+var MaxJSONRPCBodySize = int64(16 << 20)
+
+var nullResult = json.RawMessage("null")
+
+// W3C trace context header of HTTP requests
+var TraceParentHeader = "traceparent"
+
+// HTTP headers with the prefix are metadata of the calls in request, without the prefix
+var MetadataHeaderPrefix = "Vrpc-Md-"
+
+func headerMetadata(h http.Header) vrpc.Metadata {
+	var md vrpc.Metadata
+	for k, v := range h {
+		if len(v) > 0 && len(k) > len(MetadataHeaderPrefix) && strings.EqualFold(k[:len(MetadataHeaderPrefix)], MetadataHeaderPrefix) {
+			if md == nil {
+				md = make(vrpc.Metadata)
+			}
+			md.Set(k[len(MetadataHeaderPrefix):], v[0])
+		}
+	}
+	return md
+}
+
+func (t *rpcServer) JSONRPCHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, MaxJSONRPCBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		if tp := r.Header.Get(TraceParentHeader); tp != "" {
+			if sc, err := vrpc.ParseTraceParent(tp); err == nil {
+				ctx = vrpc.ContextWithRemoteSpanContext(ctx, sc)
+			}
+		}
+		out := t.serveJSONRPC(ctx, headerMetadata(r.Header), body)
+		if out == nil {
+			// notifications only
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+	})
+}
+
+// serves newline delimited JSON-RPC messages on each accepted connection until listener is closed,
+// Close of server closes the listener and open connections
+func (t *rpcServer) ServeJSONRPC(lis net.Listener) error {
+
+	t.listeners.Store(lis, true)
+	defer t.listeners.Delete(lis)
+
+	if t.isShutdown() {
+		return nil
+	}
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if t.isShutdown() || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if !t.addConn(conn) {
+			conn.Close()
+			return nil
+		}
+		go t.handleJSONRPCConnection(conn)
+	}
+}
+
+func (t *rpcServer) handleJSONRPCConnection(conn net.Conn) {
+	defer t.removeConn(conn)
+	defer conn.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if err != io.EOF {
+				// stream can not be synchronized after syntax error
+				t.writeJSONRPC(conn, parseErrorResponse(err))
+			}
+			return
+		}
+		if out := t.serveJSONRPC(context.Background(), nil, raw); out != nil {
+			if _, err := conn.Write(append(out, '\n')); err != nil {
+				t.logger.Debug("write JSON-RPC response", zap.Error(err))
+				return
+			}
+		}
+	}
+}
+
+func (t *rpcServer) writeJSONRPC(w io.Writer, resp interface{}) {
+	out, err := json.Marshal(resp)
+	if err != nil {
+		t.logger.Error("marshal JSON-RPC response", zap.Error(err))
+		return
+	}
+	w.Write(append(out, '\n'))
+}
+
+func parseErrorResponse(err error) *vrpc.JSONRPCResponse {
+	return &vrpc.JSONRPCResponse{
+		Version: vrpc.JSONRPCVersion,
+		Error:   &vrpc.JSONRPCError{Code: vrpc.JSONRPCParseError, Message: fmt.Sprintf("parse error, %v", err)},
+	}
+}
+
+// returns nil if there is nothing to respond, md is metadata of all calls in data
+func (t *rpcServer) serveJSONRPC(ctx context.Context, md vrpc.Metadata, data []byte) []byte {
+	data = bytes.TrimSpace(data)
+
+	var resp interface{}
+	if len(data) > 0 && data[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(data, &batch); err != nil {
+			resp = parseErrorResponse(err)
+		} else if len(batch) == 0 {
+			resp = invalidRequestResponse(nil, "empty batch")
+		} else {
+			var list []*vrpc.JSONRPCResponse
+			for _, item := range batch {
+				if r := t.callJSONRPC(ctx, md, item); r != nil {
+					list = append(list, r)
+				}
+			}
+			if len(list) == 0 {
+				return nil
+			}
+			resp = list
+		}
+	} else {
+		r := t.callJSONRPC(ctx, md, data)
+		if r == nil {
+			return nil
+		}
+		resp = r
+	}
+
+	out, err := json.Marshal(resp)
+	if err != nil {
+		t.logger.Error("marshal JSON-RPC response", zap.Error(err))
+		out, _ = json.Marshal(&vrpc.JSONRPCResponse{
+			Version: vrpc.JSONRPCVersion,
+			Error:   &vrpc.JSONRPCError{Code: vrpc.JSONRPCInternalError, Message: err.Error()},
+		})
+	}
+	return out
+}
+
+func invalidRequestResponse(id json.RawMessage, message string) *vrpc.JSONRPCResponse {
+	return &vrpc.JSONRPCResponse{
+		Version: vrpc.JSONRPCVersion,
+		Error:   &vrpc.JSONRPCError{Code: vrpc.JSONRPCInvalidRequest, Message: message},
+		Id:      id,
+	}
+}
+
+func (t *rpcServer) callJSONRPC(ctx context.Context, md vrpc.Metadata, data []byte) *vrpc.JSONRPCResponse {
+	var req vrpc.JSONRPCRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return parseErrorResponse(err)
+		}
+		return invalidRequestResponse(nil, fmt.Sprintf("invalid request, %v", err))
+	}
+	if req.Version != vrpc.JSONRPCVersion || req.Method == "" {
+		return invalidRequestResponse(req.Id, "invalid request, expected jsonrpc 2.0 and method")
+	}
+
+	if len(req.Metadata) > 0 {
+		merged := make(vrpc.Metadata, len(md)+len(req.Metadata))
+		merged.Merge(md)
+		for k, v := range req.Metadata {
+			merged.Set(k, v)
+		}
+		md = merged
+	}
+
+	res, trailer, rpcErr := t.callJSONRPCFunction(ctx, md, req.Method, req.Params)
+	if req.IsNotification() {
+		if rpcErr != nil {
+			t.logger.Debug("JSON-RPC notification", zap.String("method", req.Method), zap.String("err", rpcErr.Message))
+		}
+		return nil
+	}
+
+	resp := &vrpc.JSONRPCResponse{
+		Version: vrpc.JSONRPCVersion,
+		Id:      req.Id,
+		Trailer: trailer,
+	}
+	if rpcErr != nil {
+		resp.Error = rpcErr
+		return resp
+	}
+
+	resp.Result = nullResult
+	if res != nil {
+		out, err := vrpc.ToJSON(res)
+		if err != nil {
+			resp.Error = vrpc.NewJSONRPCError(vrpc.InvalidResult, fmt.Sprintf("function '%s' result to json, %v", req.Method, err), nil)
+			return resp
+		}
+		resp.Result = out
+	}
+	return resp
+}
+
+// returns trailers set by interceptors and handler, even on error
+func (t *rpcServer) callJSONRPCFunction(ctx context.Context, md vrpc.Metadata, name string, params json.RawMessage) (value.Value, vrpc.Metadata, *vrpc.JSONRPCError) {
+
+	fn, ok := t.functionMap.Load(name)
+	if !ok {
+		return nil, nil, vrpc.NewJSONRPCError(vrpc.FunctionNotFound, fmt.Sprintf("function not found %s", name), nil)
+	}
+	f := fn.(*function)
+
+	if f.ft != singleFunction {
+		return nil, nil, vrpc.NewJSONRPCError(vrpc.WrongFunctionType, fmt.Sprintf("function '%s' is %s, only functions are served over JSON-RPC", name, f.ft.Kind()), nil)
+	}
+
+	var args value.Value
+	if len(params) > 0 {
+		var err error
+		if args, err = vrpc.ParseJSON(params); err != nil {
+			return nil, nil, vrpc.NewJSONRPCError(vrpc.BadRequest, err.Error(), nil)
+		}
+	}
+
+	// params are always structured in JSON-RPC, single arg is passed by position
+	if _, single := f.args.(vrpc.ArgDef); single && args != nil && args.Kind() == value.LIST {
+		if list := args.(value.List); list.Len() == 1 {
+			args = list.GetAt(0)
+		}
+	}
+
+	args, violations := vrpc.Prepare(args, f.args)
+	if len(violations) > 0 {
+		return nil, nil, vrpc.NewJSONRPCError(vrpc.InvalidArgs, fmt.Sprintf("function '%s' invalid args, %s", name, vrpc.JoinViolations(violations)), vrpc.ToNative(vrpc.ViolationsToValue(violations)))
+	}
+
+	ctx, span := t.tracer.Get().Start(ctx, name, vrpc.SpanKindServer,
+		vrpc.Attribute(vrpc.RPCSystemAttr, "jsonrpc"),
+		vrpc.Attribute(vrpc.RPCMethodAttr, name))
+	defer span.End()
+
+	ctx = vrpc.ContextWithIncomingMetadata(ctx, md)
+	ctx, trailer := vrpc.ContextWithTrailer(ctx)
+
+	ctx, err := t.interceptors.run(ctx, name, f.ft.Kind())
+	if err != nil {
+		span.SetError(err)
+		return nil, trailer.Metadata(), vrpc.NewJSONRPCError(vrpc.RequestRejected, fmt.Sprintf("function '%s' rejected, %v", name, err), nil)
+	}
+
+	res, err := f.singleFn(ctx, args)
+	if err != nil {
+		span.SetError(err)
+		return nil, trailer.Metadata(), vrpc.NewJSONRPCError(vrpc.FunctionFailed, fmt.Sprintf("single function %s call, %v", name, err), nil)
+	}
+
+	if violations := vrpc.VerifyAt(vrpc.ResultPath, res, f.res); len(violations) > 0 {
+		return nil, trailer.Metadata(), vrpc.NewJSONRPCError(vrpc.InvalidResult, fmt.Sprintf("function '%s' invalid results, %s", name, vrpc.JoinViolations(violations)), vrpc.ToNative(vrpc.ViolationsToValue(violations)))
+	}
+
+	return res, trailer.Metadata(), nil
+}
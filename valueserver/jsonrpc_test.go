@@ -0,0 +1,281 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/codeallergy/value"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startJSONRPC(t *testing.T) (*valuetest.Harness, *httptest.Server) {
+	h := valuetest.Start(t)
+	h.HandleFunction("add", vrpc.List(vrpc.Number, vrpc.Number), vrpc.Number, func(args value.Value) (value.Value, error) {
+		list := args.(value.List)
+		return value.Long(list.GetNumberAt(0).Long() + list.GetNumberAt(1).Long()), nil
+	})
+	h.HandleFunction("echo", vrpc.String, vrpc.String, func(args value.Value) (value.Value, error) {
+		return args, nil
+	})
+	h.FakeFunction("fail", vrpc.Any, vrpc.Any, nil, errors.New("broken"))
+	h.FakeFunction("bad", vrpc.Any, vrpc.Number, value.Utf8("x"), nil)
+	h.ScriptStream("src", vrpc.Any, vrpc.Number)
+	if err := h.Server.AddContextFunction("whoami", vrpc.Void, vrpc.String, func(ctx context.Context, args value.Value) (value.Value, error) {
+		vrpc.SetTrailer(ctx, "served", "yes")
+		return value.Utf8(vrpc.IncomingMetadata(ctx).Get("user")), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h.Server.JSONRPCHandler())
+	t.Cleanup(srv.Close)
+	return h, srv
+}
+
+func postJSONRPC(t *testing.T, srv *httptest.Server, header http.Header, body string) (int, string) {
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func decodeJSONRPC(t *testing.T, body string) vrpc.JSONRPCResponse {
+	var resp vrpc.JSONRPCResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("response %q, %v", body, err)
+	}
+	if resp.Version != vrpc.JSONRPCVersion {
+		t.Errorf("expected version %s, actual %q", vrpc.JSONRPCVersion, resp.Version)
+	}
+	return resp
+}
+
+// vRPC code is kept in data of the error
+func dataCode(e *vrpc.JSONRPCError) vrpc.ErrorCode {
+	data, _ := e.Data.(map[string]interface{})
+	code, _ := data[vrpc.JSONRPCCodeField].(float64)
+	return vrpc.ErrorCode(code)
+}
+
+func TestJSONRPC(t *testing.T) {
+	_, srv := startJSONRPC(t)
+
+	tests := []struct {
+		name     string
+		body     string
+		result   string
+		code     int
+		dataCode vrpc.ErrorCode
+	}{
+		{"result", `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":1}`, "3", 0, 0},
+		{"single arg by position", `{"jsonrpc":"2.0","method":"echo","params":["hi"],"id":1}`, `"hi"`, 0, 0},
+		{"parse error", `{"jsonrpc":"2.0",`, "", vrpc.JSONRPCParseError, 0},
+		{"not an object", `"add"`, "", vrpc.JSONRPCInvalidRequest, 0},
+		{"without version", `{"method":"add","params":[1,2],"id":1}`, "", vrpc.JSONRPCInvalidRequest, 0},
+		{"without method", `{"jsonrpc":"2.0","id":1}`, "", vrpc.JSONRPCInvalidRequest, 0},
+		{"empty batch", `[]`, "", vrpc.JSONRPCInvalidRequest, 0},
+		{"method not found", `{"jsonrpc":"2.0","method":"sub","id":1}`, "", vrpc.JSONRPCMethodNotFound, vrpc.FunctionNotFound},
+		{"invalid params", `{"jsonrpc":"2.0","method":"add","params":["a",2],"id":1}`, "", vrpc.JSONRPCInvalidParams, vrpc.InvalidArgs},
+		{"invalid result", `{"jsonrpc":"2.0","method":"bad","id":1}`, "", vrpc.JSONRPCInternalError, vrpc.InvalidResult},
+		{"handler error", `{"jsonrpc":"2.0","method":"fail","id":1}`, "", vrpc.JSONRPCServerError - int(vrpc.FunctionFailed), vrpc.FunctionFailed},
+		{"stream", `{"jsonrpc":"2.0","method":"src","id":1}`, "", vrpc.JSONRPCServerError - int(vrpc.WrongFunctionType), vrpc.WrongFunctionType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := postJSONRPC(t, srv, nil, tt.body)
+			if status != http.StatusOK {
+				t.Fatalf("status %d %s", status, body)
+			}
+			resp := decodeJSONRPC(t, body)
+			if tt.code == 0 {
+				if resp.Error != nil {
+					t.Fatalf("unexpected error %+v", resp.Error)
+				}
+				if string(resp.Result) != tt.result {
+					t.Errorf("expected result %s, actual %s", tt.result, resp.Result)
+				}
+				if string(resp.Id) != "1" {
+					t.Errorf("expected id 1, actual %s", resp.Id)
+				}
+				return
+			}
+			if resp.Error == nil {
+				t.Fatalf("expected error %d, actual %s", tt.code, body)
+			}
+			if resp.Error.Code != tt.code {
+				t.Errorf("expected code %d, actual %d %s", tt.code, resp.Error.Code, resp.Error.Message)
+			}
+			if tt.dataCode != 0 && dataCode(resp.Error) != tt.dataCode {
+				t.Errorf("expected data code %d, actual %v", tt.dataCode, resp.Error.Data)
+			}
+		})
+	}
+}
+
+func TestJSONRPCNotification(t *testing.T) {
+	h, srv := startJSONRPC(t)
+
+	status, body := postJSONRPC(t, srv, nil, `{"jsonrpc":"2.0","method":"add","params":[1,2]}`)
+	if status != http.StatusNoContent || body != "" {
+		t.Errorf("expected %d without body, actual %d %q", http.StatusNoContent, status, body)
+	}
+	// failed notification is not answered either
+	status, body = postJSONRPC(t, srv, nil, `{"jsonrpc":"2.0","method":"fail"}`)
+	if status != http.StatusNoContent || body != "" {
+		t.Errorf("expected %d without body, actual %d %q", http.StatusNoContent, status, body)
+	}
+	h.AssertCallCount("add", 1)
+	h.AssertCallCount("fail", 1)
+}
+
+func TestJSONRPCBatch(t *testing.T) {
+	h, srv := startJSONRPC(t)
+
+	status, body := postJSONRPC(t, srv, nil, `[
+		{"jsonrpc":"2.0","method":"add","params":[1,2],"id":"a"},
+		{"jsonrpc":"2.0","method":"add","params":[3,4]},
+		{"jsonrpc":"2.0","method":"sub","id":"b"},
+		1
+	]`)
+	if status != http.StatusOK {
+		t.Fatalf("status %d %s", status, body)
+	}
+	var list []vrpc.JSONRPCResponse
+	if err := json.Unmarshal([]byte(body), &list); err != nil {
+		t.Fatalf("batch response %q, %v", body, err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("expected 3 responses without the notification, actual %s", body)
+	}
+	if string(list[0].Id) != `"a"` || string(list[0].Result) != "3" {
+		t.Errorf("unexpected first response %s", body)
+	}
+	if string(list[1].Id) != `"b"` || list[1].Error == nil || list[1].Error.Code != vrpc.JSONRPCMethodNotFound {
+		t.Errorf("unexpected second response %s", body)
+	}
+	if list[2].Error == nil || list[2].Error.Code != vrpc.JSONRPCInvalidRequest {
+		t.Errorf("unexpected third response %s", body)
+	}
+	h.AssertCallCount("add", 2)
+
+	status, body = postJSONRPC(t, srv, nil, `[{"jsonrpc":"2.0","method":"add","params":[1,2]}]`)
+	if status != http.StatusNoContent || body != "" {
+		t.Errorf("batch of notifications expected %d, actual %d %q", http.StatusNoContent, status, body)
+	}
+
+	status, body = postJSONRPC(t, srv, nil, `[{"jsonrpc":"2.0"`)
+	if status != http.StatusOK || decodeJSONRPC(t, body).Error.Code != vrpc.JSONRPCParseError {
+		t.Errorf("broken batch expected parse error, actual %d %s", status, body)
+	}
+}
+
+func TestJSONRPCMetadata(t *testing.T) {
+	_, srv := startJSONRPC(t)
+
+	header := http.Header{"Vrpc-Md-User": []string{"bob"}}
+	_, body := postJSONRPC(t, srv, header, `{"jsonrpc":"2.0","method":"whoami","id":1}`)
+	resp := decodeJSONRPC(t, body)
+	if string(resp.Result) != `"bob"` || resp.Trailer["served"] != "yes" {
+		t.Errorf("unexpected response %s", body)
+	}
+
+	// md member overrides headers
+	_, body = postJSONRPC(t, srv, header, `{"jsonrpc":"2.0","method":"whoami","id":1,"md":{"user":"alice"}}`)
+	if resp := decodeJSONRPC(t, body); string(resp.Result) != `"alice"` {
+		t.Errorf("unexpected response %s", body)
+	}
+}
+
+func TestJSONRPCMethodNotAllowed(t *testing.T) {
+	_, srv := startJSONRPC(t)
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodPost {
+		t.Errorf("expected %d with Allow header, actual %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func TestServeJSONRPC(t *testing.T) {
+	h, _ := startJSONRPC(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- h.Server.ServeJSONRPC(lis)
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(valuetest.DefaultWaitTimeout))
+	r := bufio.NewReader(conn)
+
+	// notification has no response line, the next line belongs to the call after it
+	io.WriteString(conn, `{"jsonrpc":"2.0","method":"add","params":[5,5]}`+"\n")
+	io.WriteString(conn, `{"jsonrpc":"2.0","method":"add","params":[1,2],"id":7}`+"\n")
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := decodeJSONRPC(t, line); string(resp.Id) != "7" || string(resp.Result) != "3" {
+		t.Errorf("unexpected response %s", line)
+	}
+
+	// connection is closed after syntax error
+	io.WriteString(conn, `{"jsonrpc":}`+"\n")
+	line, err = r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := decodeJSONRPC(t, line); resp.Error == nil || resp.Error.Code != vrpc.JSONRPCParseError {
+		t.Errorf("expected parse error, actual %s", line)
+	}
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Errorf("expected closed connection, actual %v", err)
+	}
+
+	lis.Close()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve after listener closed, %v", err)
+		}
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Fatal("ServeJSONRPC did not return after listener closed")
+	}
+}
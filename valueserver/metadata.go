@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"sync"
+)
+
+type interceptorHolder struct {
+	lock sync.RWMutex
+	list []Interceptor
+}
+
+func (t *interceptorHolder) add(interceptor Interceptor) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.list = append(t.list, interceptor)
+}
+
+// runs interceptors in order of adding, the first error stops the chain
+func (t *interceptorHolder) run(ctx context.Context, name string, kind vrpc.FunctionKind) (context.Context, error) {
+	t.lock.RLock()
+	list := t.list
+	t.lock.RUnlock()
+	for _, interceptor := range list {
+		var err error
+		ctx, err = interceptor(ctx, name, kind)
+		if err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+func (t *rpcServer) AddInterceptor(interceptor Interceptor) {
+	t.interceptors.add(interceptor)
+}
+
+// context of handler with metadata of the request and trailer for the response
+func requestContext(ctx context.Context, req value.Map) context.Context {
+	ctx = vrpc.ContextWithIncomingMetadata(ctx, vrpc.GetMetadata(req, vrpc.MetadataField))
+	ctx, _ = vrpc.ContextWithTrailer(ctx)
+	return ctx
+}
+
+// puts trailers to the final response of request, function result, error or stream end
+func withTrailer(resp value.Map, trailer *vrpc.Trailer) value.Map {
+	if trailer == nil {
+		return resp
+	}
+	mt := resp.GetNumber(vrpc.MessageTypeField)
+	if mt == nil {
+		return resp
+	}
+	switch vrpc.MessageType(mt.Long()) {
+	case vrpc.FunctionResponse, vrpc.ErrorResponse, vrpc.StreamEnd:
+		return vrpc.PutMetadata(resp, vrpc.TrailerField, trailer.Metadata())
+	default:
+		return resp
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver_test
+
+import (
+	"context"
+	"errors"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"strings"
+	"testing"
+)
+
+type userKey struct{}
+
+func TestInterceptors(t *testing.T) {
+	h := valuetest.Start(t)
+
+	var order []string
+	h.Client.AddInterceptor(func(ctx context.Context, name string, kind vrpc.FunctionKind, md vrpc.Metadata) error {
+		if name == "forbidden" {
+			return errors.New("forbidden by client")
+		}
+		md.Set("user", "alex")
+		return nil
+	})
+	h.Server.AddInterceptor(func(ctx context.Context, name string, kind vrpc.FunctionKind) (context.Context, error) {
+		order = append(order, "first")
+		if vrpc.IncomingMetadata(ctx).Get("user") != "alex" {
+			return nil, errors.New("unknown user")
+		}
+		vrpc.SetTrailer(ctx, "kind", string(kind))
+		return context.WithValue(ctx, userKey{}, "alex"), nil
+	})
+	h.Server.AddInterceptor(func(ctx context.Context, name string, kind vrpc.FunctionKind) (context.Context, error) {
+		order = append(order, "second")
+		if name == "rejected" {
+			return nil, errors.New("rejected by server")
+		}
+		return ctx, nil
+	})
+
+	handler := func(ctx context.Context, args value.Value) (value.Value, error) {
+		user, _ := ctx.Value(userKey{}).(string)
+		vrpc.SetTrailer(ctx, "handled", "true")
+		return value.Utf8(user), nil
+	}
+	for _, name := range []string{"whoami", "rejected", "forbidden"} {
+		if err := h.Server.AddContextFunction(name, vrpc.Any, vrpc.String, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var trailer vrpc.Metadata
+	res, err := h.Client.CallFunctionContext(context.Background(), "whoami", nil, valueclient.WithTrailer(&trailer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valuetest.Equal(value.Utf8("alex"), res) {
+		t.Errorf("expected alex, actual %v", res)
+	}
+	if trailer.Get("kind") != string(vrpc.SingleFunctionKind) || trailer.Get("handled") != "true" {
+		t.Errorf("unexpected trailer %v", trailer)
+	}
+	if strings.Join(order, ",") != "first,second" {
+		t.Errorf("unexpected order of interceptors %v", order)
+	}
+
+	if _, err := h.Client.CallFunction("rejected", nil); serverCode(err) != vrpc.RequestRejected {
+		t.Errorf("expected RequestRejected, actual %v", err)
+	}
+	if _, err := h.Client.CallFunction("forbidden", nil); err == nil || !strings.Contains(err.Error(), "forbidden by client") {
+		t.Errorf("expected client interceptor error, actual %v", err)
+	}
+}
+
+func TestStreamTrailer(t *testing.T) {
+	h := valuetest.Start(t)
+	err := h.Server.AddContextOutgoingStream("get", vrpc.Any, vrpc.Number, func(ctx context.Context, args value.Value) (<-chan value.Value, error) {
+		outC := make(chan value.Value, 2)
+		outC <- value.Long(1)
+		outC <- value.Long(2)
+		close(outC)
+		vrpc.SetTrailer(ctx, "count", "2")
+		return outC, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var trailer vrpc.Metadata
+	readC, _, err := h.Client.GetStreamContext(context.Background(), "get", nil, 10, valueclient.WithTrailer(&trailer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.AssertValues([]value.Value{value.Long(1), value.Long(2)}, h.Drain(readC, valuetest.DefaultWaitTimeout))
+	if trailer.Get("count") != "2" {
+		t.Errorf("unexpected trailer %v", trailer)
+	}
+}
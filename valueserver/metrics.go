@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver
+
+import (
+	"github.com/codeallergy/value"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"time"
+)
+
+var UnknownFunctionLabel = "unknown" // for requests of not registered functions, keeps label cardinality bounded
+var OkCodeLabel = "ok"
+
+type serverMetrics struct {
+	registry       *vrpc.Metrics
+	requests       *vrpc.Counter
+	latency        *vrpc.Histogram
+	inFlight       *vrpc.Gauge
+	openStreams    *vrpc.Gauge
+	streamDuration *vrpc.Histogram
+	clients        *vrpc.Gauge
+	connections    *vrpc.Counter
+	throttle       *vrpc.Counter
+	protocolErrors *vrpc.Counter
+	bytes          vrpc.ByteCounters
+}
+
+func newServerMetrics(t *rpcServer) *serverMetrics {
+	m := vrpc.NewMetrics()
+	sm := &serverMetrics{
+		registry: m,
+		requests: m.Counter("vrpc_server_requests_total",
+			"Served requests by function, kind and result code, streams are counted when opened.", "function", "kind", "code"),
+		latency: m.Histogram("vrpc_server_request_duration_seconds",
+			"Time to serve function or to open stream.", nil, "function", "kind"),
+		inFlight: m.Gauge("vrpc_server_in_flight_calls",
+			"Requests being dispatched to functions.", "function"),
+		openStreams: m.Gauge("vrpc_server_open_streams",
+			"Open GET, PUT and chat streams.", "function", "kind"),
+		streamDuration: m.Histogram("vrpc_server_stream_duration_seconds",
+			"Lifetime of closed streams.", []float64{0.01, 0.1, 1, 10, 60, 600, 3600}, "function", "kind"),
+		clients: m.Gauge("vrpc_server_connected_clients",
+			"Clients with active connection."),
+		connections: m.Counter("vrpc_server_connections_total",
+			"Accepted connections by handshake result.", "result"),
+		throttle: m.Counter("vrpc_server_throttle_received_total",
+			"Throttle messages received from clients.", "type"),
+		protocolErrors: m.Counter("vrpc_server_protocol_errors_total",
+			"Malformed or unexpected messages from clients."),
+	}
+	m.CounterFunc("vrpc_server_received_bytes_total", "Bytes read from TCP and Unix socket connections.", nil, func(observe vrpc.Observe) {
+		observe(float64(sm.bytes.In.Load()))
+	})
+	m.CounterFunc("vrpc_server_sent_bytes_total", "Bytes written to TCP and Unix socket connections.", nil, func(observe vrpc.Observe) {
+		observe(float64(sm.bytes.Out.Load()))
+	})
+	m.GaugeFunc("vrpc_server_outgoing_queue_length", "Messages waiting in outgoing queues of all clients.", nil, func(observe vrpc.Observe) {
+		total := 0
+		t.clientMap.Range(func(key, value interface{}) bool {
+			total += len(value.(*servingClient).outgoingQueue)
+			return true
+		})
+		observe(float64(total))
+	})
+	m.GaugeFunc("vrpc_server_outgoing_queue_capacity", "Capacity of outgoing queue of one client.", nil, func(observe vrpc.Observe) {
+		observe(float64(OutgoingQueueCap))
+	})
+	m.GaugeFunc("vrpc_server_functions", "Registered functions including reserved ones.", nil, func(observe vrpc.Observe) {
+		observe(float64(len(t.Functions())))
+	})
+	return sm
+}
+
+func resultCode(resp value.Map) string {
+	if resp == nil {
+		return OkCodeLabel
+	}
+	if mt := resp.GetNumber(vrpc.MessageTypeField); mt == nil || vrpc.MessageType(mt.Long()) != vrpc.ErrorResponse {
+		return OkCodeLabel
+	}
+	code := vrpc.UnknownError
+	if c := resp.GetNumber(vrpc.ErrorCodeField); c != nil {
+		code = vrpc.ErrorCode(c.Long())
+	}
+	return code.String()
+}
+
+func (t *serverMetrics) observeRequest(name string, kind vrpc.FunctionKind, resp value.Map, elapsed time.Duration) {
+	t.requests.Inc(name, string(kind), resultCode(resp))
+	t.latency.Observe(elapsed.Seconds(), name, string(kind))
+}
+
+func (t *rpcServer) Metrics() *vrpc.Metrics {
+	return t.metrics.registry
+}
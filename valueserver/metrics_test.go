@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver_test
+
+import (
+	"errors"
+	"github.com/codeallergy/value"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, m *vrpc.Metrics) string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("metrics status %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	h := valuetest.Start(t)
+	h.FakeFunction("ping", vrpc.Void, vrpc.String, value.Utf8("pong"), nil)
+	h.FakeFunction("fail", vrpc.Void, vrpc.Void, nil, errors.New("broken"))
+	h.ScriptStream("ticks", vrpc.Void, vrpc.Number, value.Long(1))
+
+	h.Client.CallFunction("ping", nil)
+	h.Client.CallFunction("fail", nil)
+	h.Client.CallFunction("missing", nil)
+	readC, _, err := h.Client.GetStream("ticks", nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Drain(readC, valuetest.DefaultWaitTimeout)
+
+	tests := []struct {
+		name     string
+		metrics  *vrpc.Metrics
+		expected []string
+	}{
+		{"server", h.Server.Metrics(), []string{
+			`vrpc_server_requests_total{function="ping",kind="function",code="ok"} 1`,
+			`vrpc_server_requests_total{function="fail",kind="function",code="FunctionFailed"} 1`,
+			// names of not registered functions are not labels
+			`vrpc_server_requests_total{function="unknown",kind="function",code="FunctionNotFound"} 1`,
+			`vrpc_server_requests_total{function="ticks",kind="outgoingStream",code="ok"} 1`,
+			`vrpc_server_connections_total{result="accepted"} 1`,
+			`vrpc_server_connected_clients 1`,
+		}},
+		{"client", h.Client.Metrics(), []string{
+			`vrpc_client_requests_total{function="ping",kind="function",code="ok"} 1`,
+			`vrpc_client_requests_total{function="missing",kind="function",code="FunctionNotFound"} 1`,
+			`vrpc_client_connected 1`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := scrape(t, tt.metrics)
+			for _, line := range tt.expected {
+				if !strings.Contains(text, line+"\n") {
+					t.Errorf("expected line %s in\n%s", line, text)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,436 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+	"sync"
+	"time"
+)
+
+/**
+Reverse calls, server starts requests to functions registered on the connected client.
+Messages of reverse calls have the same types as ordinary ones and are marked by valuerpc.ReverseField,
+request ids of reverse calls are counted by server for each client.
+*/
+
+var ReverseCallTimeout = DefaultTimeout // waiting for the first response if ctx has no deadline
+
+var ErrClientNotConnected = errors.New("client not connected")
+var ErrClientDisconnected = errors.New("client disconnected")
+var ErrReverseTimeout = errors.New("reverse call timeout")
+var ErrReverseCanceled = errors.New("reverse call canceled by client")
+
+// error returned by the client in ErrorResponse of reverse call
+type ClientError struct {
+	Code    vrpc.ErrorCode
+	Message string
+	Details value.Value
+	Trailer vrpc.Metadata
+}
+
+func NewClientError(resp value.Map) *ClientError {
+	t := &ClientError{}
+	if code := resp.GetNumber(vrpc.ErrorCodeField); code != nil {
+		t.Code = vrpc.ErrorCode(code.Long())
+	}
+	if msg := resp.GetString(vrpc.ErrorField); msg != nil {
+		t.Message = msg.String()
+	}
+	t.Details, _ = resp.Get(vrpc.ErrorDetailsField)
+	t.Trailer = vrpc.GetMetadata(resp, vrpc.TrailerField)
+	return t
+}
+
+func (t *ClientError) Error() string {
+	return "CLIENT_FUNC_ERROR " + t.Message
+}
+
+type reverseCall struct {
+	requestId int64
+	ready     chan struct{} // StreamReady received
+	done      chan struct{} // closed when call is finished
+	canceled  chan struct{} // closed when call is finished by error, stops delivery of values
+	result    value.Value
+	err       error
+	span      vrpc.Span
+
+	values       chan value.Value // incoming stream values
+	valuesLock   sync.Mutex
+	pending      []value.Value // received values waiting for the reader
+	valuesClosed bool
+	wake         chan struct{}
+
+	openDirections   atomic.Int32 // streams end the call when both directions are ended
+	throttleOutgoing atomic.Int64
+	throttleOnClient atomic.Int64
+	finishOnce       sync.Once
+}
+
+// never blocks the read loop of client connection, values are queued and the client is throttled by regulateReverseStream
+func (t *reverseCall) receive(val value.Value) {
+	t.valuesLock.Lock()
+	if t.valuesClosed || t.values == nil {
+		t.valuesLock.Unlock()
+		return
+	}
+	t.pending = append(t.pending, val)
+	t.valuesLock.Unlock()
+	t.signal()
+}
+
+func (t *reverseCall) closeValues() {
+	t.valuesLock.Lock()
+	if t.valuesClosed || t.values == nil {
+		t.valuesLock.Unlock()
+		return
+	}
+	t.valuesClosed = true
+	t.valuesLock.Unlock()
+	t.signal()
+}
+
+func (t *reverseCall) signal() {
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// values waiting in queue and channel
+func (t *reverseCall) queued() int {
+	t.valuesLock.Lock()
+	defer t.valuesLock.Unlock()
+	return len(t.pending) + len(t.values)
+}
+
+// moves queued values to the reader, closes the channel after the last one
+func (t *reverseCall) deliver() {
+	defer close(t.values)
+	for {
+		t.valuesLock.Lock()
+		batch, closed := t.pending, t.valuesClosed
+		t.pending = nil
+		t.valuesLock.Unlock()
+
+		for _, val := range batch {
+			select {
+			case t.values <- val:
+			case <-t.canceled:
+				return
+			}
+		}
+
+		if len(batch) == 0 {
+			if closed {
+				return
+			}
+			select {
+			case <-t.wake:
+			case <-t.canceled:
+				return
+			}
+		}
+	}
+}
+
+func (t *servingClient) IsConnected() bool {
+	return t.conns.Load() > 0
+}
+
+// functions advertised by client in the last handshake
+func (t *servingClient) Functions() []vrpc.FunctionInfo {
+	list, _ := t.clientFunctions.Load().([]vrpc.FunctionInfo)
+	return list
+}
+
+func (t *servingClient) ClientId() int64 {
+	return t.clientId
+}
+
+func (t *servingClient) CallFunction(ctx context.Context, name string, args value.Value) (value.Value, error) {
+	call, err := t.startReverseCall(ctx, vrpc.FunctionRequest, name, args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return call.result, nil
+}
+
+func (t *servingClient) GetStream(ctx context.Context, name string, args value.Value, receiveCap int) (<-chan value.Value, error) {
+	call, err := t.startReverseCall(ctx, vrpc.GetStreamRequest, name, args, receiveCap)
+	if err != nil {
+		return nil, err
+	}
+	t.watchReverseCall(ctx, call)
+	return call.values, nil
+}
+
+func (t *servingClient) PutStream(ctx context.Context, name string, args value.Value, putCh <-chan value.Value) error {
+	call, err := t.startReverseCall(ctx, vrpc.PutStreamRequest, name, args, 0)
+	if err != nil {
+		return err
+	}
+	t.watchReverseCall(ctx, call)
+	go t.reverseStreamOut(call, putCh)
+	return nil
+}
+
+func (t *servingClient) Chat(ctx context.Context, name string, args value.Value, receiveCap int, putCh <-chan value.Value) (<-chan value.Value, error) {
+	call, err := t.startReverseCall(ctx, vrpc.ChatRequest, name, args, receiveCap)
+	if err != nil {
+		return nil, err
+	}
+	t.watchReverseCall(ctx, call)
+	go t.reverseStreamOut(call, putCh)
+	return call.values, nil
+}
+
+// sends request and waits for the first response, function result or stream ready
+func (t *servingClient) startReverseCall(ctx context.Context, mt vrpc.MessageType, name string, args value.Value, receiveCap int) (*reverseCall, error) {
+	if !t.IsConnected() {
+		return nil, ErrClientNotConnected
+	}
+
+	call := &reverseCall{
+		requestId: t.lastReverseId.Inc(),
+		ready:     make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		canceled:  make(chan struct{}),
+		wake:      make(chan struct{}, 1),
+	}
+	switch mt {
+	case vrpc.GetStreamRequest:
+		call.values = make(chan value.Value, receiveCap)
+		call.openDirections.Store(1)
+		go call.deliver()
+	case vrpc.PutStreamRequest:
+		// the end of values and the end acknowledged by client, otherwise the acknowledgement finds no call
+		call.openDirections.Store(2)
+	case vrpc.ChatRequest:
+		call.values = make(chan value.Value, receiveCap)
+		call.openDirections.Store(2)
+		go call.deliver()
+	}
+
+	ctx, call.span = t.tracer.Get().Start(ctx, name, vrpc.SpanKindClient,
+		vrpc.Attribute(vrpc.RPCSystemAttr, vrpc.RPCSystemVrpc),
+		vrpc.Attribute(vrpc.RPCMethodAttr, name),
+		vrpc.Attribute(vrpc.ClientIdAttr, t.clientId),
+		vrpc.Attribute(vrpc.RequestIdAttr, call.requestId))
+
+	timeout := ReverseCallTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	req := value.EmptyMap().
+		Put(vrpc.MessageTypeField, mt.Long()).
+		Put(vrpc.RequestIdField, value.Long(call.requestId)).
+		Put(vrpc.FunctionNameField, value.Utf8(name)).
+		Put(vrpc.ArgumentsField, args).
+		Put(vrpc.TimeoutField, value.Long(timeout.Milliseconds())).
+		Put(vrpc.ReverseField, value.Boolean(true))
+	req = vrpc.InjectTrace(ctx, req)
+	req = vrpc.PutMetadata(req, vrpc.MetadataField, vrpc.OutgoingMetadata(ctx))
+
+	t.reverseCalls.Store(call.requestId, call)
+	t.send(req)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-call.ready:
+		return call, nil
+	case <-call.done:
+		if call.err != nil {
+			return nil, call.err
+		}
+		return call, nil
+	case <-ctx.Done():
+		t.cancelReverseCall(call, ctx.Err())
+		return nil, ctx.Err()
+	case <-timer.C:
+		t.cancelReverseCall(call, ErrReverseTimeout)
+		return nil, ErrReverseTimeout
+	}
+}
+
+// done ctx cancels open stream
+func (t *servingClient) watchReverseCall(ctx context.Context, call *reverseCall) {
+	if ctx.Done() == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			t.cancelReverseCall(call, ctx.Err())
+		case <-call.done:
+		}
+	}()
+}
+
+func (t *servingClient) sendReverse(mt vrpc.MessageType, requestId int64, val value.Value) {
+	msg := value.EmptyMap().
+		Put(vrpc.MessageTypeField, mt.Long()).
+		Put(vrpc.RequestIdField, value.Long(requestId)).
+		Put(vrpc.ReverseField, value.Boolean(true))
+	if val != nil {
+		msg = msg.Put(vrpc.ValueField, val)
+	}
+	t.send(msg)
+}
+
+func (t *servingClient) cancelReverseCall(call *reverseCall, err error) {
+	select {
+	case <-call.done:
+		return
+	default:
+	}
+	call.span.AddEvent(vrpc.CancelEvent, vrpc.Attribute(vrpc.DirectionAttr, vrpc.OutDirection))
+	t.sendReverse(vrpc.CancelRequest, call.requestId, nil)
+	t.finishReverseCall(call, err)
+}
+
+func (t *servingClient) finishReverseCall(call *reverseCall, err error) {
+	call.finishOnce.Do(func() {
+		call.err = err
+		t.reverseCalls.Delete(call.requestId)
+		if err != nil {
+			close(call.canceled)
+			call.span.SetError(err)
+		}
+		call.closeValues()
+		call.span.End()
+		close(call.done)
+	})
+}
+
+// ends one direction of the stream, the last one finishes the call
+func (t *servingClient) endReverseDirection(call *reverseCall) {
+	if call.openDirections.Dec() <= 0 {
+		t.finishReverseCall(call, nil)
+	}
+}
+
+// fails running reverse calls when the last connection of client is closed
+func (t *servingClient) failReverseCalls(err error) {
+	t.reverseCalls.Range(func(key, value interface{}) bool {
+		t.finishReverseCall(value.(*reverseCall), err)
+		return true
+	})
+}
+
+func (t *servingClient) reverseStreamOut(call *reverseCall, putCh <-chan value.Value) {
+	for seq := 0; ; seq++ {
+		select {
+		case <-call.done:
+			return
+		case val, ok := <-putCh:
+			if !ok {
+				call.span.AddEvent(vrpc.StreamEndEvent, vrpc.Attribute(vrpc.DirectionAttr, vrpc.OutDirection))
+				t.sendReverse(vrpc.StreamEnd, call.requestId, nil)
+				t.endReverseDirection(call)
+				return
+			}
+			call.span.AddEvent(vrpc.StreamValueEvent, vrpc.Attribute(vrpc.DirectionAttr, vrpc.OutDirection), vrpc.Attribute(vrpc.SeqAttr, seq))
+			t.sendReverse(vrpc.StreamValue, call.requestId, val)
+		}
+		if th := call.throttleOutgoing.Load(); th > 0 {
+			time.Sleep(time.Millisecond * time.Duration(th))
+		}
+	}
+}
+
+// asks client to slow down when incoming queue of the stream is filling up
+func (t *servingClient) regulateReverseStream(call *reverseCall) {
+	used, cap := call.queued(), cap(call.values)
+	if used*3 > cap {
+		t.sendReverse(vrpc.ThrottleIncrease, call.requestId, nil)
+		call.throttleOnClient.Inc()
+	} else if used == 0 && call.throttleOnClient.Load() > 0 {
+		t.sendReverse(vrpc.ThrottleDecrease, call.requestId, nil)
+		call.throttleOnClient.Dec()
+	}
+}
+
+// messages of reverse calls from client, responses and stream control
+func (t *servingClient) processReverse(msgType vrpc.MessageType, reqId value.Number, resp value.Map) error {
+
+	entry, ok := t.reverseCalls.Load(reqId.Long())
+	if !ok {
+		return errors.Errorf("reverse call %d not found in %s", reqId.Long(), resp.String())
+	}
+	call := entry.(*reverseCall)
+
+	switch msgType {
+
+	case vrpc.FunctionResponse:
+		call.result, _ = resp.Get(vrpc.ResultField)
+		t.finishReverseCall(call, nil)
+
+	case vrpc.ErrorResponse:
+		clientErr := NewClientError(resp)
+		call.span.SetAttributes(vrpc.Attribute(vrpc.ErrorCodeAttr, clientErr.Code.String()))
+		t.finishReverseCall(call, clientErr)
+
+	case vrpc.StreamReady:
+		select {
+		case call.ready <- struct{}{}:
+		default:
+		}
+
+	case vrpc.StreamValue:
+		val, _ := resp.Get(vrpc.ValueField)
+		call.span.AddEvent(vrpc.StreamValueEvent, vrpc.Attribute(vrpc.DirectionAttr, vrpc.InDirection))
+		call.receive(val)
+		t.regulateReverseStream(call)
+
+	case vrpc.StreamEnd:
+		if val, ok := resp.Get(vrpc.ValueField); ok && val != nil {
+			call.receive(val)
+		}
+		call.span.AddEvent(vrpc.StreamEndEvent, vrpc.Attribute(vrpc.DirectionAttr, vrpc.InDirection))
+		call.closeValues()
+		t.endReverseDirection(call)
+
+	case vrpc.CancelRequest:
+		t.finishReverseCall(call, ErrReverseCanceled)
+
+	case vrpc.ThrottleIncrease:
+		call.span.AddEvent(vrpc.ThrottleEvent, vrpc.Attribute(vrpc.ThrottleAttr, call.throttleOutgoing.Inc()))
+
+	case vrpc.ThrottleDecrease:
+		call.span.AddEvent(vrpc.ThrottleEvent, vrpc.Attribute(vrpc.ThrottleAttr, call.throttleOutgoing.Dec()))
+
+	default:
+		return errors.Errorf("unknown message type of reverse call in %s", resp.String())
+	}
+
+	return nil
+}
+
+func (t *rpcServer) Client(clientId int64) (ClientCaller, bool) {
+	if cli, ok := t.clientMap.Load(clientId); ok {
+		return cli.(*servingClient), true
+	}
+	return nil, false
+}
+
+func (t *rpcServer) ClientIds() []int64 {
+	var list []int64
+	t.clientMap.Range(func(key, value interface{}) bool {
+		if value.(*servingClient).IsConnected() {
+			list = append(list, key.(int64))
+		}
+		return true
+	})
+	return list
+}
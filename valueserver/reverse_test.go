@@ -0,0 +1,269 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver_test
+
+import (
+	"context"
+	"errors"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valueserver"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+func connectedClient(t *testing.T, h *valuetest.Harness) valueserver.ClientCaller {
+	t.Helper()
+	if !valuetest.WaitFor(func() bool { return len(h.Server.ClientIds()) == 1 }, valuetest.DefaultWaitTimeout) {
+		t.Fatalf("client is not connected, clients %v", h.Server.ClientIds())
+	}
+	cc, ok := h.Server.Client(h.Client.ClientId())
+	if !ok {
+		t.Fatalf("client %d is not known by server", h.Client.ClientId())
+	}
+	return cc
+}
+
+func TestReverseCalls(t *testing.T) {
+	h := valuetest.Start(t)
+	reg := h.Client.Registry()
+
+	err := reg.AddFunction("ping", vrpc.Any, vrpc.String, func(args value.Value) (value.Value, error) {
+		return value.Utf8("pong"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 50
+	err = reg.AddOutgoingStream("count", vrpc.Any, func(args value.Value) (<-chan value.Value, error) {
+		outC := make(chan value.Value)
+		go func() {
+			defer close(outC)
+			for i := 0; i < n; i++ {
+				outC <- value.Long(int64(i))
+			}
+		}()
+		return outC, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = reg.AddChat("echo", vrpc.Any, func(args value.Value, inC <-chan value.Value) (<-chan value.Value, error) {
+		return inC, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := connectedClient(t, h)
+	ctx := context.Background()
+
+	res, err := cc.CallFunction(ctx, "ping", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valuetest.Equal(value.Utf8("pong"), res) {
+		t.Errorf("expected pong, actual %v", res)
+	}
+
+	// small receive capacity throttles the client, no values are lost
+	readC, err := cc.GetStream(ctx, "count", nil, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []value.Value
+	for i := 0; i < n; i++ {
+		expected = append(expected, value.Long(int64(i)))
+	}
+	h.AssertValues(expected, h.Drain(readC, valuetest.DefaultWaitTimeout))
+
+	putC := make(chan value.Value)
+	readC, err = cc.Chat(ctx, "echo", nil, 10, putC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putC <- value.Utf8("hi")
+	if v := <-readC; !valuetest.Equal(value.Utf8("hi"), v) {
+		t.Errorf("expected hi, actual %v", v)
+	}
+	close(putC)
+	h.Drain(readC, valuetest.DefaultWaitTimeout)
+
+	if _, err := cc.CallFunction(ctx, "unknown", nil); err == nil {
+		t.Error("expected error of unknown reverse function")
+	}
+}
+
+func TestReversePutStream(t *testing.T) {
+	h := valuetest.Start(t)
+	received := make(chan []value.Value, 1)
+	err := h.Client.Registry().AddIncomingStream("collect", vrpc.Any, func(args value.Value, inC <-chan value.Value) error {
+		go func() {
+			var list []value.Value
+			for v := range inC {
+				list = append(list, v)
+			}
+			received <- list
+		}()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Client.Registry().AddIncomingStream("reject", vrpc.Any, func(args value.Value, inC <-chan value.Value) error {
+		return errors.New("rejected by client")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := connectedClient(t, h)
+	ctx := context.Background()
+
+	putC := make(chan value.Value, 3)
+	putC <- value.Long(1)
+	putC <- value.Long(2)
+	putC <- value.Long(3)
+	close(putC)
+	if err := cc.PutStream(ctx, "collect", nil, putC); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case list := <-received:
+		h.AssertValues([]value.Value{value.Long(1), value.Long(2), value.Long(3)}, list)
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Fatal("reverse incoming stream is not ended")
+	}
+
+	if err := cc.PutStream(ctx, "reject", nil, make(chan value.Value)); err == nil {
+		t.Error("expected error of rejected reverse stream")
+	}
+
+	// acknowledgement of the end and the error of client belong to known calls
+	if _, err := cc.CallFunction(ctx, "unknown", nil); err == nil {
+		t.Error("expected error of unknown reverse function")
+	}
+	if text := scrape(t, h.Server.Metrics()); strings.Contains(text, "\nvrpc_server_protocol_errors_total ") {
+		t.Errorf("unexpected protocol errors in\n%s", text)
+	}
+}
+
+func TestReverseSlowHandler(t *testing.T) {
+	smallIncomingQueue(t)
+	prev := valueclient.ReverseQueueCap
+	valueclient.ReverseQueueCap = 1
+	defer func() {
+		valueclient.ReverseQueueCap = prev
+	}()
+
+	h := valuetest.Start(t)
+	h.FakeFunction("ping", vrpc.Void, vrpc.String, value.Utf8("pong"), nil)
+	release := make(chan struct{})
+	defer close(release)
+	err := h.Client.Registry().AddIncomingStream("stuck", vrpc.Any, func(args value.Value, inC <-chan value.Value) error {
+		go func() {
+			<-release
+			for range inC {
+			}
+		}()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Client.Registry().AddFunction("ping", vrpc.Void, vrpc.String, func(args value.Value) (value.Value, error) {
+		return value.Utf8("pong"), nil
+	})
+
+	cc := connectedClient(t, h)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	putC := make(chan value.Value, 10)
+	for i := int64(0); i < 10; i++ {
+		putC <- value.Long(i)
+	}
+	if err := cc.PutStream(ctx, "stuck", nil, putC); err != nil {
+		t.Fatal(err)
+	}
+	// values are waiting for the embedded server of client
+	if !valuetest.WaitFor(func() bool { return len(putC) == 0 }, valuetest.DefaultWaitTimeout) {
+		t.Fatal("values are not sent")
+	}
+
+	// read loop of client is not blocked by the reverse handler
+	callCtx, callCancel := context.WithTimeout(context.Background(), valuetest.DefaultWaitTimeout)
+	defer callCancel()
+	if res, err := h.Client.CallFunctionContext(callCtx, "ping", nil); err != nil || !valuetest.Equal(value.Utf8("pong"), res) {
+		t.Fatalf("expected pong, actual %v, %v", res, err)
+	}
+
+	// new reverse requests are rejected while the queue is full
+	_, err = cc.CallFunction(callCtx, "ping", nil)
+	var clientErr *valueserver.ClientError
+	if !errors.As(err, &clientErr) || clientErr.Code != vrpc.RequestRejected {
+		t.Errorf("expected RequestRejected, actual %v", err)
+	}
+}
+
+func TestReverseCancel(t *testing.T) {
+	h := valuetest.Start(t)
+	started := make(chan struct{})
+	canceled := make(chan error, 1)
+	err := h.Client.Registry().AddContextFunction("wait", vrpc.Any, vrpc.Any, func(ctx context.Context, args value.Value) (value.Value, error) {
+		close(started)
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := connectedClient(t, h)
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+	go func() {
+		_, err := cc.CallFunction(ctx, "wait", nil)
+		errC <- err
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Fatal("reverse function is not called")
+	}
+	cancel()
+	if err := <-errC; err == nil {
+		t.Error("expected error of canceled reverse call")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Error("reverse function context is not canceled")
+	}
+}
+
+func TestReverseDisabled(t *testing.T) {
+	cli := valueclient.NewClient("pipe", "")
+	err := cli.Registry().AddFunction("ping", vrpc.Any, vrpc.Any, func(args value.Value) (value.Value, error) {
+		return nil, nil
+	})
+	if !errors.Is(err, valueclient.ErrReverseDisabled) {
+		t.Errorf("expected ErrReverseDisabled, actual %v", err)
+	}
+
+	cli.SetReverseServer(valueserver.NewListenerServer(nil, zap.NewNop()))
+	err = cli.Registry().AddFunction("ping", vrpc.Any, vrpc.Any, func(args value.Value) (value.Value, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
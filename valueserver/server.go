@@ -8,6 +8,7 @@ package valueserver
 import (
 	"github.com/codeallergy/value-rpc/valuerpc"
 	"github.com/pkg/errors"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 	"io"
 	"net"
@@ -17,16 +18,32 @@ import (
 
 
 var DefaultTimeout  = 10 * time.Second
+var CloseTimeout = 5 * time.Second // Close waits for served connections
+
+var ErrServerClosed = errors.New("server closed")
 
 type rpcServer struct {
-	listener net.Listener
-	shutdown chan bool
-	wg       sync.WaitGroup
+	listener  net.Listener // primary listener, could be nil
+	listeners sync.Map     // key is net.Listener in Serve
+	shutdown  chan bool
+	wg       sync.WaitGroup // served connections
+	conns    sync.Map       // key is io.Closer of served connection
+	connLock sync.Mutex     // connections are not added after shutdown
 	logger   *zap.Logger
 
 	clientMap   sync.Map // key is clientId, value *servingClient
 	functionMap sync.Map // key is function name, value *function
 
+	watchers   sync.Map // key is watcher id, value chan value.Value
+	watcherSeq atomic.Int64
+	watchLock  sync.Mutex // serializes changes of functions and notifications of watchers
+
+	authenticator atomic.Value // authenticatorHolder
+	recorder      atomic.Value // *valuerpc.Recorder
+	metrics       *serverMetrics
+	tracer        valuerpc.TracerHolder
+	interceptors  interceptorHolder
+
 	closeOnce sync.Once
 }
 
@@ -35,24 +52,66 @@ func NewDevelopmentServer(address string) (Server, error) {
 	return NewServer(address, logger)
 }
 
+// address is host:port for TCP or unix:/path/to/socket for Unix domain socket
 func NewServer(address string, logger *zap.Logger) (Server, error) {
 
-	t := &rpcServer{
-		shutdown: make(chan bool, 1),
-		logger:   logger,
-	}
-	lis, err := net.Listen("tcp", address)
+	network, addr := valuerpc.SplitAddress(address)
+	lis, err := net.Listen(network, addr)
 	if err != nil {
 		logger.Error("bind the server port",
 			zap.String("addr", address),
 			zap.Error(err))
 		return nil, err
 	}
-	t.listener = lis
-	t.wg.Add(1)
-	logger.Info("start vRPC server", zap.String("addr", address))
-	return t, nil
+	logger.Info("start vRPC server", zap.String("addr", lis.Addr().String()))
+	return NewListenerServer(lis, logger), nil
+
+}
+
+// server owns the listener and serves it in Run, with nil listener only Serve and ServeConn accept clients
+func NewListenerServer(lis net.Listener, logger *zap.Logger) Server {
+	t := &rpcServer{
+		listener: lis,
+		shutdown: make(chan bool),
+		logger:   logger,
+	}
+	t.metrics = newServerMetrics(t)
+	t.registerIntrospection()
+	return t
+}
+
+type authenticatorHolder struct {
+	value Authenticator
+}
+
+func (t *rpcServer) SetAuthenticator(auth Authenticator) {
+	t.authenticator.Store(authenticatorHolder{auth})
+}
+
+// nil if authentication is disabled
+func (t *rpcServer) getAuthenticator() Authenticator {
+	if h, ok := t.authenticator.Load().(authenticatorHolder); ok {
+		return h.value
+	}
+	return nil
+}
+
+func (t *rpcServer) SetRecorder(rec *valuerpc.Recorder) {
+	t.recorder.Store(rec)
+}
+
+func (t *rpcServer) wrapConn(conn valuerpc.MsgConn) valuerpc.MsgConn {
+	if rec, ok := t.recorder.Load().(*valuerpc.Recorder); ok && rec != nil {
+		return valuerpc.NewRecordingMsgConn(conn, rec, valuerpc.ServerSide)
+	}
+	return conn
+}
 
+func (t *rpcServer) Addr() net.Addr {
+	if t.listener == nil {
+		return nil
+	}
+	return t.listener.Addr()
 }
 
 func (t *rpcServer) Close() error {
@@ -60,40 +119,110 @@ func (t *rpcServer) Close() error {
 	t.closeOnce.Do(func() {
 		t.logger.Info("shutdown vRPC server")
 
+		t.connLock.Lock()
+		close(t.shutdown)
+		t.connLock.Unlock()
+
+		t.listeners.Range(func(key, value interface{}) bool {
+			key.(net.Listener).Close()
+			return true
+		})
+
 		t.clientMap.Range(func(key, value interface{}) bool {
 			cli := value.(*servingClient)
 			cli.Close()
 			return true
 		})
 
-		t.shutdown <- true
-		err = t.listener.Close()
+		t.conns.Range(func(key, value interface{}) bool {
+			key.(io.Closer).Close()
+			return true
+		})
+
+		t.closeWatchers()
+		if t.listener != nil {
+			err = t.listener.Close()
+		}
+
+		if !waitGroup(&t.wg, CloseTimeout) {
+			t.logger.Warn("served connections are not finished", zap.Duration("timeout", CloseTimeout))
+		}
 	})
 	return err
 }
 
+// false after shutdown, the connection should be closed by caller
+func (t *rpcServer) addConn(conn io.Closer) bool {
+	t.connLock.Lock()
+	defer t.connLock.Unlock()
+	if t.isShutdown() {
+		return false
+	}
+	t.conns.Store(conn, true)
+	t.wg.Add(1)
+	return true
+}
+
+func (t *rpcServer) removeConn(conn io.Closer) {
+	t.conns.Delete(conn)
+	t.wg.Done()
+}
+
+func waitGroup(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (t *rpcServer) isShutdown() bool {
+	select {
+	case <-t.shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
 func (t *rpcServer) Run() error {
+	if t.listener == nil {
+		<-t.shutdown
+		return nil
+	}
+	return t.Serve(t.listener)
+}
+
+func (t *rpcServer) Serve(lis net.Listener) error {
+
+	t.listeners.Store(lis, true)
+	defer t.listeners.Delete(lis)
 
-	defer t.wg.Done()
+	if t.isShutdown() {
+		return nil
+	}
 
 	for {
-		conn, err := t.listener.Accept()
+		conn, err := lis.Accept()
 		if err != nil {
-			select {
-			case <-t.shutdown:
+			if t.isShutdown() || errors.Is(err, net.ErrClosed) {
 				return nil
-			default:
-				t.logger.Warn("error on accept connection", zap.Error(err))
 			}
+			t.logger.Warn("error on accept connection", zap.Error(err))
 		} else {
-			t.wg.Add(1)
 			go func() {
-				defer t.wg.Done()
-				t.logger.Info("new connection", zap.String("from", conn.RemoteAddr().String()))
-				err := t.handleConnection(valuerpc.NewMsgConn(conn, DefaultTimeout))
+				err := t.ServeConn(conn)
 				if err != nil {
 					t.logger.Error("handle connection",
-						zap.String("from", conn.RemoteAddr().String()),
+						zap.String("from", remoteAddr(conn)),
 						zap.Error(err),
 					)
 				}
@@ -101,8 +230,25 @@ func (t *rpcServer) Run() error {
 		}
 	}
 
-	return nil
+}
 
+func (t *rpcServer) ServeConn(conn net.Conn) error {
+	if !t.addConn(conn) {
+		conn.Close()
+		return ErrServerClosed
+	}
+	defer t.removeConn(conn)
+	t.logger.Info("new connection", zap.String("from", remoteAddr(conn)))
+	conn = valuerpc.NewCountingConn(conn, &t.metrics.bytes)
+	return t.handleConnection(valuerpc.NewMsgConn(conn, DefaultTimeout))
+}
+
+// accepted Unix domain socket connections could have no remote address
+func remoteAddr(conn net.Conn) string {
+	if addr := conn.RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return "unknown"
 }
 
 func (t *rpcServer) handshake(conn valuerpc.MsgConn) (*servingClient, error) {
@@ -130,11 +276,31 @@ func (t *rpcServer) handshake(conn valuerpc.MsgConn) (*servingClient, error) {
 		return nil, errors.Errorf("on handshake, no client id in %s", req.String())
 	}
 	clientId := cid.Long()
+
+	if auth := t.getAuthenticator(); auth != nil {
+		var token string
+		if tok := req.GetString(valuerpc.TokenField); tok != nil {
+			token = tok.String()
+		}
+		if err := auth(clientId, token); err != nil {
+			return nil, errors.Errorf("on handshake, client %d authentication failed, %v", clientId, err)
+		}
+	}
+
 	cli := t.createOrUpdateServingClient(clientId, conn)
 
+	var functions []valuerpc.FunctionInfo
+	if fns := req.GetList(valuerpc.FunctionsField); fns != nil {
+		if functions, err = valuerpc.FunctionInfoList(fns); err != nil {
+			t.logger.Warn("on handshake, client functions", zap.Int64("clientId", clientId), zap.Error(err))
+		}
+	}
+	cli.clientFunctions.Store(functions)
+
 	resp := valuerpc.NewHandshakeResponse()
 	err = conn.WriteMessage(resp)
 	if err != nil {
+		cli.disconnected()
 		return nil, errors.Errorf("on handshake, %v", err)
 	}
 
@@ -143,6 +309,8 @@ func (t *rpcServer) handshake(conn valuerpc.MsgConn) (*servingClient, error) {
 
 func (t *rpcServer) handleConnection(conn valuerpc.MsgConn) error {
 
+	conn = t.wrapConn(conn)
+
 	defer func() {
 		defer conn.Close()
 		if r := recover(); r != nil {
@@ -153,8 +321,30 @@ func (t *rpcServer) handleConnection(conn valuerpc.MsgConn) error {
 	cli, err := t.handshake(conn)
 	if err != nil {
 		// wrong client, close connection
+		t.metrics.connections.Inc("rejected")
 		return err
 	}
+	t.metrics.connections.Inc("accepted")
+	t.metrics.clients.Inc()
+	defer t.metrics.clients.Dec()
+
+	return t.serveRequests(cli, conn)
+}
+
+// serves single connection without handshake, requests of the peer are served for clientId
+func (t *rpcServer) ServeMsgConn(clientId int64, conn valuerpc.MsgConn) error {
+	defer conn.Close()
+	if !t.addConn(conn) {
+		return ErrServerClosed
+	}
+	defer t.removeConn(conn)
+	cli := t.createOrUpdateServingClient(clientId, conn)
+	return t.serveRequests(cli, conn)
+}
+
+func (t *rpcServer) serveRequests(cli *servingClient, conn valuerpc.MsgConn) error {
+
+	defer cli.disconnected()
 
 	for {
 		req, err := conn.ReadMessage()
@@ -167,6 +357,7 @@ func (t *rpcServer) handleConnection(conn valuerpc.MsgConn) error {
 		err = cli.processRequest(req)
 		if err != nil {
 			// app error, continue after logging
+			t.metrics.protocolErrors.Inc()
 			t.logger.Debug("processMessage",
 				zap.Stringer("req", req),
 				zap.Error(err))
@@ -176,13 +367,16 @@ func (t *rpcServer) handleConnection(conn valuerpc.MsgConn) error {
 
 func (t *rpcServer) createOrUpdateServingClient(clientId int64, conn valuerpc.MsgConn) *servingClient {
 
+	// counted before the old connection is closed, so reconnect does not finish requests of the client
 	if cli, ok := t.clientMap.Load(clientId); ok {
 		client := cli.(*servingClient)
+		client.conns.Inc()
 		client.replaceConn(conn)
 		return client
 	}
 
-	client := NewServingClient(clientId, conn, &t.functionMap, t.logger)
+	client := NewServingClient(clientId, conn, &t.functionMap, t.metrics, &t.tracer, &t.interceptors, t.logger)
+	client.conns.Inc()
 	t.clientMap.Store(clientId, client)
 
 	return client
@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver_test
+
+import (
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valueserver"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"go.uber.org/zap"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func addPing(t *testing.T, srv valueserver.Server) {
+	if err := srv.AddFunction("ping", vrpc.Void, vrpc.String, func(args value.Value) (value.Value, error) {
+		return value.Utf8("pong"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// connects new client to address and calls ping
+func ping(t *testing.T, address string) {
+	t.Helper()
+	cli := valueclient.NewClient(address, "")
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect %s, %v", address, err)
+	}
+	defer cli.Close()
+	res, err := cli.CallFunction("ping", nil)
+	if err != nil {
+		t.Fatalf("call over %s, %v", address, err)
+	}
+	if !valuetest.Equal(value.Utf8("pong"), res) {
+		t.Errorf("expected pong, actual %v", res)
+	}
+}
+
+// runs fn in background, returned channel receives its error
+func serve(fn func() error) <-chan error {
+	served := make(chan error, 1)
+	go func() {
+		served <- fn()
+	}()
+	return served
+}
+
+func waitServed(t *testing.T, served <-chan error, expected error) {
+	t.Helper()
+	select {
+	case err := <-served:
+		if err != expected {
+			t.Errorf("expected %v, actual %v", expected, err)
+		}
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Fatal("server did not return")
+	}
+}
+
+func TestServe(t *testing.T) {
+	srv := valueserver.NewListenerServer(nil, zap.NewNop())
+	addPing(t, srv)
+	if addr := srv.Addr(); addr != nil {
+		t.Errorf("expected nil address without primary listener, actual %v", addr)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := serve(func() error { return srv.Serve(lis) })
+
+	ping(t, lis.Addr().String())
+
+	srv.Close()
+	waitServed(t, served, nil)
+	if _, err := net.Dial("tcp", lis.Addr().String()); err == nil {
+		t.Error("expected listener closed by server")
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv, err := valueserver.NewServer("127.0.0.1:0", zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addPing(t, srv)
+	addr := srv.Addr()
+	if addr == nil {
+		t.Fatal("expected address of primary listener")
+	}
+	if tcp, ok := addr.(*net.TCPAddr); !ok || tcp.Port == 0 {
+		t.Errorf("expected bound TCP port, actual %v", addr)
+	}
+	served := serve(srv.Run)
+
+	ping(t, addr.String())
+
+	srv.Close()
+	waitServed(t, served, nil)
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vrpc.sock")
+	srv, err := valueserver.NewServer("unix:"+path, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addPing(t, srv)
+	if addr := srv.Addr(); addr == nil || addr.Network() != "unix" || addr.String() != path {
+		t.Errorf("expected unix address %s, actual %v", path, addr)
+	}
+	served := serve(srv.Run)
+
+	ping(t, "unix:"+path)
+
+	srv.Close()
+	waitServed(t, served, nil)
+}
+
+func TestServeConn(t *testing.T) {
+	srv := valueserver.NewListenerServer(nil, zap.NewNop())
+	defer srv.Close()
+	addPing(t, srv)
+
+	// connections are accepted by application, server sees only the connection
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	conns := make(chan error, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			conns <- err
+			return
+		}
+		srv.ServeConn(conn)
+		close(conns)
+	}()
+
+	ping(t, lis.Addr().String())
+
+	// client closed the connection
+	select {
+	case err, ok := <-conns:
+		if ok {
+			t.Errorf("accept, %v", err)
+		}
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Fatal("ServeConn did not return after client closed connection")
+	}
+}
+
+func TestClose(t *testing.T) {
+	h := valuetest.Start(t)
+	h.FakeFunction("ping", vrpc.Any, vrpc.String, value.Utf8("pong"), nil)
+	if _, err := h.Client.CallFunction("ping", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Close()
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	if err := h.Server.ServeConn(serverConn); err != valueserver.ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, actual %v", err)
+	}
+	if _, err := h.Client.CallFunction("ping", nil); err == nil {
+		t.Error("expected error of call after close")
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	if err := h.Server.Serve(lis); err != nil {
+		t.Errorf("serve after close, %v", err)
+	}
+}
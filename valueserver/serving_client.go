@@ -6,6 +6,7 @@
 package valueserver
 
 import (
+	"context"
 	"fmt"
 	"github.com/codeallergy/value"
 	 vrpc "github.com/codeallergy/value-rpc/valuerpc"
@@ -13,31 +14,46 @@ import (
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 	"sync"
+	"time"
 )
 
 var OutgoingQueueCap = 4096
 
 type servingClient struct {
-	clientId    int64
-	activeConn  atomic.Value
-	functionMap *sync.Map
+	clientId     int64
+	activeConn   atomic.Value
+	functionMap  *sync.Map
+	metrics      *serverMetrics
+	tracer       *vrpc.TracerHolder
+	interceptors *interceptorHolder
 
 	logger *zap.Logger
 
 	outgoingQueue chan value.Map
+	closed        chan struct{} // stops sender, messages of finishing handlers are dropped after Close
 
 	requestMap        sync.Map
 	canceledRequests  sync.Map
+	runningCalls      sync.Map // key is request id, value context.CancelFunc of running single function
+
+	conns           atomic.Int32 // active connections, more than one while reconnecting
+	clientFunctions atomic.Value // []vrpc.FunctionInfo advertised in handshake
+	lastReverseId   atomic.Int64
+	reverseCalls    sync.Map // key is request id, value *reverseCall
 
 	closeOnce sync.Once
 }
 
-func NewServingClient(clientId int64, conn vrpc.MsgConn, functionMap *sync.Map, logger *zap.Logger) *servingClient {
+func NewServingClient(clientId int64, conn vrpc.MsgConn, functionMap *sync.Map, metrics *serverMetrics, tracer *vrpc.TracerHolder, interceptors *interceptorHolder, logger *zap.Logger) *servingClient {
 
 	client := &servingClient{
 		clientId:      clientId,
 		functionMap:   functionMap,
+		metrics:       metrics,
+		tracer:        tracer,
+		interceptors:  interceptors,
 		outgoingQueue: make(chan value.Map, OutgoingQueueCap),
+		closed:        make(chan struct{}),
 		logger:        logger,
 	}
 	client.activeConn.Store(conn)
@@ -50,15 +66,40 @@ func NewServingClient(clientId int64, conn vrpc.MsgConn, functionMap *sync.Map,
 func (t *servingClient) Close() {
 
 	t.closeOnce.Do(func() {
-		t.requestMap.Range(func(key, value interface{}) bool {
-			sr := value.(*servingRequest)
-			sr.Close()
-			return true
-		})
+		t.cancelRequests()
+		t.failReverseCalls(ErrClientDisconnected)
+		close(t.closed)
+	})
+
+}
 
-		close(t.outgoingQueue)
+// cancels handler ctx of running functions and closes streams
+func (t *servingClient) cancelRequests() {
+	t.runningCalls.Range(func(key, cancel interface{}) bool {
+		cancel.(context.CancelFunc)()
+		return true
 	})
+	t.requestMap.Range(func(key, value interface{}) bool {
+		sr := value.(*servingRequest)
+		sr.Close()
+		return true
+	})
+}
 
+// the last closed connection of the client finishes its requests
+func (t *servingClient) disconnected() {
+	if t.conns.Dec() == 0 {
+		t.cancelRequests()
+		t.failReverseCalls(ErrClientDisconnected)
+	}
+}
+
+// handler ctx is done on cancel of the request, disconnect of the client or timeout of single function
+func handlerContext(ft functionType, req value.Map) (context.Context, context.CancelFunc) {
+	if timeout := req.GetNumber(vrpc.TimeoutField); ft == singleFunction && timeout != nil && timeout.Long() > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(timeout.Long())*time.Millisecond)
+	}
+	return context.WithCancel(context.Background())
 }
 
 func (t *servingClient) replaceConn(newConn vrpc.MsgConn) {
@@ -119,14 +160,29 @@ func FunctionError(requestId value.Number, format string, args ...interface{}) v
 	}
 }
 
+func FunctionErrorCode(requestId value.Number, code vrpc.ErrorCode, format string, args ...interface{}) value.Map {
+	return FunctionError(requestId, format, args...).Put(vrpc.ErrorCodeField, code.Long())
+}
+
+func FunctionErrorDetails(requestId value.Number, code vrpc.ErrorCode, details value.Value, format string, args ...interface{}) value.Map {
+	resp := FunctionErrorCode(requestId, code, format, args...)
+	if details != nil {
+		return resp.Put(vrpc.ErrorDetailsField, details)
+	} else {
+		return resp
+	}
+}
+
 func (t *servingClient) sender() {
 
 	for {
 
-		resp, ok := <-t.outgoingQueue
-		if !ok {
+		var resp value.Map
+		select {
+		case resp = <-t.outgoingQueue:
+		case <-t.closed:
 			t.logger.Info("stop serving client", zap.Int64("clientId", t.clientId))
-			break
+			return
 		}
 
 		conn := t.activeConn.Load()
@@ -149,8 +205,12 @@ func (t *servingClient) sender() {
 }
 
 func (t *servingClient) send(resp value.Map) error {
-	t.outgoingQueue <- resp
-	return nil
+	select {
+	case t.outgoingQueue <- resp:
+		return nil
+	case <-t.closed:
+		return ErrServerClosed
+	}
 }
 
 func (t *servingClient) findFunction(name string) (*function, bool) {
@@ -161,92 +221,125 @@ func (t *servingClient) findFunction(name string) (*function, bool) {
 }
 
 func (t *servingClient) serveFunctionRequest(ft functionType, req value.Map) {
-	resp := t.doServeFunctionRequest(ft, req)
+	name := UnknownFunctionLabel
+	if fn := req.GetString(vrpc.FunctionNameField); fn != nil {
+		if _, ok := t.findFunction(fn.String()); ok {
+			name = fn.String()
+		}
+	}
+	ctx, cancel := handlerContext(ft, req)
+	ctx, span := t.startSpan(ctx, name, ft, req)
+	ctx = requestContext(ctx, req)
+	t.metrics.inFlight.Inc(name)
+	start := time.Now()
+	resp, streaming := t.doServeFunctionRequest(ctx, cancel, ft, req)
+	t.metrics.inFlight.Dec(name)
+	t.metrics.observeRequest(name, ft.Kind(), resp, time.Since(start))
+	if !streaming {
+		cancel()
+		endSpan(span, resp)
+	}
 	if resp != nil {
-		t.send(resp)
+		t.send(withTrailer(resp, vrpc.TrailerFromContext(ctx)))
 	}
 }
 
-func (t *servingClient) doServeFunctionRequest(ft functionType, req value.Map) value.Map {
+// streaming is true if serving request took the span and cancel of ctx and ends them on close
+func (t *servingClient) doServeFunctionRequest(ctx context.Context, cancel context.CancelFunc, ft functionType, req value.Map) (value.Map, bool) {
 
 	reqId := req.GetNumber(vrpc.RequestIdField)
 	if reqId == nil {
-		return FunctionError(reqId, "request id not found")
+		return FunctionErrorCode(reqId, vrpc.BadRequest, "request id not found"), false
 	}
 
 	name := req.GetString(vrpc.FunctionNameField)
 	if name == nil {
-		return FunctionError(reqId, "function name field not found")
+		return FunctionErrorCode(reqId, vrpc.BadRequest, "function name field not found"), false
 	}
 
 	fn, ok := t.findFunction(name.String())
 	if !ok {
-		return FunctionError(reqId, "function not found %s", name.String())
+		return FunctionErrorCode(reqId, vrpc.FunctionNotFound, "function not found %s", name.String()), false
 	}
 
 	args, _ := req.Get(vrpc.ArgumentsField)
-	if !vrpc.Verify(args, fn.args) {
-		return FunctionError(reqId, "function '%s' invalid args %s", name.String(), value.Jsonify(args))
+	args, violations := vrpc.Prepare(args, fn.args)
+	if len(violations) > 0 {
+		return FunctionErrorDetails(reqId, vrpc.InvalidArgs, vrpc.ViolationsToValue(violations), "function '%s' invalid args, %s", name.String(), vrpc.JoinViolations(violations)), false
 	}
 
 	if fn.ft != ft {
-		return FunctionError(reqId, "function wrong type %s, expected %d, actual %d", name.String(), fn.ft, ft)
+		return FunctionErrorCode(reqId, vrpc.WrongFunctionType, "function wrong type %s, expected %d, actual %d", name.String(), fn.ft, ft), false
+	}
+
+	ctx, err := t.interceptors.run(ctx, name.String(), fn.ft.Kind())
+	if err != nil {
+		return FunctionErrorCode(reqId, vrpc.RequestRejected, "function '%s' rejected, %v", name.String(), err), false
 	}
 
 	if _, ok := t.canceledRequests.Load(reqId.Long()); ok {
 		t.canceledRequests.Delete(reqId.Long())
-		return FunctionError(reqId, "function '%s' canceled request %d", name.String(), reqId.Long())
+		return FunctionErrorCode(reqId, vrpc.RequestCanceled, "function '%s' canceled request %d", name.String(), reqId.Long()), false
 	}
 
 	switch fn.ft {
 	case singleFunction:
-		res, err := fn.singleFn(args)
+		t.runningCalls.Store(reqId.Long(), cancel)
+		res, err := fn.singleFn(ctx, args)
+		t.runningCalls.Delete(reqId.Long())
 		if err != nil {
-			return FunctionError(reqId, "single function %s call, %v", name.String(), err)
+			return FunctionErrorCode(reqId, vrpc.FunctionFailed, "single function %s call, %v", name.String(), err), false
 		}
-		if !vrpc.Verify(res, fn.res) {
-			return FunctionError(reqId, "function '%s' invalid results %s", name.String(), value.Jsonify(res))
+		if violations := vrpc.VerifyAt(vrpc.ResultPath, res, fn.res); len(violations) > 0 {
+			return FunctionErrorDetails(reqId, vrpc.InvalidResult, vrpc.ViolationsToValue(violations), "function '%s' invalid results, %s", name.String(), vrpc.JoinViolations(violations)), false
 		}
-		return FunctionResult(reqId, res)
+		return FunctionResult(reqId, res), false
 
 	case outgoingStream:
-		sr := t.newServingRequest(ft, reqId)
-		outC, err := fn.outStream(args)
+		ctx, sr := t.newServingRequest(ctx, cancel, fn, reqId)
+		outC, err := fn.outStream(ctx, args)
 		if err != nil {
-			sr.closeRequest(t)
-			return FunctionError(reqId, "out stream function %s call, %v", name.String(), err)
+			resp := FunctionErrorCode(reqId, vrpc.FunctionFailed, "out stream function %s call, %v", name.String(), err)
+			sr.fail(resp, t)
+			return resp, true
 		}
 		go sr.outgoingStreamer(outC, t)
-		return nil
+		return nil, true
 
 	case incomingStream:
-		sr := t.newServingRequest(ft, reqId)
-		err := fn.inStream(args, sr.inC)
+		ctx, sr := t.newServingRequest(ctx, cancel, fn, reqId)
+		err := fn.inStream(ctx, args, sr.inC)
 		if err != nil {
-			sr.closeRequest(t)
-			return FunctionError(reqId, "in stream function %s call, %v", name.String(), err)
+			resp := FunctionErrorCode(reqId, vrpc.FunctionFailed, "in stream function %s call, %v", name.String(), err)
+			sr.fail(resp, t)
+			return resp, true
 		}
-		return StreamReady(reqId)
+		return StreamReady(reqId), true
 
 	case chat:
-		sr := t.newServingRequest(ft, reqId)
-		outC, err := fn.chat(args, sr.inC)
+		ctx, sr := t.newServingRequest(ctx, cancel, fn, reqId)
+		outC, err := fn.chat(ctx, args, sr.inC)
 		if err != nil {
-			sr.closeRequest(t)
-			return FunctionError(reqId, "chat function %s call, %v", name.String(), err)
+			resp := FunctionErrorCode(reqId, vrpc.FunctionFailed, "chat function %s call, %v", name.String(), err)
+			sr.fail(resp, t)
+			return resp, true
 		}
 		go sr.outgoingStreamer(outC, t)
-		return nil
+		return nil, true
 	}
 
-	return FunctionError(reqId, "unsupported function %s type", name.String())
+	return FunctionErrorCode(reqId, vrpc.WrongFunctionType, "unsupported function %s type", name.String()), false
 
 }
 
-func (t *servingClient) newServingRequest(ft functionType, reqId value.Number) *servingRequest {
-	sr := NewServingRequest(ft, reqId)
+// returned ctx of handler could fail the stream by FailStream
+func (t *servingClient) newServingRequest(ctx context.Context, cancel context.CancelFunc, fn *function, reqId value.Number) (context.Context, *servingRequest) {
+	sr := NewServingRequest(fn.ft, reqId, fn.in, fn.res)
+	sr.cancel = cancel
+	sr.openStream(fn.name, t.metrics, vrpc.SpanFromContext(ctx))
+	sr.trailer = vrpc.TrailerFromContext(ctx)
 	t.requestMap.Store(reqId.Long(), sr)
-	return sr
+	return context.WithValue(ctx, servingStreamKey{}, servingStream{sr, t}), sr
 }
 
 func (t *servingClient) findServingRequest(reqId value.Number) (*servingRequest, bool) {
@@ -278,10 +371,18 @@ func (t *servingClient) processRequest(req value.Map) error {
 		return errors.Errorf("request id not found in %s", req.String())
 	}
 
+	if rev := req.GetBool(vrpc.ReverseField); rev != nil && rev.Boolean() {
+		return t.processReverse(msgType, reqId, req)
+	}
+
 	if sr, ok := t.findServingRequest(reqId); ok {
 		return sr.serveRunningRequest(msgType, req, t)
 	} else {
 		if msgType == vrpc.CancelRequest {
+			if cancel, ok := t.runningCalls.Load(reqId.Long()); ok {
+				cancel.(context.CancelFunc)()
+				return nil
+			}
 			t.canceledRequests.Store(reqId.Long(), req)
 			return nil
 		}
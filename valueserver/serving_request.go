@@ -6,10 +6,14 @@
 package valueserver
 
 import (
+	"context"
+	"fmt"
 	"github.com/codeallergy/value"
 	vrpc "github.com/codeallergy/value-rpc/valuerpc"
 	"github.com/pkg/errors"
 	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"sync"
 	"time"
 )
 
@@ -20,16 +24,32 @@ type servingRequest struct {
 	ft               functionType
 	requestId        value.Number
 	inC              chan value.Value
+	inLock           sync.Mutex    // held by the read loop while sending to inC, Close waits for it before closing inC
+	done             chan struct{} // closed by Close, releases the read loop blocked on full inC
+	inDef            vrpc.TypeDef
+	outDef           vrpc.TypeDef
+	inCount          atomic.Int64
 	throttleOutgoing atomic.Int64
 
 	closed           atomic.Bool
+	failed           atomic.Bool // error response is sent, stream ends without stream end message
+
+	name    string
+	opened  time.Time
+	metrics *serverMetrics
+	span    vrpc.Span
+	trailer *vrpc.Trailer // sent with stream end or error
+	cancel  context.CancelFunc // cancels handler ctx on close
 }
 
-func NewServingRequest(ft functionType, requestId value.Number) *servingRequest {
+func NewServingRequest(ft functionType, requestId value.Number, inDef, outDef vrpc.TypeDef) *servingRequest {
 
 	sr := &servingRequest{
 		ft:        ft,
 		requestId: requestId,
+		inDef:     inDef,
+		outDef:    outDef,
+		done:      make(chan struct{}),
 	}
 
 	if ft == incomingStream || ft == chat {
@@ -39,10 +59,71 @@ func NewServingRequest(ft functionType, requestId value.Number) *servingRequest
 	return sr
 }
 
+func (t *servingRequest) openStream(name string, metrics *serverMetrics, span vrpc.Span) {
+	t.name = name
+	t.opened = time.Now()
+	t.metrics = metrics
+	t.span = span
+	metrics.openStreams.Inc(name, string(t.ft.Kind()))
+}
+
+// closes request with error response
+func (t *servingRequest) fail(resp value.Map, cli *servingClient) {
+	t.failed.Store(true)
+	if t.span != nil {
+		endSpan(t.span, resp)
+	}
+	t.closeRequest(cli)
+}
+
+type servingStreamKey struct{}
+
+type servingStream struct {
+	sr  *servingRequest
+	cli *servingClient
+}
+
+// ends the stream of the request served with ctx by error response, false if ctx has no stream or it is closed,
+// error is logged in any case, for example when PUT stream is already finished by client
+func FailStream(ctx context.Context, code vrpc.ErrorCode, err error) bool {
+	ss, ok := ctx.Value(servingStreamKey{}).(servingStream)
+	if !ok {
+		return false
+	}
+	ss.cli.logger.Warn("stream failed", zap.String("function", ss.sr.name), zap.Int64("requestId", ss.sr.requestId.Long()), zap.Error(err))
+	if ss.sr.closed.Load() || !ss.sr.failed.CAS(false, true) {
+		return false
+	}
+	resp := FunctionErrorCode(ss.sr.requestId, code, "stream of function '%s' failed, %v", ss.sr.name, err)
+	ss.cli.send(withTrailer(resp, ss.sr.trailer))
+	ss.sr.fail(resp, ss.cli)
+	return true
+}
+
+func (t *servingRequest) addEvent(name string, attrs ...vrpc.Attr) {
+	if t.span != nil {
+		t.span.AddEvent(name, attrs...)
+	}
+}
+
 func (t *servingRequest) Close() {
 	if t.closed.CAS(false, true) {
+		close(t.done)
 		if t.inC != nil {
+			t.inLock.Lock()
 			close(t.inC)
+			t.inLock.Unlock()
+		}
+		if t.metrics != nil {
+			kind := string(t.ft.Kind())
+			t.metrics.openStreams.Dec(t.name, kind)
+			t.metrics.streamDuration.Observe(time.Since(t.opened).Seconds(), t.name, kind)
+		}
+		if t.span != nil {
+			t.span.End()
+		}
+		if t.cancel != nil {
+			t.cancel()
 		}
 	}
 }
@@ -52,19 +133,22 @@ func (t *servingRequest) serveRunningRequest(msgType vrpc.MessageType, req value
 	switch msgType {
 
 	case vrpc.CancelRequest:
+		t.addEvent(vrpc.CancelEvent)
 		return t.closeRequest(cli)
 
 	case vrpc.StreamValue:
-		return t.incomingStreamValue(req)
+		return t.incomingStreamValue(req, cli)
 
 	case vrpc.StreamEnd:
 		return t.incomingStreamEnd(req, cli)
 
 	case vrpc.ThrottleIncrease:
-		t.throttleOutgoing.Inc()
+		cli.metrics.throttle.Inc("increase")
+		t.addEvent(vrpc.ThrottleEvent, vrpc.Attribute(vrpc.ThrottleAttr, t.throttleOutgoing.Inc()))
 
 	case vrpc.ThrottleDecrease:
-		t.throttleOutgoing.Dec()
+		cli.metrics.throttle.Inc("decrease")
+		t.addEvent(vrpc.ThrottleEvent, vrpc.Attribute(vrpc.ThrottleAttr, t.throttleOutgoing.Dec()))
 
 	default:
 		return errors.Errorf("unknown message type in %s", req.String())
@@ -75,19 +159,61 @@ func (t *servingRequest) serveRunningRequest(msgType vrpc.MessageType, req value
 
 }
 
-func (t *servingRequest) incomingStreamValue(req value.Map) error {
+func (t *servingRequest) incomingStreamValue(req value.Map, cli *servingClient) error {
 
 	if t.inC == nil {
 		return errors.Errorf("incoming value stream not found in serving request for %d", t.requestId)
 	}
 
 	if value, ok := req.Get(vrpc.ValueField); ok {
-		t.inC <- value
+		if err := t.verifyIncoming(value, cli); err != nil {
+			return err
+		}
+		t.addEvent(vrpc.StreamValueEvent, vrpc.Attribute(vrpc.DirectionAttr, vrpc.InDirection), vrpc.Attribute(vrpc.SeqAttr, t.inCount.Load()-1))
+		t.pushIncoming(value)
+	}
+
+	return nil
+}
+
+// called only by the read loop, the value is dropped when the request is closed by handler or failed stream
+func (t *servingRequest) pushIncoming(val value.Value) {
+	t.inLock.Lock()
+	defer t.inLock.Unlock()
+	if t.closed.Load() {
+		return
 	}
+	select {
+	case t.inC <- val:
+	case <-t.done:
+	}
+}
 
+// ends the request with typed error on invalid incoming value
+func (t *servingRequest) verifyIncoming(val value.Value, cli *servingClient) error {
+	path := fmt.Sprintf("%s[%d]", vrpc.IncomingPath, t.inCount.Inc()-1)
+	if violations := vrpc.VerifyAt(path, val, t.inDef); len(violations) > 0 {
+		msg := vrpc.JoinViolations(violations)
+		resp := FunctionErrorDetails(t.requestId, vrpc.InvalidStreamValue, vrpc.ViolationsToValue(violations), "invalid incoming stream value, %s", msg)
+		cli.send(withTrailer(resp, t.trailer))
+		t.fail(resp, cli)
+		return errors.Errorf("invalid incoming stream value for %d, %s", t.requestId.Long(), msg)
+	}
 	return nil
 }
 
+// ends the request with typed error on invalid outgoing value
+func (t *servingRequest) verifyOutgoing(val value.Value, seq int, cli *servingClient) bool {
+	path := fmt.Sprintf("%s[%d]", vrpc.OutgoingPath, seq)
+	if violations := vrpc.VerifyAt(path, val, t.outDef); len(violations) > 0 {
+		resp := FunctionErrorDetails(t.requestId, vrpc.InvalidStreamValue, vrpc.ViolationsToValue(violations), "invalid outgoing stream value, %s", vrpc.JoinViolations(violations))
+		cli.send(withTrailer(resp, t.trailer))
+		t.fail(resp, cli)
+		return false
+	}
+	return true
+}
+
 func (t *servingRequest) incomingStreamEnd(req value.Map, cli *servingClient) error {
 
 	if t.inC == nil {
@@ -95,9 +221,18 @@ func (t *servingRequest) incomingStreamEnd(req value.Map, cli *servingClient) er
 	}
 
 	if value, ok := req.Get(vrpc.ValueField); ok {
-		t.inC <- value
+		if err := t.verifyIncoming(value, cli); err != nil {
+			return err
+		}
+		t.addEvent(vrpc.StreamValueEvent, vrpc.Attribute(vrpc.DirectionAttr, vrpc.InDirection), vrpc.Attribute(vrpc.SeqAttr, t.inCount.Load()-1))
+		t.pushIncoming(value)
 	}
 
+	t.addEvent(vrpc.StreamEndEvent, vrpc.Attribute(vrpc.DirectionAttr, vrpc.InDirection))
+	if t.ft == incomingStream {
+		// acknowledges the end of PUT stream with trailers
+		cli.send(withTrailer(StreamEnd(t.requestId, nil), t.trailer))
+	}
 	return t.closeRequest(cli)
 }
 
@@ -112,17 +247,33 @@ func (t *servingRequest) outgoingStreamer(outC <-chan value.Value, cli *servingC
 
 	cli.send(StreamReady(t.requestId))
 
-	for {
+	for seq := 0; ; seq++ {
 
 		val, ok := <-outC
+		if t.failed.Load() {
+			if ok {
+				go drainStream(outC)
+			}
+			break
+		}
 		if !ok || t.closed.Load() {
-			cli.send(StreamEnd(t.requestId, val))
+			t.addEvent(vrpc.StreamEndEvent, vrpc.Attribute(vrpc.DirectionAttr, vrpc.OutDirection))
+			cli.send(withTrailer(StreamEnd(t.requestId, val), t.trailer))
 			if t.ft == outgoingStream {
 				t.closeRequest(cli)
 			}
+			if ok {
+				go drainStream(outC)
+			}
+			break
+		}
+
+		if !t.verifyOutgoing(val, seq, cli) {
+			go drainStream(outC)
 			break
 		}
 
+		t.addEvent(vrpc.StreamValueEvent, vrpc.Attribute(vrpc.DirectionAttr, vrpc.OutDirection), vrpc.Attribute(vrpc.SeqAttr, seq))
 		cli.send(StreamValue(t.requestId, val))
 
 		th := t.throttleOutgoing.Load()
@@ -133,3 +284,9 @@ func (t *servingRequest) outgoingStreamer(outC <-chan value.Value, cli *servingC
 	}
 
 }
+
+// releases producer blocked on sending to the ended stream, handler ctx is already done, so producer should close outC
+func drainStream(outC <-chan value.Value) {
+	for range outC {
+	}
+}
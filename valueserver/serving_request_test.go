@@ -0,0 +1,177 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver_test
+
+import (
+	"context"
+	"errors"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valueserver"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"go.uber.org/atomic"
+	"net"
+	"testing"
+	"time"
+)
+
+func serverCode(err error) vrpc.ErrorCode {
+	var se *valueclient.ServerError
+	if errors.As(err, &se) {
+		return se.Code
+	}
+	return vrpc.UnknownError
+}
+
+// counts reconnects of the client, dropped connection is restored by the next call
+type countingDialer struct {
+	*valuetest.PipeDialer
+	dials atomic.Int32
+}
+
+func (t *countingDialer) Dial(network, address string) (net.Conn, error) {
+	t.dials.Inc()
+	return t.PipeDialer.Dial(network, address)
+}
+
+func countReconnects(h *valuetest.Harness) *countingDialer {
+	d := &countingDialer{PipeDialer: valuetest.NewPipeDialer(h.Server)}
+	h.Client.SetDialer(d)
+	return d
+}
+
+// incoming queue of one value blocks the read loop on the next one
+func smallIncomingQueue(t *testing.T) {
+	prev := valueserver.IncomingQueueCap
+	valueserver.IncomingQueueCap = 1
+	t.Cleanup(func() {
+		valueserver.IncomingQueueCap = prev
+	})
+}
+
+// sends values until stop is closed
+func flood(putC chan<- value.Value, stop <-chan struct{}) {
+	defer close(putC)
+	for i := int64(0); ; i++ {
+		select {
+		case putC <- value.Long(i):
+		case <-stop:
+			return
+		}
+	}
+}
+
+func TestInvalidStreamValue(t *testing.T) {
+	h := valuetest.Start(t)
+	h.ScriptStream("get", vrpc.Any, vrpc.Number, value.Long(1), value.Utf8("two"))
+
+	done := make(chan error, 1)
+	readC, _, err := h.Client.GetStreamContext(context.Background(), "get", nil, 10, valueclient.WithDone(done))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.AssertValues([]value.Value{value.Long(1)}, h.Drain(readC, valuetest.DefaultWaitTimeout))
+	if code := serverCode(<-done); code != vrpc.InvalidStreamValue {
+		t.Errorf("expected InvalidStreamValue, actual %v", code)
+	}
+}
+
+func TestInvalidChatValueWhileReceiving(t *testing.T) {
+	smallIncomingQueue(t)
+	h := valuetest.Start(t)
+	h.FakeFunction("ping", vrpc.Any, vrpc.String, value.Utf8("pong"), nil)
+
+	// handler does not read incoming values, its invalid answer fails the chat while the read loop is blocked on full queue
+	err := h.Server.AddChatOf("chat", vrpc.Any, vrpc.Number, vrpc.Number, func(args value.Value, inC <-chan value.Value) (<-chan value.Value, error) {
+		outC := make(chan value.Value, 1)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			outC <- value.Utf8("invalid")
+			close(outC)
+		}()
+		return outC, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reconnects := countReconnects(h)
+	putC := make(chan value.Value)
+	readC, _, err := h.Client.Chat("chat", nil, 10, putC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go flood(putC, stop)
+	h.Drain(readC, valuetest.DefaultWaitTimeout)
+
+	if _, err := h.Client.CallFunction("ping", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := reconnects.dials.Load(); n != 0 {
+		t.Errorf("connection is dropped, %d reconnects after failed chat", n)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	h := valuetest.Start(t)
+	canceled := make(chan error, 1)
+	err := h.Server.AddContextOutgoingStream("infinite", vrpc.Any, vrpc.Number, func(ctx context.Context, args value.Value) (<-chan value.Value, error) {
+		outC := make(chan value.Value)
+		go func() {
+			defer close(outC)
+			for i := int64(0); ; i++ {
+				select {
+				case outC <- value.Long(i):
+				case <-ctx.Done():
+					canceled <- ctx.Err()
+					return
+				}
+			}
+		}()
+		return outC, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readC, requestId, err := h.Client.GetStream("infinite", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-readC
+	h.Client.CancelRequest(requestId)
+	select {
+	case err := <-canceled:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, actual %v", err)
+		}
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Fatal("handler context is not canceled")
+	}
+	h.Drain(readC, valuetest.DefaultWaitTimeout)
+
+	err = h.Server.AddContextFunction("wait", vrpc.Any, vrpc.Any, func(ctx context.Context, args value.Value) (value.Value, error) {
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := h.Client.CallFunctionContext(ctx, "wait", nil); err == nil {
+		t.Error("expected error of canceled call")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Fatal("function context is not canceled")
+	}
+}
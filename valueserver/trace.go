@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/pkg/errors"
+)
+
+func (t *rpcServer) SetTracer(tracer vrpc.Tracer) {
+	t.tracer.Set(tracer)
+}
+
+// server span of the request continues the trace of the client
+func (t *servingClient) startSpan(ctx context.Context, name string, ft functionType, req value.Map) (context.Context, vrpc.Span) {
+	ctx = vrpc.ExtractTrace(ctx, req)
+	attrs := []vrpc.Attr{
+		vrpc.Attribute(vrpc.RPCSystemAttr, vrpc.RPCSystemVrpc),
+		vrpc.Attribute(vrpc.RPCMethodAttr, name),
+		vrpc.Attribute(vrpc.KindAttr, string(ft.Kind())),
+		vrpc.Attribute(vrpc.ClientIdAttr, t.clientId),
+	}
+	if rid := req.GetNumber(vrpc.RequestIdField); rid != nil {
+		attrs = append(attrs, vrpc.Attribute(vrpc.RequestIdAttr, rid.Long()))
+	}
+	return t.tracer.Get().Start(ctx, name, vrpc.SpanKindServer, attrs...)
+}
+
+// marks span failed if response is an error
+func endSpan(span vrpc.Span, resp value.Map) {
+	if code := resultCode(resp); code != OkCodeLabel {
+		span.SetAttributes(vrpc.Attribute(vrpc.ErrorCodeAttr, code))
+		msg := code
+		if e := resp.GetString(vrpc.ErrorField); e != nil {
+			msg = e.String()
+		}
+		span.SetError(errors.New(msg))
+	}
+	span.End()
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver_test
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"testing"
+)
+
+func TestTracePropagation(t *testing.T) {
+	h := valuetest.Start(t)
+	spans := make(chan vrpc.SpanContext, 1)
+	if err := h.Server.AddContextFunction("traced", vrpc.Void, vrpc.Void, func(ctx context.Context, args value.Value) (value.Value, error) {
+		spans <- vrpc.SpanFromContext(ctx).SpanContext()
+		return nil, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	parent, err := vrpc.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := vrpc.ContextWithRemoteSpanContext(context.Background(), parent)
+	if _, err := h.Client.CallFunctionContext(ctx, "traced", nil); err != nil {
+		t.Fatal(err)
+	}
+	// noop tracers on both sides keep the parent span context
+	if sc := <-spans; sc.TraceID != parent.TraceID || !sc.Remote || !sc.IsSampled() {
+		t.Errorf("expected remote span of trace %v, actual %+v", parent.TraceID, sc)
+	}
+
+	if _, err := h.Client.CallFunction("traced", nil); err != nil {
+		t.Fatal(err)
+	}
+	if sc := <-spans; sc.IsValid() {
+		t.Errorf("expected no span without trace in request, actual %+v", sc)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+)
+
+/**
+Typed registration derives TypeDefs from go types and maps values to structs, see valuerpc.Marshal
+*/
+
+func AddTypedFunction[Req, Resp any](srv Server, name string, cb func(req Req) (Resp, error)) error {
+	args, err := vrpc.TypeDefFor[Req]()
+	if err != nil {
+		return err
+	}
+	res, err := vrpc.TypeDefFor[Resp]()
+	if err != nil {
+		return err
+	}
+	return srv.AddFunction(name, args, res, func(args value.Value) (value.Value, error) {
+		var req Req
+		if err := vrpc.Unmarshal(args, &req); err != nil {
+			return nil, err
+		}
+		resp, err := cb(req)
+		if err != nil {
+			return nil, err
+		}
+		return vrpc.Marshal(resp)
+	})
+}
+
+// GET for client
+func AddTypedOutgoingStream[Req, Out any](srv Server, name string, cb func(req Req) (<-chan Out, error)) error {
+	args, err := vrpc.TypeDefFor[Req]()
+	if err != nil {
+		return err
+	}
+	out, err := vrpc.TypeDefFor[Out]()
+	if err != nil {
+		return err
+	}
+	return srv.AddContextOutgoingStream(name, args, out, func(ctx context.Context, args value.Value) (<-chan value.Value, error) {
+		var req Req
+		if err := vrpc.Unmarshal(args, &req); err != nil {
+			return nil, err
+		}
+		outC, err := cb(req)
+		if err != nil {
+			return nil, err
+		}
+		return marshalStream(ctx, outC), nil
+	})
+}
+
+// PUT for client
+func AddTypedIncomingStream[Req, In any](srv Server, name string, cb func(req Req, inC <-chan In) error) error {
+	args, err := vrpc.TypeDefFor[Req]()
+	if err != nil {
+		return err
+	}
+	in, err := vrpc.TypeDefFor[In]()
+	if err != nil {
+		return err
+	}
+	return srv.AddContextIncomingStream(name, args, in, func(ctx context.Context, args value.Value, inC <-chan value.Value) error {
+		var req Req
+		if err := vrpc.Unmarshal(args, &req); err != nil {
+			return err
+		}
+		return cb(req, unmarshalStream[In](ctx, inC))
+	})
+}
+
+func AddTypedChat[Req, In, Out any](srv Server, name string, cb func(req Req, inC <-chan In) (<-chan Out, error)) error {
+	args, err := vrpc.TypeDefFor[Req]()
+	if err != nil {
+		return err
+	}
+	in, err := vrpc.TypeDefFor[In]()
+	if err != nil {
+		return err
+	}
+	out, err := vrpc.TypeDefFor[Out]()
+	if err != nil {
+		return err
+	}
+	return srv.AddContextChat(name, args, in, out, func(ctx context.Context, args value.Value, inC <-chan value.Value) (<-chan value.Value, error) {
+		var req Req
+		if err := vrpc.Unmarshal(args, &req); err != nil {
+			return nil, err
+		}
+		outC, err := cb(req, unmarshalStream[In](ctx, inC))
+		if err != nil {
+			return nil, err
+		}
+		return marshalStream(ctx, outC), nil
+	})
+}
+
+// fails the stream on the first value that can not be marshaled, drains the rest to release the producer
+func marshalStream[T any](ctx context.Context, outC <-chan T) <-chan value.Value {
+	valC := make(chan value.Value, cap(outC))
+	go func() {
+		defer close(valC)
+		for item := range outC {
+			val, err := vrpc.Marshal(item)
+			if err != nil {
+				FailStream(ctx, vrpc.FunctionFailed, err)
+				for range outC {
+				}
+				break
+			}
+			valC <- val
+		}
+	}()
+	return valC
+}
+
+// fails the stream on the first value that can not be unmarshaled, values are verified before
+func unmarshalStream[T any](ctx context.Context, inC <-chan value.Value) <-chan T {
+	itemC := make(chan T, cap(inC))
+	go func() {
+		defer close(itemC)
+		for val := range inC {
+			var item T
+			if err := vrpc.Unmarshal(val, &item); err != nil {
+				FailStream(ctx, vrpc.InvalidStreamValue, err)
+				for range inC {
+				}
+				break
+			}
+			itemC <- item
+		}
+	}()
+	return itemC
+}
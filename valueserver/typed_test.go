@@ -0,0 +1,226 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver_test
+
+import (
+	"context"
+	"errors"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valueserver"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"testing"
+	"time"
+)
+
+type user struct {
+	Name  string  `vrpc:"name"`
+	Age   int8    `vrpc:"age"`
+	Email *string `vrpc:"email"`
+}
+
+type point struct {
+	X int64 `vrpc:"0"`
+	Y int64 `vrpc:"1"`
+}
+
+func userValue(name string, age int64) value.Map {
+	return value.EmptyMap().Put("name", value.Utf8(name)).Put("age", value.Long(age))
+}
+
+func TestTypedFunction(t *testing.T) {
+	h := valuetest.Start(t)
+	err := valueserver.AddTypedFunction(h.Server, "older", func(req user) (user, error) {
+		if req.Age == 0 {
+			return user{}, errors.New("age is unknown")
+		}
+		req.Age++
+		return req, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		args   value.Value
+		result value.Value
+		code   vrpc.ErrorCode
+	}{
+		{"result", userValue("alex", 20), userValue("alex", 21), 0},
+		{"handler error", userValue("alex", 0), nil, vrpc.FunctionFailed},
+		{"required field", value.EmptyMap().Put("age", value.Long(20)), nil, vrpc.InvalidArgs},
+		{"overflow", userValue("alex", 1000), nil, vrpc.FunctionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := h.Client.CallFunction("older", tt.args)
+			if tt.code != 0 {
+				if code := serverCode(err); code != tt.code {
+					t.Errorf("expected error code %v, actual %v, %v", tt.code, code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !valuetest.Equal(tt.result, res) {
+				t.Errorf("expected %v, actual %v", tt.result, res)
+			}
+		})
+	}
+}
+
+func TestTypedStreams(t *testing.T) {
+	h := valuetest.Start(t)
+	err := valueserver.AddTypedOutgoingStream(h.Server, "line", func(req point) (<-chan point, error) {
+		outC := make(chan point, int(req.X))
+		for i := int64(0); i < req.X; i++ {
+			outC <- point{i, i * req.Y}
+		}
+		close(outC)
+		return outC, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []user, 1)
+	err = valueserver.AddTypedIncomingStream(h.Server, "users", func(req struct{}, inC <-chan user) error {
+		go func() {
+			var list []user
+			for u := range inC {
+				list = append(list, u)
+			}
+			received <- list
+		}()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = valueserver.AddTypedChat(h.Server, "swap", func(req struct{}, inC <-chan point) (<-chan point, error) {
+		outC := make(chan point)
+		go func() {
+			defer close(outC)
+			for p := range inC {
+				outC <- point{p.Y, p.X}
+			}
+		}()
+		return outC, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readC, _, err := h.Client.GetStream("line", value.Tuple(value.Long(3), value.Long(2)), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.AssertValues([]value.Value{
+		value.Tuple(value.Long(0), value.Long(0)),
+		value.Tuple(value.Long(1), value.Long(2)),
+		value.Tuple(value.Long(2), value.Long(4)),
+	}, h.Drain(readC, valuetest.DefaultWaitTimeout))
+
+	putC := make(chan value.Value, 2)
+	putC <- userValue("alex", 20)
+	putC <- userValue("bob", 30).Put("email", value.Utf8("bob@example.com"))
+	close(putC)
+	if err := h.Client.PutStream("users", value.EmptyMap(), putC); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case list := <-received:
+		if len(list) != 2 || list[0].Name != "alex" || list[0].Email != nil || list[1].Age != 30 || list[1].Email == nil || *list[1].Email != "bob@example.com" {
+			t.Errorf("unexpected users %+v", list)
+		}
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Fatal("incoming stream is not finished")
+	}
+
+	chatC := make(chan value.Value, 1)
+	chatC <- value.Tuple(value.Long(1), value.Long(2))
+	close(chatC)
+	readC, _, err = h.Client.Chat("swap", value.EmptyMap(), 10, chatC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.AssertValues([]value.Value{value.Tuple(value.Long(2), value.Long(1))}, h.Drain(readC, valuetest.DefaultWaitTimeout))
+}
+
+func TestTypedStreamConversionError(t *testing.T) {
+	h := valuetest.Start(t)
+	err := valueserver.AddTypedChat(h.Server, "echo", func(req struct{}, inC <-chan user) (<-chan user, error) {
+		return inC, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	putC := make(chan value.Value)
+	done := make(chan error, 1)
+	readC, _, err := h.Client.ChatContext(context.Background(), "echo", value.EmptyMap(), 10, putC, valueclient.WithDone(done))
+	if err != nil {
+		t.Fatal(err)
+	}
+	putC <- userValue("alex", 20)
+	if v := <-readC; !valuetest.Equal(userValue("alex", 20), v) {
+		t.Errorf("expected echo, actual %v", v)
+	}
+
+	// the value passes verification of int8 field as a number, but does not fit into it
+	putC <- userValue("bob", 1000)
+	h.AssertValues(nil, h.Drain(readC, valuetest.DefaultWaitTimeout))
+	if code := serverCode(<-done); code != vrpc.InvalidStreamValue {
+		t.Errorf("expected InvalidStreamValue, actual %v", code)
+	}
+	close(putC)
+}
+
+func TestFailStreamWhileReceiving(t *testing.T) {
+	smallIncomingQueue(t)
+	h := valuetest.Start(t)
+	h.FakeFunction("ping", vrpc.Any, vrpc.String, value.Utf8("pong"), nil)
+
+	// handler does not read incoming values and fails the stream while the read loop is blocked on full queue
+	err := h.Server.AddContextIncomingStream("put", vrpc.Any, vrpc.Number, func(ctx context.Context, args value.Value, inC <-chan value.Value) error {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			valueserver.FailStream(ctx, vrpc.InvalidStreamValue, errors.New("unexpected value"))
+		}()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reconnects := countReconnects(h)
+	putC := make(chan value.Value)
+	done := make(chan error, 1)
+	if err := h.Client.PutStreamContext(context.Background(), "put", nil, putC, valueclient.WithDone(done)); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go flood(putC, stop)
+
+	select {
+	case err := <-done:
+		if code := serverCode(err); code != vrpc.InvalidStreamValue {
+			t.Errorf("expected InvalidStreamValue, actual %v", err)
+		}
+	case <-time.After(valuetest.DefaultWaitTimeout):
+		t.Fatal("stream is not failed")
+	}
+
+	if _, err := h.Client.CallFunction("ping", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := reconnects.dials.Load(); n != 0 {
+		t.Errorf("connection is dropped, %d reconnects after failed stream", n)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver
+
+import (
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+	"net/http"
+)
+
+// origin is not checked, authenticate clients on the application level
+func (t *rpcServer) WebSocketHandler() http.Handler {
+	return websocket.Server{Handshake: acceptAnyOrigin, Handler: t.handleWebSocket}
+}
+
+// unlike websocket.Handler accepts missing and malformed Origin of non-browser clients,
+// parsed origin is kept in config when it is valid
+func acceptAnyOrigin(config *websocket.Config, req *http.Request) error {
+	if origin, err := websocket.Origin(config, req); err == nil {
+		config.Origin = origin
+	}
+	return nil
+}
+
+func (t *rpcServer) handleWebSocket(ws *websocket.Conn) {
+	if !t.addConn(ws) {
+		ws.Close()
+		return
+	}
+	defer t.removeConn(ws)
+	from := ws.Request().RemoteAddr
+	t.logger.Info("new websocket connection", zap.String("from", from))
+	err := t.handleConnection(valuerpc.NewWebSocketMsgConn(ws, DefaultTimeout))
+	if err != nil {
+		t.logger.Error("handle websocket connection",
+			zap.String("from", from),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valueserver_test
+
+import (
+	"errors"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valueserver"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"go.uber.org/zap"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func startWebSocket(t *testing.T) (valueserver.Server, string) {
+	srv := valueserver.NewListenerServer(nil, zap.NewNop())
+	t.Cleanup(func() { srv.Close() })
+	ts := httptest.NewServer(srv.WebSocketHandler())
+	t.Cleanup(ts.Close)
+	return srv, "ws" + strings.TrimPrefix(ts.URL, "http") + "/"
+}
+
+func TestWebSocket(t *testing.T) {
+	srv, address := startWebSocket(t)
+	if err := srv.AddFunction("add", vrpc.List(vrpc.Number, vrpc.Number), vrpc.Number, func(args value.Value) (value.Value, error) {
+		list := args.(value.List)
+		return value.Long(list.GetNumberAt(0).Long() + list.GetNumberAt(1).Long()), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.AddChatOf("double", vrpc.Void, vrpc.Number, vrpc.Number, func(args value.Value, inC <-chan value.Value) (<-chan value.Value, error) {
+		outC := make(chan value.Value)
+		go func() {
+			defer close(outC)
+			for v := range inC {
+				outC <- value.Long(v.(value.Number).Long() * 2)
+			}
+		}()
+		return outC, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := valueclient.NewClient(address, "")
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("connect %s, %v", address, err)
+	}
+	defer cli.Close()
+
+	res, err := cli.CallFunction("add", value.Tuple(value.Long(1), value.Long(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valuetest.Equal(value.Long(3), res) {
+		t.Errorf("expected 3, actual %v", res)
+	}
+
+	_, err = cli.CallFunction("sub", nil)
+	if code := serverCode(err); code != vrpc.FunctionNotFound {
+		t.Errorf("expected %v, actual %v", vrpc.FunctionNotFound, err)
+	}
+
+	putCh := make(chan value.Value)
+	readC, _, err := cli.Chat("double", nil, 10, putCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		putCh <- value.Long(i)
+		if actual := <-readC; !valuetest.Equal(value.Long(i*2), actual) {
+			t.Errorf("expected %d, actual %v", i*2, actual)
+		}
+	}
+	close(putCh)
+	for range readC {
+	}
+}
+
+func TestWebSocketAuthenticator(t *testing.T) {
+	srv, address := startWebSocket(t)
+	tokens := make(chan string, 10)
+	srv.SetAuthenticator(func(clientId int64, token string) error {
+		select {
+		case tokens <- token:
+		default:
+		}
+		if token != "secret" {
+			return errors.New("invalid token")
+		}
+		return nil
+	})
+	srv.AddFunction("ping", vrpc.Void, vrpc.String, func(args value.Value) (value.Value, error) {
+		return value.Utf8("pong"), nil
+	})
+
+	// handshake is rejected by closing the connection
+	rejected := valueclient.NewClient(address, "")
+	rejected.SetToken("wrong")
+	rejected.Connect()
+	if token := <-tokens; token != "wrong" {
+		t.Errorf("expected token of rejected client, actual %q", token)
+	}
+	rejected.Close()
+	if ids := srv.ClientIds(); len(ids) != 0 {
+		t.Errorf("expected no connected clients, actual %v", ids)
+	}
+
+	cli := valueclient.NewClient(address, "")
+	cli.SetToken("secret")
+	if err := cli.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if res, err := cli.CallFunction("ping", nil); err != nil || !valuetest.Equal(value.Utf8("pong"), res) {
+		t.Errorf("expected pong, actual %v, %v", res, err)
+	}
+	if ids := srv.ClientIds(); len(ids) != 1 || ids[0] != cli.ClientId() {
+		t.Errorf("expected connected client %d, actual %v", cli.ClientId(), ids)
+	}
+}
@@ -0,0 +1,292 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuetest
+
+import (
+	"context"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valueserver"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+/**
+Test harness with server and connected client over in-memory pipe, no ports are bound.
+Fake handlers record args of every call for assertions.
+*/
+
+var DefaultWaitTimeout = 5 * time.Second
+
+// subset of testing.TB
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+	Cleanup(func())
+}
+
+type Harness struct {
+	Server valueserver.Server
+	Client valueclient.Client
+
+	t      TestingT
+	dialer *PipeDialer
+
+	callsLock sync.Mutex
+	calls     map[string][]value.Value
+
+	handlers sync.WaitGroup // goroutines of scripted streams
+	done     chan struct{}  // closed by Close, stops scripted streams
+	doneOnce sync.Once
+}
+
+// starts server and connected client, both are closed on test cleanup
+func Start(t TestingT) *Harness {
+	t.Helper()
+
+	h := &Harness{
+		Server: valueserver.NewListenerServer(nil, zap.NewNop()),
+		t:      t,
+		calls:  make(map[string][]value.Value),
+		done:   make(chan struct{}),
+	}
+	h.dialer = NewPipeDialer(h.Server)
+
+	h.Client = valueclient.NewClient("pipe", "")
+	h.Client.SetDialer(h.dialer)
+	h.Client.SetReverseServer(valueserver.NewListenerServer(nil, zap.NewNop()))
+	if err := h.Client.Connect(); err != nil {
+		h.Server.Close()
+		t.Fatalf("connect client over pipe, %v", err)
+	}
+
+	t.Cleanup(h.Close)
+	return h
+}
+
+// closes client and server, waits for connections and scripted streams
+func (h *Harness) Close() {
+	h.doneOnce.Do(func() {
+		close(h.done)
+	})
+	h.Client.Close()
+	h.Server.Close()
+	if !h.dialer.Wait(DefaultWaitTimeout) {
+		h.t.Errorf("served connections are not closed in %v", DefaultWaitTimeout)
+	}
+	if !h.Wait(DefaultWaitTimeout) {
+		h.t.Errorf("scripted streams are not finished in %v", DefaultWaitTimeout)
+	}
+}
+
+// waits for goroutines of scripted streams, returns false on timeout
+func (h *Harness) Wait(timeout time.Duration) bool {
+	return WaitGroup(&h.handlers, timeout)
+}
+
+func (h *Harness) record(name string, args value.Value) {
+	h.callsLock.Lock()
+	defer h.callsLock.Unlock()
+	h.calls[name] = append(h.calls[name], args)
+}
+
+// args of all calls of the function in order
+func (h *Harness) Calls(name string) []value.Value {
+	h.callsLock.Lock()
+	defer h.callsLock.Unlock()
+	return append([]value.Value(nil), h.calls[name]...)
+}
+
+func (h *Harness) must(err error) {
+	if err != nil {
+		h.t.Helper()
+		h.t.Fatalf("register fake handler, %v", err)
+	}
+}
+
+// records calls and delegates to callback
+func (h *Harness) HandleFunction(name string, args, res vrpc.TypeDef, cb valueserver.Function) {
+	h.t.Helper()
+	h.must(h.Server.AddFunction(name, args, res, func(a value.Value) (value.Value, error) {
+		h.record(name, a)
+		return cb(a)
+	}))
+}
+
+// returns the same result or error on every call
+func (h *Harness) FakeFunction(name string, args, res vrpc.TypeDef, result value.Value, err error) {
+	h.t.Helper()
+	h.HandleFunction(name, args, res, func(value.Value) (value.Value, error) {
+		return result, err
+	})
+}
+
+// streams the values to the client on every call and ends the stream
+func (h *Harness) ScriptStream(name string, args, out vrpc.TypeDef, values ...value.Value) {
+	h.t.Helper()
+	h.must(h.Server.AddOutgoingStreamOf(name, args, out, func(a value.Value) (<-chan value.Value, error) {
+		h.record(name, a)
+		outC := make(chan value.Value, len(values))
+		h.handlers.Add(1)
+		go func() {
+			defer h.handlers.Done()
+			defer close(outC)
+			for _, v := range values {
+				outC <- v
+			}
+		}()
+		return outC, nil
+	}))
+}
+
+// answers every incoming value by respond function, nil answer is skipped
+func (h *Harness) ScriptChat(name string, args, in, out vrpc.TypeDef, respond func(value.Value) value.Value) {
+	h.t.Helper()
+	h.must(h.Server.AddContextChat(name, args, in, out, func(ctx context.Context, a value.Value, inC <-chan value.Value) (<-chan value.Value, error) {
+		h.record(name, a)
+		outC := make(chan value.Value)
+		h.handlers.Add(1)
+		go func() {
+			defer h.handlers.Done()
+			defer close(outC)
+			for v := range inC {
+				resp := respond(v)
+				if resp == nil {
+					continue
+				}
+				select {
+				case outC <- resp:
+				case <-ctx.Done():
+					return
+				case <-h.done:
+					return
+				}
+			}
+		}()
+		return outC, nil
+	}))
+}
+
+// collects values of all incoming streams of the function
+func (h *Harness) CollectStream(name string, args, in vrpc.TypeDef) *Collector {
+	h.t.Helper()
+	c := &Collector{}
+	h.must(h.Server.AddIncomingStreamOf(name, args, in, func(a value.Value, inC <-chan value.Value) error {
+		h.record(name, a)
+		h.handlers.Add(1)
+		go func() {
+			defer h.handlers.Done()
+			for v := range inC {
+				c.add(v)
+			}
+			c.end()
+		}()
+		return nil
+	}))
+	return c
+}
+
+type Collector struct {
+	lock   sync.Mutex
+	values []value.Value
+	ends   int
+}
+
+func (c *Collector) add(v value.Value) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.values = append(c.values, v)
+}
+
+func (c *Collector) end() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ends++
+}
+
+func (c *Collector) Values() []value.Value {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]value.Value(nil), c.values...)
+}
+
+// number of finished streams
+func (c *Collector) Ends() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ends
+}
+
+// waits until the number of finished streams is at least n
+func (c *Collector) WaitEnds(n int, timeout time.Duration) bool {
+	return WaitFor(func() bool { return c.Ends() >= n }, timeout)
+}
+
+func (h *Harness) AssertCalled(name string, args value.Value) {
+	h.t.Helper()
+	calls := h.Calls(name)
+	for _, a := range calls {
+		if Equal(a, args) {
+			return
+		}
+	}
+	h.t.Errorf("function '%s' was not called with args %v, calls %v", name, args, calls)
+}
+
+func (h *Harness) AssertCallCount(name string, expected int) {
+	h.t.Helper()
+	if actual := len(h.Calls(name)); actual != expected {
+		h.t.Errorf("function '%s' expected %d calls, actual %d", name, expected, actual)
+	}
+}
+
+func (h *Harness) AssertNotCalled(name string) {
+	h.t.Helper()
+	h.AssertCallCount(name, 0)
+}
+
+func (h *Harness) AssertValues(expected, actual []value.Value) {
+	h.t.Helper()
+	if len(expected) != len(actual) {
+		h.t.Errorf("expected %d values %v, actual %d values %v", len(expected), expected, len(actual), actual)
+		return
+	}
+	for i := range expected {
+		if !Equal(expected[i], actual[i]) {
+			h.t.Errorf("value %d expected %v, actual %v", i, expected[i], actual[i])
+		}
+	}
+}
+
+// reads all stream values until the channel is closed
+func (h *Harness) Drain(readC <-chan value.Value, timeout time.Duration) []value.Value {
+	h.t.Helper()
+	var list []value.Value
+	deadline := time.After(timeout)
+	for {
+		select {
+		case v, ok := <-readC:
+			if !ok {
+				return list
+			}
+			list = append(list, v)
+		case <-deadline:
+			h.t.Errorf("stream is not finished in %v, received %d values", timeout, len(list))
+			return list
+		}
+	}
+}
+
+// nil safe equality
+func Equal(a, b value.Value) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(b)
+}
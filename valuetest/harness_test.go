@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuetest_test
+
+import (
+	"context"
+	"errors"
+	"github.com/codeallergy/value"
+	"github.com/codeallergy/value-rpc/valueclient"
+	vrpc "github.com/codeallergy/value-rpc/valuerpc"
+	"github.com/codeallergy/value-rpc/valuetest"
+	"testing"
+)
+
+func serverCode(err error) vrpc.ErrorCode {
+	var se *valueclient.ServerError
+	if errors.As(err, &se) {
+		return se.Code
+	}
+	return vrpc.UnknownError
+}
+
+func TestFunction(t *testing.T) {
+	h := valuetest.Start(t)
+	h.HandleFunction("add", vrpc.List(vrpc.Number, vrpc.Number), vrpc.Number, func(args value.Value) (value.Value, error) {
+		list := args.(value.List)
+		return value.Long(list.GetAt(0).(value.Number).Long() + list.GetAt(1).(value.Number).Long()), nil
+	})
+	h.FakeFunction("fail", vrpc.Any, vrpc.Any, nil, errors.New("broken"))
+
+	tests := []struct {
+		name   string
+		fn     string
+		args   value.Value
+		result value.Value
+		code   vrpc.ErrorCode
+	}{
+		{"result", "add", value.Tuple(value.Long(1), value.Long(2)), value.Long(3), 0},
+		{"invalid args", "add", value.Utf8("1, 2"), nil, vrpc.InvalidArgs},
+		{"handler error", "fail", nil, nil, vrpc.FunctionFailed},
+		{"not found", "sub", nil, nil, vrpc.FunctionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := h.Client.CallFunction(tt.fn, tt.args)
+			if tt.code != 0 {
+				if code := serverCode(err); code != tt.code {
+					t.Errorf("expected error code %v, actual %v, %v", tt.code, code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !valuetest.Equal(tt.result, res) {
+				t.Errorf("expected %v, actual %v", tt.result, res)
+			}
+		})
+	}
+
+	h.AssertCalled("add", value.Tuple(value.Long(1), value.Long(2)))
+	h.AssertCallCount("add", 1)
+	h.AssertCallCount("fail", 1)
+}
+
+func TestStreams(t *testing.T) {
+	h := valuetest.Start(t)
+	values := []value.Value{value.Long(1), value.Long(2), value.Long(3)}
+	h.ScriptStream("get", vrpc.Any, vrpc.Number, values...)
+	collector := h.CollectStream("put", vrpc.Any, vrpc.Number)
+	h.ScriptChat("chat", vrpc.Any, vrpc.Number, vrpc.Number, func(v value.Value) value.Value {
+		return value.Long(v.(value.Number).Long() * 10)
+	})
+
+	readC, _, err := h.Client.GetStream("get", value.Utf8("args"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.AssertValues(values, h.Drain(readC, valuetest.DefaultWaitTimeout))
+	h.AssertCalled("get", value.Utf8("args"))
+
+	putC := make(chan value.Value, len(values))
+	for _, v := range values {
+		putC <- v
+	}
+	close(putC)
+	done := make(chan error, 1)
+	if err := h.Client.PutStreamContext(context.Background(), "put", nil, putC, valueclient.WithDone(done)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !collector.WaitEnds(1, valuetest.DefaultWaitTimeout) {
+		t.Fatal("incoming stream is not finished")
+	}
+	h.AssertValues(values, collector.Values())
+
+	chatC := make(chan value.Value)
+	readC, _, err = h.Client.Chat("chat", nil, 10, chatC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range values {
+		chatC <- v
+		expected := value.Long(v.(value.Number).Long() * 10)
+		if actual := <-readC; !valuetest.Equal(expected, actual) {
+			t.Errorf("expected %v, actual %v", expected, actual)
+		}
+	}
+	close(chatC)
+	h.Drain(readC, valuetest.DefaultWaitTimeout)
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuetest
+
+import (
+	"github.com/codeallergy/value-rpc/valueserver"
+	"net"
+	"sync"
+	"time"
+)
+
+/**
+In-memory transport, each Dial creates net.Pipe and serves the other end by the server.
+*/
+
+type PipeDialer struct {
+	srv valueserver.Server
+	wg  sync.WaitGroup
+}
+
+func NewPipeDialer(srv valueserver.Server) *PipeDialer {
+	return &PipeDialer{srv: srv}
+}
+
+// network and address are ignored
+func (t *PipeDialer) Dial(network, address string) (net.Conn, error) {
+	serverConn, clientConn := net.Pipe()
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		t.srv.ServeConn(serverConn)
+	}()
+	return clientConn, nil
+}
+
+// waits until all served connections are closed, returns false on timeout
+func (t *PipeDialer) Wait(timeout time.Duration) bool {
+	return WaitGroup(&t.wg, timeout)
+}
+
+func WaitGroup(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// polls condition until it is true, returns false on timeout
+func WaitFor(cond func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuetrace
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"io"
+	"strconv"
+	"sync"
+)
+
+/**
+OTLP JSON encoding of spans, one ExportTraceServiceRequest per line,
+the format of the OpenTelemetry collector otlpjsonfile receiver.
+*/
+
+var ScopeName = "github.com/codeallergy/value-rpc"
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 is a string in OTLP JSON
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 0 unset, 1 ok, 2 error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string      `json:"traceId"`
+	SpanId            string      `json:"spanId"`
+	ParentSpanId      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr  `json:"attributes,omitempty"`
+	Events            []otlpEvent `json:"events,omitempty"`
+	Status            otlpStatus  `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func toOTLPValue(v interface{}) otlpValue {
+	switch x := v.(type) {
+	case string:
+		return otlpValue{StringValue: &x}
+	case bool:
+		return otlpValue{BoolValue: &x}
+	case int:
+		s := strconv.Itoa(x)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &x}
+	default:
+		s := fmt.Sprint(x)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+func toOTLPAttrs(attrs []valuerpc.Attr) []otlpAttr {
+	var list []otlpAttr
+	for _, a := range attrs {
+		list = append(list, otlpAttr{Key: a.Key, Value: toOTLPValue(a.Value)})
+	}
+	return list
+}
+
+// OTLP span kinds are shifted by one, zero is unspecified
+func toOTLPKind(kind valuerpc.SpanKind) int {
+	switch kind {
+	case valuerpc.SpanKindServer:
+		return 2
+	case valuerpc.SpanKindClient:
+		return 3
+	default:
+		return 1
+	}
+}
+
+func toOTLPSpan(data SpanData) otlpSpan {
+	span := otlpSpan{
+		TraceId:           data.SpanContext.TraceID.String(),
+		SpanId:            data.SpanContext.SpanID.String(),
+		Name:              data.Name,
+		Kind:              toOTLPKind(data.Kind),
+		StartTimeUnixNano: strconv.FormatInt(data.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(data.End.UnixNano(), 10),
+		Attributes:        toOTLPAttrs(data.Attrs),
+		Status:            otlpStatus{Code: 1},
+	}
+	if data.ParentSpanID != (valuerpc.SpanID{}) {
+		span.ParentSpanId = data.ParentSpanID.String()
+	}
+	for _, e := range data.Events {
+		span.Events = append(span.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(e.Time.UnixNano(), 10),
+			Name:         e.Name,
+			Attributes:   toOTLPAttrs(e.Attrs),
+		})
+	}
+	if data.Err != "" {
+		span.Status = otlpStatus{Code: 2, Message: data.Err}
+	}
+	return span
+}
+
+// writes every finished span as OTLP JSON line, service name is the service.name resource attribute
+func NewOTLPExporter(w io.Writer, serviceName string) Exporter {
+	var lock sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(data SpanData) {
+		var rs otlpResourceSpans
+		rs.Resource.Attributes = toOTLPAttrs([]valuerpc.Attr{valuerpc.Attribute("service.name", serviceName)})
+		var ss otlpScopeSpans
+		ss.Scope.Name = ScopeName
+		ss.Spans = []otlpSpan{toOTLPSpan(data)}
+		rs.ScopeSpans = []otlpScopeSpans{ss}
+
+		lock.Lock()
+		defer lock.Unlock()
+		enc.Encode(otlpRequest{ResourceSpans: []otlpResourceSpans{rs}})
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2023 Zander Schwid & Co. LLC.
+ * SPDX-License-Identifier: BUSL-1.1
+ */
+
+package valuetrace
+
+import (
+	"context"
+	crand "crypto/rand"
+	"github.com/codeallergy/value-rpc/valuerpc"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/**
+Recording tracer for valuerpc.Tracer, ids and sampling follow W3C trace context,
+finished spans are passed to the exporter, see NewOTLPExporter for OpenTelemetry collectors.
+
+To use OpenTelemetry SDK instead, implement valuerpc.Tracer by starting otel span with
+trace.ContextWithRemoteSpanContext built from valuerpc.SpanFromContext(ctx).SpanContext().
+*/
+
+type Event struct {
+	Time  time.Time
+	Name  string
+	Attrs []valuerpc.Attr
+}
+
+type SpanData struct {
+	Name         string
+	Kind         valuerpc.SpanKind
+	SpanContext  valuerpc.SpanContext
+	ParentSpanID valuerpc.SpanID // zero for root span
+	Start        time.Time
+	End          time.Time
+	Attrs        []valuerpc.Attr
+	Events       []Event
+	Err          string // empty if span is ok
+}
+
+type Exporter func(SpanData)
+
+// decides whether new trace is recorded, child spans follow the parent
+type Sampler func(traceId valuerpc.TraceID) bool
+
+func AlwaysSample(valuerpc.TraceID) bool { return true }
+
+// samples fraction of traces by trace id, so all services make the same decision
+func RatioSampler(ratio float64) Sampler {
+	bound := uint64(ratio * (1 << 63))
+	return func(id valuerpc.TraceID) bool {
+		var x uint64
+		for _, b := range id[8:] {
+			x = x<<8 | uint64(b)
+		}
+		return x>>1 < bound
+	}
+}
+
+var MaxEventsPerSpan = 1000
+
+type Tracer struct {
+	exporter Exporter
+	sampler  Sampler
+
+	lock sync.Mutex
+	rnd  *rand.Rand
+}
+
+func NewTracer(exporter Exporter, sampler Sampler) *Tracer {
+	if sampler == nil {
+		sampler = AlwaysSample
+	}
+	var seed [8]byte
+	crand.Read(seed[:])
+	var s int64
+	for _, b := range seed {
+		s = s<<8 | int64(b)
+	}
+	return &Tracer{exporter: exporter, sampler: sampler, rnd: rand.New(rand.NewSource(s))}
+}
+
+func (t *Tracer) newIds(traceId *valuerpc.TraceID, spanId *valuerpc.SpanID) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if traceId != nil {
+		for *traceId == (valuerpc.TraceID{}) {
+			t.rnd.Read(traceId[:])
+		}
+	}
+	for *spanId == (valuerpc.SpanID{}) {
+		t.rnd.Read(spanId[:])
+	}
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, kind valuerpc.SpanKind, attrs ...valuerpc.Attr) (context.Context, valuerpc.Span) {
+	parent := valuerpc.SpanFromContext(ctx).SpanContext()
+
+	var sc valuerpc.SpanContext
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		t.newIds(nil, &sc.SpanID)
+	} else {
+		t.newIds(&sc.TraceID, &sc.SpanID)
+		if t.sampler(sc.TraceID) {
+			sc.Flags = 0x01
+		}
+	}
+
+	span := &recordingSpan{
+		tracer:    t,
+		recording: sc.IsSampled(),
+		data: SpanData{
+			Name:        name,
+			Kind:        kind,
+			SpanContext: sc,
+			Start:       time.Now(),
+			Attrs:       append([]valuerpc.Attr(nil), attrs...),
+		},
+	}
+	if parent.IsValid() {
+		span.data.ParentSpanID = parent.SpanID
+	}
+	return valuerpc.ContextWithSpan(ctx, span), span
+}
+
+type recordingSpan struct {
+	tracer    *Tracer
+	recording bool
+
+	lock  sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (t *recordingSpan) SpanContext() valuerpc.SpanContext {
+	return t.data.SpanContext
+}
+
+func (t *recordingSpan) AddEvent(name string, attrs ...valuerpc.Attr) {
+	if !t.recording {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.ended || len(t.data.Events) >= MaxEventsPerSpan {
+		return
+	}
+	t.data.Events = append(t.data.Events, Event{Time: time.Now(), Name: name, Attrs: attrs})
+}
+
+func (t *recordingSpan) SetAttributes(attrs ...valuerpc.Attr) {
+	if !t.recording {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.ended {
+		t.data.Attrs = append(t.data.Attrs, attrs...)
+	}
+}
+
+func (t *recordingSpan) SetError(err error) {
+	if !t.recording || err == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.ended {
+		t.data.Err = err.Error()
+	}
+}
+
+func (t *recordingSpan) End() {
+	t.lock.Lock()
+	if t.ended {
+		t.lock.Unlock()
+		return
+	}
+	t.ended = true
+	t.data.End = time.Now()
+	data := t.data
+	t.lock.Unlock()
+
+	if t.recording && t.tracer.exporter != nil {
+		t.tracer.exporter(data)
+	}
+}